@@ -4,10 +4,33 @@ import (
 	"bufio"
 	"fmt"
 	"os"
+	"os/user"
+	"time"
 
 	appMod "bitbucket.org/leeyousheng/account-deposit-server/pkg/app"
 )
 
+const (
+	auditLogPath       = "audit.log"
+	auditLogMaxSize    = 1 << 20
+	auditLogMaxBackups = 5
+	operatorsPath      = "operators.json"
+	productsPath       = "products.json"
+	ratesPath          = "rates.csv"
+	feesPath           = "fees.json"
+	limitsPath         = "limits.json"
+	screeningPath      = "screening.json"
+	outboxPath         = "outbox.jsonl"
+	eventLogPath       = "events.log"
+	webhooksPath       = "webhooks.json"
+	snapshotsPath      = "snapshots.jsonl"
+	webhookInterval    = 10 * time.Second
+)
+
+// databaseEnv names the environment variable giving the path of the database customers are kept in.
+// Customers are only kept in memory when it is not set.
+const databaseEnv = "DEPOSIT_DB"
+
 // Run starts the main loop of the app.
 func Run() {
 	scanner := bufio.NewScanner(os.Stdin)
@@ -16,5 +39,110 @@ func Run() {
 	}
 
 	app := appMod.NewApp()
+
+	auditLog, err := appMod.NewAuditLog(auditLogPath, auditLogMaxSize, auditLogMaxBackups)
+	if err != nil {
+		fmt.Println("Audit log error: ", err)
+		return
+	}
+	app.SetAuditLog(auditLog)
+
+	operators, err := appMod.LoadOperatorStore(operatorsPath)
+	if err != nil {
+		fmt.Println("Operators error: ", err)
+		return
+	}
+	app.SetOperatorStore(operators)
+
+	if _, err := os.Stat(productsPath); err == nil {
+		products, err := appMod.LoadProductCatalogue(productsPath)
+		if err != nil {
+			fmt.Println("Products error: ", err)
+			return
+		}
+		app.SetProductCatalogue(products)
+	}
+
+	if _, err := os.Stat(ratesPath); err == nil {
+		rates, err := appMod.LoadStaticRateProvider(ratesPath)
+		if err != nil {
+			fmt.Println("Rates error: ", err)
+			return
+		}
+		app.SetRateProvider(rates)
+	}
+
+	if _, err := os.Stat(feesPath); err == nil {
+		fees, err := appMod.LoadFeeSchedule(feesPath)
+		if err != nil {
+			fmt.Println("Fees error: ", err)
+			return
+		}
+		app.SetFeeSchedule(fees)
+	}
+
+	if _, err := os.Stat(limitsPath); err == nil {
+		limits, err := appMod.LoadLimits(limitsPath)
+		if err != nil {
+			fmt.Println("Limits error: ", err)
+			return
+		}
+		app.SetLimits(limits)
+	}
+
+	if _, err := os.Stat(screeningPath); err == nil {
+		rules, err := appMod.LoadScreeningRules(screeningPath)
+		if err != nil {
+			fmt.Println("Screening rules error: ", err)
+			return
+		}
+		app.SetScreener(rules)
+	}
+
+	outbox, err := appMod.NewFileOutbox(outboxPath)
+	if err != nil {
+		fmt.Println("Outbox error: ", err)
+		return
+	}
+	eventLog, err := os.OpenFile(eventLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		fmt.Println("Event log error: ", err)
+		return
+	}
+	defer eventLog.Close()
+	events := appMod.NewEventBus()
+	events.Subscribe(outbox)
+	events.SubscribeAsync(appMod.NewLogSubscriber(eventLog))
+	defer events.Close()
+	app.SetEventBus(events)
+	app.SetEventStore(outbox, appMod.NewSnapshotStore(snapshotsPath))
+
+	if path := os.Getenv(databaseEnv); path != "" {
+		repo, err := appMod.OpenSQLRepository(path)
+		if err != nil {
+			fmt.Println("Database error: ", err)
+			return
+		}
+		defer repo.Close()
+		if err := app.SetRepository(repo); err != nil {
+			fmt.Println("Database error: ", err)
+			return
+		}
+	}
+
+	webhooks, err := appMod.NewWebhookDispatcher(webhooksPath)
+	if err != nil {
+		fmt.Println("Webhooks error: ", err)
+		return
+	}
+	events.Subscribe(webhooks)
+	webhooks.Start(webhookInterval)
+	defer webhooks.Stop()
+	app.SetWebhookDispatcher(webhooks)
+
+	if u, err := user.Current(); err == nil {
+		app.SetOperator(u.Username)
+	}
+
 	app.Run(scanner)
 }
@@ -2,4 +2,8 @@ module bitbucket.org/leeyousheng/account-deposit-server
 
 go 1.15
 
-require github.com/stretchr/testify v1.6.1
+require (
+	github.com/stretchr/testify v1.6.1
+	golang.org/x/crypto v0.0.0-20211117183948-ae814b36b871
+	modernc.org/sqlite v1.14.1
+)
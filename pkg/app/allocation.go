@@ -0,0 +1,74 @@
+package app
+
+import (
+	"fmt"
+	"math"
+)
+
+// allocationTolerance is how far, in percentage points, target percentages may total away from 100
+const allocationTolerance = 0.01
+
+// SetTargetAllocation sets the percentage of the total balance of the customer each portfolio should hold.
+// The percentages are for open portfolios of the customer and total 100. An empty allocation clears the target.
+func (c *Customer) SetTargetAllocation(targets map[string]float64) error {
+	var total float64
+	for name, percent := range targets {
+		p := c.findPortfolio(name)
+		if p == nil {
+			return &PortfolioNotFoundError{Name: name}
+		}
+		if p.Closed {
+			return fmt.Errorf("%s: %w", name, ErrPortfolioClosed)
+		}
+		if percent <= 0 || math.IsNaN(percent) || math.IsInf(percent, 0) {
+			return ErrInvalidTargetPercentage
+		}
+		total += percent
+	}
+	if len(targets) > 0 && math.Abs(total-100) > allocationTolerance {
+		return ErrTargetNotTotal
+	}
+
+	c.targets = nil
+	if len(targets) > 0 {
+		c.targets = map[string]float64{}
+		for name, percent := range targets {
+			c.targets[name] = percent
+		}
+	}
+	c.publishTargets()
+	return nil
+}
+
+// TargetAllocation returns the target percentage of each portfolio, or nil when no target is set
+func (c *Customer) TargetAllocation() map[string]float64 {
+	if c.targets == nil {
+		return nil
+	}
+	targets := map[string]float64{}
+	for name, percent := range c.targets {
+		targets[name] = percent
+	}
+	return targets
+}
+
+// moveTarget gives the target percentage of a portfolio to another, when the portfolio is renamed or closed
+// into it. With no portfolio to move to, the target of the closed portfolio is dropped.
+func (c *Customer) moveTarget(from string, to string) {
+	percent, ok := c.targets[from]
+	if !ok {
+		return
+	}
+	delete(c.targets, from)
+	if to != "" {
+		c.targets[to] += percent
+	}
+	if len(c.targets) == 0 {
+		c.targets = nil
+	}
+	c.publishTargets()
+}
+
+func (c *Customer) publishTargets() {
+	c.events.Publish(TargetAllocationSet{Customer: c.ID, Targets: c.TargetAllocation(), Time: c.now()})
+}
@@ -0,0 +1,103 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetTargetAllocation_shouldSetTargets(t *testing.T) {
+	sub := &recordingSubscriber{}
+	bus := NewEventBus()
+	bus.Subscribe(sub)
+	c := Customer{ID: "c1", portfolios: []*Portfolio{{Name: "Retirement"}, {Name: "High Risk"}}, events: bus}
+
+	targets := map[string]float64{"Retirement": 60, "High Risk": 40}
+	assert.NoError(t, c.SetTargetAllocation(targets))
+	targets["Retirement"] = 10
+	assert.Equal(t, map[string]float64{"Retirement": 60, "High Risk": 40}, c.TargetAllocation())
+
+	assert.NoError(t, c.SetTargetAllocation(map[string]float64{"Retirement": 33.33, "High Risk": 66.67}))
+	assert.Equal(t, map[string]float64{"Retirement": 33.33, "High Risk": 66.67}, c.TargetAllocation())
+
+	assert.NoError(t, c.SetTargetAllocation(nil))
+	assert.Nil(t, c.TargetAllocation())
+	assert.Equal(t, []string{EventTargetAllocationSet, EventTargetAllocationSet, EventTargetAllocationSet}, sub.types())
+}
+
+func TestSetTargetAllocation_shouldReturnError_givenInvalidTargets(t *testing.T) {
+	testSetTargetAllocation := func(targets map[string]float64, expectedErr string) {
+		c := Customer{portfolios: []*Portfolio{{Name: "Retirement"}, {Name: "High Risk"}, {Name: "Closed", Closed: true}}}
+		err := c.SetTargetAllocation(targets)
+		assert.EqualError(t, err, expectedErr)
+		assert.Nil(t, c.TargetAllocation())
+	}
+
+	testSetTargetAllocation(map[string]float64{"Retirement": 60, "Growth": 40}, "portfolio not found")
+	testSetTargetAllocation(map[string]float64{"Retirement": 60, "Closed": 40}, "Closed: portfolio is closed")
+	testSetTargetAllocation(map[string]float64{"Retirement": 100, "High Risk": 0}, "invalid target percentage")
+	testSetTargetAllocation(map[string]float64{"Retirement": 120, "High Risk": -20}, "invalid target percentage")
+	testSetTargetAllocation(map[string]float64{"Retirement": 60, "High Risk": 30}, "target allocation must total 100%")
+}
+
+func TestTargetAllocation_shouldFollowPortfolios_givenRenamedOrClosed(t *testing.T) {
+	c := Customer{ID: "c1", Status: CustomerActive, portfolios: []*Portfolio{{Name: "Retirement", Balance: 100}, {Name: "High Risk", Balance: 50}, {Name: "Savings"}, {Name: "Spare"}}, ledger: NewLedger()}
+	assert.NoError(t, c.SetTargetAllocation(map[string]float64{"Retirement": 40, "High Risk": 30, "Savings": 20, "Spare": 10}))
+
+	assert.NoError(t, c.RenamePortfolio("High Risk", "Growth"))
+	assert.Equal(t, map[string]float64{"Retirement": 40, "Growth": 30, "Savings": 20, "Spare": 10}, c.TargetAllocation())
+
+	assert.NoError(t, c.MergePortfolio("Growth", "Retirement"))
+	assert.Equal(t, map[string]float64{"Retirement": 70, "Savings": 20, "Spare": 10}, c.TargetAllocation())
+
+	assert.NoError(t, c.ClosePortfolio("Savings", "Spare"))
+	assert.Equal(t, map[string]float64{"Retirement": 70, "Spare": 30}, c.TargetAllocation())
+
+	assert.NoError(t, c.ClosePortfolio("Spare", ""))
+	assert.Equal(t, map[string]float64{"Retirement": 70}, c.TargetAllocation())
+}
+
+func TestRebuild_shouldReplayTargetAllocation(t *testing.T) {
+	app, _, _ := newTestRebuildApp(t)
+	setNow(t, time.Date(2020, 1, 10, 12, 0, 0, 0, time.UTC))
+	for _, input := range []string{
+		"newcustomer test1",
+		"addportfolio Retirement",
+		"addportfolio \"High Risk\"",
+		"settarget Retirement 60 \"High Risk\" 40",
+		"renameportfolio \"High Risk\" Growth",
+	} {
+		_, err := app.processInput(input)
+		assert.NoError(t, err, input)
+	}
+
+	rebuilt, err := app.Rebuild(time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]float64{"Retirement": 60, "Growth": 40}, rebuilt[0].TargetAllocation())
+
+	snapshot := snapshotCustomer(rebuilt[0])
+	assert.Equal(t, map[string]float64{"Retirement": 60, "Growth": 40}, snapshot.restore().TargetAllocation())
+}
+
+func TestApp_SetTarget_shouldParseTargets(t *testing.T) {
+	app := NewApp()
+	_, err := app.processInput("settarget Retirement 100")
+	assert.Equal(t, ErrNoActiveCustomer, err)
+
+	for _, input := range []string{"newcustomer test1", "addportfolio Retirement", "addportfolio Growth"} {
+		_, err := app.processInput(input)
+		assert.NoError(t, err, input)
+	}
+	_, err = app.processInput("settarget Retirement 60 Growth")
+	assert.Equal(t, ErrInvalidArgs, err)
+	_, err = app.processInput("settarget Retirement sixty Growth 40")
+	assert.EqualError(t, err, "invalid target percentage")
+
+	_, err = app.processInput("settarget Retirement 60 Growth 40")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]float64{"Retirement": 60, "Growth": 40}, app.currentCustomer.TargetAllocation())
+	_, err = app.processInput("settarget")
+	assert.NoError(t, err)
+	assert.Nil(t, app.currentCustomer.TargetAllocation())
+}
@@ -0,0 +1,200 @@
+package app
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const maskedArg = "****"
+
+// sensitiveArgs lists, per command, the positions of arguments which must not be written to the audit log
+var sensitiveArgs = map[string][]int{
+	"login":       {1},
+	"addoperator": {2},
+	"addwebhook":  {1},
+}
+
+// sensitiveFlags lists the flags whose values must not be written to the audit log
+var sensitiveFlags = map[string]bool{
+	"--email": true,
+	"--phone": true,
+	"--dob":   true,
+	"--payer": true,
+}
+
+// AuditEntry is the record of a single command performed on the app
+type AuditEntry struct {
+	Timestamp time.Time `json:"timestamp"`
+	Operator  string    `json:"operator"`
+	Customer  string    `json:"customer,omitempty"`
+	Command   string    `json:"command"`
+	Args      []string  `json:"args"`
+	Outcome   string    `json:"outcome"`
+	Error     string    `json:"error,omitempty"`
+	Code      string    `json:"code,omitempty"`
+}
+
+// AuditFilter narrows down the entries returned by a query. Zero values are not filtered on.
+type AuditFilter struct {
+	Customer string
+	From     time.Time
+	To       time.Time
+}
+
+// AuditLog is an append-only log of audit entries stored as JSON lines on disk
+type AuditLog struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	now        func() time.Time
+}
+
+// NewAuditLog instantiate an audit log writing to path, rotating the file once it grows past maxSize
+// and keeping at most maxBackups rotated files.
+func NewAuditLog(path string, maxSize int64, maxBackups int) (*AuditLog, error) {
+	if path == "" {
+		return nil, &ConfigError{Message: "audit log path is empty"}
+	}
+	if maxSize <= 0 {
+		return nil, &ConfigError{Message: "invalid audit log size"}
+	}
+	if maxBackups < 0 {
+		return nil, &ConfigError{Message: "invalid audit log backups"}
+	}
+	return &AuditLog{path: path, maxSize: maxSize, maxBackups: maxBackups, now: time.Now}, nil
+}
+
+// Record appends the entry to the log, stamping it with the current time
+func (l *AuditLog) Record(entry AuditEntry) error {
+	entry.Timestamp = l.now()
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if err := l.rotateIfNeeded(int64(len(line))); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Query returns all entries, oldest first, matching the filter
+func (l *AuditLog) Query(filter AuditFilter) ([]AuditEntry, error) {
+	entries := []AuditEntry{}
+	for _, path := range l.files() {
+		f, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var entry AuditEntry
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				f.Close()
+				return nil, fmt.Errorf("%w %s: %v", ErrCorruptedAuditLog, path, err)
+			}
+			if filter.matches(entry) {
+				entries = append(entries, entry)
+			}
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+func (l *AuditLog) rotateIfNeeded(incoming int64) error {
+	info, err := os.Stat(l.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 || info.Size()+incoming <= l.maxSize {
+		return nil
+	}
+
+	if l.maxBackups == 0 {
+		return os.Remove(l.path)
+	}
+	if err := os.Remove(l.backupPath(l.maxBackups)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for i := l.maxBackups - 1; i >= 1; i-- {
+		if err := os.Rename(l.backupPath(i), l.backupPath(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(l.path, l.backupPath(1))
+}
+
+func (l *AuditLog) backupPath(n int) string {
+	return fmt.Sprintf("%s.%d", l.path, n)
+}
+
+// files returns the log files from the oldest to the current one
+func (l *AuditLog) files() []string {
+	files := []string{}
+	for i := l.maxBackups; i >= 1; i-- {
+		files = append(files, l.backupPath(i))
+	}
+	return append(files, l.path)
+}
+
+func (f AuditFilter) matches(entry AuditEntry) bool {
+	if f.Customer != "" && f.Customer != entry.Customer {
+		return false
+	}
+	if !f.From.IsZero() && entry.Timestamp.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && entry.Timestamp.After(f.To) {
+		return false
+	}
+	return true
+}
+
+// String formats the entry for display
+func (e AuditEntry) String() string {
+	s := fmt.Sprintf("%s %s [%s] %s %s: %s", e.Timestamp.Format(time.RFC3339), e.Operator, e.Customer, e.Command, strings.Join(e.Args, " "), e.Outcome)
+	if e.Error != "" {
+		s += " (" + e.Error + ")"
+	}
+	return s
+}
+
+func maskArgs(command string, args []string) []string {
+	masked := append([]string{}, args...)
+	for _, i := range sensitiveArgs[command] {
+		if i < len(masked) {
+			masked[i] = maskedArg
+		}
+	}
+	for i := 0; i+1 < len(masked); i++ {
+		if sensitiveFlags[masked[i]] {
+			masked[i+1] = maskedArg
+		}
+	}
+	return masked
+}
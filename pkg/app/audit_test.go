@@ -0,0 +1,101 @@
+package app
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestAuditLog(t *testing.T, maxSize int64, maxBackups int, now time.Time) *AuditLog {
+	l, err := NewAuditLog(filepath.Join(t.TempDir(), "audit.log"), maxSize, maxBackups)
+	assert.NoError(t, err)
+	l.now = func() time.Time { return now }
+	return l
+}
+
+func TestNewAuditLog_shouldReturnError_givenInvalidConfig(t *testing.T) {
+	testNewAuditLog := func(path string, maxSize int64, maxBackups int, expectedErr string) {
+		l, err := NewAuditLog(path, maxSize, maxBackups)
+		assert.Error(t, err)
+		assert.Equal(t, expectedErr, err.Error())
+		assert.Nil(t, l)
+	}
+
+	testNewAuditLog("", 10, 1, "audit log path is empty")
+	testNewAuditLog("audit.log", 0, 1, "invalid audit log size")
+	testNewAuditLog("audit.log", 10, -1, "invalid audit log backups")
+}
+
+func TestAuditLogRecord_shouldAppendEntries(t *testing.T) {
+	now := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	l := newTestAuditLog(t, 1<<20, 1, now)
+
+	assert.NoError(t, l.Record(AuditEntry{Operator: "op", Customer: "c1", Command: "deposit", Args: []string{"100"}, Outcome: "success"}))
+	assert.NoError(t, l.Record(AuditEntry{Operator: "op", Command: "endDeposit", Args: []string{}, Outcome: "failure", Error: "no active customer"}))
+
+	entries, err := l.Query(AuditFilter{})
+	assert.NoError(t, err)
+	assert.Equal(t, []AuditEntry{
+		{Timestamp: now, Operator: "op", Customer: "c1", Command: "deposit", Args: []string{"100"}, Outcome: "success"},
+		{Timestamp: now, Operator: "op", Command: "endDeposit", Args: []string{}, Outcome: "failure", Error: "no active customer"},
+	}, entries)
+}
+
+func TestAuditLogRecord_shouldRotateFile_givenMaxSizeReached(t *testing.T) {
+	now := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	l := newTestAuditLog(t, 150, 2, now)
+
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, l.Record(AuditEntry{Operator: "op", Command: "deposit", Args: []string{string(rune('0' + i))}, Outcome: "success"}))
+	}
+
+	current, err := ioutil.ReadFile(l.path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(current), `"args":["4"]`)
+	assert.FileExists(t, l.backupPath(1))
+	assert.FileExists(t, l.backupPath(2))
+	assert.NoFileExists(t, l.backupPath(3))
+
+	entries, err := l.Query(AuditFilter{})
+	assert.NoError(t, err)
+	assert.Len(t, entries, 3)
+	assert.Equal(t, []string{"2"}, entries[0].Args)
+	assert.Equal(t, []string{"4"}, entries[2].Args)
+}
+
+func TestAuditLogQuery_shouldFilterEntries(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2020, 1, d, 0, 0, 0, 0, time.UTC) }
+	l := newTestAuditLog(t, 1<<20, 1, day(1))
+	for d, customer := range []string{"c1", "c2", "c1"} {
+		l.now = func() time.Time { return day(d + 1) }
+		assert.NoError(t, l.Record(AuditEntry{Operator: "op", Customer: customer, Command: "deposit", Outcome: "success"}))
+	}
+
+	testQuery := func(filter AuditFilter, expectedTimes []time.Time) {
+		entries, err := l.Query(filter)
+		assert.NoError(t, err)
+		times := []time.Time{}
+		for _, e := range entries {
+			times = append(times, e.Timestamp)
+		}
+		assert.Equal(t, expectedTimes, times)
+	}
+
+	testQuery(AuditFilter{}, []time.Time{day(1), day(2), day(3)})
+	testQuery(AuditFilter{Customer: "c1"}, []time.Time{day(1), day(3)})
+	testQuery(AuditFilter{From: day(2)}, []time.Time{day(2), day(3)})
+	testQuery(AuditFilter{To: day(2)}, []time.Time{day(1), day(2)})
+	testQuery(AuditFilter{Customer: "c1", From: day(2)}, []time.Time{day(3)})
+}
+
+func TestMaskArgs_shouldMaskSensitiveArgs(t *testing.T) {
+	sensitiveArgs["secret"] = []int{1, 5}
+	defer delete(sensitiveArgs, "secret")
+
+	assert.Equal(t, []string{"a", maskedArg}, maskArgs("secret", []string{"a", "b"}))
+	assert.Equal(t, []string{"a", "b"}, maskArgs("other", []string{"a", "b"}))
+	assert.Equal(t, []string{"--name", "a", "--email", maskedArg}, maskArgs("updatecustomer", []string{"--name", "a", "--email", "a@b.c"}))
+}
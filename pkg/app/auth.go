@@ -0,0 +1,209 @@
+package app
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Role determines the commands an operator is allowed to perform
+type Role string
+
+// Operator roles
+const (
+	RoleTeller     Role = "teller"
+	RoleSupervisor Role = "supervisor"
+	RoleAuditor    Role = "auditor"
+)
+
+type permission int
+
+const (
+	permNone permission = iota
+	permRead
+	permAudit
+	permDeposit
+	permWithdraw
+	permLargeWithdraw
+	permManageCustomers
+	permManageOperators
+	permApproveLimits
+	permReview
+	permReconcile
+	permManageWebhooks
+)
+
+var rolePermissions = map[Role][]permission{
+	RoleAuditor:    {permRead, permAudit},
+	RoleTeller:     {permRead, permDeposit},
+	RoleSupervisor: {permRead, permAudit, permDeposit, permWithdraw, permLargeWithdraw, permManageCustomers, permManageOperators, permApproveLimits, permReview, permReconcile, permManageWebhooks},
+}
+
+func (r Role) valid() bool {
+	_, ok := rolePermissions[r]
+	return ok
+}
+
+func (r Role) can(perm permission) bool {
+	for _, p := range rolePermissions[r] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// Operator is a staff account permitted to perform commands on the app. The password is kept as a bcrypt hash.
+type Operator struct {
+	ID   string
+	Role Role
+	hash []byte
+}
+
+type operatorRecord struct {
+	ID   string `json:"id"`
+	Role Role   `json:"role"`
+	Hash string `json:"hash"`
+}
+
+// OperatorStore keeps the operator accounts and the tokens issued to them
+type OperatorStore struct {
+	path      string
+	operators []*Operator
+	tokens    map[string]*Operator
+}
+
+// NewOperatorStore instantiate a store without any operators. When path is not empty, the
+// store is saved to it whenever an operator is added.
+func NewOperatorStore(path string) *OperatorStore {
+	return &OperatorStore{path: path, operators: []*Operator{}, tokens: map[string]*Operator{}}
+}
+
+// LoadOperatorStore reads the operators saved at path. A missing file gives an empty store.
+func LoadOperatorStore(path string) (*OperatorStore, error) {
+	s := NewOperatorStore(path)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	records := []operatorRecord{}
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, err
+	}
+	for _, r := range records {
+		hash, err := hex.DecodeString(r.Hash)
+		if err != nil {
+			return nil, err
+		}
+		if r.ID == "" || !r.Role.valid() {
+			return nil, ErrInvalidOperatorRecord
+		}
+		s.operators = append(s.operators, &Operator{ID: r.ID, Role: r.Role, hash: hash})
+	}
+	return s, nil
+}
+
+// Enabled reports whether any operator is set up. Commands are only checked against
+// permissions once there is an operator able to log in.
+func (s *OperatorStore) Enabled() bool {
+	return s != nil && len(s.operators) > 0
+}
+
+// AddOperator creates an operator account with the specified role and password
+func (s *OperatorStore) AddOperator(id string, role Role, password string) error {
+	if id == "" {
+		return ErrIDEmpty
+	}
+	if !role.valid() {
+		return ErrInvalidRole
+	}
+	if password == "" {
+		return ErrPasswordEmpty
+	}
+	if s.find(id) != nil {
+		return ErrOperatorExists
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	s.operators = append(s.operators, &Operator{ID: id, Role: role, hash: hash})
+	return s.save()
+}
+
+// Login verifies the operator password and issues a token identifying the operator
+func (s *OperatorStore) Login(id string, password string) (string, error) {
+	o := s.find(id)
+	if o == nil || !o.checkPassword(password) {
+		return "", ErrInvalidCredentials
+	}
+
+	b, err := randomBytes(32)
+	if err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+	s.tokens[token] = o
+	return token, nil
+}
+
+// Logout revokes the token
+func (s *OperatorStore) Logout(token string) {
+	delete(s.tokens, token)
+}
+
+// Authenticate returns the operator the token was issued to
+func (s *OperatorStore) Authenticate(token string) (*Operator, error) {
+	o, ok := s.tokens[token]
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	return o, nil
+}
+
+// checkPassword reports whether the password is the one the operator set
+func (o *Operator) checkPassword(password string) bool {
+	return bcrypt.CompareHashAndPassword(o.hash, []byte(password)) == nil
+}
+
+func (s *OperatorStore) find(id string) *Operator {
+	for _, o := range s.operators {
+		if o.ID == id {
+			return o
+		}
+	}
+	return nil
+}
+
+func (s *OperatorStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	records := []operatorRecord{}
+	for _, o := range s.operators {
+		records = append(records, operatorRecord{ID: o.ID, Role: o.Role, Hash: hex.EncodeToString(o.hash)})
+	}
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.path, data, 0600)
+}
+
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
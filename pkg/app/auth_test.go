@@ -0,0 +1,91 @@
+package app
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddOperator_shouldAddOperator(t *testing.T) {
+	s := NewOperatorStore("")
+	assert.False(t, s.Enabled())
+
+	err := s.AddOperator("op1", RoleTeller, "secret")
+	assert.NoError(t, err)
+	assert.True(t, s.Enabled())
+	assert.Equal(t, "op1", s.operators[0].ID)
+	assert.Equal(t, RoleTeller, s.operators[0].Role)
+	assert.NotEqual(t, []byte("secret"), s.operators[0].hash)
+}
+
+func TestAddOperator_shouldReturnError_givenInvalidOperator(t *testing.T) {
+	testAddOperator := func(id string, role Role, password string, expectedErr string) {
+		s := NewOperatorStore("")
+		s.AddOperator("op1", RoleTeller, "secret")
+		err := s.AddOperator(id, role, password)
+		assert.Error(t, err)
+		assert.Equal(t, expectedErr, err.Error())
+		assert.Len(t, s.operators, 1)
+	}
+
+	testAddOperator("", RoleTeller, "secret", "id is empty")
+	testAddOperator("op2", Role("admin"), "secret", "invalid role")
+	testAddOperator("op2", RoleTeller, "", "password is empty")
+	testAddOperator("op1", RoleAuditor, "secret", "operator with specified id already added")
+}
+
+func TestLogin_shouldIssueToken(t *testing.T) {
+	s := NewOperatorStore("")
+	s.AddOperator("op1", RoleSupervisor, "secret")
+
+	token, err := s.Login("op1", "secret")
+	assert.NoError(t, err)
+	o, err := s.Authenticate(token)
+	assert.NoError(t, err)
+	assert.Equal(t, "op1", o.ID)
+
+	s.Logout(token)
+	_, err = s.Authenticate(token)
+	assert.Error(t, err)
+	assert.Equal(t, "invalid token", err.Error())
+}
+
+func TestLogin_shouldReturnError_givenInvalidCredentials(t *testing.T) {
+	testLogin := func(id string, password string) {
+		s := NewOperatorStore("")
+		s.AddOperator("op1", RoleSupervisor, "secret")
+		token, err := s.Login(id, password)
+		assert.Error(t, err)
+		assert.Equal(t, "invalid operator id or password", err.Error())
+		assert.Empty(t, token)
+	}
+
+	testLogin("op1", "wrong")
+	testLogin("op2", "secret")
+}
+
+func TestLoadOperatorStore_shouldLoadSavedOperators(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "operators.json")
+	s, err := LoadOperatorStore(path)
+	assert.NoError(t, err)
+	assert.False(t, s.Enabled())
+	assert.NoError(t, s.AddOperator("op1", RoleAuditor, "secret"))
+
+	loaded, err := LoadOperatorStore(path)
+	assert.NoError(t, err)
+	assert.Equal(t, s.operators, loaded.operators)
+	_, err = loaded.Login("op1", "secret")
+	assert.NoError(t, err)
+}
+
+func TestRoleCan_shouldReturnPermissionsOfRole(t *testing.T) {
+	assert.True(t, RoleAuditor.can(permRead))
+	assert.False(t, RoleAuditor.can(permDeposit))
+	assert.True(t, RoleTeller.can(permDeposit))
+	assert.False(t, RoleTeller.can(permWithdraw))
+	assert.False(t, RoleAuditor.can(permReconcile))
+	assert.False(t, RoleTeller.can(permLargeWithdraw))
+	assert.False(t, RoleTeller.can(permManageCustomers))
+	assert.True(t, RoleSupervisor.can(permLargeWithdraw))
+}
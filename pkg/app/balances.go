@@ -0,0 +1,39 @@
+package app
+
+import "time"
+
+// PortfolioBalance is the balance of a customer portfolio at a point in time
+type PortfolioBalance struct {
+	Customer  string
+	Portfolio string
+	Balance   float32
+	Currency  string
+}
+
+// BalancesAsOf returns the balance of every portfolio of the customer at asOf, summed from the ledger entries
+// recorded up to then. Entries recorded under a former name of a portfolio count towards it.
+func (c *Customer) BalancesAsOf(asOf time.Time) []PortfolioBalance {
+	totals := map[string]float64{}
+	if c.ledger != nil {
+		for i, e := range c.ledger.entries {
+			if e.Customer == c.ID && !e.Time.After(asOf) {
+				totals[c.ledger.currentName(c.ID, e.Portfolio, i)] += float64(e.Amount)
+			}
+		}
+	}
+
+	balances := []PortfolioBalance{}
+	for _, p := range c.portfolios {
+		balances = append(balances, PortfolioBalance{Customer: c.ID, Portfolio: p.Name, Balance: roundCents(totals[p.Name]), Currency: p.currency()})
+	}
+	return balances
+}
+
+// BalancesAsOf returns the balance of every portfolio of every customer at asOf
+func (a *App) BalancesAsOf(asOf time.Time) []PortfolioBalance {
+	balances := []PortfolioBalance{}
+	for _, c := range a.customers {
+		balances = append(balances, c.BalancesAsOf(asOf)...)
+	}
+	return balances
+}
@@ -0,0 +1,83 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBalancesAsOf_shouldSumLedgerEntries_givenDate(t *testing.T) {
+	app := NewApp()
+	setNow(t, time.Date(2020, 1, 31, 12, 0, 0, 0, time.Local))
+	app.processInput("newcustomer test1")
+	app.processInput("addportfolio Retirement")
+	app.processInput("addportfolio Growth")
+	app.processInput("startDeposit")
+	app.processInput("addOneTimePlan plan Retirement 100 Growth 50")
+	app.processInput("deposit 150")
+	app.processInput("endDeposit")
+
+	setNow(t, time.Date(2020, 2, 10, 12, 0, 0, 0, time.Local))
+	app.processInput("withdraw Retirement 40")
+	setNow(t, time.Date(2020, 2, 20, 12, 0, 0, 0, time.Local))
+	app.processInput("mergeportfolio Growth Retirement")
+
+	testBalancesAsOf := func(asOf time.Time, expected []PortfolioBalance) {
+		assert.Equal(t, expected, app.BalancesAsOf(asOf), asOf.String())
+	}
+	testBalancesAsOf(time.Date(2020, 1, 30, 23, 59, 59, 0, time.Local), []PortfolioBalance{
+		{Customer: "test1", Portfolio: "Retirement", Balance: 0, Currency: "SGD"},
+		{Customer: "test1", Portfolio: "Growth", Balance: 0, Currency: "SGD"},
+	})
+	testBalancesAsOf(time.Date(2020, 1, 31, 23, 59, 59, 0, time.Local), []PortfolioBalance{
+		{Customer: "test1", Portfolio: "Retirement", Balance: 100, Currency: "SGD"},
+		{Customer: "test1", Portfolio: "Growth", Balance: 50, Currency: "SGD"},
+	})
+	testBalancesAsOf(time.Date(2020, 2, 10, 23, 59, 59, 0, time.Local), []PortfolioBalance{
+		{Customer: "test1", Portfolio: "Retirement", Balance: 60, Currency: "SGD"},
+		{Customer: "test1", Portfolio: "Growth", Balance: 50, Currency: "SGD"},
+	})
+	testBalancesAsOf(time.Date(2020, 2, 20, 23, 59, 59, 0, time.Local), []PortfolioBalance{
+		{Customer: "test1", Portfolio: "Retirement", Balance: 110, Currency: "SGD"},
+		{Customer: "test1", Portfolio: "Growth", Balance: 0, Currency: "SGD"},
+	})
+}
+
+func TestBalancesAsOf_shouldFollowRenamedPortfolio(t *testing.T) {
+	c, _ := NewCustomer("test1")
+	c.ledger = NewLedger()
+	setNow(t, time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC))
+	c.AddPortfolio("Old")
+	plan, _ := NewOneTimeDepositPlan("plan", map[string]float32{"Old": 100})
+	c.PerformDeposit([]DepositPlan{plan}, []float32{100})
+	c.RenamePortfolio("Old", "New")
+	c.AddPortfolio("Old")
+	setNow(t, time.Date(2020, 1, 2, 12, 0, 0, 0, time.UTC))
+	c.Withdraw("New", 30)
+
+	assert.Equal(t, []PortfolioBalance{
+		{Customer: "test1", Portfolio: "New", Balance: 70, Currency: "SGD"},
+		{Customer: "test1", Portfolio: "Old", Balance: 0, Currency: "SGD"},
+	}, c.BalancesAsOf(time.Date(2020, 1, 2, 23, 59, 59, 0, time.UTC)))
+	assert.Equal(t, float32(100), c.BalancesAsOf(time.Date(2020, 1, 1, 23, 59, 59, 0, time.UTC))[0].Balance)
+}
+
+func TestCliBalances_shouldReturnError_givenInvalidArgs(t *testing.T) {
+	app := NewApp()
+	app.processInput("newcustomer test1")
+	testProcessInput := func(command string, expectedErr string) {
+		_, err := app.processInput(command)
+		if expectedErr == "" {
+			assert.NoError(t, err, command)
+		} else {
+			assert.EqualError(t, err, expectedErr, command)
+		}
+	}
+
+	testProcessInput("balances", "as-of date not specified")
+	testProcessInput("balances --as-of yesterday", "invalid time: yesterday")
+	testProcessInput("balances --as-of 2020-01-31 --customer test2", "customer not found")
+	testProcessInput("balances --as-of 2020-01-31 --customer test1", "")
+	testProcessInput("balances --as-of 2020-01-31", "")
+}
@@ -0,0 +1,255 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Deposit sources
+const (
+	SourceBankTransfer = "bank-transfer"
+	SourceCheque       = "cheque"
+	SourceCash         = "cash"
+	SourceCard         = "card"
+)
+
+// DepositStatus is the state of the funds of a received deposit
+type DepositStatus string
+
+// Deposit statuses
+const (
+	DepositPending  DepositStatus = "pending"
+	DepositCleared  DepositStatus = "cleared"
+	DepositReturned DepositStatus = "returned"
+)
+
+// DepositDetails describes where the money of a deposit came from, so it can be reconciled to the payment
+type DepositDetails struct {
+	Source     string
+	Reference  string
+	Payer      string
+	ReceivedAt time.Time
+}
+
+// ReceivedDeposit is money received from the customer in a deposit session. Portfolios are credited
+// provisionally with pending funds, which cannot be withdrawn until cleared. Should the funds be returned
+// instead, the share of every credit made from them is reversed. Fees charged are not refunded.
+type ReceivedDeposit struct {
+	DepositDetails
+	Amount   float32
+	Currency string
+	Status   DepositStatus
+	// Reconciled is set once the deposit is matched to a bank statement line
+	Reconciled bool
+
+	session    *DepositSession
+	settlement *depositSettlement
+}
+
+// depositSettlement is the credits a completed session made from the deposits received in it, along with the
+// portfolio of each credit so the funds follow the portfolio should it be renamed
+type depositSettlement struct {
+	credits    []DepositCredit
+	portfolios []*Portfolio
+	total      float32
+}
+
+// completeSession credits the portfolios with the deposits of the session, holding back the funds still pending.
+// Monthly plans paid are saved for the customer to pay into again. Should the credits fail, the plans saved are
+// put back and their events dropped.
+func (c *Customer) completeSession(session *DepositSession) ([]DepositCredit, error) {
+	saved := append([]DepositPlan(nil), c.savedPlans...)
+	c.events.Hold()
+	for _, dp := range session.depositPlans {
+		if dp.PlanType() != "monthly" {
+			continue
+		}
+		if err := c.SavePlan(dp); err != nil {
+			c.savedPlans = saved
+			c.events.Discard()
+			return nil, err
+		}
+	}
+	credits, err := c.performDeposit(session.depositPlans, session.deposits, session.currency)
+	if err != nil {
+		c.savedPlans = saved
+		c.events.Discard()
+		return nil, err
+	}
+	c.events.Release()
+
+	s := &depositSettlement{credits: credits}
+	for _, cr := range credits {
+		s.portfolios = append(s.portfolios, c.findPortfolio(cr.Portfolio))
+	}
+	for _, d := range session.deposits {
+		s.total += d
+	}
+	var held []PortfolioAmount
+	var references []string
+	for _, r := range session.received {
+		r.settlement = s
+		references = append(references, r.Reference)
+		if r.Status != DepositPending {
+			continue
+		}
+		for i, cr := range credits {
+			share := r.share(cr)
+			s.portfolios[i].uncleared += share
+			if share != 0 {
+				held = append(held, PortfolioAmount{Portfolio: cr.Portfolio, Amount: share})
+			}
+		}
+	}
+	c.events.Publish(SessionCommitted{Customer: c.ID, Currency: currencyOrDefault(session.currency), Deposits: session.deposits, Credits: credits, Held: held, Time: c.now(), References: references})
+	return credits, nil
+}
+
+// ClearDeposit marks the pending deposit with the reference cleared, releasing the funds for withdrawal
+func (c *Customer) ClearDeposit(reference string) error {
+	r, err := c.pendingDeposit(reference)
+	if err != nil {
+		return err
+	}
+
+	r.Status = DepositCleared
+	event := FundsCleared{Customer: c.ID, Reference: r.Reference, Time: c.now()}
+	if r.settlement != nil {
+		for i, cr := range r.settlement.credits {
+			p := r.settlement.portfolios[i]
+			share := r.share(cr)
+			p.release(share)
+			if share != 0 {
+				event.Released = append(event.Released, PortfolioAmount{Portfolio: p.Name, Amount: share})
+			}
+		}
+	}
+	c.events.Publish(event)
+	return nil
+}
+
+// ReturnDeposit marks the pending deposit with the reference returned. Once its session is completed, the
+// share of every credit made from it is taken back out of the portfolios, which must still hold it. Before then
+// the deposit is taken out of the session.
+func (c *Customer) ReturnDeposit(reference string) error {
+	r, err := c.pendingDeposit(reference)
+	if err != nil {
+		return err
+	}
+	if r.settlement != nil {
+		for i, cr := range r.settlement.credits {
+			if p := r.settlement.portfolios[i]; p.Balance-r.share(cr) < -0.005 {
+				return fmt.Errorf("%s: %w", p.Name, ErrInsufficientBalance)
+			}
+		}
+	}
+
+	r.Status = DepositReturned
+	event := FundsReturned{Customer: c.ID, Reference: r.Reference, Time: c.now()}
+	if r.settlement == nil {
+		if r.session != nil {
+			r.session.remove(r)
+		}
+	} else {
+		for i, cr := range r.settlement.credits {
+			p := r.settlement.portfolios[i]
+			share := r.share(cr)
+			if share == 0 {
+				continue
+			}
+			p.Balance -= share
+			p.release(share)
+			c.record(p, EntryReturn, -share, r.Reference)
+			event.Reversed = append(event.Reversed, PortfolioAmount{Portfolio: p.Name, Amount: share})
+		}
+	}
+	c.events.Publish(event)
+	return nil
+}
+
+// returnSession marks every deposit received in the session returned, giving the funds back without crediting
+// any portfolio
+func (c *Customer) returnSession(session *DepositSession) {
+	for _, r := range session.received {
+		r.Status = DepositReturned
+		c.events.Publish(FundsReturned{Customer: c.ID, Reference: r.Reference, Time: c.now()})
+	}
+}
+
+// SearchDeposits returns the deposits received from the customer whose reference contains the query,
+// ignoring case
+func (c *Customer) SearchDeposits(query string) []*ReceivedDeposit {
+	found := []*ReceivedDeposit{}
+	query = strings.ToLower(query)
+	for _, r := range c.received {
+		if strings.Contains(strings.ToLower(r.Reference), query) {
+			found = append(found, r)
+		}
+	}
+	return found
+}
+
+func (c *Customer) pendingDeposit(reference string) (*ReceivedDeposit, error) {
+	r := c.findDeposit(reference)
+	if r == nil {
+		return nil, ErrDepositNotFound
+	}
+	if r.Status != DepositPending {
+		return nil, &AlreadySettledError{Item: "deposit", Status: string(r.Status)}
+	}
+	return r, nil
+}
+
+// findDeposit returns the latest deposit received with the reference
+func (c *Customer) findDeposit(reference string) *ReceivedDeposit {
+	for i := len(c.received) - 1; i >= 0; i-- {
+		if c.received[i].Reference == reference {
+			return c.received[i]
+		}
+	}
+	return nil
+}
+
+// share returns the part of the net credit made from the deposit
+func (r *ReceivedDeposit) share(cr DepositCredit) float32 {
+	if r.settlement.total == 0 {
+		return 0
+	}
+	return roundCents(float64(cr.Net) * float64(r.Amount) / float64(r.settlement.total))
+}
+
+// release takes the amount off the uncleared funds of the portfolio
+func (p *Portfolio) release(amount float32) {
+	p.uncleared -= amount
+	if p.uncleared < 0.01 {
+		p.uncleared = 0
+	}
+}
+
+// remove takes the deposit out of the session
+func (s *DepositSession) remove(r *ReceivedDeposit) {
+	for i, d := range s.received {
+		if d == r {
+			s.received = append(s.received[:i], s.received[i+1:]...)
+			s.deposits = append(s.deposits[:i], s.deposits[i+1:]...)
+			return
+		}
+	}
+}
+
+func (d DepositDetails) validate() error {
+	if d.Source != SourceBankTransfer && d.Source != SourceCheque && d.Source != SourceCash && d.Source != SourceCard {
+		return &InvalidValueError{Field: "deposit source", Value: d.Source}
+	}
+	return nil
+}
+
+// String formats the deposit for display
+func (r *ReceivedDeposit) String() string {
+	s := fmt.Sprintf("%s %s %s %.2f %s %s", r.ReceivedAt.Format("2006-01-02 15:04:05"), r.Reference, r.Source, r.Amount, r.Currency, r.Status)
+	if r.Payer != "" {
+		s += " from " + r.Payer
+	}
+	return s
+}
@@ -0,0 +1,145 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestClearingCustomer(t *testing.T) *Customer {
+	c := &Customer{ID: "c1", portfolios: []*Portfolio{{Name: "A"}, {Name: "B"}}, ledger: NewLedger()}
+	assert.NoError(t, c.StartSession())
+	assert.NoError(t, c.PayDepositPlan(&baseDepositPlan{name: "Plan", planType: "one-time", portfolioRatio: map[string]float32{"A": 300, "B": 100}}))
+	assert.NoError(t, c.ReceiveDeposit(100, "", DepositDetails{}, DepositCleared))
+	assert.NoError(t, c.ReceiveDeposit(300, "", DepositDetails{Source: SourceBankTransfer, Reference: "TX1", Payer: "Test One"}, DepositPending))
+	return c
+}
+
+func TestReceiveDeposit_shouldRecordDepositWithReference(t *testing.T) {
+	c := newTestClearingCustomer(t)
+	assert.Equal(t, []float32{100, 300}, c.DepositSession.deposits)
+	assert.Equal(t, "c1-1", c.received[0].Reference)
+	assert.Equal(t, DepositCleared, c.received[0].Status)
+	assert.Equal(t, "TX1", c.received[1].Reference)
+	assert.Equal(t, DepositPending, c.received[1].Status)
+
+	err := c.ReceiveDeposit(10, "", DepositDetails{Reference: "TX1"}, DepositPending)
+	assert.Error(t, err)
+	assert.Equal(t, "duplicate deposit reference", err.Error())
+	err = c.ReceiveDeposit(10, "", DepositDetails{Reference: "TX2"}, DepositReturned)
+	assert.Error(t, err)
+	assert.Equal(t, "invalid deposit status", err.Error())
+}
+
+func TestCompleteSession_shouldHoldBackPendingFunds(t *testing.T) {
+	c := newTestClearingCustomer(t)
+	_, err := c.completeSession(c.DepositSession)
+	assert.NoError(t, err)
+
+	assert.Equal(t, float32(300), c.portfolios[0].Balance)
+	assert.Equal(t, float32(225), c.portfolios[0].uncleared)
+	assert.Equal(t, float32(75), c.portfolios[1].uncleared)
+
+	err = c.Withdraw("A", 76)
+	assert.Error(t, err)
+	assert.Equal(t, "withdrawal amount more than cleared balance", err.Error())
+	assert.NoError(t, c.Withdraw("A", 75))
+}
+
+func TestClearDeposit_shouldReleaseFunds(t *testing.T) {
+	c := newTestClearingCustomer(t)
+	c.completeSession(c.DepositSession)
+
+	assert.NoError(t, c.ClearDeposit("TX1"))
+	assert.Equal(t, float32(0), c.portfolios[0].uncleared)
+	assert.Equal(t, float32(0), c.portfolios[1].uncleared)
+	assert.NoError(t, c.Withdraw("A", 300))
+
+	err := c.ClearDeposit("TX1")
+	assert.Error(t, err)
+	assert.Equal(t, "deposit already cleared", err.Error())
+	err = c.ClearDeposit("TX2")
+	assert.Error(t, err)
+	assert.Equal(t, "deposit not found", err.Error())
+}
+
+func TestReturnDeposit_shouldReverseCredits(t *testing.T) {
+	tm := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	setNow(t, tm)
+	c := newTestClearingCustomer(t)
+	c.completeSession(c.DepositSession)
+
+	assert.NoError(t, c.ReturnDeposit("TX1"))
+	assert.Equal(t, float32(75), c.portfolios[0].Balance)
+	assert.Equal(t, float32(25), c.portfolios[1].Balance)
+	assert.Equal(t, float32(0), c.portfolios[0].uncleared)
+	assert.Equal(t, DepositReturned, c.received[1].Status)
+	entries := c.ledger.Entries("c1")
+	assert.Equal(t, LedgerEntry{Time: tm, Customer: "c1", Portfolio: "B", Type: EntryReturn, Amount: -75, Currency: "SGD", Reference: "TX1"}, entries[len(entries)-1])
+}
+
+func TestClearAndReturnDeposit_shouldFollowPortfolio_givenRenamed(t *testing.T) {
+	c := newTestClearingCustomer(t)
+	c.completeSession(c.DepositSession)
+	assert.NoError(t, c.RenamePortfolio("A", "C"))
+	assert.NoError(t, c.ClearDeposit("TX1"))
+	assert.Equal(t, float32(0), c.portfolios[0].uncleared)
+
+	c = newTestClearingCustomer(t)
+	c.completeSession(c.DepositSession)
+	assert.NoError(t, c.RenamePortfolio("A", "C"))
+	assert.NoError(t, c.ReturnDeposit("TX1"))
+	assert.Equal(t, float32(75), c.portfolios[0].Balance)
+	entries := c.ledger.Entries("c1")
+	assert.Equal(t, "C", entries[len(entries)-2].Portfolio)
+}
+
+func TestReturnDeposit_shouldReturnError_givenBalanceTooLow(t *testing.T) {
+	c := newTestClearingCustomer(t)
+	c.completeSession(c.DepositSession)
+	c.portfolios[1].Balance = 50
+
+	assert.EqualError(t, c.ReturnDeposit("TX1"), "B: withdrawal amount more than balance")
+	assert.Equal(t, float32(300), c.portfolios[0].Balance)
+	assert.Equal(t, DepositPending, c.received[1].Status)
+}
+
+func TestReturnDeposit_shouldRemoveDepositFromSession_givenSessionNotCompleted(t *testing.T) {
+	c := newTestClearingCustomer(t)
+	assert.NoError(t, c.ReturnDeposit("TX1"))
+	assert.Equal(t, []float32{100}, c.DepositSession.deposits)
+	assert.Equal(t, 1, len(c.DepositSession.received))
+}
+
+func TestReceiveDeposit_shouldDefaultDetails_andValidateSource(t *testing.T) {
+	tm := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	setNow(t, tm)
+	c := Customer{ID: "c1"}
+	c.StartSession()
+
+	assert.NoError(t, c.Deposit(100))
+	assert.Equal(t, DepositDetails{Source: SourceCash, Reference: "c1-1", ReceivedAt: tm}, c.received[0].DepositDetails)
+	err := c.ReceiveDeposit(100, "", DepositDetails{Source: "crypto"}, DepositCleared)
+	assert.Error(t, err)
+	assert.Equal(t, "invalid deposit source: crypto", err.Error())
+}
+
+func TestSearchDeposits_shouldMatchReferenceIgnoringCase(t *testing.T) {
+	c := newTestClearingCustomer(t)
+	c.ReceiveDeposit(50, "", DepositDetails{Reference: "tx10"}, DepositCleared)
+
+	assert.Equal(t, []*ReceivedDeposit{c.received[1], c.received[2]}, c.SearchDeposits("TX1"))
+	assert.Equal(t, []*ReceivedDeposit{c.received[2]}, c.SearchDeposits("tx10"))
+	assert.Empty(t, c.SearchDeposits("TX2"))
+}
+
+func TestReceivedDepositString_shouldShowDetails(t *testing.T) {
+	r := &ReceivedDeposit{
+		DepositDetails: DepositDetails{Source: SourceBankTransfer, Reference: "TX1", Payer: "Test One", ReceivedAt: time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)},
+		Amount:         300,
+		Currency:       "SGD",
+		Status:         DepositPending,
+	}
+	assert.Equal(t, "2020-01-01 10:00:00 TX1 bank-transfer 300.00 SGD pending from Test One", r.String())
+}
@@ -1,36 +1,163 @@
 package app
 
 import (
-	"errors"
 	"fmt"
+	"math"
+	"regexp"
+	"time"
+)
+
+// CustomerStatus is the state of the customer account
+type CustomerStatus string
+
+// Customer account statuses
+const (
+	CustomerActive CustomerStatus = "active"
+	CustomerFrozen CustomerStatus = "frozen"
+	CustomerClosed CustomerStatus = "closed"
+)
+
+// KYCStatus is the progress of the customer identity verification
+type KYCStatus string
+
+// KYC statuses
+const (
+	KYCPending  KYCStatus = "pending"
+	KYCVerified KYCStatus = "verified"
+	KYCRejected KYCStatus = "rejected"
+)
+
+const maxCustomerAge = 150
+
+var (
+	emailPattern = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+	phonePattern = regexp.MustCompile(`^\+?[0-9 -]{6,20}$`)
 )
 
 // DepositSession allows customer to perform a transaction
 type DepositSession struct {
 	depositPlans []DepositPlan
 	deposits     []float32
+	currency     string
+	flags        []string
+	received     []*ReceivedDeposit
 }
 
 // Customer is the portfolio owner
 type Customer struct {
 	ID             string
+	Name           string
+	Email          string
+	Phone          string
+	DateOfBirth    time.Time
+	KYCStatus      KYCStatus
+	Status         CustomerStatus
 	portfolios     []*Portfolio
 	DepositSession *DepositSession
+	received       []*ReceivedDeposit
+	savedPlans     []DepositPlan
+	events         *EventBus
+	ledger         *Ledger
+	fx             RateProvider
+	fees           *FeeSchedule
+	limits         *Limits
+	screener       Screener
+	clock          func() time.Time
+	targets        map[string]float64
+	goals          map[string]Goal
+
+	approvedLimit *LimitError
 }
 
-// NewCustomer instantiate a new customer with no portfolios
+// NewCustomer instantiate a new active customer with no portfolios
 func NewCustomer(id string) (Customer, error) {
 	if id == "" {
-		return Customer{}, errors.New("id is empty")
+		return Customer{}, ErrIDEmpty
+	}
+	return Customer{ID: id, KYCStatus: KYCPending, Status: CustomerActive, portfolios: []*Portfolio{}}, nil
+}
+
+// UpdateProfile validates and sets the profile fields given by name. Either all fields are
+// updated or none are.
+func (c *Customer) UpdateProfile(fields map[string]string) error {
+	if len(fields) == 0 {
+		return ErrNoFieldsToUpdate
+	}
+
+	updated := *c
+	for field, value := range fields {
+		switch field {
+		case "name":
+			if value == "" {
+				return ErrNameEmpty
+			}
+			updated.Name = value
+		case "email":
+			if !emailPattern.MatchString(value) {
+				return ErrInvalidEmail
+			}
+			updated.Email = value
+		case "phone":
+			if !phonePattern.MatchString(value) {
+				return ErrInvalidPhone
+			}
+			updated.Phone = value
+		case "dob":
+			dob, err := time.Parse("2006-01-02", value)
+			if err != nil {
+				return ErrInvalidDateOfBirth
+			}
+			today := c.now()
+			if dob.After(today) || dob.Before(today.AddDate(-maxCustomerAge, 0, 0)) {
+				return ErrInvalidDateOfBirth
+			}
+			updated.DateOfBirth = dob
+		case "kyc":
+			status := KYCStatus(value)
+			if status != KYCPending && status != KYCVerified && status != KYCRejected {
+				return ErrInvalidKYCStatus
+			}
+			updated.KYCStatus = status
+		case "status":
+			status := CustomerStatus(value)
+			if status != CustomerActive && status != CustomerFrozen && status != CustomerClosed {
+				return ErrInvalidCustomerStatus
+			}
+			if c.Status == CustomerClosed && status != CustomerClosed {
+				return &AccountInactiveError{Status: CustomerClosed}
+			}
+			updated.Status = status
+		default:
+			return fmt.Errorf("%w: %s", ErrUnknownField, field)
+		}
+	}
+
+	*c = updated
+	c.events.Publish(CustomerUpdated{Customer: c.ID, Fields: fields, Time: c.now()})
+	return nil
+}
+
+// now returns the current time of the clock the customer was attached to, or of the package clock without one
+func (c *Customer) now() time.Time {
+	if c.clock != nil {
+		return c.clock()
 	}
-	return Customer{ID: id, portfolios: []*Portfolio{}}, nil
+	return now()
+}
+
+// checkActive returns an error when the customer account cannot take deposits
+func (c *Customer) checkActive() error {
+	if c.Status == CustomerFrozen || c.Status == CustomerClosed {
+		return &AccountInactiveError{Status: c.Status}
+	}
+	return nil
 }
 
 // AddPortfolio adds portfolio after determining validity
 func (c *Customer) AddPortfolio(name string) error {
 	for _, p := range c.portfolios {
 		if p.Name == name {
-			return errors.New("portfolio with specfied name already added")
+			return ErrPortfolioExists
 		}
 	}
 
@@ -39,39 +166,136 @@ func (c *Customer) AddPortfolio(name string) error {
 		return err
 	}
 	c.portfolios = append(c.portfolios, &newP)
+	c.events.Publish(PortfolioAdded{Customer: c.ID, Portfolio: newP.Name, ProductType: newP.Product.Type, Product: newP.Product, Currency: newP.currency(), Time: c.now()})
 	return nil
 }
 
-// StartSession starts a deposit session
+// AddProductPortfolio adds portfolio held in the currency and governed by the rules of the product after
+// determining validity. A zero product adds a portfolio without rules and an empty currency uses the default.
+func (c *Customer) AddProductPortfolio(name string, product Product, currency string) error {
+	if c.findPortfolio(name) != nil {
+		return ErrPortfolioExists
+	}
+	if currency != "" {
+		if err := validateCurrency(currency); err != nil {
+			return err
+		}
+	}
+
+	var newP Portfolio
+	var err error
+	if product == (Product{}) {
+		newP, err = NewPortfolio(name)
+	} else {
+		newP, err = newProductPortfolio(name, product, c.now())
+	}
+	if err != nil {
+		return err
+	}
+	newP.Currency = currency
+	c.portfolios = append(c.portfolios, &newP)
+	c.events.Publish(PortfolioAdded{Customer: c.ID, Portfolio: newP.Name, ProductType: newP.Product.Type, Product: newP.Product, Currency: newP.currency(), Time: c.now()})
+	return nil
+}
+
+// StartSession starts a deposit session with deposits in the default currency
 func (c *Customer) StartSession() error {
+	return c.StartSessionInCurrency("")
+}
+
+// StartSessionInCurrency starts a deposit session with deposits in the specified currency
+func (c *Customer) StartSessionInCurrency(currency string) error {
+	if err := c.checkActive(); err != nil {
+		return err
+	}
 	if c.DepositSession != nil {
-		return errors.New("another transaction is still active")
+		return ErrSessionActive
+	}
+	if currency != "" {
+		if err := validateCurrency(currency); err != nil {
+			return err
+		}
 	}
-	c.DepositSession = &DepositSession{depositPlans: []DepositPlan{}, deposits: []float32{}}
+	c.DepositSession = &DepositSession{depositPlans: []DepositPlan{}, deposits: []float32{}, currency: currency}
+	c.events.Publish(SessionStarted{Customer: c.ID, Currency: currencyOrDefault(currency), Time: c.now()})
 	return nil
 }
 
 // Deposit represents the amount the customer has deposit
 func (c *Customer) Deposit(amount float32) error {
 	if c.DepositSession == nil {
-		return errors.New("no active session")
+		return ErrNoActiveSession
+	}
+	return c.DepositInCurrency(amount, c.DepositSession.currency)
+}
+
+// DepositInCurrency represents the amount the customer has deposit, which must be in the session currency
+func (c *Customer) DepositInCurrency(amount float32, currency string) error {
+	return c.ReceiveDeposit(amount, currency, DepositDetails{}, DepositCleared)
+}
+
+// ReceiveDeposit represents money received from the customer in the session currency, either cleared or
+// pending clearing. Details left empty default to cash received now, with a reference generated from the
+// customer id. A reference cannot be reused, whatever became of the deposit received with it.
+func (c *Customer) ReceiveDeposit(amount float32, currency string, details DepositDetails, status DepositStatus) error {
+	if c.DepositSession == nil {
+		return ErrNoActiveSession
+	}
+	if currencyOrDefault(currency) != currencyOrDefault(c.DepositSession.currency) {
+		return ErrSessionCurrency
 	}
 	if amount < 0 {
-		return errors.New("amount is negative")
+		return ErrAmountNegative
+	}
+	if math.IsNaN(float64(amount)) || math.IsInf(float64(amount), 0) {
+		return ErrInvalidAmount
+	}
+	if err := c.checkActive(); err != nil {
+		return err
+	}
+	if status != DepositPending && status != DepositCleared {
+		return ErrInvalidDepositStatus
+	}
+	if details.Reference == "" {
+		details.Reference = fmt.Sprintf("%s-%d", c.ID, len(c.received)+1)
+	}
+	if details.Source == "" {
+		details.Source = SourceCash
+	}
+	if details.ReceivedAt.IsZero() {
+		details.ReceivedAt = c.now()
+	}
+	if err := details.validate(); err != nil {
+		return err
+	}
+	if c.findDeposit(details.Reference) != nil {
+		return ErrDuplicateReference
 	}
+	if err := c.checkSessionDepositLimits(amount, currency); err != nil {
+		return err
+	}
+	deposits := append(append([]float32{}, c.DepositSession.deposits...), amount)
+	if err := c.screen(ScreenDeposit, amount, deposits); err != nil {
+		return err
+	}
+
 	c.DepositSession.deposits = append(c.DepositSession.deposits, amount)
+	r := &ReceivedDeposit{DepositDetails: details, Amount: amount, Currency: currencyOrDefault(currency), Status: status, session: c.DepositSession}
+	c.DepositSession.received = append(c.DepositSession.received, r)
+	c.received = append(c.received, r)
+	c.events.Publish(DepositReceived{Customer: c.ID, Reference: r.Reference, Source: r.Source, Amount: amount, Currency: r.Currency, Status: status, Time: c.now(), Payer: r.Payer, ReceivedAt: r.ReceivedAt})
 	return nil
 }
 
 // PayDepositPlan represnts the plans the customer would like to pay
 func (c *Customer) PayDepositPlan(plan DepositPlan) error {
 	if c.DepositSession == nil {
-		return errors.New("no active session")
+		return ErrNoActiveSession
 	}
 
 	for _, dp := range c.DepositSession.depositPlans {
 		if dp.Name() == plan.Name() {
-			return errors.New("duplicate plan name in session")
+			return ErrDuplicatePlanName
 		}
 	}
 
@@ -79,54 +303,412 @@ func (c *Customer) PayDepositPlan(plan DepositPlan) error {
 	return nil
 }
 
+// Withdraw takes the amount out of the named portfolio
+func (c *Customer) Withdraw(portfolio string, amount float32) error {
+	p := c.findPortfolio(portfolio)
+	if p == nil {
+		return &PortfolioNotFoundError{Name: portfolio}
+	}
+
+	if err := c.checkWithdrawalLimits(p, amount); err != nil {
+		return err
+	}
+	if err := p.withdraw(amount, c.now()); err != nil {
+		return err
+	}
+	c.record(p, EntryWithdrawal, -amount, "")
+	c.events.Publish(WithdrawalMade{Customer: c.ID, Portfolio: p.Name, Amount: amount, Currency: p.currency(), Time: c.now()})
+	return nil
+}
+
+// RenamePortfolio renames the portfolio along with the plans in the active session and the saved plans referring
+// to it
+func (c *Customer) RenamePortfolio(name string, newName string) error {
+	p := c.findPortfolio(name)
+	if p == nil {
+		return &PortfolioNotFoundError{Name: name}
+	}
+	if newName == "" {
+		return ErrInvalidName
+	}
+	if c.findPortfolio(newName) != nil {
+		return ErrPortfolioExists
+	}
+
+	if err := c.redirectPlans(name, newName); err != nil {
+		return err
+	}
+	p.Name = newName
+	c.ledger.recordRename(c.ID, name, newName)
+	c.events.Publish(PortfolioRenamed{Customer: c.ID, Portfolio: name, NewName: newName, Time: c.now()})
+	c.moveTarget(name, newName)
+	c.moveGoal(name, newName)
+	return nil
+}
+
+// ClosePortfolio closes the portfolio. Any remaining balance is moved to the target portfolio,
+// which must be given unless the balance is zero.
+func (c *Customer) ClosePortfolio(name string, target string) error {
+	p := c.findPortfolio(name)
+	if p == nil {
+		return &PortfolioNotFoundError{Name: name}
+	}
+	if p.Closed {
+		return ErrPortfolioClosed
+	}
+	if c.sessionUsesPortfolio(name) {
+		return ErrPortfolioInSession
+	}
+
+	if p.Balance != 0 {
+		if target == "" {
+			return ErrBalanceNotZero
+		}
+		if err := c.transfer(p, target); err != nil {
+			return err
+		}
+	}
+	p.Closed = true
+	c.events.Publish(PortfolioClosed{Customer: c.ID, Portfolio: p.Name, Time: c.now()})
+	if t := c.findPortfolio(target); t != nil && t != p && !t.Closed {
+		c.moveTarget(p.Name, t.Name)
+	} else {
+		c.moveTarget(p.Name, "")
+	}
+	c.moveGoal(p.Name, "")
+	return nil
+}
+
+// MergePortfolio moves the balance of the source portfolio into the target and closes the source.
+// Plans in the active session and saved plans paying into the source pay into the target instead.
+func (c *Customer) MergePortfolio(source string, target string) error {
+	p := c.findPortfolio(source)
+	if p == nil {
+		return &PortfolioNotFoundError{Name: source}
+	}
+	if p.Closed {
+		return ErrPortfolioClosed
+	}
+
+	t := c.findPortfolio(target)
+	if t == nil {
+		return ErrTargetNotFound
+	}
+	if t.Closed {
+		return ErrTargetClosed
+	}
+	if t == p {
+		return ErrMergeIntoItself
+	}
+
+	if err := c.redirectPlans(source, target); err != nil {
+		return err
+	}
+	if err := c.transfer(p, target); err != nil {
+		return err
+	}
+	p.Closed = true
+	c.events.Publish(PortfolioClosed{Customer: c.ID, Portfolio: p.Name, Time: c.now()})
+	c.moveTarget(p.Name, t.Name)
+	c.moveGoal(p.Name, "")
+	return nil
+}
+
+// transfer moves the whole balance of the portfolio to the target portfolio
+func (c *Customer) transfer(p *Portfolio, target string) error {
+	t := c.findPortfolio(target)
+	if t == nil {
+		return ErrTargetNotFound
+	}
+	if t == p {
+		return ErrTransferToSame
+	}
+	if t.Closed {
+		return ErrTargetClosed
+	}
+
+	if err := t.canDeposit(p.Balance, c.now()); err != nil {
+		return err
+	}
+	amount, err := p.withdrawAll(c.now())
+	if err != nil {
+		return err
+	}
+	if err := t.deposit(amount, c.now()); err != nil {
+		return err
+	}
+	c.recordTransfer(p, t, amount)
+	return nil
+}
+
+// recordTransfer records the amount moved from the portfolio to the target on the ledger
+func (c *Customer) recordTransfer(p *Portfolio, t *Portfolio, amount float32) {
+	c.record(p, EntryTransferOut, -amount, t.Name)
+	c.record(t, EntryTransferIn, amount, p.Name)
+	c.events.Publish(FundsTransferred{Customer: c.ID, From: p.Name, To: t.Name, Amount: amount, Currency: p.currency(), Time: c.now()})
+}
+
+// record adds an entry for the portfolio to the customer ledger
+func (c *Customer) record(p *Portfolio, entryType string, amount float32, reference string) {
+	c.ledger.Record(LedgerEntry{Time: c.now(), Customer: c.ID, Portfolio: p.Name, Type: entryType, Amount: amount, Currency: p.currency(), Reference: reference})
+}
+
+// redirectPlans makes the plans in the active session and the plans saved for the customer paying into a
+// portfolio pay into another instead
+func (c *Customer) redirectPlans(from string, to string) error {
+	saved, err := redirectPlans(c.savedPlans, from, to)
+	if err != nil {
+		return err
+	}
+	if c.DepositSession != nil {
+		plans, err := redirectPlans(c.DepositSession.depositPlans, from, to)
+		if err != nil {
+			return err
+		}
+		c.DepositSession.depositPlans = plans
+	}
+	if plansUse(c.savedPlans, from) {
+		c.savedPlans = saved
+		c.publishPlans()
+	}
+	return nil
+}
+
+// plansUse reports whether any of the plans pays into the portfolio
+func plansUse(plans []DepositPlan, portfolio string) bool {
+	for _, dp := range plans {
+		if _, ok := dp.PortfolioRatio()[portfolio]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func redirectPlans(plans []DepositPlan, from string, to string) ([]DepositPlan, error) {
+	if plans == nil {
+		return nil, nil
+	}
+	redirected := make([]DepositPlan, len(plans))
+	for i, dp := range plans {
+		if _, ok := dp.PortfolioRatio()[from]; !ok {
+			redirected[i] = dp
+			continue
+		}
+		updated, err := renamePlanPortfolio(dp, from, to)
+		if err != nil {
+			return nil, err
+		}
+		redirected[i] = updated
+	}
+	return redirected, nil
+}
+
+func (c *Customer) sessionUsesPortfolio(name string) bool {
+	if c.DepositSession == nil {
+		return false
+	}
+	for _, dp := range c.DepositSession.depositPlans {
+		if _, ok := dp.PortfolioRatio()[name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Customer) findPortfolio(name string) *Portfolio {
+	for _, p := range c.portfolios {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
 // PrintPortfolio prints the balance of the customer portfolios
 func (c *Customer) PrintPortfolio() {
 	for _, p := range c.portfolios {
-		fmt.Println(p.Name, ": ", p.Balance)
+		name := p.Name
+		if p.Product.Type != "" {
+			name += " [" + p.Product.Type + "]"
+		}
+		if p.Currency != "" {
+			name += " (" + p.Currency + ")"
+		}
+		if p.Closed {
+			fmt.Println(name, ": ", p.Balance, "(closed)")
+			continue
+		}
+		if p.uncleared > 0 {
+			fmt.Println(name, ": ", p.Balance, fmt.Sprintf("(%.2f uncleared)", p.uncleared))
+			continue
+		}
+		fmt.Println(name, ": ", p.Balance)
 	}
 }
 
+// PrintProfile prints the customer details
+func (c *Customer) PrintProfile() {
+	fmt.Println("ID:", c.ID)
+	fmt.Println("Name:", c.Name)
+	fmt.Println("Email:", c.Email)
+	fmt.Println("Phone:", c.Phone)
+	if c.DateOfBirth.IsZero() {
+		fmt.Println("Date of birth:")
+	} else {
+		fmt.Println("Date of birth:", c.DateOfBirth.Format("2006-01-02"))
+	}
+	fmt.Println("KYC status:", c.KYCStatus)
+	fmt.Println("Status:", c.Status)
+	fmt.Println("Portfolios:", len(c.portfolios))
+}
+
 // PerformDeposit split the passed in deposit into the respective portfolio
 func (c *Customer) PerformDeposit(depositPlans []DepositPlan, deposits []float32) error {
-	var totalDeposit float32
-	for _, v := range deposits {
-		totalDeposit += v
+	return c.PerformDepositInCurrency(depositPlans, deposits, "")
+}
+
+// PerformDepositInCurrency split the passed in deposit, made in the specified currency, into the respective
+// portfolio. Plans and portfolios in other currencies are converted with the customer rate provider.
+func (c *Customer) PerformDepositInCurrency(depositPlans []DepositPlan, deposits []float32, currency string) error {
+	_, err := c.performDeposit(depositPlans, deposits, currency)
+	return err
+}
+
+// performDeposit credits the portfolios and returns the credits made. The credits are validated before any is
+// made, and when one fails all the portfolios and the ledger are restored to their state before the deposit.
+func (c *Customer) performDeposit(depositPlans []DepositPlan, deposits []float32, currency string) ([]DepositCredit, error) {
+	credits, err := c.PreviewDeposit(depositPlans, deposits, currency)
+	if err != nil {
+		return nil, err
 	}
 
-	var totalNeeded float32
-	for _, dp := range depositPlans {
-		ok := true
-		for k := range dp.PortfolioRatio() {
-			var exist bool
-			for _, p := range c.portfolios {
-				if k == p.Name {
-					exist = true
-					break
-				}
-			}
-			if !exist {
-				ok = false
-				break
-			}
+	if err := c.beginDeposit(credits).credit(credits); err != nil {
+		return nil, err
+	}
+	return credits, nil
+}
+
+// depositUnit is the state of the portfolios credited by a deposit and of the ledger before the deposit, so
+// the deposit can be undone as a whole when one of its credits fails
+type depositUnit struct {
+	customer   *Customer
+	portfolios map[*Portfolio]Portfolio
+	entries    int
+	deposit    func(p *Portfolio, amount float32, at time.Time) error
+}
+
+func (c *Customer) beginDeposit(credits []DepositCredit) depositUnit {
+	u := depositUnit{customer: c, portfolios: map[*Portfolio]Portfolio{}, entries: c.ledger.size(), deposit: (*Portfolio).deposit}
+	for _, cr := range credits {
+		if p := c.findPortfolio(cr.Portfolio); p != nil {
+			u.portfolios[p] = *p
 		}
+	}
+	return u
+}
 
-		if !ok {
-			return errors.New("deposit plan does not match customer portfolio")
+// credit makes the credits to the portfolios and records them to the ledger. When one fails, the deposit is
+// rolled back.
+func (u depositUnit) credit(credits []DepositCredit) error {
+	c := u.customer
+	for _, cr := range credits {
+		p := c.findPortfolio(cr.Portfolio)
+		if err := u.deposit(p, cr.Net, c.now()); err != nil {
+			u.rollback()
+			return fmt.Errorf("%s: %w", p.Name, err)
+		}
+		if cr.Gross == 0 {
+			continue
+		}
+		c.ledger.Record(LedgerEntry{
+			Time:             c.now(),
+			Customer:         c.ID,
+			Portfolio:        p.Name,
+			Type:             EntryDeposit,
+			Amount:           cr.Gross,
+			Currency:         cr.Currency,
+			OriginalAmount:   cr.Original,
+			OriginalCurrency: cr.OriginalCurrency,
+			Reference:        cr.Plan,
+			PlanType:         cr.PlanType,
+		})
+		if cr.Fee > 0 {
+			c.record(p, EntryFee, -cr.Fee, cr.Plan)
+			c.ledger.Record(LedgerEntry{Time: c.now(), Portfolio: FeeIncomeAccount, Type: EntryFee, Amount: cr.Fee, Currency: cr.Currency, Reference: c.ID})
 		}
+	}
+	return nil
+}
 
-		totalNeeded += dp.DepositTotal()
+// rollback restores the portfolios and removes the ledger entries recorded since the deposit began
+func (u depositUnit) rollback() {
+	for p, saved := range u.portfolios {
+		*p = saved
+	}
+	u.customer.ledger.truncate(u.entries)
+}
+
+// PreviewDeposit validates the deposit and returns the credits, net of fees, it would make to each portfolio
+// without changing any balance.
+func (c *Customer) PreviewDeposit(depositPlans []DepositPlan, deposits []float32, currency string) ([]DepositCredit, error) {
+	if err := c.checkActive(); err != nil {
+		return nil, err
 	}
 
-	if totalNeeded != totalDeposit {
-		return errors.New("deposits does not match the plan amounts")
+	if err := c.validateDeposit(depositPlans, deposits, currency); err != nil {
+		return nil, err
 	}
 
+	credits := []DepositCredit{}
 	for _, dp := range depositPlans {
 		for _, p := range c.portfolios {
-			v := dp.PortfolioRatio()[p.Name]
-			p.Deposit(v)
+			v, ok := dp.PortfolioRatio()[p.Name]
+			if !ok {
+				continue
+			}
+			booked, err := convert(c.fx, v, dp.Currency(), p.currency())
+			if err != nil {
+				return nil, err
+			}
+			credits = append(credits, DepositCredit{
+				Plan:             dp.Name(),
+				PlanType:         dp.PlanType(),
+				Portfolio:        p.Name,
+				ProductType:      p.Product.Type,
+				Original:         v,
+				OriginalCurrency: dp.Currency(),
+				Gross:            booked,
+				Net:              booked,
+				Currency:         p.currency(),
+			})
 		}
 	}
 
-	return nil
+	credits, err := c.fees.apply(credits, len(deposits), currency, c.fx)
+	if err != nil {
+		return nil, err
+	}
+
+	totals := map[string]float32{}
+	for _, cr := range credits {
+		totals[cr.Portfolio] += cr.Net
+	}
+	for _, p := range c.portfolios {
+		amount, ok := totals[p.Name]
+		if !ok {
+			continue
+		}
+		if err := p.canDeposit(amount, c.now()); err != nil {
+			return nil, fmt.Errorf("%s: %w", p.Name, err)
+		}
+		if amount > 0 && p.Balance+amount < p.Product.MinimumBalance {
+			return nil, fmt.Errorf("%s: %w", p.Name, ErrBelowMinimumDeposit)
+		}
+	}
+
+	if err := c.checkDepositLimits(credits, deposits, currency); err != nil {
+		return nil, err
+	}
+
+	return credits, nil
 }
@@ -1,7 +1,9 @@
 package app
 
 import (
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -179,43 +181,111 @@ func TestPerformDeposit_shouldUpdatePortfolioBalance(t *testing.T) {
 	}
 
 	testPerformDeposit(
-		[]*Portfolio{{"Retirement", 0}},
+		[]*Portfolio{{Name: "Retirement", Balance: 0}},
 		[]DepositPlan{&baseDepositPlan{name: "Plan A", planType: "one-time", portfolioRatio: map[string]float32{"Retirement": 100}}},
 		[]float32{100},
-		[]*Portfolio{{"Retirement", 100}},
+		[]*Portfolio{{Name: "Retirement", Balance: 100}},
 	)
 	testPerformDeposit(
-		[]*Portfolio{{"Retirement", 0}, {"High Risk", 0}},
+		[]*Portfolio{{Name: "Retirement", Balance: 0}, {Name: "High Risk", Balance: 0}},
 		[]DepositPlan{&baseDepositPlan{name: "Plan A", planType: "one-time", portfolioRatio: map[string]float32{"Retirement": 100}}},
 		[]float32{100},
-		[]*Portfolio{{"Retirement", 100}, {"High Risk", 0}},
+		[]*Portfolio{{Name: "Retirement", Balance: 100}, {Name: "High Risk", Balance: 0}},
 	)
 	testPerformDeposit(
-		[]*Portfolio{{"Retirement", 0}, {"High Risk", 0}},
+		[]*Portfolio{{Name: "Retirement", Balance: 0}, {Name: "High Risk", Balance: 0}},
 		[]DepositPlan{&baseDepositPlan{name: "Plan A", planType: "one-time", portfolioRatio: map[string]float32{"Retirement": 100, "High Risk": 100}}},
 		[]float32{200},
-		[]*Portfolio{{"Retirement", 100}, {"High Risk", 100}},
+		[]*Portfolio{{Name: "Retirement", Balance: 100}, {Name: "High Risk", Balance: 100}},
 	)
 	testPerformDeposit(
-		[]*Portfolio{{"Retirement", 0}, {"High Risk", 0}},
+		[]*Portfolio{{Name: "Retirement", Balance: 0}, {Name: "High Risk", Balance: 0}},
 		[]DepositPlan{
 			&baseDepositPlan{name: "Plan A", planType: "one-time", portfolioRatio: map[string]float32{"Retirement": 100, "High Risk": 100}},
 			&baseDepositPlan{name: "Plan B", planType: "monthly", portfolioRatio: map[string]float32{"Retirement": 50, "High Risk": 100}},
 		},
 		[]float32{350},
-		[]*Portfolio{{"Retirement", 150}, {"High Risk", 200}},
+		[]*Portfolio{{Name: "Retirement", Balance: 150}, {Name: "High Risk", Balance: 200}},
 	)
 	testPerformDeposit(
-		[]*Portfolio{{"Retirement", 100}, {"High Risk", 100}},
+		[]*Portfolio{{Name: "Retirement", Balance: 100}, {Name: "High Risk", Balance: 100}},
 		[]DepositPlan{
 			&baseDepositPlan{name: "Plan A", planType: "one-time", portfolioRatio: map[string]float32{"Retirement": 100, "High Risk": 100}},
 			&baseDepositPlan{name: "Plan B", planType: "monthly", portfolioRatio: map[string]float32{"Retirement": 50, "High Risk": 100}},
 		},
 		[]float32{50, 50, 100, 100, 25, 25},
-		[]*Portfolio{{"Retirement", 250}, {"High Risk", 300}},
+		[]*Portfolio{{Name: "Retirement", Balance: 250}, {Name: "High Risk", Balance: 300}},
 	)
 }
 
+func TestPerformDeposit_shouldRollBackEveryPortfolio_givenCreditFails(t *testing.T) {
+	testPerformDeposit := func(failAt int, expectedErr string) {
+		tm := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		setNow(t, tm)
+		ledger := NewLedger()
+		ledger.Record(LedgerEntry{Time: tm, Customer: "c1", Portfolio: "Retirement", Type: EntryDeposit, Amount: 10})
+		c := Customer{
+			ID: "c1",
+			portfolios: []*Portfolio{
+				{Name: "Retirement", Balance: 10, Product: Product{Type: "retirement", AnnualCap: 1000}, contributionYear: 2020, yearContributions: 10},
+				{Name: "High Risk", Product: Product{Type: "high-risk"}},
+				{Name: "Savings", Balance: 5},
+			},
+			ledger: ledger,
+			fees:   &FeeSchedule{PercentageByProduct: map[string]float64{"high-risk": 1}},
+		}
+		credits, err := c.PreviewDeposit(
+			[]DepositPlan{
+				&baseDepositPlan{name: "Plan A", planType: "one-time", portfolioRatio: map[string]float32{"Retirement": 100, "High Risk": 100}},
+				&baseDepositPlan{name: "Plan B", planType: "one-time", portfolioRatio: map[string]float32{"Savings": 50}},
+			},
+			[]float32{250},
+			"",
+		)
+		assert.NoError(t, err)
+		unit := c.beginDeposit(credits)
+		calls := 0
+		unit.deposit = func(p *Portfolio, amount float32, at time.Time) error {
+			calls++
+			if calls == failAt {
+				return errors.New("injected failure")
+			}
+			return p.deposit(amount, at)
+		}
+		err = unit.credit(credits)
+		assert.EqualError(t, err, expectedErr)
+		assert.Equal(t, []*Portfolio{
+			{Name: "Retirement", Balance: 10, Product: Product{Type: "retirement", AnnualCap: 1000}, contributionYear: 2020, yearContributions: 10},
+			{Name: "High Risk", Product: Product{Type: "high-risk"}},
+			{Name: "Savings", Balance: 5},
+		}, c.portfolios)
+		assert.Equal(t, 1, len(ledger.entries))
+		assert.Empty(t, ledger.AccountEntries(FeeIncomeAccount))
+	}
+
+	testPerformDeposit(1, "Retirement: injected failure")
+	testPerformDeposit(2, "High Risk: injected failure")
+	testPerformDeposit(3, "Savings: injected failure")
+}
+
+func TestPerformDeposit_shouldNotCreditAnyPortfolio_givenOneCreditInvalid(t *testing.T) {
+	setNow(t, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	c := Customer{
+		portfolios: []*Portfolio{
+			{Name: "Retirement"},
+			{Name: "Capped", Product: Product{AnnualCap: 50}},
+		},
+		ledger: NewLedger(),
+	}
+	err := c.PerformDeposit(
+		[]DepositPlan{&baseDepositPlan{name: "Plan A", planType: "one-time", portfolioRatio: map[string]float32{"Retirement": 100, "Capped": 100}}},
+		[]float32{200},
+	)
+	assert.EqualError(t, err, "Capped: annual contribution cap exceeded")
+	assert.Equal(t, []*Portfolio{{Name: "Retirement"}, {Name: "Capped", Product: Product{AnnualCap: 50}}}, c.portfolios)
+	assert.Empty(t, c.ledger.entries)
+}
+
 func TestPerformDeposit_shouldReturnError_givenCustomerDoesNotHaveTheSpecifiedPortfolio(t *testing.T) {
 	testPerformDeposit := func(portfolios []*Portfolio, depositPlans []DepositPlan, deposits []float32, updatedPortfolios []*Portfolio) {
 		c := Customer{portfolios: portfolios}
@@ -226,16 +296,16 @@ func TestPerformDeposit_shouldReturnError_givenCustomerDoesNotHaveTheSpecifiedPo
 	}
 
 	testPerformDeposit(
-		[]*Portfolio{{"Retirement", 0}},
+		[]*Portfolio{{Name: "Retirement", Balance: 0}},
 		[]DepositPlan{&baseDepositPlan{name: "Plan A", planType: "one-time", portfolioRatio: map[string]float32{"Retirement1": 100}}},
 		[]float32{100},
-		[]*Portfolio{{"Retirement", 0}},
+		[]*Portfolio{{Name: "Retirement", Balance: 0}},
 	)
 	testPerformDeposit(
-		[]*Portfolio{{"Retirement", 100}, {"High Risk", 0}},
+		[]*Portfolio{{Name: "Retirement", Balance: 100}, {Name: "High Risk", Balance: 0}},
 		[]DepositPlan{&baseDepositPlan{name: "Plan A", planType: "one-time", portfolioRatio: map[string]float32{"Retirement1": 100, "High Risk": 100}}},
 		[]float32{200},
-		[]*Portfolio{{"Retirement", 100}, {"High Risk", 0}},
+		[]*Portfolio{{Name: "Retirement", Balance: 100}, {Name: "High Risk", Balance: 0}},
 	)
 }
 
@@ -249,15 +319,316 @@ func TestPerformDeposit_shouldReturnError_givenDepositAmountDoesNotMeetDepositPl
 	}
 
 	testPerformDeposit(
-		[]*Portfolio{{"Retirement", 0}},
+		[]*Portfolio{{Name: "Retirement", Balance: 0}},
 		[]DepositPlan{&baseDepositPlan{name: "Plan A", planType: "one-time", portfolioRatio: map[string]float32{"Retirement": 100}}},
 		[]float32{99},
-		[]*Portfolio{{"Retirement", 0}},
+		[]*Portfolio{{Name: "Retirement", Balance: 0}},
 	)
 	testPerformDeposit(
-		[]*Portfolio{{"Retirement", 100}},
+		[]*Portfolio{{Name: "Retirement", Balance: 100}},
 		[]DepositPlan{&baseDepositPlan{name: "Plan A", planType: "one-time", portfolioRatio: map[string]float32{"Retirement": 100}}},
 		[]float32{101},
-		[]*Portfolio{{"Retirement", 100}},
+		[]*Portfolio{{Name: "Retirement", Balance: 100}},
+	)
+}
+
+func TestCustomerWithdraw_shouldWithdrawFromPortfolio(t *testing.T) {
+	c := Customer{portfolios: []*Portfolio{{Name: "Retirement", Balance: 100}, {Name: "High Risk", Balance: 50}}}
+	err := c.Withdraw("High Risk", 20)
+	assert.NoError(t, err)
+	assert.Equal(t, []*Portfolio{{Name: "Retirement", Balance: 100}, {Name: "High Risk", Balance: 30}}, c.portfolios)
+}
+
+func TestCustomerWithdraw_shouldReturnError_givenUnknownPortfolio(t *testing.T) {
+	c := Customer{portfolios: []*Portfolio{{Name: "Retirement", Balance: 100}}}
+	err := c.Withdraw("High Risk", 20)
+	assert.Error(t, err)
+	assert.Equal(t, "portfolio not found", err.Error())
+}
+
+func TestUpdateProfile_shouldUpdateFields(t *testing.T) {
+	c, _ := NewCustomer("test")
+	err := c.UpdateProfile(map[string]string{
+		"name":  "Test One",
+		"email": "test1@example.com",
+		"phone": "+65 9123 4567",
+		"dob":   "1990-01-31",
+		"kyc":   "verified",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "Test One", c.Name)
+	assert.Equal(t, "test1@example.com", c.Email)
+	assert.Equal(t, "+65 9123 4567", c.Phone)
+	assert.Equal(t, time.Date(1990, 1, 31, 0, 0, 0, 0, time.UTC), c.DateOfBirth)
+	assert.Equal(t, KYCVerified, c.KYCStatus)
+	assert.Equal(t, CustomerActive, c.Status)
+
+	assert.NoError(t, c.UpdateProfile(map[string]string{"status": "frozen"}))
+	assert.Equal(t, CustomerFrozen, c.Status)
+}
+
+func TestUpdateProfile_shouldReturnError_givenInvalidField(t *testing.T) {
+	testUpdateProfile := func(status CustomerStatus, fields map[string]string, expectedErr string) {
+		c, _ := NewCustomer("test")
+		c.Status = status
+		expected := c
+		err := c.UpdateProfile(fields)
+		assert.Error(t, err)
+		assert.Equal(t, expectedErr, err.Error())
+		assert.Equal(t, expected, c)
+	}
+
+	testUpdateProfile(CustomerActive, map[string]string{}, "no fields to update")
+	testUpdateProfile(CustomerActive, map[string]string{"name": ""}, "name is empty")
+	testUpdateProfile(CustomerActive, map[string]string{"name": "ok", "email": "not-an-email"}, "invalid email")
+	testUpdateProfile(CustomerActive, map[string]string{"phone": "abc"}, "invalid phone")
+	testUpdateProfile(CustomerActive, map[string]string{"dob": "31-01-1990"}, "invalid date of birth")
+	testUpdateProfile(CustomerActive, map[string]string{"dob": "2999-01-01"}, "invalid date of birth")
+	testUpdateProfile(CustomerActive, map[string]string{"dob": "1800-01-01"}, "invalid date of birth")
+	testUpdateProfile(CustomerActive, map[string]string{"kyc": "maybe"}, "invalid kyc status")
+	testUpdateProfile(CustomerActive, map[string]string{"status": "dormant"}, "invalid customer status")
+	testUpdateProfile(CustomerClosed, map[string]string{"status": "active"}, "customer account is closed")
+	testUpdateProfile(CustomerActive, map[string]string{"age": "30"}, "unknown customer field: age")
+}
+
+func TestStartSession_shouldReturnError_givenCustomerNotActive(t *testing.T) {
+	testStartSession := func(status CustomerStatus, expectedErr string) {
+		c := Customer{Status: status}
+		err := c.StartSession()
+		assert.Error(t, err)
+		assert.Equal(t, expectedErr, err.Error())
+		assert.Nil(t, c.DepositSession)
+	}
+
+	testStartSession(CustomerFrozen, "customer account is frozen")
+	testStartSession(CustomerClosed, "customer account is closed")
+}
+
+func TestDeposit_shouldReturnError_givenCustomerNotActive(t *testing.T) {
+	c := Customer{Status: CustomerFrozen, DepositSession: &DepositSession{depositPlans: []DepositPlan{}, deposits: []float32{}}}
+	err := c.Deposit(100)
+	assert.Error(t, err)
+	assert.Equal(t, "customer account is frozen", err.Error())
+	assert.Empty(t, c.DepositSession.deposits)
+}
+
+func TestPerformDeposit_shouldReturnError_givenCustomerNotActive(t *testing.T) {
+	c := Customer{Status: CustomerClosed, portfolios: []*Portfolio{{Name: "Retirement", Balance: 0}}}
+	err := c.PerformDeposit(
+		[]DepositPlan{&baseDepositPlan{name: "Plan A", planType: "one-time", portfolioRatio: map[string]float32{"Retirement": 100}}},
+		[]float32{100},
+	)
+	assert.Error(t, err)
+	assert.Equal(t, "customer account is closed", err.Error())
+	assert.Equal(t, []*Portfolio{{Name: "Retirement", Balance: 0}}, c.portfolios)
+}
+
+func TestRenamePortfolio_shouldRenamePortfolioAndSessionPlans(t *testing.T) {
+	c := Customer{
+		portfolios:     []*Portfolio{{Name: "Retirement", Balance: 100}, {Name: "High Risk"}},
+		DepositSession: &DepositSession{depositPlans: []DepositPlan{&baseDepositPlan{name: "Plan A", planType: "one-time", portfolioRatio: map[string]float32{"High Risk": 100}}}},
+	}
+	err := c.RenamePortfolio("High Risk", "Growth")
+	assert.NoError(t, err)
+	assert.Equal(t, []*Portfolio{{Name: "Retirement", Balance: 100}, {Name: "Growth"}}, c.portfolios)
+	assert.Equal(t, map[string]float32{"Growth": 100}, c.DepositSession.depositPlans[0].PortfolioRatio())
+}
+
+func TestRenamePortfolio_shouldReturnError_givenInvalidName(t *testing.T) {
+	testRenamePortfolio := func(name string, newName string, expectedErr string) {
+		c := Customer{portfolios: []*Portfolio{{Name: "Retirement"}, {Name: "High Risk"}}}
+		err := c.RenamePortfolio(name, newName)
+		assert.Error(t, err)
+		assert.Equal(t, expectedErr, err.Error())
+		assert.Equal(t, []*Portfolio{{Name: "Retirement"}, {Name: "High Risk"}}, c.portfolios)
+	}
+
+	testRenamePortfolio("Missing", "Growth", "portfolio not found")
+	testRenamePortfolio("High Risk", "", "invalid name")
+	testRenamePortfolio("High Risk", "Retirement", "portfolio with specfied name already added")
+}
+
+func TestClosePortfolio_shouldClosePortfolio(t *testing.T) {
+	testClosePortfolio := func(name string, target string, expected []*Portfolio) {
+		c := Customer{portfolios: []*Portfolio{{Name: "Retirement", Balance: 100}, {Name: "Empty"}, {Name: "High Risk", Balance: 50}}}
+		err := c.ClosePortfolio(name, target)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, c.portfolios)
+	}
+
+	testClosePortfolio("Empty", "", []*Portfolio{{Name: "Retirement", Balance: 100}, {Name: "Empty", Closed: true}, {Name: "High Risk", Balance: 50}})
+	testClosePortfolio("Retirement", "High Risk", []*Portfolio{{Name: "Retirement", Closed: true}, {Name: "Empty"}, {Name: "High Risk", Balance: 150}})
+}
+
+func TestClosePortfolio_shouldReturnError_givenPortfolioCannotBeClosed(t *testing.T) {
+	testClosePortfolio := func(session *DepositSession, name string, target string, expectedErr string) {
+		portfolios := []*Portfolio{{Name: "Retirement", Balance: 100}, {Name: "Old", Closed: true}, {Name: "High Risk", Balance: 50}}
+		c := Customer{portfolios: portfolios, DepositSession: session}
+		err := c.ClosePortfolio(name, target)
+		assert.Error(t, err)
+		assert.Equal(t, expectedErr, err.Error())
+		assert.Equal(t, []*Portfolio{{Name: "Retirement", Balance: 100}, {Name: "Old", Closed: true}, {Name: "High Risk", Balance: 50}}, c.portfolios)
+	}
+
+	testClosePortfolio(nil, "Missing", "", "portfolio not found")
+	testClosePortfolio(nil, "Old", "", "portfolio is closed")
+	testClosePortfolio(nil, "Retirement", "", "portfolio balance is not zero")
+	testClosePortfolio(nil, "Retirement", "Missing", "target portfolio not found")
+	testClosePortfolio(nil, "Retirement", "Retirement", "cannot transfer to the same portfolio")
+	testClosePortfolio(nil, "Retirement", "Old", "target portfolio is closed")
+	testClosePortfolio(
+		&DepositSession{depositPlans: []DepositPlan{&baseDepositPlan{name: "Plan A", planType: "one-time", portfolioRatio: map[string]float32{"Retirement": 100}}}},
+		"Retirement", "High Risk", "portfolio is used by the active deposit session",
+	)
+}
+
+func TestMergePortfolio_shouldMoveBalanceAndSessionPlans(t *testing.T) {
+	c := Customer{
+		portfolios: []*Portfolio{{Name: "Retirement", Balance: 100}, {Name: "High Risk", Balance: 50}},
+		DepositSession: &DepositSession{depositPlans: []DepositPlan{
+			&baseDepositPlan{name: "Plan A", planType: "one-time", portfolioRatio: map[string]float32{"High Risk": 100, "Retirement": 10}},
+		}},
+	}
+	err := c.MergePortfolio("High Risk", "Retirement")
+	assert.NoError(t, err)
+	assert.Equal(t, []*Portfolio{{Name: "Retirement", Balance: 150}, {Name: "High Risk", Closed: true}}, c.portfolios)
+	assert.Equal(t, map[string]float32{"Retirement": 110}, c.DepositSession.depositPlans[0].PortfolioRatio())
+}
+
+func TestMergePortfolio_shouldReturnError_givenInvalidPortfolios(t *testing.T) {
+	testMergePortfolio := func(source string, target string, expectedErr string) {
+		c := Customer{portfolios: []*Portfolio{{Name: "Retirement", Balance: 100}, {Name: "Old", Closed: true}}}
+		err := c.MergePortfolio(source, target)
+		assert.Error(t, err)
+		assert.Equal(t, expectedErr, err.Error())
+		assert.Equal(t, []*Portfolio{{Name: "Retirement", Balance: 100}, {Name: "Old", Closed: true}}, c.portfolios)
+	}
+
+	testMergePortfolio("Missing", "Retirement", "portfolio not found")
+	testMergePortfolio("Old", "Retirement", "portfolio is closed")
+	testMergePortfolio("Retirement", "Missing", "target portfolio not found")
+	testMergePortfolio("Retirement", "Old", "target portfolio is closed")
+	testMergePortfolio("Retirement", "Retirement", "cannot merge portfolio into itself")
+}
+
+func TestPerformDeposit_shouldReturnError_givenPlanIncludesClosedPortfolio(t *testing.T) {
+	c := Customer{portfolios: []*Portfolio{{Name: "Retirement", Closed: true}}}
+	err := c.PerformDeposit(
+		[]DepositPlan{&baseDepositPlan{name: "Plan A", planType: "one-time", portfolioRatio: map[string]float32{"Retirement": 100}}},
+		[]float32{100},
+	)
+	assert.Error(t, err)
+	assert.Equal(t, "deposit plan includes closed portfolio", err.Error())
+	assert.Equal(t, []*Portfolio{{Name: "Retirement", Closed: true}}, c.portfolios)
+}
+
+func TestAddProductPortfolio_shouldAddPortfolioWithProduct(t *testing.T) {
+	opened := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	setNow(t, opened)
+	product := Product{Type: "savings", MinimumBalance: 100}
+	c := Customer{ID: "name", portfolios: []*Portfolio{{Name: "p1"}}}
+
+	err := c.AddProductPortfolio("p2", product, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []*Portfolio{{Name: "p1"}, {Name: "p2", Product: product, OpenedAt: opened}}, c.portfolios)
+
+	err = c.AddProductPortfolio("p1", product, "")
+	assert.Error(t, err)
+	assert.Equal(t, "portfolio with specfied name already added", err.Error())
+}
+
+func TestPerformDeposit_shouldReturnError_givenProductRulesBroken(t *testing.T) {
+	testPerformDeposit := func(portfolio Portfolio, depositPlans []DepositPlan, deposits []float32, expectedErr string) {
+		c := Customer{portfolios: []*Portfolio{{Name: "Other"}, &portfolio}}
+		err := c.PerformDeposit(depositPlans, deposits)
+		assert.Error(t, err)
+		assert.Equal(t, expectedErr, err.Error())
+		assert.Equal(t, float32(0), c.portfolios[0].Balance)
+	}
+
+	testPerformDeposit(
+		Portfolio{Name: "Retirement", Product: Product{Type: "retirement", AnnualCap: 100}},
+		[]DepositPlan{
+			&baseDepositPlan{name: "Plan A", planType: "one-time", portfolioRatio: map[string]float32{"Retirement": 60, "Other": 10}},
+			&baseDepositPlan{name: "Plan B", planType: "monthly", portfolioRatio: map[string]float32{"Retirement": 60}},
+		},
+		[]float32{130},
+		"Retirement: annual contribution cap exceeded",
+	)
+	testPerformDeposit(
+		Portfolio{Name: "Savings", Product: Product{Type: "savings", MinimumBalance: 100}},
+		[]DepositPlan{&baseDepositPlan{name: "Plan A", planType: "one-time", portfolioRatio: map[string]float32{"Savings": 50, "Other": 10}}},
+		[]float32{60},
+		"Savings: deposit does not meet minimum balance",
+	)
+}
+
+func TestPerformDepositInCurrency_shouldConvertToPortfolioCurrency(t *testing.T) {
+	c := Customer{
+		ID:         "c1",
+		portfolios: []*Portfolio{{Name: "Retirement"}, {Name: "US Equities", Currency: "USD"}},
+		ledger:     NewLedger(),
+		fx:         StaticRateProvider{"USD/SGD": 1.25},
+	}
+	err := c.PerformDepositInCurrency(
+		[]DepositPlan{
+			&baseDepositPlan{name: "Plan A", planType: "one-time", portfolioRatio: map[string]float32{"Retirement": 125}},
+			&baseDepositPlan{name: "Plan B", planType: "one-time", currency: "USD", portfolioRatio: map[string]float32{"US Equities": 100, "Retirement": 40}},
+		},
+		[]float32{100, 140},
+		"USD",
 	)
+	assert.NoError(t, err)
+	assert.Equal(t, float32(175), c.portfolios[0].Balance)
+	assert.Equal(t, float32(100), c.portfolios[1].Balance)
+
+	entries := c.ledger.Entries("c1")
+	assert.Len(t, entries, 3)
+	assert.Equal(t, float32(50), entries[1].Amount)
+	assert.Equal(t, "SGD", entries[1].Currency)
+	assert.Equal(t, float32(40), entries[1].OriginalAmount)
+	assert.Equal(t, "USD", entries[1].OriginalCurrency)
+}
+
+func TestPerformDepositInCurrency_shouldReturnError_givenCurrencyMismatchWithoutRates(t *testing.T) {
+	testPerformDeposit := func(portfolios []*Portfolio, depositPlans []DepositPlan, currency string, expectedErr string) {
+		c := Customer{portfolios: portfolios}
+		err := c.PerformDepositInCurrency(depositPlans, []float32{100}, currency)
+		assert.Error(t, err)
+		assert.Equal(t, expectedErr, err.Error())
+		assert.Equal(t, float32(0), c.portfolios[0].Balance)
+	}
+
+	testPerformDeposit(
+		[]*Portfolio{{Name: "Retirement"}},
+		[]DepositPlan{&baseDepositPlan{name: "Plan A", planType: "one-time", portfolioRatio: map[string]float32{"Retirement": 100}}},
+		"USD",
+		"currency mismatch: SGD to USD",
+	)
+	testPerformDeposit(
+		[]*Portfolio{{Name: "Retirement"}},
+		[]DepositPlan{&baseDepositPlan{name: "Plan A", planType: "one-time", currency: "USD", portfolioRatio: map[string]float32{"Retirement": 100}}},
+		"USD",
+		"currency mismatch: USD to SGD",
+	)
+}
+
+func TestDepositInCurrency_shouldReturnError_givenCurrencyDiffersFromSession(t *testing.T) {
+	c := Customer{}
+	assert.NoError(t, c.StartSessionInCurrency("USD"))
+	assert.NoError(t, c.DepositInCurrency(10, "USD"))
+	assert.NoError(t, c.Deposit(10))
+
+	err := c.DepositInCurrency(10, "SGD")
+	assert.Error(t, err)
+	assert.Equal(t, "deposit currency does not match session currency", err.Error())
+	assert.Equal(t, []float32{10, 10}, c.DepositSession.deposits)
+}
+
+func TestStartSessionInCurrency_shouldReturnError_givenInvalidCurrency(t *testing.T) {
+	c := Customer{}
+	err := c.StartSessionInCurrency("usd")
+	assert.Error(t, err)
+	assert.Equal(t, "invalid currency: usd", err.Error())
+	assert.Nil(t, c.DepositSession)
 }
@@ -1,41 +1,47 @@
 package app
 
-import "errors"
-
 // DepositPlan is the plan used to determine the splitting of deposits to the various portfolio
 type DepositPlan interface {
 	Name() string
 	PlanType() string
 	PortfolioRatio() map[string]float32
 	DepositTotal() float32
+	Currency() string
 }
 
 type baseDepositPlan struct {
 	name           string
 	planType       string
 	portfolioRatio map[string]float32
+	currency       string
 }
 
-func newBaseDepositPlan(name string, planType string, portfolioRatio map[string]float32) (DepositPlan, error) {
+func newBaseDepositPlan(name string, planType string, currency string, portfolioRatio map[string]float32) (DepositPlan, error) {
 	if name == "" {
-		return nil, errors.New("name cannot be empty")
+		return nil, ErrPlanNameEmpty
 	}
 
 	if planType != "monthly" && planType != "one-time" {
-		return nil, errors.New("invalid plan type")
+		return nil, ErrInvalidPlanType
+	}
+
+	if currency != "" {
+		if err := validateCurrency(currency); err != nil {
+			return nil, err
+		}
 	}
 
 	if len(portfolioRatio) == 0 {
-		return nil, errors.New("no portfolio defined")
+		return nil, ErrNoPlanPortfolio
 	}
 
 	for k, v := range portfolioRatio {
-		if k == "" || v < 0 {
-			return nil, errors.New("invalid portfolio ratio")
+		if k == "" || v <= 0 {
+			return nil, ErrInvalidPortfolioRatio
 		}
 	}
 
-	return &baseDepositPlan{name: name, planType: planType, portfolioRatio: portfolioRatio}, nil
+	return &baseDepositPlan{name: name, planType: planType, portfolioRatio: portfolioRatio, currency: currency}, nil
 }
 
 func (dp *baseDepositPlan) Name() string {
@@ -50,6 +56,10 @@ func (dp *baseDepositPlan) PortfolioRatio() map[string]float32 {
 	return dp.portfolioRatio
 }
 
+func (dp *baseDepositPlan) Currency() string {
+	return currencyOrDefault(dp.currency)
+}
+
 func (dp *baseDepositPlan) DepositTotal() float32 {
 	var sum float32
 	for _, v := range dp.portfolioRatio {
@@ -64,7 +74,7 @@ type monthlyDepositPlan struct {
 
 // NewMonthlyDepositPlan creates a new monthly deposit plan
 func NewMonthlyDepositPlan(name string, portfolioRatio map[string]float32) (DepositPlan, error) {
-	return newBaseDepositPlan(name, "monthly", portfolioRatio)
+	return newBaseDepositPlan(name, "monthly", "", portfolioRatio)
 }
 
 type onetimeDepositPlan struct {
@@ -73,5 +83,18 @@ type onetimeDepositPlan struct {
 
 // NewOneTimeDepositPlan creates a new one-time deposit plan
 func NewOneTimeDepositPlan(name string, portfolioRatio map[string]float32) (DepositPlan, error) {
-	return newBaseDepositPlan(name, "one-time", portfolioRatio)
+	return newBaseDepositPlan(name, "one-time", "", portfolioRatio)
+}
+
+// renamePlanPortfolio returns a copy of the plan with the amount for one portfolio moved to
+// another name, adding to any amount already planned for that name.
+func renamePlanPortfolio(dp DepositPlan, from string, to string) (DepositPlan, error) {
+	portfolioRatio := map[string]float32{}
+	for k, v := range dp.PortfolioRatio() {
+		if k == from {
+			k = to
+		}
+		portfolioRatio[k] += v
+	}
+	return newBaseDepositPlan(dp.Name(), dp.PlanType(), dp.Currency(), portfolioRatio)
 }
@@ -7,7 +7,7 @@ import (
 )
 
 func TestNewBaseDepositPlan_shouldReturnError_givenInvalidType(t *testing.T) {
-	dp, err := newBaseDepositPlan("TestName", "some-other-type", map[string]float32{"retirement": 100})
+	dp, err := newBaseDepositPlan("TestName", "some-other-type", "", map[string]float32{"retirement": 100})
 	assert.Error(t, err)
 	assert.Equal(t, "invalid plan type", err.Error())
 	assert.Nil(t, dp)
@@ -20,7 +20,7 @@ func TestDepositTotal_shouldReturnTotalValueOfDepositNeeded(t *testing.T) {
 	}
 
 	testDepositTotal(map[string]float32{"High risk": 10000, "Retirement": 500}, 10500)
-	testDepositTotal(map[string]float32{"High risk": 0, "Retirement": 0}, 0)
+	testDepositTotal(map[string]float32{"High risk": 0.5, "Retirement": 0.25}, 0.75)
 }
 
 func TestNewMonthlyDepositPlan_shouldCreateNewDepositPlan(t *testing.T) {
@@ -53,6 +53,8 @@ func TestNewMonthlyDepositPlan_shouldReturnError_givenInvalidPortfolioRatio(t *t
 		assert.Nil(t, dp)
 	}
 	testNewMonthlyDepositPlan(map[string]float32{"retirement": -100})
+	testNewMonthlyDepositPlan(map[string]float32{"retirement": 0})
+	testNewMonthlyDepositPlan(map[string]float32{"retirement": 100, "high risk": 0})
 	testNewMonthlyDepositPlan(map[string]float32{"": 100})
 }
 
@@ -77,3 +79,18 @@ func TestNewOneTimeDepositPlan_shouldReturnError_givenNoPortfolioGiven(t *testin
 	assert.Equal(t, "no portfolio defined", err.Error())
 	assert.Nil(t, dp)
 }
+
+func TestRenamePlanPortfolio_shouldMoveAmountToNewName(t *testing.T) {
+	testRenamePlanPortfolio := func(portfolioRatio map[string]float32, expected map[string]float32) {
+		dp, _ := NewMonthlyDepositPlan("TestName", portfolioRatio)
+		res, err := renamePlanPortfolio(dp, "a", "b")
+		assert.NoError(t, err)
+		assert.Equal(t, "TestName", res.Name())
+		assert.Equal(t, "monthly", res.PlanType())
+		assert.Equal(t, expected, res.PortfolioRatio())
+		assert.Equal(t, portfolioRatio, dp.PortfolioRatio())
+	}
+
+	testRenamePlanPortfolio(map[string]float32{"a": 100, "c": 50}, map[string]float32{"b": 100, "c": 50})
+	testRenamePlanPortfolio(map[string]float32{"a": 100, "b": 50}, map[string]float32{"b": 150})
+}
@@ -0,0 +1,325 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// CodedError is an error with a stable code, for programs reading the outcome of a command
+type CodedError interface {
+	error
+	Code() string
+}
+
+// codeUnknown is the code of errors without one of their own
+const codeUnknown = "ERROR"
+
+// codeFile is the code of errors opening or reading a file
+const codeFile = "FILE_ERROR"
+
+// ErrorCode returns the code of the error, or of the first error it wraps that has one
+func ErrorCode(err error) string {
+	var coded CodedError
+	var pathErr *os.PathError
+	switch {
+	case errors.As(err, &coded):
+		return coded.Code()
+	case errors.As(err, &pathErr):
+		return codeFile
+	}
+	return codeUnknown
+}
+
+// codeOf returns the text as a code, such as "PAYMENT_DATE" for "payment date"
+func codeOf(text string) string {
+	return strings.ToUpper(strings.NewReplacer(" ", "_", "-", "_").Replace(text))
+}
+
+// sentinelError is an error that is always the same, compared with errors.Is
+type sentinelError struct {
+	code    string
+	message string
+}
+
+func (e *sentinelError) Error() string {
+	return e.message
+}
+
+func (e *sentinelError) Code() string {
+	return e.code
+}
+
+// Errors returned by commands, compared with errors.Is
+var (
+	ErrInvalidCommand       = &sentinelError{code: "INVALID_COMMAND", message: "invalid command"}
+	ErrInvalidArgs          = &sentinelError{code: "INVALID_ARGS", message: "invalid number of args"}
+	ErrNotLoggedIn          = &sentinelError{code: "NOT_LOGGED_IN", message: "not logged in"}
+	ErrPermissionDenied     = &sentinelError{code: "PERMISSION_DENIED", message: "permission denied"}
+	ErrNoActiveCustomer     = &sentinelError{code: "NO_ACTIVE_CUSTOMER", message: "no active customer"}
+	ErrCustomerNotFound     = &sentinelError{code: "CUSTOMER_NOT_FOUND", message: "customer not found"}
+	ErrCustomerExists       = &sentinelError{code: "CUSTOMER_EXISTS", message: "customer already exists"}
+	ErrNoActiveSession      = &sentinelError{code: "NO_ACTIVE_SESSION", message: "no active session"}
+	ErrPortfolioExists      = &sentinelError{code: "PORTFOLIO_EXISTS", message: "portfolio with specfied name already added"}
+	ErrPortfolioClosed      = &sentinelError{code: "PORTFOLIO_CLOSED", message: "portfolio is closed"}
+	ErrAmountNegative       = &sentinelError{code: "AMOUNT_NEGATIVE", message: "amount is negative"}
+	ErrInsufficientBalance  = &sentinelError{code: "INSUFFICIENT_BALANCE", message: "withdrawal amount more than balance"}
+	ErrInsufficientCleared  = &sentinelError{code: "INSUFFICIENT_CLEARED_BALANCE", message: "withdrawal amount more than cleared balance"}
+	ErrDepositNotFound      = &sentinelError{code: "DEPOSIT_NOT_FOUND", message: "deposit not found"}
+	ErrEmptySession         = &sentinelError{code: "EMPTY_SESSION", message: "deposit session is empty"}
+	ErrNoDepositPlan        = &sentinelError{code: "NO_DEPOSIT_PLAN", message: "no deposit plan selected"}
+	ErrPlanPortfolioUnknown = &sentinelError{code: "PLAN_PORTFOLIO_UNKNOWN", message: "deposit plan does not match customer portfolio"}
+	ErrPlanPortfolioClosed  = &sentinelError{code: "PLAN_PORTFOLIO_CLOSED", message: "deposit plan includes closed portfolio"}
+	ErrPlanTotalZero        = &sentinelError{code: "PLAN_TOTAL_ZERO", message: "deposit plan total is zero"}
+	ErrNoTargetAllocation   = &sentinelError{code: "NO_TARGET_ALLOCATION", message: "no target allocation set"}
+)
+
+// Errors about customers and their portfolios, compared with errors.Is
+var (
+	ErrIDEmpty                 = &sentinelError{code: "ID_EMPTY", message: "id is empty"}
+	ErrNameEmpty               = &sentinelError{code: "NAME_EMPTY", message: "name is empty"}
+	ErrInvalidName             = &sentinelError{code: "INVALID_NAME", message: "invalid name"}
+	ErrNoFieldsToUpdate        = &sentinelError{code: "NO_FIELDS_TO_UPDATE", message: "no fields to update"}
+	ErrUnknownField            = &sentinelError{code: "UNKNOWN_FIELD", message: "unknown customer field"}
+	ErrInvalidEmail            = &sentinelError{code: "INVALID_EMAIL", message: "invalid email"}
+	ErrInvalidPhone            = &sentinelError{code: "INVALID_PHONE", message: "invalid phone"}
+	ErrInvalidDateOfBirth      = &sentinelError{code: "INVALID_DATE_OF_BIRTH", message: "invalid date of birth"}
+	ErrInvalidKYCStatus        = &sentinelError{code: "INVALID_KYC_STATUS", message: "invalid kyc status"}
+	ErrInvalidCustomerStatus   = &sentinelError{code: "INVALID_CUSTOMER_STATUS", message: "invalid customer status"}
+	ErrPortfolioInSession      = &sentinelError{code: "PORTFOLIO_IN_SESSION", message: "portfolio is used by the active deposit session"}
+	ErrBalanceNotZero          = &sentinelError{code: "BALANCE_NOT_ZERO", message: "portfolio balance is not zero"}
+	ErrTargetNotFound          = &sentinelError{code: "TARGET_PORTFOLIO_NOT_FOUND", message: "target portfolio not found"}
+	ErrTargetClosed            = &sentinelError{code: "TARGET_PORTFOLIO_CLOSED", message: "target portfolio is closed"}
+	ErrMergeIntoItself         = &sentinelError{code: "MERGE_INTO_ITSELF", message: "cannot merge portfolio into itself"}
+	ErrTransferToSame          = &sentinelError{code: "TRANSFER_TO_SAME_PORTFOLIO", message: "cannot transfer to the same portfolio"}
+	ErrMinimumBalance          = &sentinelError{code: "MINIMUM_BALANCE", message: "withdrawal would breach minimum balance"}
+	ErrBelowMinimumDeposit     = &sentinelError{code: "BELOW_MINIMUM_DEPOSIT", message: "deposit does not meet minimum balance"}
+	ErrContributionCapExceeded = &sentinelError{code: "CONTRIBUTION_CAP_EXCEEDED", message: "annual contribution cap exceeded"}
+	ErrUnknownProductType      = &sentinelError{code: "UNKNOWN_PRODUCT_TYPE", message: "unknown product type"}
+	ErrInvalidGoalAmount       = &sentinelError{code: "INVALID_GOAL_AMOUNT", message: "invalid goal amount"}
+	ErrGoalDateInPast          = &sentinelError{code: "GOAL_DATE_IN_PAST", message: "goal date is in the past"}
+	ErrNoGoal                  = &sentinelError{code: "NO_GOAL", message: "no goal set on portfolio"}
+	ErrInvalidTargetPercentage = &sentinelError{code: "INVALID_TARGET_PERCENTAGE", message: "invalid target percentage"}
+	ErrTargetNotTotal          = &sentinelError{code: "TARGET_NOT_100_PERCENT", message: "target allocation must total 100%"}
+	ErrInvalidTolerance        = &sentinelError{code: "INVALID_TOLERANCE", message: "invalid tolerance"}
+	ErrMixedCurrencies         = &sentinelError{code: "MIXED_CURRENCIES", message: "cannot rebalance portfolios held in different currencies"}
+	ErrNoRebalance             = &sentinelError{code: "NO_REBALANCE", message: "no rebalance to confirm"}
+	ErrBalancesChanged         = &sentinelError{code: "BALANCES_CHANGED", message: "balances changed since the rebalance was proposed"}
+	ErrInvalidMonths           = &sentinelError{code: "INVALID_MONTHS", message: "invalid number of months"}
+	ErrNoRate                  = &sentinelError{code: "NO_RATE", message: "no rate"}
+)
+
+// Errors about deposits, plans and their review, compared with errors.Is
+var (
+	ErrSessionActive            = &sentinelError{code: "SESSION_ACTIVE", message: "another transaction is still active"}
+	ErrCustomerInSession        = &sentinelError{code: "CUSTOMER_IN_SESSION", message: "customer has an active session"}
+	ErrSessionCurrency          = &sentinelError{code: "SESSION_CURRENCY_MISMATCH", message: "deposit currency does not match session currency"}
+	ErrInvalidAmount            = &sentinelError{code: "INVALID_AMOUNT", message: "invalid amount"}
+	ErrAmountNotPositive        = &sentinelError{code: "AMOUNT_NOT_POSITIVE", message: "deposit amount must be positive"}
+	ErrInvalidDepositStatus     = &sentinelError{code: "INVALID_DEPOSIT_STATUS", message: "invalid deposit status"}
+	ErrDuplicateReference       = &sentinelError{code: "DUPLICATE_REFERENCE", message: "duplicate deposit reference"}
+	ErrDuplicatePlanName        = &sentinelError{code: "DUPLICATE_PLAN_NAME", message: "duplicate plan name in session"}
+	ErrPlanNameEmpty            = &sentinelError{code: "PLAN_NAME_EMPTY", message: "name cannot be empty"}
+	ErrInvalidPlanType          = &sentinelError{code: "INVALID_PLAN_TYPE", message: "invalid plan type"}
+	ErrNoPlanPortfolio          = &sentinelError{code: "NO_PLAN_PORTFOLIO", message: "no portfolio defined"}
+	ErrInvalidPortfolioRatio    = &sentinelError{code: "INVALID_PORTFOLIO_RATIO", message: "invalid portfolio ratio"}
+	ErrPaymentMatchesPlans      = &sentinelError{code: "PAYMENT_MATCHES_PLANS", message: "payment matches more than one plan"}
+	ErrPaymentUnmatched         = &sentinelError{code: "PAYMENT_UNMATCHED", message: "payment does not match any plan"}
+	ErrReferenceAmbiguous       = &sentinelError{code: "REFERENCE_AMBIGUOUS", message: "reference matches more than one customer"}
+	ErrReferenceUnmatched       = &sentinelError{code: "REFERENCE_UNMATCHED", message: "reference does not match any customer"}
+	ErrReviewNotFound           = &sentinelError{code: "REVIEW_NOT_FOUND", message: "review not found"}
+	ErrNoPendingApproval        = &sentinelError{code: "NO_PENDING_APPROVAL", message: "no command pending approval"}
+	ErrApprovalForOther         = &sentinelError{code: "APPROVAL_FOR_OTHER_CUSTOMER", message: "pending approval is for another customer"}
+	ErrNoReconciliation         = &sentinelError{code: "NO_RECONCILIATION", message: "no reconciliation in progress"}
+	ErrTransactionBeforeOpening = &sentinelError{code: "TRANSACTION_BEFORE_OPENING", message: "transaction before opening balance"}
+	ErrStatementLineNotFound    = &sentinelError{code: "STATEMENT_LINE_NOT_FOUND", message: "statement line not found"}
+	ErrUnmatchedNotFound        = &sentinelError{code: "UNMATCHED_DEPOSIT_NOT_FOUND", message: "unmatched deposit not found"}
+)
+
+// Errors about operators, webhooks and the records kept, compared with errors.Is
+var (
+	ErrInvalidRole           = &sentinelError{code: "INVALID_ROLE", message: "invalid role"}
+	ErrPasswordEmpty         = &sentinelError{code: "PASSWORD_EMPTY", message: "password is empty"}
+	ErrOperatorExists        = &sentinelError{code: "OPERATOR_EXISTS", message: "operator with specified id already added"}
+	ErrInvalidOperatorRecord = &sentinelError{code: "INVALID_OPERATOR_RECORD", message: "invalid operator record"}
+	ErrInvalidCredentials    = &sentinelError{code: "INVALID_CREDENTIALS", message: "invalid operator id or password"}
+	ErrInvalidToken          = &sentinelError{code: "INVALID_TOKEN", message: "invalid token"}
+	ErrInvalidWebhookURL     = &sentinelError{code: "INVALID_WEBHOOK_URL", message: "invalid webhook url"}
+	ErrWebhookSecretEmpty    = &sentinelError{code: "WEBHOOK_SECRET_EMPTY", message: "webhook secret is empty"}
+	ErrWebhookNotFound       = &sentinelError{code: "WEBHOOK_NOT_FOUND", message: "webhook not found"}
+	ErrDeadLetterNotFound    = &sentinelError{code: "DEAD_LETTER_NOT_FOUND", message: "dead letter not found"}
+	ErrUnexpectedStatus      = &sentinelError{code: "UNEXPECTED_STATUS", message: "unexpected status"}
+	ErrUnknownEventType      = &sentinelError{code: "UNKNOWN_EVENT_TYPE", message: "unknown event type"}
+	ErrCorruptedAuditLog     = &sentinelError{code: "CORRUPTED_AUDIT_LOG", message: "corrupted audit log"}
+	ErrRebuildMismatch       = &sentinelError{code: "REBUILD_MISMATCH", message: "rebuilt state does not match live state"}
+)
+
+// PortfolioNotFoundError is returned when the customer has no portfolio with the name
+type PortfolioNotFoundError struct {
+	Name string
+}
+
+func (e *PortfolioNotFoundError) Error() string {
+	return "portfolio not found"
+}
+
+func (e *PortfolioNotFoundError) Code() string {
+	return "PORTFOLIO_NOT_FOUND"
+}
+
+// AmountMismatchError is returned when the deposits received do not add up to what the plans expect
+type AmountMismatchError struct {
+	Expected float32
+	Received float32
+}
+
+func (e *AmountMismatchError) Error() string {
+	return "deposits does not match the plan amounts"
+}
+
+func (e *AmountMismatchError) Code() string {
+	return "AMOUNT_MISMATCH"
+}
+
+// CurrencyMismatchError is returned when an amount cannot be converted for lack of exchange rates
+type CurrencyMismatchError struct {
+	From string
+	To   string
+}
+
+func (e *CurrencyMismatchError) Error() string {
+	return fmt.Sprintf("currency mismatch: %s to %s", e.From, e.To)
+}
+
+func (e *CurrencyMismatchError) Code() string {
+	return "CURRENCY_MISMATCH"
+}
+
+// NotConfiguredError is returned by commands needing a feature the app was started without
+type NotConfiguredError struct {
+	Feature string
+}
+
+func (e *NotConfiguredError) Error() string {
+	return e.Feature + " not configured"
+}
+
+func (e *NotConfiguredError) Code() string {
+	return codeOf(e.Feature) + "_NOT_CONFIGURED"
+}
+
+// MissingArgumentError is returned when a command is run without an argument it needs
+type MissingArgumentError struct {
+	Name string
+}
+
+func (e *MissingArgumentError) Error() string {
+	return e.Name + " not specified"
+}
+
+func (e *MissingArgumentError) Code() string {
+	return codeOf(e.Name) + "_NOT_SPECIFIED"
+}
+
+// InvalidValueError is returned when a value given in a command or read from a file cannot be used
+type InvalidValueError struct {
+	Field string
+	Value string
+}
+
+func (e *InvalidValueError) Error() string {
+	return "invalid " + e.Field + ": " + e.Value
+}
+
+func (e *InvalidValueError) Code() string {
+	return "INVALID_" + codeOf(e.Field)
+}
+
+// ConfigError is returned when the rules the app is started with are not valid
+type ConfigError struct {
+	Message string
+}
+
+func (e *ConfigError) Error() string {
+	return e.Message
+}
+
+func (e *ConfigError) Code() string {
+	return "INVALID_CONFIG"
+}
+
+// AccountInactiveError is returned when a frozen or closed customer account is used
+type AccountInactiveError struct {
+	Status CustomerStatus
+}
+
+func (e *AccountInactiveError) Error() string {
+	return "customer account is " + string(e.Status)
+}
+
+func (e *AccountInactiveError) Code() string {
+	return "ACCOUNT_" + codeOf(string(e.Status))
+}
+
+// LockedInError is returned when a portfolio is withdrawn from before its lock-in period ends
+type LockedInError struct {
+	Until time.Time
+}
+
+func (e *LockedInError) Error() string {
+	return "portfolio is locked in until " + e.Until.Format("2006-01-02")
+}
+
+func (e *LockedInError) Code() string {
+	return "PORTFOLIO_LOCKED_IN"
+}
+
+// AlreadySettledError is returned when a deposit or review is acted on after it was settled
+type AlreadySettledError struct {
+	Item   string
+	Status string
+}
+
+func (e *AlreadySettledError) Error() string {
+	return e.Item + " already " + e.Status
+}
+
+func (e *AlreadySettledError) Code() string {
+	return codeOf(e.Item) + "_ALREADY_" + codeOf(e.Status)
+}
+
+// FriendlyMessage returns the message shown to the operator for the error
+func FriendlyMessage(err error) string {
+	var notFound *PortfolioNotFoundError
+	var mismatch *AmountMismatchError
+	var validation *DepositValidationError
+	switch {
+	case errors.As(err, &validation) && len(validation.Problems) > 1:
+		lines := []string{"The deposit cannot be completed:"}
+		for _, p := range validation.Problems {
+			lines = append(lines, "  - "+p.String())
+		}
+		return strings.Join(lines, "\n")
+	case errors.As(err, &notFound):
+		return fmt.Sprintf("Portfolio %q not found.", notFound.Name)
+	case errors.As(err, &mismatch):
+		return fmt.Sprintf("Deposits received total %.2f but the plans need %.2f.", mismatch.Received, mismatch.Expected)
+	case errors.Is(err, ErrInvalidCommand):
+		return "Unknown command, type \"help\" for the list of commands."
+	case errors.Is(err, ErrInvalidArgs):
+		return "Wrong arguments for the command, type \"help\" for its usage."
+	case errors.Is(err, ErrNotLoggedIn):
+		return "Log in first with \"login <operator> <password>\"."
+	case errors.Is(err, ErrPermissionDenied):
+		return "You are not allowed to run this command."
+	case errors.Is(err, ErrNoActiveCustomer):
+		return "No customer selected, select one with \"selectcustomer <id>\" or create one with \"newcustomer <id>\" first."
+	case errors.Is(err, ErrNoActiveSession):
+		return "No deposit session in progress, start one with \"startDeposit\"."
+	case errors.Is(err, ErrNoTargetAllocation):
+		return "No target allocation set, set one with \"settarget <portfolio> <percent>...\"."
+	}
+	return err.Error()
+}
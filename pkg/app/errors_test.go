@@ -0,0 +1,136 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"bitbucket.org/leeyousheng/account-deposit-server/pkg/cli"
+)
+
+func TestErrorCode_shouldReturnCode(t *testing.T) {
+	testErrorCode := func(err error, expected string) {
+		assert.Equal(t, expected, ErrorCode(err))
+	}
+
+	testErrorCode(ErrNoActiveCustomer, "NO_ACTIVE_CUSTOMER")
+	testErrorCode(fmt.Errorf("Retirement: %w", ErrPortfolioClosed), "PORTFOLIO_CLOSED")
+	testErrorCode(&PortfolioNotFoundError{Name: "Retirement"}, "PORTFOLIO_NOT_FOUND")
+	testErrorCode(&NotConfiguredError{Feature: "audit log"}, "AUDIT_LOG_NOT_CONFIGURED")
+	testErrorCode(&LimitError{Rule: LimitRule{Operation: OperationDeposit, Period: PeriodDaily, Scope: ScopeCustomer}}, "LIMIT_DEPOSIT_DAILY_CUSTOMER_HARD")
+	testErrorCode(&DepositValidationError{Problems: []DepositProblem{{Err: ErrEmptySession}}}, "DEPOSIT_INVALID")
+	testErrorCode(cli.ErrNoCommand, "NO_COMMAND")
+	testErrorCode(&MissingArgumentError{Name: "deposit reference"}, "DEPOSIT_REFERENCE_NOT_SPECIFIED")
+	testErrorCode(&InvalidValueError{Field: "payment date", Value: "x"}, "INVALID_PAYMENT_DATE")
+	testErrorCode(&AccountInactiveError{Status: CustomerFrozen}, "ACCOUNT_FROZEN")
+	testErrorCode(&LockedInError{Until: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)}, "PORTFOLIO_LOCKED_IN")
+	testErrorCode(&AlreadySettledError{Item: "review", Status: "approved"}, "REVIEW_ALREADY_APPROVED")
+	testErrorCode(&ConfigError{Message: "invalid tolerance"}, "INVALID_CONFIG")
+	testErrorCode(fmt.Errorf("%w for USD/EUR", ErrNoRate), "NO_RATE")
+	testErrorCode(&os.PathError{Op: "open", Path: "missing.csv", Err: os.ErrNotExist}, "FILE_ERROR")
+	testErrorCode(errors.New("failed"), "ERROR")
+}
+
+func TestDepositValidationError_shouldMatchProblems(t *testing.T) {
+	err := error(&DepositValidationError{Problems: []DepositProblem{
+		{Plan: "Plan A", Portfolio: "Growth", Err: ErrPlanPortfolioUnknown},
+		{Err: &AmountMismatchError{Expected: 150, Received: 100}},
+	}})
+
+	assert.True(t, errors.Is(err, ErrPlanPortfolioUnknown))
+	assert.False(t, errors.Is(err, ErrEmptySession))
+	var mismatch *AmountMismatchError
+	assert.True(t, errors.As(err, &mismatch))
+	assert.Equal(t, &AmountMismatchError{Expected: 150, Received: 100}, mismatch)
+}
+
+func TestApp_shouldReturnTypedErrors(t *testing.T) {
+	app := NewApp()
+	_, err := app.processInput("addportfolio Retirement")
+	assert.True(t, errors.Is(err, ErrNoActiveCustomer))
+
+	_, err = app.processInput("newcustomer test1")
+	assert.NoError(t, err)
+	_, err = app.processInput("withdraw Growth 10")
+	var notFound *PortfolioNotFoundError
+	assert.True(t, errors.As(err, &notFound))
+	assert.Equal(t, "Growth", notFound.Name)
+
+	_, err = app.processInput("cleardeposit")
+	assert.Equal(t, "DEPOSIT_REFERENCE_NOT_SPECIFIED", ErrorCode(err))
+	_, err = app.processInput("updatecustomer --status frozen")
+	assert.NoError(t, err)
+	_, err = app.processInput("startDeposit")
+	assert.Equal(t, "ACCOUNT_FROZEN", ErrorCode(err))
+
+	_, err = app.processInput("")
+	assert.NoError(t, err)
+}
+
+func TestFriendlyMessage_shouldDescribeError(t *testing.T) {
+	testFriendlyMessage := func(err error, expected string) {
+		assert.Equal(t, expected, FriendlyMessage(err))
+	}
+
+	testFriendlyMessage(ErrNoActiveCustomer, "No customer selected, select one with \"selectcustomer <id>\" or create one with \"newcustomer <id>\" first.")
+	testFriendlyMessage(&PortfolioNotFoundError{Name: "Growth"}, "Portfolio \"Growth\" not found.")
+	testFriendlyMessage(&DepositValidationError{Problems: []DepositProblem{{Err: &AmountMismatchError{Expected: 150, Received: 100}}}},
+		"Deposits received total 100.00 but the plans need 150.00.")
+	testFriendlyMessage(&DepositValidationError{Problems: []DepositProblem{
+		{Plan: "Plan A", Portfolio: "Growth", Err: ErrPlanPortfolioUnknown},
+		{Plan: "Plan B", Err: ErrPlanTotalZero},
+		{Err: &AmountMismatchError{Expected: 150, Received: 100}},
+	}}, "The deposit cannot be completed:\n"+
+		"  - deposit plan does not match customer portfolio (plan Plan A, portfolio Growth)\n"+
+		"  - deposit plan total is zero (plan Plan B)\n"+
+		"  - deposits does not match the plan amounts")
+	testFriendlyMessage(errors.New("failed"), "failed")
+}
+
+func TestPackageErrors_shouldHaveCode(t *testing.T) {
+	for _, dir := range []string{".", "../cli"} {
+		files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+		assert.NoError(t, err)
+		fset := token.NewFileSet()
+		for _, path := range files {
+			if strings.HasSuffix(path, "_test.go") {
+				continue
+			}
+			f, err := parser.ParseFile(fset, path, nil, 0)
+			assert.NoError(t, err)
+			ast.Inspect(f, func(n ast.Node) bool {
+				call, ok := n.(*ast.CallExpr)
+				if !ok {
+					return true
+				}
+				sel, ok := call.Fun.(*ast.SelectorExpr)
+				if !ok {
+					return true
+				}
+				pkg, ok := sel.X.(*ast.Ident)
+				if !ok {
+					return true
+				}
+				switch {
+				case pkg.Name == "errors" && sel.Sel.Name == "New":
+					t.Errorf("%s: error without a code", fset.Position(call.Pos()))
+				case pkg.Name == "fmt" && sel.Sel.Name == "Errorf":
+					format, ok := call.Args[0].(*ast.BasicLit)
+					if !ok || !strings.Contains(format.Value, "%w") {
+						t.Errorf("%s: error wrapping no error with a code", fset.Position(call.Pos()))
+					}
+				}
+				return true
+			})
+		}
+	}
+}
@@ -0,0 +1,655 @@
+package app
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Event types
+const (
+	EventCustomerCreated     = "CustomerCreated"
+	EventPortfolioAdded      = "PortfolioAdded"
+	EventSessionStarted      = "SessionStarted"
+	EventDepositReceived     = "DepositReceived"
+	EventSessionCommitted    = "SessionCommitted"
+	EventWithdrawalMade      = "WithdrawalMade"
+	EventCustomerUpdated     = "CustomerUpdated"
+	EventPortfolioRenamed    = "PortfolioRenamed"
+	EventPortfolioClosed     = "PortfolioClosed"
+	EventFundsTransferred    = "FundsTransferred"
+	EventInterestPaid        = "InterestPaid"
+	EventFundsCleared        = "FundsCleared"
+	EventFundsReturned       = "FundsReturned"
+	EventTargetAllocationSet = "TargetAllocationSet"
+	EventGoalsSet            = "GoalsSet"
+	EventPlansSaved          = "PlansSaved"
+	EventDepositReconciled   = "DepositReconciled"
+)
+
+// asyncQueueSize is the number of events an async subscriber can fall behind by before publishing blocks
+const asyncQueueSize = 100
+
+// Event is something that happened to a customer
+type Event interface {
+	Type() string
+	CustomerID() string
+	OccurredAt() time.Time
+}
+
+// CustomerCreated is emitted when a customer is added to the app
+type CustomerCreated struct {
+	Customer string    `json:"customer"`
+	Time     time.Time `json:"time"`
+}
+
+// PortfolioAdded is emitted when a portfolio is added to a customer
+type PortfolioAdded struct {
+	Customer    string    `json:"customer"`
+	Portfolio   string    `json:"portfolio"`
+	ProductType string    `json:"productType,omitempty"`
+	Product     Product   `json:"product"`
+	Currency    string    `json:"currency"`
+	Time        time.Time `json:"time"`
+}
+
+// SessionStarted is emitted when a customer starts a deposit session
+type SessionStarted struct {
+	Customer string    `json:"customer"`
+	Currency string    `json:"currency"`
+	Time     time.Time `json:"time"`
+}
+
+// DepositReceived is emitted when money is received in a deposit session
+type DepositReceived struct {
+	Customer  string        `json:"customer"`
+	Reference string        `json:"reference"`
+	Source    string        `json:"source"`
+	Amount    float32       `json:"amount"`
+	Currency  string        `json:"currency"`
+	Status    DepositStatus `json:"status"`
+	Time      time.Time     `json:"time"`
+
+	Payer      string    `json:"payer,omitempty"`
+	ReceivedAt time.Time `json:"receivedAt"`
+}
+
+// SessionCommitted is emitted when the deposits of a session are credited to the portfolios
+type SessionCommitted struct {
+	Customer string          `json:"customer"`
+	Currency string          `json:"currency"`
+	Deposits []float32       `json:"deposits"`
+	Credits  []DepositCredit `json:"credits"`
+	// Held is the part of the credits made from pending funds, which cannot be withdrawn until cleared
+	Held []PortfolioAmount `json:"held,omitempty"`
+	Time time.Time         `json:"time"`
+	// References is the deposits received in the session, which the credits were made from
+	References []string `json:"references,omitempty"`
+}
+
+// WithdrawalMade is emitted when money is taken out of a portfolio
+type WithdrawalMade struct {
+	Customer  string    `json:"customer"`
+	Portfolio string    `json:"portfolio"`
+	Amount    float32   `json:"amount"`
+	Currency  string    `json:"currency"`
+	Time      time.Time `json:"time"`
+}
+
+// CustomerUpdated is emitted when fields of the customer profile are changed
+type CustomerUpdated struct {
+	Customer string            `json:"customer"`
+	Fields   map[string]string `json:"fields"`
+	Time     time.Time         `json:"time"`
+}
+
+// PortfolioRenamed is emitted when a portfolio is given a new name
+type PortfolioRenamed struct {
+	Customer  string    `json:"customer"`
+	Portfolio string    `json:"portfolio"`
+	NewName   string    `json:"newName"`
+	Time      time.Time `json:"time"`
+}
+
+// PortfolioClosed is emitted when a portfolio is closed
+type PortfolioClosed struct {
+	Customer  string    `json:"customer"`
+	Portfolio string    `json:"portfolio"`
+	Time      time.Time `json:"time"`
+}
+
+// FundsTransferred is emitted when the balance of a portfolio is moved to another
+type FundsTransferred struct {
+	Customer string    `json:"customer"`
+	From     string    `json:"from"`
+	To       string    `json:"to"`
+	Amount   float32   `json:"amount"`
+	Currency string    `json:"currency"`
+	Time     time.Time `json:"time"`
+}
+
+// InterestPaid is emitted when accrued interest is capitalised into a portfolio
+type InterestPaid struct {
+	Customer  string    `json:"customer"`
+	Portfolio string    `json:"portfolio"`
+	Amount    float32   `json:"amount"`
+	Currency  string    `json:"currency"`
+	Time      time.Time `json:"time"`
+}
+
+// FundsCleared is emitted when the funds of a pending deposit clear, releasing them for withdrawal
+type FundsCleared struct {
+	Customer  string            `json:"customer"`
+	Reference string            `json:"reference"`
+	Released  []PortfolioAmount `json:"released,omitempty"`
+	Time      time.Time         `json:"time"`
+}
+
+// FundsReturned is emitted when the funds of a pending deposit are returned, reversing the credits made from them
+type FundsReturned struct {
+	Customer  string            `json:"customer"`
+	Reference string            `json:"reference"`
+	Reversed  []PortfolioAmount `json:"reversed,omitempty"`
+	Time      time.Time         `json:"time"`
+}
+
+// TargetAllocationSet is emitted when the target allocation of a customer is set or changed along with its
+// portfolios. Targets is empty when the target allocation is cleared.
+type TargetAllocationSet struct {
+	Customer string             `json:"customer"`
+	Targets  map[string]float64 `json:"targets"`
+	Time     time.Time          `json:"time"`
+}
+
+// GoalsSet is emitted when a goal of a customer is set, removed or follows its portfolio. Goals is empty when the
+// customer has no goal left.
+type GoalsSet struct {
+	Customer string          `json:"customer"`
+	Goals    map[string]Goal `json:"goals"`
+	Time     time.Time       `json:"time"`
+}
+
+// PlansSaved is emitted when the monthly plans saved for a customer are changed. Plans is empty when the
+// customer has no plan left.
+type PlansSaved struct {
+	Customer string         `json:"customer"`
+	Plans    []PlanSnapshot `json:"plans"`
+	Time     time.Time      `json:"time"`
+}
+
+// DepositReconciled is emitted when a received deposit is matched to a bank statement line
+type DepositReconciled struct {
+	Customer  string    `json:"customer"`
+	Reference string    `json:"reference"`
+	Time      time.Time `json:"time"`
+}
+
+// PortfolioAmount is an amount of money in a portfolio
+type PortfolioAmount struct {
+	Portfolio string  `json:"portfolio"`
+	Amount    float32 `json:"amount"`
+}
+
+// eventTypes returns a new event of each type, for records to be decoded into
+var eventTypes = map[string]func() Event{
+	EventCustomerCreated:     func() Event { return &CustomerCreated{} },
+	EventPortfolioAdded:      func() Event { return &PortfolioAdded{} },
+	EventSessionStarted:      func() Event { return &SessionStarted{} },
+	EventDepositReceived:     func() Event { return &DepositReceived{} },
+	EventSessionCommitted:    func() Event { return &SessionCommitted{} },
+	EventWithdrawalMade:      func() Event { return &WithdrawalMade{} },
+	EventCustomerUpdated:     func() Event { return &CustomerUpdated{} },
+	EventPortfolioRenamed:    func() Event { return &PortfolioRenamed{} },
+	EventPortfolioClosed:     func() Event { return &PortfolioClosed{} },
+	EventFundsTransferred:    func() Event { return &FundsTransferred{} },
+	EventInterestPaid:        func() Event { return &InterestPaid{} },
+	EventFundsCleared:        func() Event { return &FundsCleared{} },
+	EventFundsReturned:       func() Event { return &FundsReturned{} },
+	EventTargetAllocationSet: func() Event { return &TargetAllocationSet{} },
+	EventGoalsSet:            func() Event { return &GoalsSet{} },
+	EventPlansSaved:          func() Event { return &PlansSaved{} },
+	EventDepositReconciled:   func() Event { return &DepositReconciled{} },
+}
+
+// Type returns the event type
+func (e CustomerCreated) Type() string { return EventCustomerCreated }
+
+// Type returns the event type
+func (e PortfolioAdded) Type() string { return EventPortfolioAdded }
+
+// Type returns the event type
+func (e SessionStarted) Type() string { return EventSessionStarted }
+
+// Type returns the event type
+func (e DepositReceived) Type() string { return EventDepositReceived }
+
+// Type returns the event type
+func (e SessionCommitted) Type() string { return EventSessionCommitted }
+
+// Type returns the event type
+func (e WithdrawalMade) Type() string { return EventWithdrawalMade }
+
+// Type returns the event type
+func (e CustomerUpdated) Type() string { return EventCustomerUpdated }
+
+// Type returns the event type
+func (e PortfolioRenamed) Type() string { return EventPortfolioRenamed }
+
+// Type returns the event type
+func (e PortfolioClosed) Type() string { return EventPortfolioClosed }
+
+// Type returns the event type
+func (e FundsTransferred) Type() string { return EventFundsTransferred }
+
+// Type returns the event type
+func (e InterestPaid) Type() string { return EventInterestPaid }
+
+// Type returns the event type
+func (e FundsCleared) Type() string { return EventFundsCleared }
+
+// Type returns the event type
+func (e FundsReturned) Type() string { return EventFundsReturned }
+
+// Type returns the event type
+func (e TargetAllocationSet) Type() string { return EventTargetAllocationSet }
+
+// Type returns the event type
+func (e GoalsSet) Type() string { return EventGoalsSet }
+
+// Type returns the event type
+func (e PlansSaved) Type() string { return EventPlansSaved }
+
+// Type returns the event type
+func (e DepositReconciled) Type() string { return EventDepositReconciled }
+
+// CustomerID returns the customer the event happened to
+func (e CustomerCreated) CustomerID() string { return e.Customer }
+
+// CustomerID returns the customer the event happened to
+func (e PortfolioAdded) CustomerID() string { return e.Customer }
+
+// CustomerID returns the customer the event happened to
+func (e SessionStarted) CustomerID() string { return e.Customer }
+
+// CustomerID returns the customer the event happened to
+func (e DepositReceived) CustomerID() string { return e.Customer }
+
+// CustomerID returns the customer the event happened to
+func (e SessionCommitted) CustomerID() string { return e.Customer }
+
+// CustomerID returns the customer the event happened to
+func (e WithdrawalMade) CustomerID() string { return e.Customer }
+
+// CustomerID returns the customer the event happened to
+func (e CustomerUpdated) CustomerID() string { return e.Customer }
+
+// CustomerID returns the customer the event happened to
+func (e PortfolioRenamed) CustomerID() string { return e.Customer }
+
+// CustomerID returns the customer the event happened to
+func (e PortfolioClosed) CustomerID() string { return e.Customer }
+
+// CustomerID returns the customer the event happened to
+func (e FundsTransferred) CustomerID() string { return e.Customer }
+
+// CustomerID returns the customer the event happened to
+func (e InterestPaid) CustomerID() string { return e.Customer }
+
+// CustomerID returns the customer the event happened to
+func (e FundsCleared) CustomerID() string { return e.Customer }
+
+// CustomerID returns the customer the event happened to
+func (e FundsReturned) CustomerID() string { return e.Customer }
+
+// CustomerID returns the customer the event happened to
+func (e TargetAllocationSet) CustomerID() string { return e.Customer }
+
+// CustomerID returns the customer the event happened to
+func (e GoalsSet) CustomerID() string { return e.Customer }
+
+// CustomerID returns the customer the event happened to
+func (e PlansSaved) CustomerID() string { return e.Customer }
+
+// CustomerID returns the customer the event happened to
+func (e DepositReconciled) CustomerID() string { return e.Customer }
+
+// OccurredAt returns the time the event happened
+func (e CustomerCreated) OccurredAt() time.Time { return e.Time }
+
+// OccurredAt returns the time the event happened
+func (e PortfolioAdded) OccurredAt() time.Time { return e.Time }
+
+// OccurredAt returns the time the event happened
+func (e SessionStarted) OccurredAt() time.Time { return e.Time }
+
+// OccurredAt returns the time the event happened
+func (e DepositReceived) OccurredAt() time.Time { return e.Time }
+
+// OccurredAt returns the time the event happened
+func (e SessionCommitted) OccurredAt() time.Time { return e.Time }
+
+// OccurredAt returns the time the event happened
+func (e WithdrawalMade) OccurredAt() time.Time { return e.Time }
+
+// OccurredAt returns the time the event happened
+func (e CustomerUpdated) OccurredAt() time.Time { return e.Time }
+
+// OccurredAt returns the time the event happened
+func (e PortfolioRenamed) OccurredAt() time.Time { return e.Time }
+
+// OccurredAt returns the time the event happened
+func (e PortfolioClosed) OccurredAt() time.Time { return e.Time }
+
+// OccurredAt returns the time the event happened
+func (e FundsTransferred) OccurredAt() time.Time { return e.Time }
+
+// OccurredAt returns the time the event happened
+func (e InterestPaid) OccurredAt() time.Time { return e.Time }
+
+// OccurredAt returns the time the event happened
+func (e FundsCleared) OccurredAt() time.Time { return e.Time }
+
+// OccurredAt returns the time the event happened
+func (e FundsReturned) OccurredAt() time.Time { return e.Time }
+
+// OccurredAt returns the time the event happened
+func (e TargetAllocationSet) OccurredAt() time.Time { return e.Time }
+
+// OccurredAt returns the time the event happened
+func (e GoalsSet) OccurredAt() time.Time { return e.Time }
+
+// OccurredAt returns the time the event happened
+func (e PlansSaved) OccurredAt() time.Time { return e.Time }
+
+// OccurredAt returns the time the event happened
+func (e DepositReconciled) OccurredAt() time.Time { return e.Time }
+
+// Subscriber handles the events published on a bus
+type Subscriber interface {
+	Handle(e Event) error
+}
+
+// SubscriberFunc adapts a function to a subscriber
+type SubscriberFunc func(e Event) error
+
+// Handle calls the function
+func (f SubscriberFunc) Handle(e Event) error {
+	return f(e)
+}
+
+// EventBus delivers published events to its subscribers. Synchronous subscribers handle each event before
+// Publish returns, while async subscribers handle events in order on a goroutine of their own. Errors from
+// subscribers are passed to the error handler, which prints them to stderr unless replaced.
+type EventBus struct {
+	mu           sync.Mutex
+	closeMu      sync.RWMutex
+	sync         []Subscriber
+	async        []chan Event
+	wg           sync.WaitGroup
+	errorHandler func(e Event, err error)
+
+	// holds are the number of events held back when each hold still in place began
+	holds []int
+	held  []Event
+}
+
+// NewEventBus instantiate a bus without any subscribers
+func NewEventBus() *EventBus {
+	return &EventBus{errorHandler: func(e Event, err error) {
+		fmt.Fprintln(os.Stderr, "Event subscriber error:", e.Type(), err)
+	}}
+}
+
+// SetErrorHandler sets the function errors returned by subscribers are passed to
+func (b *EventBus) SetErrorHandler(handler func(e Event, err error)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.errorHandler = handler
+}
+
+// Subscribe adds a subscriber handling events as they are published
+func (b *EventBus) Subscribe(s Subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sync = append(b.sync, s)
+}
+
+// SubscribeAsync adds a subscriber handling events in the background
+func (b *EventBus) SubscribeAsync(s Subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	queue := make(chan Event, asyncQueueSize)
+	b.async = append(b.async, queue)
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		for e := range queue {
+			b.handle(s, e)
+		}
+	}()
+}
+
+// Publish delivers the event to every subscriber, or keeps it back while the bus is holding events.
+// Publishing on a nil bus does nothing.
+func (b *EventBus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	if len(b.holds) > 0 {
+		b.held = append(b.held, e)
+		b.mu.Unlock()
+		return
+	}
+	b.mu.Unlock()
+	b.deliver(e)
+}
+
+// Hold keeps back the events published from now on until they are released or discarded, so the events of an
+// operation are only delivered once it succeeds. Holds nest: the events of an inner hold released are kept
+// back until the outer hold is released too.
+func (b *EventBus) Hold() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.holds = append(b.holds, len(b.held))
+}
+
+// Release ends the last hold. Once no hold is left, the events held back are delivered in the order they were
+// published.
+func (b *EventBus) Release() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	var held []Event
+	if len(b.holds) > 0 {
+		b.holds = b.holds[:len(b.holds)-1]
+	}
+	if len(b.holds) == 0 {
+		held, b.held = b.held, nil
+	}
+	b.mu.Unlock()
+
+	for _, e := range held {
+		b.deliver(e)
+	}
+}
+
+// Discard ends the last hold and drops the events published since it began
+func (b *EventBus) Discard() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.holds) == 0 {
+		return
+	}
+	b.held = b.held[:b.holds[len(b.holds)-1]]
+	b.holds = b.holds[:len(b.holds)-1]
+}
+
+func (b *EventBus) deliver(e Event) {
+	b.closeMu.RLock()
+	defer b.closeMu.RUnlock()
+	b.mu.Lock()
+	subscribers, queues := b.sync, b.async
+	b.mu.Unlock()
+
+	for _, s := range subscribers {
+		b.handle(s, e)
+	}
+	for _, q := range queues {
+		q <- e
+	}
+}
+
+// Close detaches the async subscribers and waits for them to handle the events already published
+func (b *EventBus) Close() {
+	b.closeMu.Lock()
+	b.mu.Lock()
+	queues := b.async
+	b.async = nil
+	b.mu.Unlock()
+	for _, q := range queues {
+		close(q)
+	}
+	b.closeMu.Unlock()
+	b.wg.Wait()
+}
+
+func (b *EventBus) handle(s Subscriber, e Event) {
+	if err := s.Handle(e); err != nil {
+		b.mu.Lock()
+		handler := b.errorHandler
+		b.mu.Unlock()
+		if handler != nil {
+			handler(e, err)
+		}
+	}
+}
+
+// NewLogSubscriber returns a subscriber writing a line for every event to w
+func NewLogSubscriber(w io.Writer) Subscriber {
+	var mu sync.Mutex
+	return SubscriberFunc(func(e Event) error {
+		payload, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		_, err = fmt.Fprintf(w, "%s %s %s %s\n", e.OccurredAt().Format("2006-01-02 15:04:05"), e.Type(), e.CustomerID(), payload)
+		return err
+	})
+}
+
+// OutboxRecord is an event as written to the outbox
+type OutboxRecord struct {
+	ID      int             `json:"id"`
+	Type    string          `json:"type"`
+	Time    time.Time       `json:"time"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// FileOutbox is a subscriber appending every event to a file, one JSON record per line, for other
+// systems to pick up
+type FileOutbox struct {
+	mu     sync.Mutex
+	path   string
+	lastID int
+}
+
+// NewFileOutbox instantiate an outbox writing to path, numbering events after those already in the file
+func NewFileOutbox(path string) (*FileOutbox, error) {
+	records, err := ReadOutbox(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	o := &FileOutbox{path: path}
+	if len(records) > 0 {
+		o.lastID = records[len(records)-1].ID
+	}
+	return o, nil
+}
+
+// Handle appends the event to the outbox file
+func (o *FileOutbox) Handle(e Event) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	line, err := json.Marshal(OutboxRecord{ID: o.lastID + 1, Type: e.Type(), Time: e.OccurredAt(), Payload: payload})
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(o.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	o.lastID++
+	return nil
+}
+
+// Records returns the records in the outbox, oldest first
+func (o *FileOutbox) Records() ([]OutboxRecord, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	records, err := ReadOutbox(o.path)
+	if os.IsNotExist(err) {
+		return []OutboxRecord{}, nil
+	}
+	return records, err
+}
+
+// DecodeEvent returns the event written to the outbox record
+func DecodeEvent(r OutboxRecord) (Event, error) {
+	newEvent, ok := eventTypes[r.Type]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownEventType, r.Type)
+	}
+	e := newEvent()
+	if err := json.Unmarshal(r.Payload, e); err != nil {
+		return nil, err
+	}
+	return reflect.ValueOf(e).Elem().Interface().(Event), nil
+}
+
+// ReadOutbox returns the records in the outbox file, oldest first
+func ReadOutbox(path string) ([]OutboxRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	records := []OutboxRecord{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var r OutboxRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	return records, scanner.Err()
+}
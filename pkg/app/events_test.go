@@ -0,0 +1,172 @@
+package app
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingSubscriber struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (s *recordingSubscriber) Handle(e Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, e)
+	return nil
+}
+
+func (s *recordingSubscriber) types() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	types := []string{}
+	for _, e := range s.events {
+		types = append(types, e.Type())
+	}
+	return types
+}
+
+func TestEventBus_shouldDeliverToSyncAndAsyncSubscribers(t *testing.T) {
+	bus := NewEventBus()
+	syncSub, asyncSub := &recordingSubscriber{}, &recordingSubscriber{}
+	bus.Subscribe(syncSub)
+	bus.SubscribeAsync(asyncSub)
+
+	bus.Publish(CustomerCreated{Customer: "c1"})
+	bus.Publish(SessionStarted{Customer: "c1"})
+	assert.Equal(t, []string{EventCustomerCreated, EventSessionStarted}, syncSub.types())
+
+	bus.Close()
+	assert.Equal(t, []string{EventCustomerCreated, EventSessionStarted}, asyncSub.types())
+	bus.Publish(WithdrawalMade{Customer: "c1"})
+	assert.Equal(t, 2, len(asyncSub.types()))
+}
+
+func TestEventBus_shouldPassSubscriberErrorsToHandler(t *testing.T) {
+	bus := NewEventBus()
+	var handled []error
+	bus.SetErrorHandler(func(e Event, err error) { handled = append(handled, err) })
+	bus.Subscribe(SubscriberFunc(func(e Event) error { return errors.New("failed") }))
+
+	bus.Publish(CustomerCreated{Customer: "c1"})
+	assert.Equal(t, []error{errors.New("failed")}, handled)
+
+	var nilBus *EventBus
+	nilBus.Publish(CustomerCreated{Customer: "c1"})
+}
+
+func TestEventBus_shouldHoldEventsUntilReleased(t *testing.T) {
+	bus := NewEventBus()
+	sub := &recordingSubscriber{}
+	bus.Subscribe(sub)
+
+	bus.Hold()
+	bus.Publish(CustomerCreated{Customer: "c1"})
+	bus.Publish(SessionStarted{Customer: "c1"})
+	assert.Empty(t, sub.types())
+	bus.Release()
+	assert.Equal(t, []string{EventCustomerCreated, EventSessionStarted}, sub.types())
+
+	bus.Hold()
+	bus.Publish(WithdrawalMade{Customer: "c1"})
+	bus.Discard()
+	bus.Publish(PortfolioAdded{Customer: "c1"})
+	assert.Equal(t, []string{EventCustomerCreated, EventSessionStarted, EventPortfolioAdded}, sub.types())
+}
+
+func TestEventBus_shouldDiscardInnerHoldOnly_givenHoldsNested(t *testing.T) {
+	bus := NewEventBus()
+	sub := &recordingSubscriber{}
+	bus.Subscribe(sub)
+
+	bus.Hold()
+	bus.Publish(CustomerCreated{Customer: "c1"})
+	bus.Hold()
+	bus.Publish(SessionStarted{Customer: "c1"})
+	bus.Discard()
+	bus.Hold()
+	bus.Publish(PortfolioAdded{Customer: "c1"})
+	bus.Release()
+	assert.Empty(t, sub.types())
+	bus.Release()
+	assert.Equal(t, []string{EventCustomerCreated, EventPortfolioAdded}, sub.types())
+}
+
+func TestLogSubscriber_shouldWriteEventLine(t *testing.T) {
+	var buf bytes.Buffer
+	s := NewLogSubscriber(&buf)
+	assert.NoError(t, s.Handle(CustomerCreated{Customer: "c1", Time: time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)}))
+	assert.Equal(t, "2020-01-01 10:00:00 CustomerCreated c1 {\"customer\":\"c1\",\"time\":\"2020-01-01T10:00:00Z\"}\n", buf.String())
+}
+
+func TestFileOutbox_shouldAppendNumberedRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.jsonl")
+	tm := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	o, err := NewFileOutbox(path)
+	assert.NoError(t, err)
+	assert.NoError(t, o.Handle(CustomerCreated{Customer: "c1", Time: tm}))
+
+	o, err = NewFileOutbox(path)
+	assert.NoError(t, err)
+	assert.NoError(t, o.Handle(WithdrawalMade{Customer: "c1", Portfolio: "A", Amount: 10, Currency: "SGD", Time: tm}))
+
+	records, err := ReadOutbox(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []OutboxRecord{
+		{ID: 1, Type: EventCustomerCreated, Time: tm, Payload: []byte(`{"customer":"c1","time":"2020-01-01T10:00:00Z"}`)},
+		{ID: 2, Type: EventWithdrawalMade, Time: tm, Payload: []byte(`{"customer":"c1","portfolio":"A","amount":10,"currency":"SGD","time":"2020-01-01T10:00:00Z"}`)},
+	}, records)
+}
+
+func TestApp_shouldPublishDomainEvents(t *testing.T) {
+	app := NewApp()
+	bus := NewEventBus()
+	sub := &recordingSubscriber{}
+	bus.Subscribe(sub)
+	app.SetEventBus(bus)
+
+	app.processInput("newcustomer test")
+	app.processInput("addportfolio Retirement")
+	app.processInput("startDeposit")
+	app.processInput("addOneTimePlan plan Retirement 100")
+	app.processInput("deposit 100")
+	app.processInput("endDeposit")
+	app.processInput("withdraw Retirement 40")
+
+	assert.Equal(t, []string{
+		EventCustomerCreated, EventPortfolioAdded, EventSessionStarted, EventDepositReceived, EventSessionCommitted, EventWithdrawalMade,
+	}, sub.types())
+	committed := sub.events[4].(SessionCommitted)
+	assert.Equal(t, "test", committed.CustomerID())
+	assert.Equal(t, []float32{100}, committed.Deposits)
+	assert.Equal(t, float32(100), committed.Credits[0].Net)
+	assert.Equal(t, WithdrawalMade{Customer: "test", Portfolio: "Retirement", Amount: 40, Currency: "SGD", Time: sub.events[5].OccurredAt()}, sub.events[5])
+}
+
+func TestApp_shouldNotPublishEvents_givenCommandFails(t *testing.T) {
+	app := NewApp()
+	repo := &failingRepository{MemoryRepository: NewMemoryRepository()}
+	assert.NoError(t, app.SetRepository(repo))
+	bus := NewEventBus()
+	sub := &recordingSubscriber{}
+	bus.Subscribe(sub)
+	app.SetEventBus(bus)
+
+	for _, input := range []string{"newcustomer test", "addportfolio Retirement", "startDeposit", "addOneTimePlan plan Retirement 100", "deposit 100"} {
+		_, err := app.processInput(input)
+		assert.NoError(t, err, input)
+	}
+	_, err := app.processInput("withdraw Retirement 10")
+	assert.Error(t, err)
+	repo.failing = true
+	_, err = app.processInput("endDeposit")
+	assert.EqualError(t, err, "commit failed")
+	assert.Equal(t, []string{EventCustomerCreated, EventPortfolioAdded, EventSessionStarted, EventDepositReceived}, sub.types())
+}
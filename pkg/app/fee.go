@@ -0,0 +1,137 @@
+package app
+
+import (
+	"encoding/json"
+	"io/ioutil"
+)
+
+// DepositCredit is the amount a plan credits to a portfolio, booked in the portfolio currency
+type DepositCredit struct {
+	Plan             string
+	PlanType         string
+	Portfolio        string
+	ProductType      string
+	Original         float32
+	OriginalCurrency string
+	Gross            float32
+	Fee              float32
+	Net              float32
+	Currency         string
+}
+
+// FeeSchedule is the fees charged on deposits. FlatPerDeposit is charged, in the currency of the deposit,
+// for every deposit made in a session and shared between the credits by their size. PercentageByProduct
+// charges a percentage of each credit by the product type of the portfolio, reduced by
+// MonthlyPlanDiscount (a fraction between 0 and 1) for credits from monthly plans.
+type FeeSchedule struct {
+	FlatPerDeposit      float32            `json:"flatPerDeposit"`
+	PercentageByProduct map[string]float64 `json:"percentageByProduct"`
+	MonthlyPlanDiscount float64            `json:"monthlyPlanDiscount"`
+}
+
+// LoadFeeSchedule reads a JSON fee schedule from path
+func LoadFeeSchedule(path string) (*FeeSchedule, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fs := &FeeSchedule{}
+	if err := json.Unmarshal(data, fs); err != nil {
+		return nil, err
+	}
+	if err := fs.validate(); err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+func (fs *FeeSchedule) validate() error {
+	if fs.FlatPerDeposit < 0 {
+		return &ConfigError{Message: "flat fee is negative"}
+	}
+	for productType, pct := range fs.PercentageByProduct {
+		if pct < 0 || pct > 100 {
+			return &ConfigError{Message: "invalid fee percentage for " + productType}
+		}
+	}
+	if fs.MonthlyPlanDiscount < 0 || fs.MonthlyPlanDiscount > 1 {
+		return &ConfigError{Message: "invalid monthly plan discount"}
+	}
+	return nil
+}
+
+// apply works out the fee of each credit for a session of the specified number of deposits made in currency.
+// A nil schedule charges no fees.
+func (fs *FeeSchedule) apply(credits []DepositCredit, deposits int, currency string, fx RateProvider) ([]DepositCredit, error) {
+	if fs == nil {
+		return credits, nil
+	}
+
+	weights := make([]float32, len(credits))
+	var totalWeight float32
+	for i, cr := range credits {
+		w, err := convert(fx, cr.Original, cr.OriginalCurrency, currency)
+		if err != nil {
+			return nil, err
+		}
+		weights[i] = w
+		totalWeight += w
+	}
+
+	// The shares of the flat fee and the percentage fees in each currency are rounded to the cent, with the
+	// rounding remainder charged to the last credit sharing in them
+	lastWeighted, lastCharged := -1, map[string]int{}
+	for i, cr := range credits {
+		if weights[i] > 0 {
+			lastWeighted = i
+		}
+		if fs.percentage(cr) > 0 && cr.Gross > 0 {
+			lastCharged[cr.Currency] = i
+		}
+	}
+
+	flat := fs.FlatPerDeposit * float32(deposits)
+	var allocated float32
+	exact, charged := map[string]float64{}, map[string]float32{}
+	for i := range credits {
+		cr := &credits[i]
+
+		if flat > 0 && totalWeight > 0 && weights[i] > 0 {
+			share := roundCents(float64(flat * weights[i] / totalWeight))
+			if allocated+share > flat || i == lastWeighted {
+				share = flat - allocated
+			}
+			allocated += share
+			fee, err := convert(fx, share, currency, cr.Currency)
+			if err != nil {
+				return nil, err
+			}
+			cr.Fee += fee
+		}
+
+		pctFee := float64(cr.Gross) * fs.percentage(*cr) / 100
+		exact[cr.Currency] += pctFee
+		share := roundCents(pctFee)
+		if last, ok := lastCharged[cr.Currency]; ok && last == i {
+			share = roundCents(float64(roundCents(exact[cr.Currency]) - charged[cr.Currency]))
+		}
+		charged[cr.Currency] += share
+		cr.Fee = roundCents(float64(cr.Fee + share))
+
+		if cr.Fee > cr.Gross {
+			cr.Fee = cr.Gross
+		}
+		cr.Net = cr.Gross - cr.Fee
+	}
+	return credits, nil
+}
+
+// percentage returns the percentage fee charged on the credit
+func (fs *FeeSchedule) percentage(cr DepositCredit) float64 {
+	pct := fs.PercentageByProduct[cr.ProductType]
+	if cr.PlanType == "monthly" {
+		pct *= 1 - fs.MonthlyPlanDiscount
+	}
+	return pct
+}
@@ -0,0 +1,100 @@
+package app
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadFeeSchedule_shouldLoadSchedule(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fees.json")
+	ioutil.WriteFile(path, []byte(`{"flatPerDeposit":2,"percentageByProduct":{"high-risk":1.5},"monthlyPlanDiscount":0.5}`), 0600)
+
+	res, err := LoadFeeSchedule(path)
+	assert.NoError(t, err)
+	assert.Equal(t, &FeeSchedule{FlatPerDeposit: 2, PercentageByProduct: map[string]float64{"high-risk": 1.5}, MonthlyPlanDiscount: 0.5}, res)
+}
+
+func TestLoadFeeSchedule_shouldReturnError_givenInvalidSchedule(t *testing.T) {
+	testLoad := func(content string, expectedErr string) {
+		path := filepath.Join(t.TempDir(), "fees.json")
+		ioutil.WriteFile(path, []byte(content), 0600)
+		res, err := LoadFeeSchedule(path)
+		assert.Error(t, err)
+		assert.Equal(t, expectedErr, err.Error())
+		assert.Nil(t, res)
+	}
+
+	testLoad(`{"flatPerDeposit":-1}`, "flat fee is negative")
+	testLoad(`{"percentageByProduct":{"savings":101}}`, "invalid fee percentage for savings")
+	testLoad(`{"monthlyPlanDiscount":2}`, "invalid monthly plan discount")
+}
+
+func TestFeeScheduleApply_shouldWorkOutFees(t *testing.T) {
+	testApply := func(fs *FeeSchedule, deposits int, expectedFees []float32) {
+		credits := []DepositCredit{
+			{Plan: "A", PlanType: "one-time", Portfolio: "Retirement", ProductType: "retirement", Original: 300, Gross: 300, Net: 300},
+			{Plan: "A", PlanType: "one-time", Portfolio: "High Risk", ProductType: "high-risk", Original: 100, Gross: 100, Net: 100},
+			{Plan: "B", PlanType: "monthly", Portfolio: "High Risk", ProductType: "high-risk", Original: 200, Gross: 200, Net: 200},
+		}
+		res, err := fs.apply(credits, deposits, "", nil)
+		assert.NoError(t, err)
+		for i, cr := range res {
+			assert.Equal(t, expectedFees[i], cr.Fee)
+			assert.Equal(t, cr.Gross-expectedFees[i], cr.Net)
+		}
+	}
+
+	testApply(nil, 2, []float32{0, 0, 0})
+	testApply(&FeeSchedule{FlatPerDeposit: 3}, 2, []float32{3, 1, 2})
+	testApply(&FeeSchedule{PercentageByProduct: map[string]float64{"high-risk": 1}}, 1, []float32{0, 1, 2})
+	testApply(&FeeSchedule{PercentageByProduct: map[string]float64{"high-risk": 1}, MonthlyPlanDiscount: 0.25}, 1, []float32{0, 1, 1.5})
+	testApply(&FeeSchedule{FlatPerDeposit: 1000}, 1, []float32{300, 100, 200})
+}
+
+func TestFeeScheduleApply_shouldChargeRoundingRemainderToLastCredit(t *testing.T) {
+	testApply := func(fs *FeeSchedule, credits []DepositCredit, expectedFees []float32) {
+		res, err := fs.apply(credits, 1, "", nil)
+		assert.NoError(t, err)
+		for i, cr := range res {
+			assert.Equal(t, expectedFees[i], cr.Fee)
+		}
+	}
+
+	credit := func(portfolio string, productType string, amount float32) DepositCredit {
+		return DepositCredit{Plan: "A", PlanType: "one-time", Portfolio: portfolio, ProductType: productType, Original: amount, Gross: amount, Net: amount}
+	}
+	testApply(&FeeSchedule{PercentageByProduct: map[string]float64{"high-risk": 1}},
+		[]DepositCredit{credit("A", "high-risk", 10.49), credit("B", "high-risk", 10.49), credit("C", "high-risk", 10.49), credit("D", "savings", 10)},
+		[]float32{0.1, 0.1, 0.11, 0})
+	testApply(&FeeSchedule{FlatPerDeposit: 1},
+		[]DepositCredit{credit("A", "savings", 10), credit("B", "savings", 10), credit("C", "savings", 10), credit("D", "savings", 0)},
+		[]float32{0.33, 0.33, 0.34, 0})
+}
+
+func TestPerformDeposit_shouldBookFeesToFeeIncome(t *testing.T) {
+	tm := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	setNow(t, tm)
+	c := Customer{
+		ID:         "c1",
+		portfolios: []*Portfolio{{Name: "High Risk", Product: Product{Type: "high-risk"}}},
+		ledger:     NewLedger(),
+		fees:       &FeeSchedule{FlatPerDeposit: 1, PercentageByProduct: map[string]float64{"high-risk": 2}},
+	}
+	err := c.PerformDeposit(
+		[]DepositPlan{&baseDepositPlan{name: "Plan A", planType: "one-time", portfolioRatio: map[string]float32{"High Risk": 100}}},
+		[]float32{100},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, float32(97), c.portfolios[0].Balance)
+	assert.Equal(t, []LedgerEntry{
+		{Time: tm, Customer: "c1", Portfolio: "High Risk", Type: EntryDeposit, Amount: 100, Currency: "SGD", OriginalAmount: 100, OriginalCurrency: "SGD", Reference: "Plan A", PlanType: "one-time"},
+		{Time: tm, Customer: "c1", Portfolio: "High Risk", Type: EntryFee, Amount: -3, Currency: "SGD", Reference: "Plan A"},
+	}, c.ledger.Entries("c1"))
+	assert.Equal(t, []LedgerEntry{
+		{Time: tm, Portfolio: FeeIncomeAccount, Type: EntryFee, Amount: 3, Currency: "SGD", Reference: "c1"},
+	}, c.ledger.AccountEntries(FeeIncomeAccount))
+}
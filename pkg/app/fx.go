@@ -0,0 +1,104 @@
+package app
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+// DefaultCurrency is the currency portfolios, plans and deposits are in when none is specified
+const DefaultCurrency = "SGD"
+
+var currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// RateProvider gives the rate to convert an amount from one currency to another
+type RateProvider interface {
+	Rate(from string, to string) (float64, error)
+}
+
+// StaticRateProvider serves fixed rates keyed by "FROM/TO"
+type StaticRateProvider map[string]float64
+
+// LoadStaticRateProvider reads rates from a CSV file with lines of "from,to,rate"
+func LoadStaticRateProvider(path string) (StaticRateProvider, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = 3
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	rates := StaticRateProvider{}
+	for _, rec := range records {
+		if err := validateCurrency(rec[0]); err != nil {
+			return nil, err
+		}
+		if err := validateCurrency(rec[1]); err != nil {
+			return nil, err
+		}
+		rate, err := strconv.ParseFloat(rec[2], 64)
+		if err != nil || rate <= 0 {
+			return nil, &InvalidValueError{Field: "rate", Value: rec[2]}
+		}
+		rates[rec[0]+"/"+rec[1]] = rate
+	}
+	return rates, nil
+}
+
+// Rate returns the rate from the file, or the inverse of the rate in the opposite direction
+func (s StaticRateProvider) Rate(from string, to string) (float64, error) {
+	if from == to {
+		return 1, nil
+	}
+	if rate, ok := s[from+"/"+to]; ok {
+		return rate, nil
+	}
+	if rate, ok := s[to+"/"+from]; ok {
+		return 1 / rate, nil
+	}
+	return 0, fmt.Errorf("%w for %s/%s", ErrNoRate, from, to)
+}
+
+func validateCurrency(currency string) error {
+	if !currencyPattern.MatchString(currency) {
+		return &InvalidValueError{Field: "currency", Value: currency}
+	}
+	return nil
+}
+
+func currencyOrDefault(currency string) string {
+	if currency == "" {
+		return DefaultCurrency
+	}
+	return currency
+}
+
+// convert the amount between currencies, rounded to cents. Without a rate provider only amounts
+// already in the target currency are accepted.
+func convert(fx RateProvider, amount float32, from string, to string) (float32, error) {
+	from, to = currencyOrDefault(from), currencyOrDefault(to)
+	if from == to {
+		return amount, nil
+	}
+	if fx == nil {
+		return 0, &CurrencyMismatchError{From: from, To: to}
+	}
+	rate, err := fx.Rate(from, to)
+	if err != nil {
+		return 0, err
+	}
+	return roundCents(float64(amount) * rate), nil
+}
+
+func roundCents(amount float64) float32 {
+	return float32(math.Round(amount*100) / 100)
+}
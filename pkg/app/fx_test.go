@@ -0,0 +1,65 @@
+package app
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadStaticRateProvider_shouldLoadRates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rates.csv")
+	ioutil.WriteFile(path, []byte("USD,SGD,1.35\nEUR,SGD,1.5\n"), 0600)
+
+	res, err := LoadStaticRateProvider(path)
+	assert.NoError(t, err)
+	assert.Equal(t, StaticRateProvider{"USD/SGD": 1.35, "EUR/SGD": 1.5}, res)
+}
+
+func TestLoadStaticRateProvider_shouldReturnError_givenInvalidRates(t *testing.T) {
+	testLoad := func(content string, expectedErr string) {
+		path := filepath.Join(t.TempDir(), "rates.csv")
+		ioutil.WriteFile(path, []byte(content), 0600)
+		res, err := LoadStaticRateProvider(path)
+		assert.Error(t, err)
+		assert.Equal(t, expectedErr, err.Error())
+		assert.Nil(t, res)
+	}
+
+	testLoad("usd,SGD,1.35\n", "invalid currency: usd")
+	testLoad("USD,SGD,abc\n", "invalid rate: abc")
+	testLoad("USD,SGD,-1\n", "invalid rate: -1")
+}
+
+func TestStaticRateProviderRate_shouldReturnRate(t *testing.T) {
+	rates := StaticRateProvider{"USD/SGD": 1.25}
+	testRate := func(from string, to string, expected float64) {
+		res, err := rates.Rate(from, to)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, res)
+	}
+
+	testRate("USD", "SGD", 1.25)
+	testRate("SGD", "USD", 0.8)
+	testRate("EUR", "EUR", 1)
+
+	_, err := rates.Rate("EUR", "SGD")
+	assert.Error(t, err)
+	assert.Equal(t, "no rate for EUR/SGD", err.Error())
+}
+
+func TestConvert_shouldConvertBetweenCurrencies(t *testing.T) {
+	testConvert := func(fx RateProvider, amount float32, from string, to string, expected float32) {
+		res, err := convert(fx, amount, from, to)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, res)
+	}
+
+	testConvert(nil, 100, "", "SGD", 100)
+	testConvert(StaticRateProvider{"USD/SGD": 1.3333}, 100, "USD", "", 133.33)
+
+	_, err := convert(nil, 100, "USD", "SGD")
+	assert.Error(t, err)
+	assert.Equal(t, "currency mismatch: USD to SGD", err.Error())
+}
@@ -0,0 +1,198 @@
+package app
+
+import (
+	"math"
+	"time"
+)
+
+// Goal is an amount a portfolio saves towards, to be reached by the end of the month of Date. The amount is in
+// the currency of the portfolio.
+type Goal struct {
+	Amount float32   `json:"amount"`
+	Date   time.Time `json:"date"`
+}
+
+// month returns the number of months from the start of the calendar to the month of the time
+func month(t time.Time) int {
+	return t.Year()*12 + int(t.Month()) - 1
+}
+
+// SetGoal sets the amount the open portfolio should hold by the end of the month of date, replacing any goal set
+// on it before
+func (c *Customer) SetGoal(portfolio string, amount float32, date time.Time) error {
+	p, err := c.openPortfolio(portfolio)
+	if err != nil {
+		return err
+	}
+	if amount <= 0 || math.IsInf(float64(amount), 0) || math.IsNaN(float64(amount)) {
+		return ErrInvalidGoalAmount
+	}
+	if month(date) < month(c.now()) {
+		return ErrGoalDateInPast
+	}
+
+	if c.goals == nil {
+		c.goals = map[string]Goal{}
+	}
+	c.goals[p.Name] = Goal{Amount: amount, Date: time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, time.UTC)}
+	c.publishGoals()
+	return nil
+}
+
+// RemoveGoal removes the goal set on the portfolio
+func (c *Customer) RemoveGoal(portfolio string) error {
+	if c.findPortfolio(portfolio) == nil {
+		return &PortfolioNotFoundError{Name: portfolio}
+	}
+	if _, ok := c.goals[portfolio]; !ok {
+		return ErrNoGoal
+	}
+	c.moveGoal(portfolio, "")
+	return nil
+}
+
+// Goals returns the goal of each portfolio that has one, or nil when none does
+func (c *Customer) Goals() map[string]Goal {
+	if c.goals == nil {
+		return nil
+	}
+	goals := map[string]Goal{}
+	for name, g := range c.goals {
+		goals[name] = g
+	}
+	return goals
+}
+
+// moveGoal gives the goal of a portfolio to the portfolio it is renamed to. With no portfolio to move to, the goal
+// is dropped.
+func (c *Customer) moveGoal(from string, to string) {
+	g, ok := c.goals[from]
+	if !ok {
+		return
+	}
+	delete(c.goals, from)
+	if to != "" {
+		c.goals[to] = g
+	}
+	if len(c.goals) == 0 {
+		c.goals = nil
+	}
+	c.publishGoals()
+}
+
+func (c *Customer) publishGoals() {
+	c.events.Publish(GoalsSet{Customer: c.ID, Goals: c.Goals(), Time: c.now()})
+}
+
+// GoalProgress is how far a portfolio is towards its goal. Monthly is what monthly plans pay into the portfolio
+// each month, and Required the monthly contribution that reaches the goal over the months left. Amounts
+// are in the currency of the portfolio.
+type GoalProgress struct {
+	Portfolio  string
+	Goal       Goal
+	Currency   string
+	Balance    float32
+	Progress   float64
+	MonthsLeft int
+	Monthly    float32
+	Required   float32
+	OnTrack    bool
+}
+
+// Shortfall returns the monthly contribution needed on top of the monthly plans to reach the goal
+func (g GoalProgress) Shortfall() float32 {
+	if g.OnTrack || g.Required <= g.Monthly {
+		return 0
+	}
+	return roundCents(float64(g.Required - g.Monthly))
+}
+
+// GoalProgress returns the progress of each portfolio with a goal, in the order of the portfolios. Interest yet to
+// be paid is not counted towards the goals.
+func (c *Customer) GoalProgress() ([]GoalProgress, error) {
+	var progress []GoalProgress
+	for _, p := range c.portfolios {
+		g, ok := c.goals[p.Name]
+		if !ok {
+			continue
+		}
+		monthly, err := c.monthlyContribution(p)
+		if err != nil {
+			return nil, err
+		}
+
+		gp := GoalProgress{
+			Portfolio:  p.Name,
+			Goal:       g,
+			Currency:   p.currency(),
+			Balance:    p.Balance,
+			Progress:   roundPercent(float64(p.Balance) / float64(g.Amount) * 100),
+			MonthsLeft: month(g.Date) - month(c.now()),
+			Monthly:    monthly,
+		}
+		if gp.MonthsLeft < 0 {
+			gp.MonthsLeft = 0
+		}
+		remaining := float64(g.Amount - p.Balance)
+		switch {
+		case remaining <= 0:
+			gp.OnTrack = true
+		case gp.MonthsLeft == 0:
+			gp.Required = roundCents(remaining)
+		default:
+			gp.Required = float32(math.Ceil(remaining/float64(gp.MonthsLeft)*100) / 100)
+			gp.OnTrack = float64(monthly)*float64(gp.MonthsLeft) >= remaining
+		}
+		progress = append(progress, gp)
+	}
+	return progress, nil
+}
+
+// monthlyContribution returns what monthly plans pay into the portfolio each month: the larger of what the saved
+// monthly plans would pay into it and the deposits monthly plans paid into it over the past month
+func (c *Customer) monthlyContribution(p *Portfolio) (float32, error) {
+	paid, err := c.monthlyPaid(p)
+	if err != nil {
+		return 0, err
+	}
+	var planned float32
+	for _, dp := range c.savedPlans {
+		amount, ok := dp.PortfolioRatio()[p.Name]
+		if !ok {
+			continue
+		}
+		converted, err := convert(c.fx, amount, dp.Currency(), p.currency())
+		if err != nil {
+			return 0, err
+		}
+		planned += converted
+	}
+	if planned > paid {
+		return roundCents(float64(planned)), nil
+	}
+	return paid, nil
+}
+
+// monthlyPaid returns the deposits monthly plans paid into the portfolio over the past month, including those paid
+// under a former name of the portfolio
+func (c *Customer) monthlyPaid(p *Portfolio) (float32, error) {
+	if c.ledger == nil {
+		return 0, nil
+	}
+	since := c.now().AddDate(0, -1, 0)
+	var total float32
+	for i, e := range c.ledger.entries {
+		if e.Customer != c.ID || e.Type != EntryDeposit || e.PlanType != "monthly" || !e.Time.After(since) || e.Time.After(c.now()) {
+			continue
+		}
+		if c.ledger.currentName(c.ID, e.Portfolio, i) != p.Name {
+			continue
+		}
+		amount, err := convert(c.fx, e.Amount, e.Currency, p.currency())
+		if err != nil {
+			return 0, err
+		}
+		total += amount
+	}
+	return roundCents(float64(total)), nil
+}
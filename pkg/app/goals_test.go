@@ -0,0 +1,207 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetGoal_shouldSetGoal(t *testing.T) {
+	setNow(t, time.Date(2020, 1, 15, 12, 0, 0, 0, time.UTC))
+	sub := &recordingSubscriber{}
+	bus := NewEventBus()
+	bus.Subscribe(sub)
+	c := Customer{ID: "c1", portfolios: []*Portfolio{{Name: "House"}, {Name: "Car"}}, events: bus}
+
+	assert.NoError(t, c.SetGoal("House", 50000, time.Date(2028, 12, 31, 23, 0, 0, 0, time.Local)))
+	assert.NoError(t, c.SetGoal("Car", 20000, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)))
+	assert.NoError(t, c.SetGoal("Car", 15000, time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)))
+	assert.Equal(t, map[string]Goal{
+		"House": {Amount: 50000, Date: time.Date(2028, 12, 1, 0, 0, 0, 0, time.UTC)},
+		"Car":   {Amount: 15000, Date: time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)},
+	}, c.Goals())
+
+	assert.NoError(t, c.RemoveGoal("Car"))
+	assert.NoError(t, c.RemoveGoal("House"))
+	assert.Nil(t, c.Goals())
+	assert.Equal(t, []string{EventGoalsSet, EventGoalsSet, EventGoalsSet, EventGoalsSet, EventGoalsSet}, sub.types())
+}
+
+func TestSetGoal_shouldReturnError_givenInvalidGoal(t *testing.T) {
+	setNow(t, time.Date(2020, 1, 15, 12, 0, 0, 0, time.UTC))
+	testSetGoal := func(portfolio string, amount float32, date time.Time, expectedErr string) {
+		c := Customer{portfolios: []*Portfolio{{Name: "House"}, {Name: "Closed", Closed: true}}}
+		err := c.SetGoal(portfolio, amount, date)
+		assert.EqualError(t, err, expectedErr)
+		assert.Nil(t, c.Goals())
+	}
+
+	date := time.Date(2028, 12, 1, 0, 0, 0, 0, time.UTC)
+	testSetGoal("Car", 50000, date, "portfolio not found")
+	testSetGoal("Closed", 50000, date, "Closed: portfolio is closed")
+	testSetGoal("House", 0, date, "invalid goal amount")
+	testSetGoal("House", -100, date, "invalid goal amount")
+	testSetGoal("House", 50000, time.Date(2019, 12, 31, 0, 0, 0, 0, time.UTC), "goal date is in the past")
+}
+
+func TestRemoveGoal_shouldReturnError_givenNoGoal(t *testing.T) {
+	c := Customer{portfolios: []*Portfolio{{Name: "House"}}}
+	assert.EqualError(t, c.RemoveGoal("Car"), "portfolio not found")
+	assert.EqualError(t, c.RemoveGoal("House"), "no goal set on portfolio")
+}
+
+func TestGoals_shouldFollowPortfolios_givenRenamedOrClosed(t *testing.T) {
+	setNow(t, time.Date(2020, 1, 15, 12, 0, 0, 0, time.UTC))
+	c := Customer{ID: "c1", Status: CustomerActive, portfolios: []*Portfolio{{Name: "House", Balance: 100}, {Name: "Car"}, {Name: "Savings"}}, ledger: NewLedger()}
+	date := time.Date(2028, 12, 1, 0, 0, 0, 0, time.UTC)
+	for _, name := range []string{"House", "Car", "Savings"} {
+		assert.NoError(t, c.SetGoal(name, 1000, date))
+	}
+
+	assert.NoError(t, c.RenamePortfolio("House", "Home"))
+	assert.Equal(t, map[string]Goal{"Home": {Amount: 1000, Date: date}, "Car": {Amount: 1000, Date: date}, "Savings": {Amount: 1000, Date: date}}, c.Goals())
+
+	assert.NoError(t, c.MergePortfolio("Home", "Savings"))
+	assert.NoError(t, c.ClosePortfolio("Car", ""))
+	assert.Equal(t, map[string]Goal{"Savings": {Amount: 1000, Date: date}}, c.Goals())
+}
+
+func TestGoalProgress_shouldReportRequiredContribution(t *testing.T) {
+	setNow(t, time.Date(2020, 1, 15, 12, 0, 0, 0, time.UTC))
+	ledger := NewLedger()
+	ledger.Record(LedgerEntry{Time: time.Date(2019, 12, 1, 0, 0, 0, 0, time.UTC), Customer: "c1", Portfolio: "House", Type: EntryDeposit, Amount: 300, PlanType: "monthly"})
+	ledger.Record(LedgerEntry{Time: time.Date(2020, 1, 10, 0, 0, 0, 0, time.UTC), Customer: "c1", Portfolio: "House", Type: EntryDeposit, Amount: 300, PlanType: "monthly"})
+	ledger.Record(LedgerEntry{Time: time.Date(2020, 1, 10, 0, 0, 0, 0, time.UTC), Customer: "c1", Portfolio: "House", Type: EntryDeposit, Amount: 5000, PlanType: "one-time"})
+	ledger.Record(LedgerEntry{Time: time.Date(2020, 1, 10, 0, 0, 0, 0, time.UTC), Customer: "c2", Portfolio: "House", Type: EntryDeposit, Amount: 300, PlanType: "monthly"})
+	ledger.Record(LedgerEntry{Time: time.Date(2020, 1, 10, 0, 0, 0, 0, time.UTC), Customer: "c1", Portfolio: "Savings", Type: EntryDeposit, Amount: 50, Currency: "USD", PlanType: "monthly"})
+	c := Customer{
+		ID: "c1",
+		portfolios: []*Portfolio{
+			{Name: "House", Balance: 12000},
+			{Name: "Savings", Balance: 1000},
+			{Name: "Car", Balance: 600},
+			{Name: "Holiday", Balance: 100},
+			{Name: "Spare", Balance: 100},
+		},
+		ledger: ledger,
+		fx:     StaticRateProvider{"USD/SGD": 2},
+		goals: map[string]Goal{
+			"House":   {Amount: 50000, Date: time.Date(2020, 12, 1, 0, 0, 0, 0, time.UTC)},
+			"Savings": {Amount: 1200, Date: time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)},
+			"Car":     {Amount: 500, Date: time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)},
+			"Holiday": {Amount: 500, Date: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+		},
+	}
+
+	progress, err := c.GoalProgress()
+	assert.NoError(t, err)
+	assert.Equal(t, []GoalProgress{
+		{Portfolio: "House", Goal: c.goals["House"], Currency: "SGD", Balance: 12000, Progress: 24, MonthsLeft: 11, Monthly: 300, Required: 3454.55},
+		{Portfolio: "Savings", Goal: c.goals["Savings"], Currency: "SGD", Balance: 1000, Progress: 83.33, MonthsLeft: 2, Monthly: 100, Required: 100, OnTrack: true},
+		{Portfolio: "Car", Goal: c.goals["Car"], Currency: "SGD", Balance: 600, Progress: 120, MonthsLeft: 5, OnTrack: true},
+		{Portfolio: "Holiday", Goal: c.goals["Holiday"], Currency: "SGD", Balance: 100, Progress: 20, Required: 400},
+	}, progress)
+	assert.Equal(t, float32(3154.55), progress[0].Shortfall())
+	assert.Equal(t, float32(0), progress[1].Shortfall())
+	assert.Equal(t, float32(400), progress[3].Shortfall())
+}
+
+func TestGoalProgress_shouldCountSavedMonthlyPlans(t *testing.T) {
+	setNow(t, time.Date(2020, 1, 15, 12, 0, 0, 0, time.UTC))
+	ledger := NewLedger()
+	ledger.Record(LedgerEntry{Time: time.Date(2020, 1, 10, 0, 0, 0, 0, time.UTC), Customer: "c1", Portfolio: "Car", Type: EntryDeposit, Amount: 300, PlanType: "monthly"})
+	c := Customer{
+		ID: "c1",
+		portfolios: []*Portfolio{
+			{Name: "House", Balance: 1000},
+			{Name: "Car", Balance: 1000},
+		},
+		ledger: ledger,
+		fx:     StaticRateProvider{"USD/SGD": 2},
+		savedPlans: []DepositPlan{
+			&baseDepositPlan{name: "Monthly", planType: "monthly", currency: "SGD", portfolioRatio: map[string]float32{"House": 400, "Car": 100}},
+			&baseDepositPlan{name: "Extra", planType: "monthly", currency: "USD", portfolioRatio: map[string]float32{"House": 50}},
+		},
+		goals: map[string]Goal{
+			"House": {Amount: 6000, Date: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)},
+			"Car":   {Amount: 6000, Date: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)},
+		},
+	}
+
+	progress, err := c.GoalProgress()
+	assert.NoError(t, err)
+	assert.Equal(t, []GoalProgress{
+		{Portfolio: "House", Goal: c.goals["House"], Currency: "SGD", Balance: 1000, Progress: 16.67, MonthsLeft: 12, Monthly: 500, Required: 416.67, OnTrack: true},
+		{Portfolio: "Car", Goal: c.goals["Car"], Currency: "SGD", Balance: 1000, Progress: 16.67, MonthsLeft: 12, Monthly: 300, Required: 416.67},
+	}, progress)
+	assert.Equal(t, float32(116.67), progress[1].Shortfall())
+}
+
+func TestRebuild_shouldReplayGoals(t *testing.T) {
+	app, _, _ := newTestRebuildApp(t)
+	setNow(t, time.Date(2020, 1, 10, 12, 0, 0, 0, time.UTC))
+	for _, input := range []string{
+		"newcustomer test1",
+		"addportfolio House",
+		"addportfolio Car",
+		"setgoal House 50000 2028-12",
+		"setgoal Car 20000 2022-06-30",
+		"renameportfolio House Home",
+		"removegoal Car",
+	} {
+		_, err := app.processInput(input)
+		assert.NoError(t, err, input)
+	}
+
+	expected := map[string]Goal{"Home": {Amount: 50000, Date: time.Date(2028, 12, 1, 0, 0, 0, 0, time.UTC)}}
+	rebuilt, err := app.Rebuild(time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, expected, rebuilt[0].Goals())
+
+	snapshot := snapshotCustomer(rebuilt[0])
+	assert.Equal(t, expected, snapshot.restore().Goals())
+}
+
+func TestApp_Goals_shouldPrintProgress(t *testing.T) {
+	setNow(t, time.Date(2020, 1, 15, 12, 0, 0, 0, time.UTC))
+	app := NewApp()
+	_, err := app.processInput("goals")
+	assert.Equal(t, ErrNoActiveCustomer, err)
+
+	for _, input := range []string{
+		"newcustomer test1",
+		"addportfolio House",
+		"goals",
+		"setgoal House 12000 2020-12",
+		"goals",
+		"startDeposit",
+		"addMonthlyPlan monthly House 500",
+		"deposit 500",
+		"endDeposit",
+		"renameportfolio House Home",
+		"goals",
+	} {
+		_, err := app.processInput(input)
+		assert.NoError(t, err, input)
+	}
+	progress, err := app.currentCustomer.GoalProgress()
+	assert.NoError(t, err)
+	if assert.Len(t, progress, 1) {
+		assert.Equal(t, "Home", progress[0].Portfolio)
+		assert.Equal(t, float32(500), progress[0].Monthly)
+		assert.Equal(t, float32(1045.46), progress[0].Required)
+		assert.Equal(t, float32(545.46), progress[0].Shortfall())
+	}
+
+	_, err = app.processInput("setgoal Home 12000")
+	assert.Equal(t, ErrInvalidArgs, err)
+	_, err = app.processInput("setgoal Home lots 2020-12")
+	assert.EqualError(t, err, "invalid goal amount")
+	_, err = app.processInput("setgoal Home 12000 december")
+	assert.EqualError(t, err, "invalid time: december")
+
+	_, err = app.processInput("removegoal Home")
+	assert.NoError(t, err)
+	assert.Nil(t, app.currentCustomer.Goals())
+}
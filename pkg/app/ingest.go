@@ -0,0 +1,259 @@
+package app
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// IncomingPayment is a transfer received into the bank account, yet to be credited to a customer
+type IncomingPayment struct {
+	Amount     float32
+	Currency   string
+	Reference  string
+	Payer      string
+	ReceivedAt time.Time
+}
+
+// QueuedPayment is an incoming payment that could not be credited automatically
+type QueuedPayment struct {
+	Payment IncomingPayment
+	Reason  string
+}
+
+// String formats the payment for display
+func (p IncomingPayment) String() string {
+	s := fmt.Sprintf("%s %.2f %s %s", p.ReceivedAt.Format("2006-01-02"), p.Amount, p.Currency, p.Reference)
+	if p.Payer != "" {
+		s += " from " + p.Payer
+	}
+	return s
+}
+
+// String formats the queued payment for display
+func (q QueuedPayment) String() string {
+	return q.Payment.String() + ": " + q.Reason
+}
+
+// IngestResult is the outcome of crediting an incoming payment. Customer and Plans are set once matched,
+// while Review is set when the session created for the payment is held for review.
+type IngestResult struct {
+	Payment  IncomingPayment
+	Customer string
+	Plans    []string
+	Review   *Review
+	Err      error
+}
+
+// LoadIncomingPayments reads a CSV file of incoming payments with a header of date,amount,currency,reference,payer
+func LoadIncomingPayments(path string) ([]IncomingPayment, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = 5
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	payments := []IncomingPayment{}
+	for i, rec := range records {
+		if i == 0 {
+			continue
+		}
+		date, err := time.Parse("2006-01-02", rec[0])
+		if err != nil {
+			return nil, &InvalidValueError{Field: "payment date", Value: rec[0]}
+		}
+		amount, err := strconv.ParseFloat(rec[1], 32)
+		if err != nil || amount <= 0 {
+			return nil, &InvalidValueError{Field: "payment amount", Value: rec[1]}
+		}
+		if err := validateCurrency(rec[2]); err != nil {
+			return nil, err
+		}
+		payments = append(payments, IncomingPayment{Amount: float32(amount), Currency: rec[2], Reference: rec[3], Payer: rec[4], ReceivedAt: date})
+	}
+	return payments, nil
+}
+
+// SavePlan keeps the plan for the customer to pay into again, replacing any saved plan with the same name
+func (c *Customer) SavePlan(plan DepositPlan) error {
+	for k := range plan.PortfolioRatio() {
+		if c.findPortfolio(k) == nil {
+			return ErrPlanPortfolioUnknown
+		}
+	}
+
+	for i, dp := range c.savedPlans {
+		if dp.Name() == plan.Name() {
+			c.savedPlans[i] = plan
+			c.publishPlans()
+			return nil
+		}
+	}
+	c.savedPlans = append(c.savedPlans, plan)
+	c.publishPlans()
+	return nil
+}
+
+// publishPlans publishes the plans saved for the customer
+func (c *Customer) publishPlans() {
+	plans := []PlanSnapshot{}
+	for _, dp := range c.savedPlans {
+		plans = append(plans, snapshotPlan(dp))
+	}
+	c.events.Publish(PlansSaved{Customer: c.ID, Plans: plans, Time: c.now()})
+}
+
+// SavedPlans returns the plans saved for the customer
+func (c *Customer) SavedPlans() []DepositPlan {
+	return c.savedPlans
+}
+
+// matchPlans returns the saved plans the payment pays for: the one plan totalling the payment amount or,
+// failing that, all the saved plans when together they total it
+func (c *Customer) matchPlans(payment IncomingPayment) ([]DepositPlan, error) {
+	var matched []DepositPlan
+	var total float32
+	for _, dp := range c.savedPlans {
+		planTotal, err := convert(c.fx, dp.DepositTotal(), dp.Currency(), payment.Currency)
+		if err != nil {
+			continue
+		}
+		total += planTotal
+		if roundCents(float64(planTotal)) == roundCents(float64(payment.Amount)) {
+			matched = append(matched, dp)
+		}
+	}
+
+	if len(matched) > 1 {
+		return nil, ErrPaymentMatchesPlans
+	}
+	if len(matched) == 1 {
+		return matched, nil
+	}
+	if len(c.savedPlans) > 1 && roundCents(float64(total)) == roundCents(float64(payment.Amount)) {
+		return c.savedPlans, nil
+	}
+	return nil, ErrPaymentUnmatched
+}
+
+// IngestPayments credits each incoming payment to the customer whose id appears in its reference, paying the
+// saved plans matching its amount in a session created and completed for it. Payments not matching exactly
+// one customer and plan, or failing to be credited, are queued for a teller to handle, and the events they
+// published are dropped.
+func (a *App) IngestPayments(payments []IncomingPayment) []IngestResult {
+	results := []IngestResult{}
+	for _, p := range payments {
+		a.events.Hold()
+		res := a.ingestPayment(p)
+		if res.Err != nil {
+			a.events.Discard()
+			a.paymentQueue = append(a.paymentQueue, QueuedPayment{Payment: p, Reason: res.Err.Error()})
+		} else {
+			a.events.Release()
+		}
+		results = append(results, res)
+	}
+	return results
+}
+
+// QueuedPayments returns the incoming payments waiting for a teller
+func (a *App) QueuedPayments() []QueuedPayment {
+	return a.paymentQueue
+}
+
+func (a *App) ingestPayment(payment IncomingPayment) IngestResult {
+	res := IngestResult{Payment: payment}
+	c, err := a.matchCustomer(payment.Reference)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	res.Customer = c.ID
+	a.markChanged(c)
+	if c.DepositSession != nil {
+		res.Err = ErrCustomerInSession
+		return res
+	}
+
+	plans, err := c.matchPlans(payment)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	for _, dp := range plans {
+		res.Plans = append(res.Plans, dp.Name())
+	}
+
+	res.Review, res.Err = c.depositPayment(payment, plans, &a.reviews, a.now())
+	return res
+}
+
+// matchCustomer returns the one customer whose id appears as a word in the reference
+func (a *App) matchCustomer(reference string) (*Customer, error) {
+	var matched *Customer
+	for _, c := range a.customers {
+		pattern := regexp.MustCompile(`(?i)(^|[^A-Za-z0-9])` + regexp.QuoteMeta(c.ID) + `($|[^A-Za-z0-9])`)
+		if !pattern.MatchString(reference) {
+			continue
+		}
+		if matched != nil {
+			return nil, ErrReferenceAmbiguous
+		}
+		matched = c
+	}
+	if matched == nil {
+		return nil, ErrReferenceUnmatched
+	}
+	return matched, nil
+}
+
+// depositPayment pays the plans with the payment in a session of its own, holding the session for review
+// when it is flagged. Nothing is credited when the session cannot be completed.
+func (c *Customer) depositPayment(payment IncomingPayment, plans []DepositPlan, reviews *ReviewQueue, at time.Time) (review *Review, err error) {
+	if err := c.StartSessionInCurrency(payment.Currency); err != nil {
+		return nil, err
+	}
+	session := c.DepositSession
+	received := len(c.received)
+	defer func() {
+		if err != nil {
+			c.received = c.received[:received]
+		}
+		c.DepositSession = nil
+	}()
+
+	for _, dp := range plans {
+		if err := c.PayDepositPlan(dp); err != nil {
+			return nil, err
+		}
+	}
+	details := DepositDetails{Source: SourceBankTransfer, Reference: payment.Reference, Payer: payment.Payer, ReceivedAt: payment.ReceivedAt}
+	if err := c.ReceiveDeposit(payment.Amount, payment.Currency, details, DepositCleared); err != nil {
+		return nil, err
+	}
+	if _, err := c.PreviewDeposit(session.depositPlans, session.deposits, session.currency); err != nil {
+		return nil, err
+	}
+
+	flags, err := c.ScreenSession()
+	if err != nil {
+		return nil, err
+	}
+	if len(flags) > 0 {
+		return reviews.Hold(c.ID, session, flags, at), nil
+	}
+	if _, err := c.completeSession(session); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
@@ -0,0 +1,165 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestIngestApp(t *testing.T) App {
+	app := NewApp()
+	for _, id := range []string{"c1", "c2"} {
+		assert.NoError(t, app.createNewCustomer([]string{id}))
+		assert.NoError(t, app.currentCustomer.AddPortfolio("Retirement"))
+		assert.NoError(t, app.currentCustomer.AddPortfolio("High Risk"))
+	}
+	c1 := app.findCustomer("c1")
+	c1.SavePlan(&baseDepositPlan{name: "Monthly", planType: "monthly", portfolioRatio: map[string]float32{"Retirement": 100}})
+	c1.SavePlan(&baseDepositPlan{name: "Growth", planType: "monthly", portfolioRatio: map[string]float32{"High Risk": 250}})
+	return app
+}
+
+func TestLoadIncomingPayments_shouldReadPayments(t *testing.T) {
+	path := writeTestStatement(t, "payments.csv", "date,amount,currency,reference,payer\n2020-01-31,100,SGD,c1 JAN,Test One\n")
+	res, err := LoadIncomingPayments(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []IncomingPayment{{Amount: 100, Currency: "SGD", Reference: "c1 JAN", Payer: "Test One", ReceivedAt: time.Date(2020, 1, 31, 0, 0, 0, 0, time.UTC)}}, res)
+
+	_, err = LoadIncomingPayments(writeTestStatement(t, "payments.csv", "date,amount,currency,reference,payer\n2020-01-31,-1,SGD,c1,\n"))
+	assert.Error(t, err)
+	assert.Equal(t, "invalid payment amount: -1", err.Error())
+}
+
+func TestSavePlan_shouldReplacePlanWithSameName(t *testing.T) {
+	c := Customer{portfolios: []*Portfolio{{Name: "A"}}}
+	assert.NoError(t, c.SavePlan(&baseDepositPlan{name: "P", portfolioRatio: map[string]float32{"A": 1}}))
+	assert.NoError(t, c.SavePlan(&baseDepositPlan{name: "P", portfolioRatio: map[string]float32{"A": 2}}))
+	assert.Equal(t, 1, len(c.SavedPlans()))
+	assert.Equal(t, float32(2), c.SavedPlans()[0].DepositTotal())
+
+	err := c.SavePlan(&baseDepositPlan{name: "Q", portfolioRatio: map[string]float32{"B": 1}})
+	assert.Error(t, err)
+	assert.Equal(t, "deposit plan does not match customer portfolio", err.Error())
+}
+
+func TestCompleteSession_shouldSaveMonthlyPlans(t *testing.T) {
+	c := &Customer{ID: "c1", portfolios: []*Portfolio{{Name: "A"}}}
+	c.StartSession()
+	c.PayDepositPlan(&baseDepositPlan{name: "Once", planType: "one-time", portfolioRatio: map[string]float32{"A": 10}})
+	c.PayDepositPlan(&baseDepositPlan{name: "Monthly", planType: "monthly", portfolioRatio: map[string]float32{"A": 20}})
+	c.Deposit(30)
+
+	_, err := c.completeSession(c.DepositSession)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(c.SavedPlans()))
+	assert.Equal(t, "Monthly", c.SavedPlans()[0].Name())
+}
+
+func TestCompleteSession_shouldReturnError_givenMonthlyPlanCannotBeSaved(t *testing.T) {
+	c := &Customer{ID: "c1", portfolios: []*Portfolio{{Name: "A"}}}
+	c.StartSession()
+	c.PayDepositPlan(&baseDepositPlan{name: "Monthly", planType: "monthly", portfolioRatio: map[string]float32{"B": 20}})
+	c.Deposit(20)
+
+	_, err := c.completeSession(c.DepositSession)
+	assert.Equal(t, ErrPlanPortfolioUnknown, err)
+	assert.Empty(t, c.SavedPlans())
+	assert.Equal(t, float32(0), c.portfolios[0].Balance)
+}
+
+func TestIngestPayments_shouldCreditMatchedPayments_andQueueTheRest(t *testing.T) {
+	app := newTestIngestApp(t)
+	tm := time.Date(2020, 1, 31, 0, 0, 0, 0, time.UTC)
+	payments := []IncomingPayment{
+		{Amount: 100, Currency: "SGD", Reference: "C1/JAN", ReceivedAt: tm},
+		{Amount: 350, Currency: "SGD", Reference: "c1 both", ReceivedAt: tm},
+		{Amount: 100, Currency: "SGD", Reference: "c12", ReceivedAt: tm},
+		{Amount: 100, Currency: "SGD", Reference: "c1 c2", ReceivedAt: tm},
+		{Amount: 100, Currency: "SGD", Reference: "c2", ReceivedAt: tm},
+		{Amount: 99, Currency: "SGD", Reference: "c1", ReceivedAt: tm},
+	}
+
+	res := app.IngestPayments(payments)
+	assert.NoError(t, res[0].Err)
+	assert.Equal(t, "c1", res[0].Customer)
+	assert.Equal(t, []string{"Monthly"}, res[0].Plans)
+	assert.NoError(t, res[1].Err)
+	assert.Equal(t, []string{"Monthly", "Growth"}, res[1].Plans)
+	assert.Equal(t, "reference does not match any customer", res[2].Err.Error())
+	assert.Equal(t, "reference matches more than one customer", res[3].Err.Error())
+	assert.Equal(t, "payment does not match any plan", res[4].Err.Error())
+	assert.Equal(t, "payment does not match any plan", res[5].Err.Error())
+
+	c1 := app.findCustomer("c1")
+	assert.Equal(t, float32(200), c1.portfolios[0].Balance)
+	assert.Equal(t, float32(250), c1.portfolios[1].Balance)
+	assert.Nil(t, c1.DepositSession)
+	assert.Equal(t, 2, len(c1.received))
+	assert.Equal(t, DepositDetails{Source: SourceBankTransfer, Reference: "C1/JAN", ReceivedAt: tm}, c1.received[0].DepositDetails)
+	assert.Equal(t, 4, len(app.QueuedPayments()))
+	assert.Equal(t, payments[2], app.QueuedPayments()[0].Payment)
+}
+
+func TestIngestPayments_shouldQueuePayments_givenFileIngestedAgain(t *testing.T) {
+	app := newTestIngestApp(t)
+	tm := time.Date(2020, 1, 31, 0, 0, 0, 0, time.UTC)
+	payments := []IncomingPayment{{Amount: 100, Currency: "SGD", Reference: "c1 JAN", ReceivedAt: tm}}
+
+	res := app.IngestPayments(payments)
+	assert.NoError(t, res[0].Err)
+	res = app.IngestPayments(payments)
+	assert.EqualError(t, res[0].Err, "duplicate deposit reference")
+
+	c1 := app.findCustomer("c1")
+	assert.Equal(t, float32(100), c1.portfolios[0].Balance)
+	assert.Equal(t, 1, len(c1.received))
+	assert.Equal(t, 1, len(app.QueuedPayments()))
+}
+
+func TestIngest_shouldNotPublishEvents_givenPaymentQueued(t *testing.T) {
+	app := newTestIngestApp(t)
+	bus := NewEventBus()
+	sub := &recordingSubscriber{}
+	bus.Subscribe(sub)
+	app.SetEventBus(bus)
+	path := writeTestStatement(t, "payments.csv", "date,amount,currency,reference,payer\n2020-01-31,100,SGD,c1 JAN,\n2020-01-31,100,SGD,c1 JAN,\n")
+
+	_, err := app.processInput("ingest " + path)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(app.QueuedPayments()))
+	assert.Equal(t, []string{EventSessionStarted, EventDepositReceived, EventPlansSaved, EventSessionCommitted}, sub.types())
+}
+
+func TestSavedPlans_shouldFollowPortfolios_givenRenamedOrMerged(t *testing.T) {
+	app := newTestIngestApp(t)
+	c1 := app.findCustomer("c1")
+	assert.NoError(t, c1.RenamePortfolio("Retirement", "Pension"))
+	assert.Equal(t, map[string]float32{"Pension": 100}, c1.SavedPlans()[0].PortfolioRatio())
+	assert.NoError(t, c1.MergePortfolio("High Risk", "Pension"))
+	assert.Equal(t, map[string]float32{"Pension": 250}, c1.SavedPlans()[1].PortfolioRatio())
+
+	res := app.IngestPayments([]IncomingPayment{{Amount: 250, Currency: "SGD", Reference: "c1 FEB"}})
+	assert.NoError(t, res[0].Err)
+	assert.Equal(t, float32(250), c1.portfolios[0].Balance)
+}
+
+func TestIngestPayments_shouldHoldFlaggedPaymentForReview(t *testing.T) {
+	app := newTestIngestApp(t)
+	app.SetScreener(&ScreeningRules{Threshold: 200})
+
+	res := app.IngestPayments([]IncomingPayment{{Amount: 250, Currency: "SGD", Reference: "c1"}})
+	assert.NoError(t, res[0].Err)
+	assert.NotNil(t, res[0].Review)
+	assert.Equal(t, float32(0), app.findCustomer("c1").portfolios[1].Balance)
+	assert.Equal(t, 1, len(app.reviews.Pending()))
+}
+
+func TestIngestPayments_shouldQueuePayment_givenCustomerInSession(t *testing.T) {
+	app := newTestIngestApp(t)
+	app.findCustomer("c1").StartSession()
+
+	res := app.IngestPayments([]IncomingPayment{{Amount: 100, Currency: "SGD", Reference: "c1"}})
+	assert.Equal(t, "customer has an active session", res[0].Err.Error())
+	assert.NotNil(t, app.findCustomer("c1").DepositSession)
+}
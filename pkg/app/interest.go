@@ -0,0 +1,117 @@
+package app
+
+import (
+	"math"
+	"time"
+)
+
+// Interest methods
+const (
+	InterestSimple   = "simple"
+	InterestCompound = "compound"
+)
+
+const daysInYear = 365
+
+// InterestRule is the interest paid on the portfolios of a product. Interest accrues daily and is
+// capitalised into the balance on the first day of every month. Simple interest is only paid on the
+// balance excluding capitalised interest, while compound interest is paid on the full balance
+// including interest accrued so far.
+type InterestRule struct {
+	Method     string  `json:"method"`
+	AnnualRate float64 `json:"annualRate"`
+}
+
+type interestCapitalisation struct {
+	time   time.Time
+	amount float32
+}
+
+func (r InterestRule) validate() error {
+	if r.AnnualRate < 0 {
+		return &ConfigError{Message: "interest rate is negative"}
+	}
+	if r.AnnualRate > 0 && r.Method != InterestSimple && r.Method != InterestCompound {
+		return &ConfigError{Message: "invalid interest method"}
+	}
+	return nil
+}
+
+// accrueInterest accrues daily interest up to the start of the day of asOf, returning the interest
+// capitalised along the way.
+func (p *Portfolio) accrueInterest(asOf time.Time) []interestCapitalisation {
+	capitalisations := []interestCapitalisation{}
+	rule := p.Product.Interest
+	if p.Closed || rule.AnnualRate == 0 {
+		return capitalisations
+	}
+
+	end := startOfDay(asOf)
+	if p.accruedThrough.IsZero() {
+		p.accruedThrough = end
+		if !p.OpenedAt.IsZero() {
+			p.accruedThrough = startOfDay(p.OpenedAt)
+		}
+	}
+
+	for day := p.accruedThrough; day.Before(end); {
+		day = day.AddDate(0, 0, 1)
+
+		base := float64(p.Balance) + p.accruedInterest
+		if rule.Method == InterestSimple {
+			base = math.Max(float64(p.Balance-p.capitalisedInterest), 0)
+		}
+		p.accruedInterest += base * rule.AnnualRate / daysInYear
+
+		if day.Day() == 1 {
+			amount := float32(math.Floor(p.accruedInterest*100) / 100)
+			if amount > 0 {
+				p.Balance += amount
+				p.capitalisedInterest += amount
+				p.accruedInterest -= float64(amount)
+				capitalisations = append(capitalisations, interestCapitalisation{time: day, amount: amount})
+			}
+		}
+		p.accruedThrough = day
+	}
+	return capitalisations
+}
+
+// projectInterest returns the expected balance at the end of each of the coming months, assuming
+// no further deposits or withdrawals.
+func (p Portfolio) projectInterest(from time.Time, months int) []float32 {
+	p.accrueInterest(from)
+	balances := []float32{}
+	for i := 1; i <= months; i++ {
+		p.accrueInterest(from.AddDate(0, i, 0))
+		balances = append(balances, p.Balance)
+	}
+	return balances
+}
+
+// AccrueInterest accrues interest on every portfolio up to asOf, recording each capitalisation in the ledger
+func (c *Customer) AccrueInterest(asOf time.Time) {
+	for _, p := range c.portfolios {
+		for _, ic := range p.accrueInterest(asOf) {
+			c.ledger.Record(LedgerEntry{Time: ic.time, Customer: c.ID, Portfolio: p.Name, Type: EntryInterest, Amount: ic.amount, Currency: p.currency()})
+			c.events.Publish(InterestPaid{Customer: c.ID, Portfolio: p.Name, Amount: ic.amount, Currency: p.currency(), Time: ic.time})
+		}
+	}
+}
+
+// ProjectInterest returns the expected balance of the portfolio at the end of each of the coming months
+func (c *Customer) ProjectInterest(portfolio string, from time.Time, months int) ([]float32, error) {
+	p := c.findPortfolio(portfolio)
+	if p == nil {
+		return nil, &PortfolioNotFoundError{Name: portfolio}
+	}
+	if months <= 0 {
+		return nil, ErrInvalidMonths
+	}
+	return p.projectInterest(from, months), nil
+}
+
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
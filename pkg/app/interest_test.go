@@ -0,0 +1,102 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInterestRuleValidate_shouldReturnError_givenInvalidRule(t *testing.T) {
+	assert.NoError(t, InterestRule{}.validate())
+	assert.NoError(t, InterestRule{Method: InterestSimple, AnnualRate: 0.01}.validate())
+	assert.Equal(t, "interest rate is negative", InterestRule{Method: InterestSimple, AnnualRate: -0.01}.validate().Error())
+	assert.Equal(t, "invalid interest method", InterestRule{AnnualRate: 0.01}.validate().Error())
+}
+
+func TestAccrueInterest_shouldCapitaliseMonthly(t *testing.T) {
+	testAccrueInterest := func(method string, asOf time.Time, expectedBalance float32, expectedCapitalisations []interestCapitalisation) {
+		p := Portfolio{
+			Name:     "test",
+			Balance:  36500,
+			Product:  Product{Type: "savings", Interest: InterestRule{Method: method, AnnualRate: 0.1}},
+			OpenedAt: time.Date(2020, 1, 31, 15, 0, 0, 0, time.UTC),
+		}
+		res := p.accrueInterest(asOf)
+		assert.Equal(t, expectedCapitalisations, res)
+		assert.Equal(t, expectedBalance, p.Balance)
+	}
+
+	// 36500 at 10% accrues 10 per day; February 2020 has 29 days
+	testAccrueInterest(InterestSimple, time.Date(2020, 1, 31, 23, 0, 0, 0, time.UTC), 36500, []interestCapitalisation{})
+	testAccrueInterest(InterestSimple, time.Date(2020, 2, 1, 9, 0, 0, 0, time.UTC), 36510, []interestCapitalisation{
+		{time: time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC), amount: 10},
+	})
+	testAccrueInterest(InterestSimple, time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC), 36800, []interestCapitalisation{
+		{time: time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC), amount: 10},
+		{time: time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC), amount: 290},
+	})
+	testAccrueInterest(InterestCompound, time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC), 36801.19, []interestCapitalisation{
+		{time: time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC), amount: 10},
+		{time: time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC), amount: 291.19},
+	})
+}
+
+func TestAccrueInterest_shouldSkipPortfolioWithoutInterest(t *testing.T) {
+	testAccrueInterest := func(p Portfolio) {
+		res := p.accrueInterest(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+		assert.Empty(t, res)
+		assert.Equal(t, float32(100), p.Balance)
+	}
+
+	testAccrueInterest(Portfolio{Name: "test", Balance: 100})
+	testAccrueInterest(Portfolio{Name: "test", Balance: 100, Closed: true, Product: Product{Type: "savings", Interest: InterestRule{Method: InterestSimple, AnnualRate: 0.1}}})
+}
+
+func TestCustomerAccrueInterest_shouldRecordCapitalisationInLedger(t *testing.T) {
+	c := Customer{ID: "c1", ledger: NewLedger(), portfolios: []*Portfolio{{
+		Name:     "Savings",
+		Balance:  36500,
+		Product:  Product{Type: "savings", Interest: InterestRule{Method: InterestSimple, AnnualRate: 0.1}},
+		OpenedAt: time.Date(2020, 1, 31, 0, 0, 0, 0, time.UTC),
+	}}}
+
+	c.AccrueInterest(time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC))
+	c.AccrueInterest(time.Date(2020, 2, 1, 12, 0, 0, 0, time.UTC))
+	assert.Equal(t, []LedgerEntry{
+		{Time: time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC), Customer: "c1", Portfolio: "Savings", Type: EntryInterest, Amount: 10, Currency: "SGD"},
+	}, c.ledger.Entries("c1"))
+}
+
+func TestProjectInterest_shouldReturnMonthlyBalances(t *testing.T) {
+	c := Customer{portfolios: []*Portfolio{{
+		Name:     "Savings",
+		Balance:  36500,
+		Product:  Product{Type: "savings", Interest: InterestRule{Method: InterestSimple, AnnualRate: 0.1}},
+		OpenedAt: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+	}}}
+
+	res, err := c.ProjectInterest("Savings", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []float32{36810, 37100}, res)
+	assert.Equal(t, float32(36500), c.portfolios[0].Balance)
+
+	_, err = c.ProjectInterest("Missing", time.Now(), 2)
+	assert.Equal(t, "portfolio not found", err.Error())
+	_, err = c.ProjectInterest("Savings", time.Now(), 0)
+	assert.Equal(t, "invalid number of months", err.Error())
+}
+
+func TestPerformCommand_shouldAccrueInterestUpToAppClock(t *testing.T) {
+	opened := time.Date(2020, 1, 31, 0, 0, 0, 0, time.UTC)
+	setNow(t, opened)
+	app := NewApp()
+	app.SetProductCatalogue(ProductCatalogue{"savings": {Type: "savings", Interest: InterestRule{Method: InterestSimple, AnnualRate: 0.1}}})
+	app.processInput("newcustomer test")
+	app.processInput("addportfolio Savings savings")
+	app.currentCustomer.portfolios[0].Balance = 36500
+
+	app.SetClock(func() time.Time { return time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC) })
+	app.processInput("printPortfolios")
+	assert.Equal(t, float32(36510), app.currentCustomer.portfolios[0].Balance)
+}
@@ -0,0 +1,139 @@
+package app
+
+import (
+	"fmt"
+	"time"
+)
+
+// Ledger entry types
+const (
+	EntryDeposit     = "deposit"
+	EntryWithdrawal  = "withdrawal"
+	EntryTransferIn  = "transfer-in"
+	EntryTransferOut = "transfer-out"
+	EntryInterest    = "interest"
+	EntryFee         = "fee"
+	EntryReturn      = "return"
+)
+
+// FeeIncomeAccount is the account fees charged on deposits are booked to
+const FeeIncomeAccount = "fee income"
+
+// LedgerEntry is a single movement of money on a customer portfolio. Credits are positive and debits negative.
+// Amount is booked in the portfolio currency, converted from the original amount where the money came in
+// another currency.
+type LedgerEntry struct {
+	Time             time.Time
+	Customer         string
+	Portfolio        string
+	Type             string
+	Amount           float32
+	Currency         string
+	OriginalAmount   float32
+	OriginalCurrency string
+	Reference        string
+	// PlanType is the type of plan a deposit is paid through
+	PlanType string
+}
+
+// Ledger is the append-only record of every movement of money
+type Ledger struct {
+	entries []LedgerEntry
+	renames []ledgerRename
+}
+
+// ledgerRename is a portfolio renamed once the entries before seq were recorded
+type ledgerRename struct {
+	customer string
+	from     string
+	to       string
+	seq      int
+}
+
+// NewLedger instantiate a ledger without any entries
+func NewLedger() *Ledger {
+	return &Ledger{entries: []LedgerEntry{}}
+}
+
+// Record appends the entry to the ledger. Recording on a nil ledger does nothing.
+func (l *Ledger) Record(entry LedgerEntry) {
+	if l == nil {
+		return
+	}
+	l.entries = append(l.entries, entry)
+}
+
+// size returns the number of entries recorded. A nil ledger has none.
+func (l *Ledger) size() int {
+	if l == nil {
+		return 0
+	}
+	return len(l.entries)
+}
+
+// truncate removes the entries recorded after the first n, undoing the operation that recorded them
+func (l *Ledger) truncate(n int) {
+	if l == nil || n >= len(l.entries) {
+		return
+	}
+	l.entries = l.entries[:n]
+}
+
+// recordRename notes the portfolio of the customer is renamed, so the entries already recorded under the
+// former name can be attributed to it
+func (l *Ledger) recordRename(customer string, from string, to string) {
+	if l == nil {
+		return
+	}
+	l.renames = append(l.renames, ledgerRename{customer: customer, from: from, to: to, seq: len(l.entries)})
+}
+
+// currentName returns the name the portfolio of the entry recorded at seq goes by now
+func (l *Ledger) currentName(customer string, name string, seq int) string {
+	for _, r := range l.renames {
+		if r.customer == customer && r.seq > seq && r.from == name {
+			name = r.to
+		}
+	}
+	return name
+}
+
+// AccountEntries returns the entries recorded against an account not owned by a customer, oldest first
+func (l *Ledger) AccountEntries(account string) []LedgerEntry {
+	entries := []LedgerEntry{}
+	if l == nil {
+		return entries
+	}
+	for _, e := range l.entries {
+		if e.Customer == "" && e.Portfolio == account {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// Entries returns the entries recorded for the customer, oldest first
+func (l *Ledger) Entries(customer string) []LedgerEntry {
+	entries := []LedgerEntry{}
+	if l == nil {
+		return entries
+	}
+	for _, e := range l.entries {
+		if e.Customer == customer {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// String formats the entry for display
+func (e LedgerEntry) String() string {
+	s := fmt.Sprintf("%s %s %s %.2f %s", e.Time.Format("2006-01-02 15:04:05"), e.Portfolio, e.Type, e.Amount, e.Currency)
+	if e.OriginalCurrency != "" && e.OriginalCurrency != e.Currency {
+		s += fmt.Sprintf(" [%.2f %s]", e.OriginalAmount, e.OriginalCurrency)
+	}
+	if e.Reference != "" {
+		s += " (" + e.Reference + ")"
+	}
+	return s
+}
@@ -0,0 +1,60 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLedgerEntries_shouldReturnEntriesOfCustomer(t *testing.T) {
+	tm := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := NewLedger()
+	l.Record(LedgerEntry{Time: tm, Customer: "c1", Portfolio: "Retirement", Type: EntryDeposit, Amount: 100})
+	l.Record(LedgerEntry{Time: tm, Customer: "c2", Portfolio: "Retirement", Type: EntryDeposit, Amount: 50})
+	l.Record(LedgerEntry{Time: tm, Customer: "c1", Portfolio: "Retirement", Type: EntryWithdrawal, Amount: -20})
+
+	assert.Equal(t, []LedgerEntry{
+		{Time: tm, Customer: "c1", Portfolio: "Retirement", Type: EntryDeposit, Amount: 100},
+		{Time: tm, Customer: "c1", Portfolio: "Retirement", Type: EntryWithdrawal, Amount: -20},
+	}, l.Entries("c1"))
+	assert.Empty(t, l.Entries("c3"))
+}
+
+func TestLedgerRecord_shouldIgnoreNilLedger(t *testing.T) {
+	var l *Ledger
+	l.Record(LedgerEntry{Customer: "c1"})
+	assert.Empty(t, l.Entries("c1"))
+}
+
+func TestCustomerLedger_shouldRecordMovements(t *testing.T) {
+	tm := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	setNow(t, tm)
+	c := Customer{ID: "c1", portfolios: []*Portfolio{{Name: "Retirement"}, {Name: "High Risk"}}, ledger: NewLedger()}
+
+	assert.NoError(t, c.PerformDeposit(
+		[]DepositPlan{&baseDepositPlan{name: "Plan A", planType: "one-time", portfolioRatio: map[string]float32{"Retirement": 100}}},
+		[]float32{100},
+	))
+	assert.NoError(t, c.Withdraw("Retirement", 30))
+	assert.NoError(t, c.MergePortfolio("Retirement", "High Risk"))
+
+	assert.Equal(t, []LedgerEntry{
+		{Time: tm, Customer: "c1", Portfolio: "Retirement", Type: EntryDeposit, Amount: 100, Currency: "SGD", OriginalAmount: 100, OriginalCurrency: "SGD", Reference: "Plan A", PlanType: "one-time"},
+		{Time: tm, Customer: "c1", Portfolio: "Retirement", Type: EntryWithdrawal, Amount: -30, Currency: "SGD"},
+		{Time: tm, Customer: "c1", Portfolio: "Retirement", Type: EntryTransferOut, Amount: -70, Currency: "SGD", Reference: "High Risk"},
+		{Time: tm, Customer: "c1", Portfolio: "High Risk", Type: EntryTransferIn, Amount: 70, Currency: "SGD", Reference: "Retirement"},
+	}, c.ledger.Entries("c1"))
+}
+
+func TestLedgerEntryString_shouldShowOriginalAmount_givenConverted(t *testing.T) {
+	tm := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	testString := func(e LedgerEntry, expected string) {
+		assert.Equal(t, expected, e.String())
+	}
+
+	testString(LedgerEntry{Time: tm, Portfolio: "Retirement", Type: EntryDeposit, Amount: 100, Currency: "SGD", OriginalAmount: 100, OriginalCurrency: "SGD", Reference: "Plan A"},
+		"2020-01-01 00:00:00 Retirement deposit 100.00 SGD (Plan A)")
+	testString(LedgerEntry{Time: tm, Portfolio: "Retirement", Type: EntryDeposit, Amount: 135, Currency: "SGD", OriginalAmount: 100, OriginalCurrency: "USD", Reference: "Plan A"},
+		"2020-01-01 00:00:00 Retirement deposit 135.00 SGD [100.00 USD] (Plan A)")
+}
@@ -0,0 +1,293 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// Limit periods
+const (
+	PeriodTransaction = "transaction"
+	PeriodDaily       = "daily"
+	PeriodMonthly     = "monthly"
+)
+
+// Limit scopes
+const (
+	ScopeCustomer = "customer"
+	ScopeProduct  = "product"
+)
+
+// Limited operations
+const (
+	OperationDeposit    = "deposit"
+	OperationWithdrawal = "withdrawal"
+)
+
+// LimitRule caps the amount, in the default currency, of an operation within a period. Customer scoped rules
+// apply to all the money a customer moves and product scoped rules to the money moved into or out of
+// portfolios of the product type. Breaking a soft rule requires supervisor approval, while hard rules reject.
+type LimitRule struct {
+	Operation   string  `json:"operation"`
+	Scope       string  `json:"scope"`
+	ProductType string  `json:"productType"`
+	Period      string  `json:"period"`
+	Amount      float32 `json:"amount"`
+	Soft        bool    `json:"soft"`
+}
+
+// Limits is the set of rules operations are evaluated against
+type Limits struct {
+	Rules []LimitRule `json:"rules"`
+}
+
+// LimitError is returned when an operation breaks a limit rule
+type LimitError struct {
+	Rule      LimitRule
+	Attempted float32
+}
+
+// LoadLimits reads a JSON limit configuration from path
+func LoadLimits(path string) (*Limits, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Limits{}
+	if err := json.Unmarshal(data, l); err != nil {
+		return nil, err
+	}
+	for _, r := range l.Rules {
+		if err := r.validate(); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+func (r LimitRule) validate() error {
+	if r.Operation != OperationDeposit && r.Operation != OperationWithdrawal {
+		return &ConfigError{Message: "invalid limit operation: " + r.Operation}
+	}
+	if r.Scope != ScopeCustomer && r.Scope != ScopeProduct {
+		return &ConfigError{Message: "invalid limit scope: " + r.Scope}
+	}
+	if r.Scope == ScopeProduct && r.ProductType == "" {
+		return &ConfigError{Message: "product limit without product type"}
+	}
+	if r.Period != PeriodTransaction && r.Period != PeriodDaily && r.Period != PeriodMonthly {
+		return &ConfigError{Message: "invalid limit period: " + r.Period}
+	}
+	if r.Amount < 0 {
+		return &ConfigError{Message: "limit amount is negative"}
+	}
+	return nil
+}
+
+// Code identifies the kind of rule broken, e.g. LIMIT_DEPOSIT_DAILY_CUSTOMER_HARD
+func (r LimitRule) Code() string {
+	severity := "hard"
+	if r.Soft {
+		severity = "soft"
+	}
+	return strings.ToUpper(fmt.Sprintf("limit_%s_%s_%s_%s", r.Operation, r.Period, r.Scope, severity))
+}
+
+func (r LimitRule) periodStart(t time.Time) time.Time {
+	if r.Period == PeriodMonthly {
+		y, m, _ := t.Date()
+		return time.Date(y, m, 1, 0, 0, 0, 0, t.Location())
+	}
+	return startOfDay(t)
+}
+
+// Code returns the code of the broken rule
+func (e *LimitError) Code() string {
+	return e.Rule.Code()
+}
+
+func (e *LimitError) Error() string {
+	scope := e.Rule.Scope
+	if e.Rule.Scope == ScopeProduct {
+		scope = e.Rule.ProductType
+	}
+	msg := fmt.Sprintf("%s: %s %s %s limit of %.2f exceeded (%.2f)", e.Code(), scope, e.Rule.Period, e.Rule.Operation, e.Rule.Amount, e.Attempted)
+	if e.Rule.Soft {
+		msg += ", supervisor approval required"
+	}
+	return msg
+}
+
+// covers reports whether the approval of the limit error extends to the other error, which breaks the same soft
+// rule by no more than was approved
+func (e *LimitError) covers(err *LimitError) bool {
+	return e != nil && e.Rule.Soft && e.Rule == err.Rule && err.Attempted <= e.Attempted
+}
+
+// checkLimits evaluates the amounts, in the default currency, of an operation against the rules of the scope.
+// Every amount is checked against per transaction rules while their total is checked against period rules
+// along with pending amounts not yet in the ledger. Hard limits are reported before soft ones. The soft limit a
+// supervisor approved is not reported again unless the amount attempted grew since.
+func (c *Customer) checkLimits(operation string, scope string, productType string, amounts []float32, pending float32) error {
+	if c.limits == nil {
+		return nil
+	}
+
+	var total float32
+	for _, a := range amounts {
+		total += a
+	}
+
+	var soft error
+	for _, r := range c.limits.Rules {
+		if r.Operation != operation || r.Scope != scope || (scope == ScopeProduct && r.ProductType != productType) {
+			continue
+		}
+		var err *LimitError
+		if r.Period == PeriodTransaction {
+			for _, a := range amounts {
+				if a > r.Amount {
+					err = &LimitError{Rule: r, Attempted: a}
+					break
+				}
+			}
+		} else {
+			used, uerr := c.usedInPeriod(r)
+			if uerr != nil {
+				return uerr
+			}
+			if used+pending+total > r.Amount {
+				err = &LimitError{Rule: r, Attempted: used + pending + total}
+			}
+		}
+
+		if err == nil || c.approvedLimit.covers(err) {
+			continue
+		}
+		if !r.Soft {
+			return err
+		}
+		if soft == nil {
+			soft = err
+		}
+	}
+	return soft
+}
+
+// usedInPeriod sums, in the default currency, the money moved by the rule operation so far in the rule period
+func (c *Customer) usedInPeriod(r LimitRule) (float32, error) {
+	entryType := EntryDeposit
+	if r.Operation == OperationWithdrawal {
+		entryType = EntryWithdrawal
+	}
+	since := r.periodStart(c.now())
+
+	var used float32
+	if c.ledger == nil {
+		return 0, nil
+	}
+	for i, e := range c.ledger.entries {
+		if e.Customer != c.ID || e.Type != entryType || e.Time.Before(since) {
+			continue
+		}
+		if r.Scope == ScopeProduct {
+			p := c.findPortfolio(c.ledger.currentName(c.ID, e.Portfolio, i))
+			if p == nil || p.Product.Type != r.ProductType {
+				continue
+			}
+		}
+		amount := e.Amount
+		if amount < 0 {
+			amount = -amount
+		}
+		converted, err := convert(c.fx, amount, e.Currency, DefaultCurrency)
+		if err != nil {
+			return 0, err
+		}
+		used += converted
+	}
+	return used, nil
+}
+
+// checkSessionDepositLimits evaluates a deposit in the session currency, along with the deposits already
+// made in the session, against the customer deposit limits
+func (c *Customer) checkSessionDepositLimits(amount float32, currency string) error {
+	if c.limits == nil {
+		return nil
+	}
+
+	converted, err := convert(c.fx, amount, currency, DefaultCurrency)
+	if err != nil {
+		return err
+	}
+	var pending float32
+	for _, d := range c.DepositSession.deposits {
+		pending += d
+	}
+	if pending, err = convert(c.fx, pending, currency, DefaultCurrency); err != nil {
+		return err
+	}
+	return c.checkLimits(OperationDeposit, ScopeCustomer, "", []float32{converted}, pending)
+}
+
+// checkWithdrawalLimits evaluates a withdrawal from the portfolio against the withdrawal limits
+func (c *Customer) checkWithdrawalLimits(p *Portfolio, amount float32) error {
+	if c.limits == nil {
+		return nil
+	}
+
+	converted, err := convert(c.fx, amount, p.currency(), DefaultCurrency)
+	if err != nil {
+		return err
+	}
+	if err := c.checkLimits(OperationWithdrawal, ScopeCustomer, "", []float32{converted}, 0); err != nil {
+		return err
+	}
+	return c.checkLimits(OperationWithdrawal, ScopeProduct, p.Product.Type, []float32{converted}, 0)
+}
+
+// checkDepositLimits evaluates the deposits made, and the credits to portfolios of each product type,
+// against the deposit limits
+func (c *Customer) checkDepositLimits(credits []DepositCredit, deposits []float32, currency string) error {
+	if c.limits == nil {
+		return nil
+	}
+
+	amounts := []float32{}
+	for _, d := range deposits {
+		converted, err := convert(c.fx, d, currency, DefaultCurrency)
+		if err != nil {
+			return err
+		}
+		amounts = append(amounts, converted)
+	}
+	if err := c.checkLimits(OperationDeposit, ScopeCustomer, "", amounts, 0); err != nil {
+		return err
+	}
+
+	productTypes := []string{}
+	byProduct := map[string][]float32{}
+	for _, cr := range credits {
+		if cr.ProductType == "" || cr.Net == 0 {
+			continue
+		}
+		converted, err := convert(c.fx, cr.Net, cr.Currency, DefaultCurrency)
+		if err != nil {
+			return err
+		}
+		if _, ok := byProduct[cr.ProductType]; !ok {
+			productTypes = append(productTypes, cr.ProductType)
+		}
+		byProduct[cr.ProductType] = append(byProduct[cr.ProductType], converted)
+	}
+	for _, productType := range productTypes {
+		if err := c.checkLimits(OperationDeposit, ScopeProduct, productType, byProduct[productType], 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package app
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadLimits_shouldLoadRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "limits.json")
+	ioutil.WriteFile(path, []byte(`{"rules":[{"operation":"deposit","scope":"customer","period":"daily","amount":1000,"soft":true}]}`), 0600)
+
+	res, err := LoadLimits(path)
+	assert.NoError(t, err)
+	assert.Equal(t, &Limits{Rules: []LimitRule{{Operation: OperationDeposit, Scope: ScopeCustomer, Period: PeriodDaily, Amount: 1000, Soft: true}}}, res)
+}
+
+func TestLoadLimits_shouldReturnError_givenInvalidRule(t *testing.T) {
+	testLoad := func(rule string, expectedErr string) {
+		path := filepath.Join(t.TempDir(), "limits.json")
+		ioutil.WriteFile(path, []byte(`{"rules":[`+rule+`]}`), 0600)
+		res, err := LoadLimits(path)
+		assert.Error(t, err)
+		assert.Equal(t, expectedErr, err.Error())
+		assert.Nil(t, res)
+	}
+
+	testLoad(`{"operation":"transfer","scope":"customer","period":"daily"}`, "invalid limit operation: transfer")
+	testLoad(`{"operation":"deposit","scope":"branch","period":"daily"}`, "invalid limit scope: branch")
+	testLoad(`{"operation":"deposit","scope":"product","period":"daily"}`, "product limit without product type")
+	testLoad(`{"operation":"deposit","scope":"customer","period":"weekly"}`, "invalid limit period: weekly")
+	testLoad(`{"operation":"deposit","scope":"customer","period":"daily","amount":-1}`, "limit amount is negative")
+}
+
+func TestLimitError_shouldDescribeRuleBroken(t *testing.T) {
+	err := &LimitError{Rule: LimitRule{Operation: OperationWithdrawal, Scope: ScopeProduct, ProductType: "savings", Period: PeriodMonthly, Amount: 500, Soft: true}, Attempted: 600}
+	assert.Equal(t, "LIMIT_WITHDRAWAL_MONTHLY_PRODUCT_SOFT", err.Code())
+	assert.Equal(t, "LIMIT_WITHDRAWAL_MONTHLY_PRODUCT_SOFT: savings monthly withdrawal limit of 500.00 exceeded (600.00), supervisor approval required", err.Error())
+}
+
+func TestDeposit_shouldEnforceLimits(t *testing.T) {
+	setNow(t, time.Date(2020, 1, 15, 10, 0, 0, 0, time.UTC))
+	testDeposit := func(rule LimitRule, history []LedgerEntry, amount float32, expectedCode string) {
+		c := Customer{ID: "c1", ledger: NewLedger(), limits: &Limits{Rules: []LimitRule{rule}}}
+		for _, e := range history {
+			c.ledger.Record(e)
+		}
+		c.StartSession()
+		assert.NoError(t, c.Deposit(100))
+
+		err := c.Deposit(amount)
+		if expectedCode == "" {
+			assert.NoError(t, err)
+			return
+		}
+		assert.Error(t, err)
+		assert.Equal(t, expectedCode, err.(*LimitError).Code())
+	}
+
+	daily := LimitRule{Operation: OperationDeposit, Scope: ScopeCustomer, Period: PeriodDaily, Amount: 1000}
+	monthly := LimitRule{Operation: OperationDeposit, Scope: ScopeCustomer, Period: PeriodMonthly, Amount: 1000}
+	yesterday := []LedgerEntry{{Time: time.Date(2020, 1, 14, 10, 0, 0, 0, time.UTC), Customer: "c1", Type: EntryDeposit, Amount: 500, Currency: "SGD"}}
+
+	testDeposit(LimitRule{Operation: OperationDeposit, Scope: ScopeCustomer, Period: PeriodTransaction, Amount: 500}, nil, 500, "")
+	testDeposit(LimitRule{Operation: OperationDeposit, Scope: ScopeCustomer, Period: PeriodTransaction, Amount: 500}, nil, 501, "LIMIT_DEPOSIT_TRANSACTION_CUSTOMER_HARD")
+	testDeposit(daily, nil, 900, "")
+	testDeposit(daily, nil, 901, "LIMIT_DEPOSIT_DAILY_CUSTOMER_HARD")
+	testDeposit(daily, yesterday, 900, "")
+	testDeposit(monthly, yesterday, 401, "LIMIT_DEPOSIT_MONTHLY_CUSTOMER_HARD")
+}
+
+func TestWithdraw_shouldEnforceProductLimits(t *testing.T) {
+	setNow(t, time.Date(2020, 1, 15, 10, 0, 0, 0, time.UTC))
+	c := Customer{
+		ID:         "c1",
+		portfolios: []*Portfolio{{Name: "Savings", Balance: 1000, Product: Product{Type: "savings"}}, {Name: "Other", Balance: 1000}},
+		ledger:     NewLedger(),
+		limits: &Limits{Rules: []LimitRule{
+			{Operation: OperationWithdrawal, Scope: ScopeProduct, ProductType: "savings", Period: PeriodDaily, Amount: 300},
+		}},
+	}
+
+	assert.NoError(t, c.Withdraw("Savings", 200))
+	assert.NoError(t, c.Withdraw("Other", 500))
+	err := c.Withdraw("Savings", 200)
+	assert.Error(t, err)
+	assert.Equal(t, "LIMIT_WITHDRAWAL_DAILY_PRODUCT_HARD: savings daily withdrawal limit of 300.00 exceeded (400.00)", err.Error())
+	assert.Equal(t, float32(800), c.portfolios[0].Balance)
+}
+
+func TestWithdraw_shouldEnforceProductLimits_givenPortfolioRenamed(t *testing.T) {
+	setNow(t, time.Date(2020, 1, 15, 10, 0, 0, 0, time.UTC))
+	c := Customer{
+		ID:         "c1",
+		portfolios: []*Portfolio{{Name: "Savings", Balance: 1000, Product: Product{Type: "savings"}}},
+		ledger:     NewLedger(),
+		limits: &Limits{Rules: []LimitRule{
+			{Operation: OperationWithdrawal, Scope: ScopeProduct, ProductType: "savings", Period: PeriodDaily, Amount: 300},
+		}},
+	}
+
+	assert.NoError(t, c.Withdraw("Savings", 200))
+	assert.NoError(t, c.RenamePortfolio("Savings", "Rainy Day"))
+	err := c.Withdraw("Rainy Day", 200)
+	assert.Error(t, err)
+	assert.Equal(t, "LIMIT_WITHDRAWAL_DAILY_PRODUCT_HARD: savings daily withdrawal limit of 300.00 exceeded (400.00)", err.Error())
+	assert.Equal(t, float32(800), c.portfolios[0].Balance)
+}
+
+func TestCheckLimits_shouldReportHardLimitsFirst_andSkipApprovedSoftLimit(t *testing.T) {
+	soft := LimitRule{Operation: OperationDeposit, Scope: ScopeCustomer, Period: PeriodTransaction, Amount: 100, Soft: true}
+	hard := LimitRule{Operation: OperationDeposit, Scope: ScopeCustomer, Period: PeriodTransaction, Amount: 200}
+	c := Customer{ID: "c1", limits: &Limits{Rules: []LimitRule{soft, hard}}}
+
+	err := c.checkLimits(OperationDeposit, ScopeCustomer, "", []float32{300}, 0)
+	assert.Equal(t, &LimitError{Rule: hard, Attempted: 300}, err)
+	err = c.checkLimits(OperationDeposit, ScopeCustomer, "", []float32{150}, 0)
+	assert.Equal(t, &LimitError{Rule: soft, Attempted: 150}, err)
+
+	c.approvedLimit = &LimitError{Rule: soft, Attempted: 150}
+	assert.NoError(t, c.checkLimits(OperationDeposit, ScopeCustomer, "", []float32{150}, 0))
+	assert.NoError(t, c.checkLimits(OperationDeposit, ScopeCustomer, "", []float32{120}, 0))
+	err = c.checkLimits(OperationDeposit, ScopeCustomer, "", []float32{180}, 0)
+	assert.Equal(t, &LimitError{Rule: soft, Attempted: 180}, err)
+	assert.Error(t, c.checkLimits(OperationDeposit, ScopeCustomer, "", []float32{300}, 0))
+}
@@ -1,40 +1,146 @@
 package app
 
 import (
-	"errors"
+	"time"
 )
 
+// now returns the current time. It is replaced in tests to control the date rules are evaluated on.
+var now = time.Now
+
 // Portfolio stores the state of the product
 type Portfolio struct {
-	Name    string
-	Balance float32
+	Name     string
+	Balance  float32
+	Closed   bool
+	Product  Product
+	OpenedAt time.Time
+	Currency string
+
+	contributionYear  int
+	yearContributions float32
+
+	accruedThrough      time.Time
+	accruedInterest     float64
+	capitalisedInterest float32
+
+	uncleared float32
 }
 
 // NewPortfolio instantiate and returns a portfolio with the specified name.
 func NewPortfolio(name string) (Portfolio, error) {
 	if name == "" {
-		return Portfolio{}, errors.New("invalid name")
+		return Portfolio{}, ErrInvalidName
 	}
 	return Portfolio{Name: name}, nil
 }
 
+// NewProductPortfolio instantiate and returns a portfolio with the specified name, governed by the product rules.
+func NewProductPortfolio(name string, product Product) (Portfolio, error) {
+	return newProductPortfolio(name, product, now())
+}
+
+// newProductPortfolio returns a product portfolio opened at the time
+func newProductPortfolio(name string, product Product, openedAt time.Time) (Portfolio, error) {
+	p, err := NewPortfolio(name)
+	if err != nil {
+		return Portfolio{}, err
+	}
+	if err := product.validate(); err != nil {
+		return Portfolio{}, err
+	}
+	p.Product = product
+	p.OpenedAt = openedAt
+	return p, nil
+}
+
+// currency returns the currency the portfolio is held in
+func (p *Portfolio) currency() string {
+	return currencyOrDefault(p.Currency)
+}
+
 // Deposit add to portfolio balance by the specified amount.
 func (p *Portfolio) Deposit(amount float32) error {
-	if amount < 0 {
-		return errors.New("amount is negative")
+	return p.deposit(amount, now())
+}
+
+// deposit adds the amount to the portfolio balance as at the time
+func (p *Portfolio) deposit(amount float32, at time.Time) error {
+	if err := p.canDeposit(amount, at); err != nil {
+		return err
 	}
 	p.Balance += amount
+	if p.Product.AnnualCap > 0 {
+		p.yearContributions = p.contributedThisYear(at) + amount
+		p.contributionYear = at.Year()
+	}
 	return nil
 }
 
 // Withdraw subtract from portfolio balance by the specified amount.
 func (p *Portfolio) Withdraw(amount float32) error {
+	return p.withdraw(amount, now())
+}
+
+// withdraw subtracts the amount from the portfolio balance as at the time
+func (p *Portfolio) withdraw(amount float32, at time.Time) error {
+	if err := p.canWithdraw(amount, at); err != nil {
+		return err
+	}
+	if p.Product.MinimumBalance > 0 && p.Balance-amount < p.Product.MinimumBalance {
+		return ErrMinimumBalance
+	}
+	p.Balance -= amount
+	return nil
+}
+
+// withdrawAll empties the portfolio. The minimum balance does not apply as the portfolio is being closed.
+func (p *Portfolio) withdrawAll(at time.Time) (float32, error) {
+	amount := p.Balance
+	if err := p.canWithdraw(amount, at); err != nil {
+		return 0, err
+	}
+	p.Balance = 0
+	return amount, nil
+}
+
+func (p *Portfolio) canDeposit(amount float32, at time.Time) error {
+	if amount < 0 {
+		return ErrAmountNegative
+	}
+	if p.Closed {
+		return ErrPortfolioClosed
+	}
+	if p.Product.AnnualCap > 0 && p.contributedThisYear(at)+amount > p.Product.AnnualCap {
+		return ErrContributionCapExceeded
+	}
+	return nil
+}
+
+func (p *Portfolio) canWithdraw(amount float32, at time.Time) error {
 	if amount < 0 {
-		return errors.New("amount is negative")
+		return ErrAmountNegative
+	}
+	if p.Closed {
+		return ErrPortfolioClosed
+	}
+	if p.Product.LockInMonths > 0 {
+		unlock := p.OpenedAt.AddDate(0, p.Product.LockInMonths, 0)
+		if at.Before(unlock) {
+			return &LockedInError{Until: unlock}
+		}
 	}
 	if p.Balance < amount {
-		return errors.New("withdrawal amount more than balance")
+		return ErrInsufficientBalance
+	}
+	if p.Balance-p.uncleared < amount {
+		return ErrInsufficientCleared
 	}
-	p.Balance -= amount
 	return nil
 }
+
+func (p *Portfolio) contributedThisYear(at time.Time) float32 {
+	if p.contributionYear != at.Year() {
+		return 0
+	}
+	return p.yearContributions
+}
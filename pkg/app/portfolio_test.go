@@ -2,6 +2,7 @@ package app
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -88,3 +89,80 @@ func TestWithdraw_shouldReturnError_givenAmountMoreThanBalance(t *testing.T) {
 	testWithdraw(10.10, 0)
 	testWithdraw(10.11, 10.10)
 }
+
+func TestDeposit_shouldReturnError_givenPortfolioClosed(t *testing.T) {
+	p := Portfolio{Name: "test", Balance: 10, Closed: true}
+	err := p.Deposit(10)
+	assert.Error(t, err)
+	assert.Equal(t, "portfolio is closed", err.Error())
+	assert.Equal(t, float32(10), p.Balance)
+}
+
+func TestWithdraw_shouldReturnError_givenPortfolioClosed(t *testing.T) {
+	p := Portfolio{Name: "test", Balance: 10, Closed: true}
+	err := p.Withdraw(10)
+	assert.Error(t, err)
+	assert.Equal(t, "portfolio is closed", err.Error())
+	assert.Equal(t, float32(10), p.Balance)
+}
+
+func setNow(t *testing.T, tm time.Time) {
+	original := now
+	now = func() time.Time { return tm }
+	t.Cleanup(func() { now = original })
+}
+
+func TestNewProductPortfolio_shouldCreatePortfolioWithProduct(t *testing.T) {
+	opened := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	setNow(t, opened)
+	product := Product{Type: "retirement", AnnualCap: 100}
+
+	res, err := NewProductPortfolio("test", product)
+	assert.NoError(t, err)
+	assert.Equal(t, Portfolio{Name: "test", Product: product, OpenedAt: opened}, res)
+}
+
+func TestDeposit_shouldReturnError_givenAnnualCapExceeded(t *testing.T) {
+	setNow(t, time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC))
+	p := Portfolio{Name: "test", Product: Product{Type: "retirement", AnnualCap: 100}}
+
+	assert.NoError(t, p.Deposit(60))
+	err := p.Deposit(50)
+	assert.Error(t, err)
+	assert.Equal(t, "annual contribution cap exceeded", err.Error())
+	assert.Equal(t, float32(60), p.Balance)
+
+	setNow(t, time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	assert.NoError(t, p.Deposit(100))
+	assert.Equal(t, float32(160), p.Balance)
+}
+
+func TestWithdraw_shouldReturnError_givenPortfolioLockedIn(t *testing.T) {
+	p := Portfolio{Name: "test", Balance: 100, Product: Product{Type: "retirement", LockInMonths: 12}, OpenedAt: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+	setNow(t, time.Date(2020, 12, 31, 0, 0, 0, 0, time.UTC))
+	err := p.Withdraw(10)
+	assert.Error(t, err)
+	assert.Equal(t, "portfolio is locked in until 2021-01-01", err.Error())
+	_, err = p.withdrawAll(now())
+	assert.Error(t, err)
+	assert.Equal(t, float32(100), p.Balance)
+
+	setNow(t, time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	assert.NoError(t, p.Withdraw(10))
+	assert.Equal(t, float32(90), p.Balance)
+}
+
+func TestWithdraw_shouldReturnError_givenMinimumBalanceBreached(t *testing.T) {
+	p := Portfolio{Name: "test", Balance: 150, Product: Product{Type: "savings", MinimumBalance: 100}}
+
+	err := p.Withdraw(60)
+	assert.Error(t, err)
+	assert.Equal(t, "withdrawal would breach minimum balance", err.Error())
+	assert.NoError(t, p.Withdraw(50))
+
+	amount, err := p.withdrawAll(now())
+	assert.NoError(t, err)
+	assert.Equal(t, float32(100), amount)
+	assert.Equal(t, float32(0), p.Balance)
+}
@@ -0,0 +1,76 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// Product is a portfolio product type along with the rules applied to its portfolios.
+// Zero valued rules are not enforced.
+type Product struct {
+	Type           string       `json:"type"`
+	AnnualCap      float32      `json:"annualCap"`
+	LockInMonths   int          `json:"lockInMonths"`
+	MinimumBalance float32      `json:"minimumBalance"`
+	Interest       InterestRule `json:"interest"`
+}
+
+// ProductCatalogue lists the products portfolios can be opened with, by type
+type ProductCatalogue map[string]Product
+
+// DefaultProductCatalogue returns the products offered when no catalogue is configured
+func DefaultProductCatalogue() ProductCatalogue {
+	return ProductCatalogue{
+		"retirement": {Type: "retirement", AnnualCap: 15000, LockInMonths: 120, Interest: InterestRule{Method: InterestCompound, AnnualRate: 0.025}},
+		"savings":    {Type: "savings", MinimumBalance: 100, Interest: InterestRule{Method: InterestSimple, AnnualRate: 0.01}},
+		"high-risk":  {Type: "high-risk", MinimumBalance: 1000},
+	}
+}
+
+// LoadProductCatalogue reads a JSON list of products from path
+func LoadProductCatalogue(path string) (ProductCatalogue, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	products := []Product{}
+	if err := json.Unmarshal(data, &products); err != nil {
+		return nil, err
+	}
+
+	catalogue := ProductCatalogue{}
+	for _, p := range products {
+		if err := p.validate(); err != nil {
+			return nil, err
+		}
+		if _, ok := catalogue[p.Type]; ok {
+			return nil, &ConfigError{Message: "duplicate product type: " + p.Type}
+		}
+		catalogue[p.Type] = p
+	}
+	return catalogue, nil
+}
+
+// Get returns the product of the specified type
+func (pc ProductCatalogue) Get(productType string) (Product, error) {
+	p, ok := pc[productType]
+	if !ok {
+		return Product{}, fmt.Errorf("%w: %s", ErrUnknownProductType, productType)
+	}
+	return p, nil
+}
+
+func (p Product) validate() error {
+	if p.Type == "" {
+		return &ConfigError{Message: "product type is empty"}
+	}
+	if p.AnnualCap < 0 || p.LockInMonths < 0 || p.MinimumBalance < 0 {
+		return &ConfigError{Message: "invalid product rules: " + p.Type}
+	}
+	if err := p.Interest.validate(); err != nil {
+		return fmt.Errorf("%s: %w", p.Type, err)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package app
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadProductCatalogue_shouldLoadProducts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "products.json")
+	ioutil.WriteFile(path, []byte(`[{"type":"retirement","annualCap":1000,"lockInMonths":12},{"type":"savings","minimumBalance":50}]`), 0600)
+
+	res, err := LoadProductCatalogue(path)
+	assert.NoError(t, err)
+	assert.Equal(t, ProductCatalogue{
+		"retirement": {Type: "retirement", AnnualCap: 1000, LockInMonths: 12},
+		"savings":    {Type: "savings", MinimumBalance: 50},
+	}, res)
+}
+
+func TestLoadProductCatalogue_shouldReturnError_givenInvalidProducts(t *testing.T) {
+	testLoadProductCatalogue := func(content string, expectedErr string) {
+		path := filepath.Join(t.TempDir(), "products.json")
+		ioutil.WriteFile(path, []byte(content), 0600)
+		res, err := LoadProductCatalogue(path)
+		assert.Error(t, err)
+		assert.Equal(t, expectedErr, err.Error())
+		assert.Nil(t, res)
+	}
+
+	testLoadProductCatalogue(`[{"type":""}]`, "product type is empty")
+	testLoadProductCatalogue(`[{"type":"savings","minimumBalance":-1}]`, "invalid product rules: savings")
+	testLoadProductCatalogue(`[{"type":"savings"},{"type":"savings"}]`, "duplicate product type: savings")
+}
+
+func TestProductCatalogueGet_shouldReturnProduct(t *testing.T) {
+	catalogue := DefaultProductCatalogue()
+	p, err := catalogue.Get("retirement")
+	assert.NoError(t, err)
+	assert.Equal(t, "retirement", p.Type)
+
+	_, err = catalogue.Get("crypto")
+	assert.Error(t, err)
+	assert.Equal(t, "unknown product type: crypto", err.Error())
+}
@@ -0,0 +1,222 @@
+package app
+
+import (
+	"fmt"
+	"math"
+)
+
+// DefaultRebalanceTolerance is how far, in percentage points, a portfolio may drift from its target before a
+// rebalance is proposed
+const DefaultRebalanceTolerance = 5.0
+
+// RebalanceTransfer is an amount moved between two portfolios of the customer to bring them back to the target
+// allocation
+type RebalanceTransfer struct {
+	From     string
+	To       string
+	Amount   float32
+	Currency string
+}
+
+func (t RebalanceTransfer) String() string {
+	return fmt.Sprintf("%s -> %s: %.2f %s", t.From, t.To, t.Amount, t.Currency)
+}
+
+// PlanRebalance returns the transfers that bring the open portfolios of the customer back to the target allocation,
+// or none when every portfolio is within tolerance percentage points of its target. Portfolios without a target
+// are emptied into those with one. Transfers do not convert between currencies, so the portfolios holding funds
+// or a target must be held in the same currency.
+func (c *Customer) PlanRebalance(tolerance float64) ([]RebalanceTransfer, error) {
+	if c.targets == nil {
+		return nil, ErrNoTargetAllocation
+	}
+	if tolerance < 0 || math.IsNaN(tolerance) {
+		return nil, ErrInvalidTolerance
+	}
+
+	var portfolios []*Portfolio
+	var currency string
+	var total, targetTotal float64
+	for _, p := range c.portfolios {
+		if p.Closed || (p.Balance == 0 && c.targets[p.Name] == 0) {
+			continue
+		}
+		if currency != "" && p.currency() != currency {
+			return nil, ErrMixedCurrencies
+		}
+		currency = p.currency()
+		portfolios = append(portfolios, p)
+		total += float64(p.Balance)
+		targetTotal += c.targets[p.Name]
+	}
+	if total == 0 {
+		return nil, nil
+	}
+
+	drifted := false
+	excess := make([]float64, len(portfolios))
+	for i, p := range portfolios {
+		target := c.targets[p.Name] / targetTotal
+		if math.Abs(float64(p.Balance)/total-target)*100 > tolerance {
+			drifted = true
+		}
+		excess[i] = float64(roundCents(float64(p.Balance) - total*target))
+	}
+	if !drifted {
+		return nil, nil
+	}
+
+	var transfers []RebalanceTransfer
+	from, to := 0, 0
+	for {
+		for from < len(excess) && excess[from] < 0.01 {
+			from++
+		}
+		for to < len(excess) && excess[to] > -0.01 {
+			to++
+		}
+		if from == len(excess) || to == len(excess) {
+			return transfers, nil
+		}
+		amount := roundCents(math.Min(excess[from], -excess[to]))
+		transfers = append(transfers, RebalanceTransfer{From: portfolios[from].Name, To: portfolios[to].Name, Amount: amount, Currency: currency})
+		excess[from] -= float64(amount)
+		excess[to] += float64(amount)
+	}
+}
+
+// Rebalance makes the transfers between the portfolios of the customer. Every transfer is checked against the
+// portfolio rules before any is made, so either all of them are made or none is.
+func (c *Customer) Rebalance(transfers []RebalanceTransfer) error {
+	if err := c.checkActive(); err != nil {
+		return err
+	}
+
+	staged := map[*Portfolio]*Portfolio{}
+	stage := func(p *Portfolio) *Portfolio {
+		if staged[p] == nil {
+			copied := *p
+			staged[p] = &copied
+		}
+		return staged[p]
+	}
+	for _, t := range transfers {
+		from, to, err := c.transferPortfolios(t)
+		if err != nil {
+			return err
+		}
+		if err := stage(from).withdraw(t.Amount, c.now()); err != nil {
+			return fmt.Errorf("%s: %w", from.Name, err)
+		}
+		if err := stage(to).deposit(t.Amount, c.now()); err != nil {
+			return fmt.Errorf("%s: %w", to.Name, err)
+		}
+	}
+
+	for _, t := range transfers {
+		from, to, _ := c.transferPortfolios(t)
+		if err := from.withdraw(t.Amount, c.now()); err != nil {
+			return err
+		}
+		if err := to.deposit(t.Amount, c.now()); err != nil {
+			return err
+		}
+		c.recordTransfer(from, to, t.Amount)
+	}
+	return nil
+}
+
+// transferPortfolios returns the open portfolios a rebalance transfer moves funds between
+func (c *Customer) transferPortfolios(t RebalanceTransfer) (*Portfolio, *Portfolio, error) {
+	from, err := c.openPortfolio(t.From)
+	if err != nil {
+		return nil, nil, err
+	}
+	to, err := c.openPortfolio(t.To)
+	if err != nil {
+		return nil, nil, err
+	}
+	if from == to {
+		return nil, nil, ErrTransferToSame
+	}
+	if from.currency() != currencyOrDefault(t.Currency) || to.currency() != currencyOrDefault(t.Currency) {
+		return nil, nil, &CurrencyMismatchError{From: from.currency(), To: to.currency()}
+	}
+	return from, to, nil
+}
+
+func (c *Customer) openPortfolio(name string) (*Portfolio, error) {
+	p := c.findPortfolio(name)
+	if p == nil {
+		return nil, &PortfolioNotFoundError{Name: name}
+	}
+	if p.Closed {
+		return nil, fmt.Errorf("%s: %w", name, ErrPortfolioClosed)
+	}
+	return p, nil
+}
+
+// SteerDeposit splits the amount, in the default currency, between the portfolios with a target so that the
+// most underweight portfolios receive the most, bringing the allocation closer to the target without moving
+// existing funds
+func (c *Customer) SteerDeposit(amount float32) (map[string]float32, error) {
+	if c.targets == nil {
+		return nil, ErrNoTargetAllocation
+	}
+	if amount <= 0 {
+		return nil, ErrAmountNotPositive
+	}
+
+	balances := map[string]float64{}
+	total := float64(amount)
+	var targetTotal float64
+	for _, p := range c.portfolios {
+		if p.Closed {
+			continue
+		}
+		balance, err := convert(c.fx, p.Balance, p.currency(), "")
+		if err != nil {
+			return nil, err
+		}
+		balances[p.Name] = float64(balance)
+		total += float64(balance)
+		targetTotal += c.targets[p.Name]
+	}
+
+	// The shortfalls of the portfolios below their target after the deposit always add up to at least the amount
+	gaps := map[string]float64{}
+	var gapTotal float64
+	for _, p := range c.portfolios {
+		percent, ok := c.targets[p.Name]
+		if !ok || p.Closed {
+			continue
+		}
+		if gap := total*percent/targetTotal - balances[p.Name]; gap > 0 {
+			gaps[p.Name] = gap
+			gapTotal += gap
+		}
+	}
+
+	split := map[string]float32{}
+	var allocated float32
+	var largest string
+	for _, p := range c.portfolios {
+		gap, ok := gaps[p.Name]
+		if !ok {
+			continue
+		}
+		share := roundCents(float64(amount) * gap / gapTotal)
+		if largest == "" || share > split[largest] {
+			largest = p.Name
+		}
+		split[p.Name] = share
+		allocated += share
+	}
+	split[largest] = roundCents(float64(split[largest] + amount - allocated))
+	for name, share := range split {
+		if share <= 0 {
+			delete(split, name)
+		}
+	}
+	return split, nil
+}
@@ -0,0 +1,215 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanRebalance_shouldReturnTransfers_givenDrift(t *testing.T) {
+	testPlanRebalance := func(balances []float32, tolerance float64, expected []RebalanceTransfer) {
+		c := Customer{
+			ID: "c1",
+			portfolios: []*Portfolio{
+				{Name: "Retirement", Balance: balances[0]},
+				{Name: "High Risk", Balance: balances[1]},
+				{Name: "Spare", Balance: balances[2]},
+				{Name: "Closed", Closed: true},
+			},
+			targets: map[string]float64{"Retirement": 60, "High Risk": 40},
+		}
+		transfers, err := c.PlanRebalance(tolerance)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, transfers)
+	}
+
+	testPlanRebalance([]float32{900, 100, 0}, 5, []RebalanceTransfer{{From: "Retirement", To: "High Risk", Amount: 300, Currency: "SGD"}})
+	testPlanRebalance([]float32{620, 380, 0}, 5, nil)
+	testPlanRebalance([]float32{620, 380, 0}, 1, []RebalanceTransfer{{From: "Retirement", To: "High Risk", Amount: 20, Currency: "SGD"}})
+	testPlanRebalance([]float32{500, 300, 200}, 5, []RebalanceTransfer{
+		{From: "Spare", To: "Retirement", Amount: 100, Currency: "SGD"},
+		{From: "Spare", To: "High Risk", Amount: 100, Currency: "SGD"},
+	})
+	testPlanRebalance([]float32{100, 0, 0}, 0, []RebalanceTransfer{{From: "Retirement", To: "High Risk", Amount: 40, Currency: "SGD"}})
+	testPlanRebalance([]float32{0, 0, 0}, 5, nil)
+}
+
+func TestPlanRebalance_shouldReturnError_givenNoTargetOrMixedCurrencies(t *testing.T) {
+	c := Customer{ID: "c1", portfolios: []*Portfolio{{Name: "Retirement", Balance: 100}, {Name: "US Equities", Balance: 100, Currency: "USD"}}}
+	_, err := c.PlanRebalance(5)
+	assert.Equal(t, ErrNoTargetAllocation, err)
+
+	c.targets = map[string]float64{"Retirement": 50, "US Equities": 50}
+	_, err = c.PlanRebalance(-1)
+	assert.EqualError(t, err, "invalid tolerance")
+	_, err = c.PlanRebalance(5)
+	assert.EqualError(t, err, "cannot rebalance portfolios held in different currencies")
+}
+
+func TestRebalance_shouldTransferFunds(t *testing.T) {
+	sub := &recordingSubscriber{}
+	bus := NewEventBus()
+	bus.Subscribe(sub)
+	c := Customer{ID: "c1", portfolios: []*Portfolio{{Name: "Retirement", Balance: 900}, {Name: "High Risk", Balance: 100}}, ledger: NewLedger(), events: bus}
+
+	assert.NoError(t, c.Rebalance([]RebalanceTransfer{{From: "Retirement", To: "High Risk", Amount: 300}}))
+	assert.Equal(t, float32(600), c.portfolios[0].Balance)
+	assert.Equal(t, float32(400), c.portfolios[1].Balance)
+	assert.Equal(t, []string{EventFundsTransferred}, sub.types())
+
+	entries := c.ledger.Entries("c1")
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, EntryTransferOut, entries[0].Type)
+		assert.Equal(t, float32(-300), entries[0].Amount)
+		assert.Equal(t, "High Risk", entries[0].Reference)
+		assert.Equal(t, EntryTransferIn, entries[1].Type)
+		assert.Equal(t, float32(300), entries[1].Amount)
+	}
+}
+
+func TestRebalance_shouldMakeNoTransfer_givenOneFails(t *testing.T) {
+	testRebalance := func(transfers []RebalanceTransfer, expectedErr string) {
+		c := Customer{
+			ID: "c1",
+			portfolios: []*Portfolio{
+				{Name: "Retirement", Balance: 900, Product: Product{MinimumBalance: 500}},
+				{Name: "High Risk", Balance: 100},
+				{Name: "Savings", Balance: 100, Product: Product{AnnualCap: 150}},
+				{Name: "US Equities", Currency: "USD"},
+			},
+			ledger: NewLedger(),
+		}
+		err := c.Rebalance(transfers)
+		assert.EqualError(t, err, expectedErr)
+		assert.Equal(t, float32(900), c.portfolios[0].Balance)
+		assert.Equal(t, float32(100), c.portfolios[1].Balance)
+		assert.Equal(t, float32(100), c.portfolios[2].Balance)
+		assert.Empty(t, c.ledger.Entries("c1"))
+	}
+
+	testRebalance([]RebalanceTransfer{
+		{From: "Retirement", To: "High Risk", Amount: 300},
+		{From: "Retirement", To: "High Risk", Amount: 200},
+	}, "Retirement: withdrawal would breach minimum balance")
+	testRebalance([]RebalanceTransfer{
+		{From: "Retirement", To: "High Risk", Amount: 100},
+		{From: "High Risk", To: "Savings", Amount: 200},
+	}, "Savings: annual contribution cap exceeded")
+	testRebalance([]RebalanceTransfer{
+		{From: "Retirement", To: "High Risk", Amount: 100},
+		{From: "High Risk", To: "Growth", Amount: 100},
+	}, "portfolio not found")
+	testRebalance([]RebalanceTransfer{{From: "Retirement", To: "US Equities", Amount: 100}}, "currency mismatch: SGD to USD")
+}
+
+func TestSteerDeposit_shouldFavourUnderweightPortfolios(t *testing.T) {
+	testSteerDeposit := func(balances []float32, amount float32, expected map[string]float32) {
+		c := Customer{
+			ID: "c1",
+			portfolios: []*Portfolio{
+				{Name: "Retirement", Balance: balances[0]},
+				{Name: "High Risk", Balance: balances[1]},
+				{Name: "US Equities", Balance: balances[2], Currency: "USD"},
+			},
+			fx:      StaticRateProvider{"USD/SGD": 2},
+			targets: map[string]float64{"Retirement": 50, "High Risk": 25, "US Equities": 25},
+		}
+		split, err := c.SteerDeposit(amount)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, split)
+	}
+
+	testSteerDeposit([]float32{0, 0, 0}, 100, map[string]float32{"Retirement": 50, "High Risk": 25, "US Equities": 25})
+	testSteerDeposit([]float32{500, 0, 50}, 300, map[string]float32{"High Risk": 192.86, "US Equities": 107.14})
+	testSteerDeposit([]float32{100, 100, 0}, 200, map[string]float32{"Retirement": 100, "US Equities": 100})
+	testSteerDeposit([]float32{0, 0, 0}, 0.01, map[string]float32{"Retirement": 0.01})
+}
+
+func TestSteerDeposit_shouldReturnError_givenNoTargetOrAmount(t *testing.T) {
+	c := Customer{ID: "c1", portfolios: []*Portfolio{{Name: "Retirement"}}}
+	_, err := c.SteerDeposit(100)
+	assert.Equal(t, ErrNoTargetAllocation, err)
+
+	c.targets = map[string]float64{"Retirement": 100}
+	_, err = c.SteerDeposit(0)
+	assert.EqualError(t, err, "deposit amount must be positive")
+}
+
+func TestApp_Rebalance_shouldTransferOnceApplied(t *testing.T) {
+	app, _, _ := newTestRebuildApp(t)
+	setNow(t, time.Date(2020, 1, 15, 12, 0, 0, 0, time.UTC))
+	_, err := app.processInput("rebalance")
+	assert.Equal(t, ErrNoActiveCustomer, err)
+
+	for _, input := range []string{
+		"newcustomer test1",
+		"addportfolio Retirement",
+		"addportfolio \"High Risk\"",
+		"startDeposit",
+		"addOneTimePlan plan Retirement 900 \"High Risk\" 100",
+		"deposit 1000",
+		"endDeposit",
+	} {
+		_, err := app.processInput(input)
+		assert.NoError(t, err, input)
+	}
+	_, err = app.processInput("rebalance")
+	assert.Equal(t, ErrNoTargetAllocation, err)
+	_, err = app.processInput("applyrebalance")
+	assert.EqualError(t, err, "no rebalance to confirm")
+
+	for _, input := range []string{"settarget Retirement 60 \"High Risk\" 40", "rebalance", "withdraw Retirement 100"} {
+		_, err := app.processInput(input)
+		assert.NoError(t, err, input)
+	}
+	_, err = app.processInput("applyrebalance")
+	assert.EqualError(t, err, "balances changed since the rebalance was proposed")
+
+	for _, input := range []string{"rebalance --tolerance 1", "applyrebalance"} {
+		_, err := app.processInput(input)
+		assert.NoError(t, err, input)
+	}
+	assert.Equal(t, float32(540), app.currentCustomer.portfolios[0].Balance)
+	assert.Equal(t, float32(360), app.currentCustomer.portfolios[1].Balance)
+	_, err = app.processInput("applyrebalance")
+	assert.EqualError(t, err, "no rebalance to confirm")
+
+	rebuilt, err := app.Rebuild(time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, float32(540), rebuilt[0].portfolios[0].Balance)
+	assert.Equal(t, float32(360), rebuilt[0].portfolios[1].Balance)
+}
+
+func TestApp_AddTargetPlan_shouldDepositTowardsTarget(t *testing.T) {
+	app := NewApp()
+	for _, input := range []string{
+		"newcustomer test1",
+		"addportfolio Retirement",
+		"addportfolio Growth",
+		"settarget Retirement 50 Growth 50",
+		"startDeposit",
+		"addOneTimePlan plan Retirement 300",
+		"deposit 300",
+		"endDeposit",
+	} {
+		_, err := app.processInput(input)
+		assert.NoError(t, err, input)
+	}
+	_, err := app.processInput("addTargetPlan top 500")
+	assert.Equal(t, ErrNoActiveSession, err)
+
+	for _, input := range []string{"startDeposit", "addTargetPlan top 500", "deposit 500", "endDeposit"} {
+		_, err := app.processInput(input)
+		assert.NoError(t, err, input)
+	}
+	assert.Equal(t, float32(400), app.currentCustomer.portfolios[0].Balance)
+	assert.Equal(t, float32(400), app.currentCustomer.portfolios[1].Balance)
+
+	_, err = app.processInput("startDeposit")
+	assert.NoError(t, err)
+	_, err = app.processInput("addTargetPlan top")
+	assert.Equal(t, ErrInvalidArgs, err)
+	_, err = app.processInput("addTargetPlan top -5")
+	assert.EqualError(t, err, "deposit amount must be positive")
+}
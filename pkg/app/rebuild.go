@@ -0,0 +1,557 @@
+package app
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"time"
+)
+
+// snapshotInterval is the number of events replayed past the latest snapshot before a new one is taken
+const snapshotInterval = 100
+
+// PortfolioSnapshot is the state of a portfolio in a snapshot
+type PortfolioSnapshot struct {
+	Name      string    `json:"name"`
+	Balance   float32   `json:"balance"`
+	Closed    bool      `json:"closed"`
+	Product   Product   `json:"product"`
+	OpenedAt  time.Time `json:"openedAt"`
+	Currency  string    `json:"currency"`
+	Uncleared float32   `json:"uncleared"`
+
+	ContributionYear    int       `json:"contributionYear,omitempty"`
+	YearContributions   float32   `json:"yearContributions,omitempty"`
+	AccruedThrough      time.Time `json:"accruedThrough"`
+	AccruedInterest     float64   `json:"accruedInterest,omitempty"`
+	CapitalisedInterest float32   `json:"capitalisedInterest,omitempty"`
+}
+
+// CustomerSnapshot is the state of a customer in a snapshot
+type CustomerSnapshot struct {
+	ID          string              `json:"id"`
+	Name        string              `json:"name"`
+	Email       string              `json:"email"`
+	Phone       string              `json:"phone"`
+	DateOfBirth time.Time           `json:"dateOfBirth"`
+	KYCStatus   KYCStatus           `json:"kycStatus"`
+	Status      CustomerStatus      `json:"status"`
+	Portfolios  []PortfolioSnapshot `json:"portfolios"`
+	Targets     map[string]float64  `json:"targets,omitempty"`
+	Goals       map[string]Goal     `json:"goals,omitempty"`
+	Deposits    []DepositSnapshot   `json:"deposits,omitempty"`
+	Plans       []PlanSnapshot      `json:"plans,omitempty"`
+}
+
+// DepositSnapshot is the state of a received deposit in a snapshot. Once its session is completed, Credited is
+// the share of the deposit each portfolio was credited with.
+type DepositSnapshot struct {
+	Source     string            `json:"source"`
+	Reference  string            `json:"reference"`
+	Payer      string            `json:"payer,omitempty"`
+	ReceivedAt time.Time         `json:"receivedAt"`
+	Amount     float32           `json:"amount"`
+	Currency   string            `json:"currency"`
+	Status     DepositStatus     `json:"status"`
+	Reconciled bool              `json:"reconciled,omitempty"`
+	Settled    bool              `json:"settled,omitempty"`
+	Credited   []PortfolioAmount `json:"credited,omitempty"`
+}
+
+// PlanSnapshot is a deposit plan in a snapshot
+type PlanSnapshot struct {
+	Name       string             `json:"name"`
+	Type       string             `json:"type"`
+	Currency   string             `json:"currency"`
+	Portfolios map[string]float32 `json:"portfolios"`
+}
+
+// Snapshot is the state of every customer once the events up to LastEventID are replayed. Time is when the
+// latest of those events occurred.
+type Snapshot struct {
+	LastEventID int                `json:"lastEventId"`
+	Time        time.Time          `json:"time"`
+	Customers   []CustomerSnapshot `json:"customers"`
+}
+
+// NewSnapshot takes a snapshot of the customers
+func NewSnapshot(customers []*Customer, lastEventID int, at time.Time) Snapshot {
+	s := Snapshot{LastEventID: lastEventID, Time: at, Customers: []CustomerSnapshot{}}
+	for _, c := range customers {
+		s.Customers = append(s.Customers, snapshotCustomer(c))
+	}
+	return s
+}
+
+// restore returns the customers in the snapshot
+func (s Snapshot) restore() []*Customer {
+	customers := []*Customer{}
+	for _, cs := range s.Customers {
+		customers = append(customers, cs.restore())
+	}
+	return customers
+}
+
+// snapshotCustomer returns the state of the customer, its portfolios, the deposits received from it and its saved
+// plans. Deposits received in the active session are left out until the session is completed.
+func snapshotCustomer(c *Customer) CustomerSnapshot {
+	cs := CustomerSnapshot{ID: c.ID, Name: c.Name, Email: c.Email, Phone: c.Phone, DateOfBirth: c.DateOfBirth, KYCStatus: c.KYCStatus, Status: c.Status, Portfolios: []PortfolioSnapshot{}, Targets: c.TargetAllocation(), Goals: c.Goals()}
+	for _, r := range c.received {
+		if c.DepositSession != nil && r.session == c.DepositSession {
+			continue
+		}
+		cs.Deposits = append(cs.Deposits, snapshotDeposit(r))
+	}
+	for _, dp := range c.savedPlans {
+		cs.Plans = append(cs.Plans, snapshotPlan(dp))
+	}
+	for _, p := range c.portfolios {
+		cs.Portfolios = append(cs.Portfolios, PortfolioSnapshot{
+			Name:                p.Name,
+			Balance:             p.Balance,
+			Closed:              p.Closed,
+			Product:             p.Product,
+			OpenedAt:            p.OpenedAt,
+			Currency:            p.Currency,
+			Uncleared:           p.uncleared,
+			ContributionYear:    p.contributionYear,
+			YearContributions:   p.yearContributions,
+			AccruedThrough:      p.accruedThrough,
+			AccruedInterest:     p.accruedInterest,
+			CapitalisedInterest: p.capitalisedInterest,
+		})
+	}
+	return cs
+}
+
+// restore returns the customer in the state of the snapshot
+func (cs CustomerSnapshot) restore() *Customer {
+	c := &Customer{ID: cs.ID, Name: cs.Name, Email: cs.Email, Phone: cs.Phone, DateOfBirth: cs.DateOfBirth, KYCStatus: cs.KYCStatus, Status: cs.Status, portfolios: []*Portfolio{}}
+	if len(cs.Targets) > 0 {
+		c.targets = map[string]float64{}
+		for name, percent := range cs.Targets {
+			c.targets[name] = percent
+		}
+	}
+	if len(cs.Goals) > 0 {
+		c.goals = map[string]Goal{}
+		for name, g := range cs.Goals {
+			c.goals[name] = g
+		}
+	}
+	for _, ps := range cs.Portfolios {
+		c.portfolios = append(c.portfolios, &Portfolio{
+			Name:                ps.Name,
+			Balance:             ps.Balance,
+			Closed:              ps.Closed,
+			Product:             ps.Product,
+			OpenedAt:            ps.OpenedAt,
+			Currency:            ps.Currency,
+			uncleared:           ps.Uncleared,
+			contributionYear:    ps.ContributionYear,
+			yearContributions:   ps.YearContributions,
+			accruedThrough:      ps.AccruedThrough,
+			accruedInterest:     ps.AccruedInterest,
+			capitalisedInterest: ps.CapitalisedInterest,
+		})
+	}
+	for _, ds := range cs.Deposits {
+		c.received = append(c.received, ds.restore(c))
+	}
+	for _, ps := range cs.Plans {
+		c.savedPlans = append(c.savedPlans, ps.restore())
+	}
+	return c
+}
+
+func snapshotDeposit(r *ReceivedDeposit) DepositSnapshot {
+	ds := DepositSnapshot{Source: r.Source, Reference: r.Reference, Payer: r.Payer, ReceivedAt: r.ReceivedAt, Amount: r.Amount, Currency: r.Currency, Status: r.Status, Reconciled: r.Reconciled}
+	if r.settlement != nil {
+		ds.Settled = true
+		for i, cr := range r.settlement.credits {
+			if share := r.share(cr); share != 0 {
+				ds.Credited = append(ds.Credited, PortfolioAmount{Portfolio: r.settlement.portfolios[i].Name, Amount: share})
+			}
+		}
+	}
+	return ds
+}
+
+// restore returns the deposit received from the customer. The settlement of a completed session is restored with
+// a credit of each share, so the shares work out the same.
+func (ds DepositSnapshot) restore(c *Customer) *ReceivedDeposit {
+	r := &ReceivedDeposit{
+		DepositDetails: DepositDetails{Source: ds.Source, Reference: ds.Reference, Payer: ds.Payer, ReceivedAt: ds.ReceivedAt},
+		Amount:         ds.Amount,
+		Currency:       ds.Currency,
+		Status:         ds.Status,
+		Reconciled:     ds.Reconciled,
+	}
+	if ds.Settled {
+		r.settlement = &depositSettlement{total: ds.Amount}
+		for _, pa := range ds.Credited {
+			if p := c.findPortfolio(pa.Portfolio); p != nil {
+				r.settlement.credits = append(r.settlement.credits, DepositCredit{Portfolio: p.Name, Net: pa.Amount})
+				r.settlement.portfolios = append(r.settlement.portfolios, p)
+			}
+		}
+	}
+	return r
+}
+
+func snapshotPlan(dp DepositPlan) PlanSnapshot {
+	ps := PlanSnapshot{Name: dp.Name(), Type: dp.PlanType(), Currency: dp.Currency(), Portfolios: map[string]float32{}}
+	for name, amount := range dp.PortfolioRatio() {
+		ps.Portfolios[name] = amount
+	}
+	return ps
+}
+
+func (ps PlanSnapshot) restore() DepositPlan {
+	ratio := map[string]float32{}
+	for name, amount := range ps.Portfolios {
+		ratio[name] = amount
+	}
+	return &baseDepositPlan{name: ps.Name, planType: ps.Type, currency: ps.Currency, portfolioRatio: ratio}
+}
+
+// SnapshotStore keeps snapshots in a file, one JSON snapshot per line
+type SnapshotStore struct {
+	path string
+}
+
+// NewSnapshotStore instantiate a store keeping snapshots in path
+func NewSnapshotStore(path string) *SnapshotStore {
+	return &SnapshotStore{path: path}
+}
+
+// Save appends the snapshot to the store
+func (s *SnapshotStore) Save(snapshot Snapshot) error {
+	line, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// Latest returns the snapshot of the most events taken no later than asOf, or nil when there is none.
+// A zero asOf returns the latest snapshot.
+func (s *SnapshotStore) Latest(asOf time.Time) (*Snapshot, error) {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var latest *Snapshot
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64<<20)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var snapshot Snapshot
+		if err := json.Unmarshal(scanner.Bytes(), &snapshot); err != nil {
+			return nil, err
+		}
+		if !asOf.IsZero() && snapshot.Time.After(asOf) {
+			continue
+		}
+		if latest == nil || snapshot.LastEventID > latest.LastEventID {
+			latest = &snapshot
+		}
+	}
+	return latest, scanner.Err()
+}
+
+// Rebuild reconstructs the customers by replaying the outbox records, in order, on top of the snapshot if given.
+// Records up to the last event of the snapshot are skipped, as are events which occurred after asOf unless it
+// is zero. The snapshot of the rebuilt state is returned along with the customers.
+func Rebuild(records []OutboxRecord, snapshot *Snapshot, asOf time.Time) ([]*Customer, Snapshot, error) {
+	r := &replay{customers: []*Customer{}}
+	if snapshot != nil {
+		r.customers = snapshot.restore()
+		r.lastEventID, r.time = snapshot.LastEventID, snapshot.Time
+	}
+
+	for _, rec := range records {
+		if rec.ID <= r.lastEventID {
+			continue
+		}
+		if !asOf.IsZero() && rec.Time.After(asOf) {
+			continue
+		}
+		e, err := DecodeEvent(rec)
+		if err != nil {
+			return nil, Snapshot{}, err
+		}
+		if err := r.apply(e); err != nil {
+			return nil, Snapshot{}, fmt.Errorf("event %d: %w", rec.ID, err)
+		}
+		r.lastEventID = rec.ID
+		if rec.Time.After(r.time) {
+			r.time = rec.Time
+		}
+	}
+	return r.customers, NewSnapshot(r.customers, r.lastEventID, r.time), nil
+}
+
+// CompareCustomers returns the differences between the live customers and the rebuilt ones, in their profile,
+// portfolios, deposits received and saved plans. Rebuilt customers not in the live state are ignored, as the live
+// state only holds the customers added since the app started.
+func CompareCustomers(live []*Customer, rebuilt []*Customer) []string {
+	diffs := []string{}
+	for _, c := range live {
+		rc := findCustomer(rebuilt, c.ID)
+		if rc == nil {
+			diffs = append(diffs, c.ID+": missing from rebuilt state")
+			continue
+		}
+		if c.Name != rc.Name || c.Email != rc.Email || c.Phone != rc.Phone || !c.DateOfBirth.Equal(rc.DateOfBirth) || c.KYCStatus != rc.KYCStatus || c.Status != rc.Status {
+			diffs = append(diffs, c.ID+": profile does not match")
+		}
+		diffs = append(diffs, compareDeposits(c, rc)...)
+		if !reflect.DeepEqual(snapshotCustomer(c).Plans, snapshotCustomer(rc).Plans) {
+			diffs = append(diffs, c.ID+": saved plans do not match")
+		}
+		if len(c.portfolios) != len(rc.portfolios) {
+			diffs = append(diffs, fmt.Sprintf("%s: %d portfolios, rebuilt %d", c.ID, len(c.portfolios), len(rc.portfolios)))
+			continue
+		}
+		for i, p := range c.portfolios {
+			rp := rc.portfolios[i]
+			switch {
+			case p.Name != rp.Name:
+				diffs = append(diffs, fmt.Sprintf("%s: portfolio %s, rebuilt %s", c.ID, p.Name, rp.Name))
+			case roundCents(float64(p.Balance)) != roundCents(float64(rp.Balance)):
+				diffs = append(diffs, fmt.Sprintf("%s: portfolio %s balance %.2f, rebuilt %.2f", c.ID, p.Name, p.Balance, rp.Balance))
+			case roundCents(float64(p.uncleared)) != roundCents(float64(rp.uncleared)):
+				diffs = append(diffs, fmt.Sprintf("%s: portfolio %s uncleared %.2f, rebuilt %.2f", c.ID, p.Name, p.uncleared, rp.uncleared))
+			case p.Closed != rp.Closed || p.currency() != rp.currency() || p.Product.Type != rp.Product.Type:
+				diffs = append(diffs, fmt.Sprintf("%s: portfolio %s does not match", c.ID, p.Name))
+			}
+		}
+	}
+	return diffs
+}
+
+// compareDeposits returns the differences between the deposits received from the live customer and the rebuilt
+// one. Deposits of the active session are left out, as snapshots leave them out until the session is completed.
+func compareDeposits(c *Customer, rc *Customer) []string {
+	diffs := []string{}
+	for _, d := range c.received {
+		if c.DepositSession != nil && d.session == c.DepositSession {
+			continue
+		}
+		rd := rc.findDeposit(d.Reference)
+		if rd == nil {
+			diffs = append(diffs, fmt.Sprintf("%s: deposit %s missing from rebuilt state", c.ID, d.Reference))
+			continue
+		}
+		ds, rds := snapshotDeposit(d), snapshotDeposit(rd)
+		ds.ReceivedAt, rds.ReceivedAt = ds.ReceivedAt.UTC(), rds.ReceivedAt.UTC()
+		if !reflect.DeepEqual(ds, rds) {
+			diffs = append(diffs, fmt.Sprintf("%s: deposit %s does not match", c.ID, d.Reference))
+		}
+	}
+	return diffs
+}
+
+// replay is the state of customers being rebuilt from events
+type replay struct {
+	customers   []*Customer
+	lastEventID int
+	time        time.Time
+}
+
+func (r *replay) apply(e Event) error {
+	if created, ok := e.(CustomerCreated); ok {
+		if findCustomer(r.customers, created.Customer) != nil {
+			return fmt.Errorf("%w: %s", ErrCustomerExists, created.Customer)
+		}
+		c, err := NewCustomer(created.Customer)
+		if err != nil {
+			return err
+		}
+		r.customers = append(r.customers, &c)
+		return nil
+	}
+
+	c := findCustomer(r.customers, e.CustomerID())
+	if c == nil {
+		return fmt.Errorf("%w: %s", ErrCustomerNotFound, e.CustomerID())
+	}
+	switch e := e.(type) {
+	case CustomerUpdated:
+		return c.applyProfile(e.Fields)
+	case PortfolioAdded:
+		if c.findPortfolio(e.Portfolio) != nil {
+			return fmt.Errorf("%w: %s", ErrPortfolioExists, e.Portfolio)
+		}
+		p := &Portfolio{Name: e.Portfolio, Product: e.Product, Currency: e.Currency}
+		if e.Product != (Product{}) {
+			p.OpenedAt = e.Time
+		}
+		c.portfolios = append(c.portfolios, p)
+	case PortfolioRenamed:
+		return c.applyToPortfolio(e.Portfolio, func(p *Portfolio) { p.Name = e.NewName })
+	case PortfolioClosed:
+		return c.applyToPortfolio(e.Portfolio, func(p *Portfolio) { p.Closed = true })
+	case FundsTransferred:
+		if err := c.applyToPortfolio(e.From, func(p *Portfolio) { p.Balance -= e.Amount }); err != nil {
+			return err
+		}
+		return c.applyToPortfolio(e.To, func(p *Portfolio) { p.Balance += e.Amount })
+	case InterestPaid:
+		return c.applyToPortfolio(e.Portfolio, func(p *Portfolio) { p.Balance += e.Amount })
+	case DepositReceived:
+		c.received = append(c.received, &ReceivedDeposit{
+			DepositDetails: DepositDetails{Source: e.Source, Reference: e.Reference, Payer: e.Payer, ReceivedAt: e.ReceivedAt},
+			Amount:         e.Amount,
+			Currency:       e.Currency,
+			Status:         e.Status,
+		})
+	case SessionCommitted:
+		for _, cr := range e.Credits {
+			if err := c.applyToPortfolio(cr.Portfolio, func(p *Portfolio) { p.Balance += cr.Net }); err != nil {
+				return err
+			}
+		}
+		for _, h := range e.Held {
+			if err := c.applyToPortfolio(h.Portfolio, func(p *Portfolio) { p.uncleared += h.Amount }); err != nil {
+				return err
+			}
+		}
+		return c.applySettlement(e)
+	case WithdrawalMade:
+		return c.applyToPortfolio(e.Portfolio, func(p *Portfolio) { p.Balance -= e.Amount })
+	case FundsCleared:
+		if err := c.applyToDeposit(e.Reference, func(d *ReceivedDeposit) { d.Status = DepositCleared }); err != nil {
+			return err
+		}
+		for _, rel := range e.Released {
+			if err := c.applyToPortfolio(rel.Portfolio, func(p *Portfolio) { p.release(rel.Amount) }); err != nil {
+				return err
+			}
+		}
+	case TargetAllocationSet:
+		c.targets = nil
+		if len(e.Targets) > 0 {
+			c.targets = e.Targets
+		}
+	case GoalsSet:
+		c.goals = nil
+		if len(e.Goals) > 0 {
+			c.goals = e.Goals
+		}
+	case PlansSaved:
+		c.savedPlans = nil
+		for _, ps := range e.Plans {
+			c.savedPlans = append(c.savedPlans, ps.restore())
+		}
+	case DepositReconciled:
+		return c.applyToDeposit(e.Reference, func(d *ReceivedDeposit) { d.Reconciled = true })
+	case FundsReturned:
+		if err := c.applyToDeposit(e.Reference, func(d *ReceivedDeposit) { d.Status = DepositReturned }); err != nil {
+			return err
+		}
+		for _, rev := range e.Reversed {
+			if err := c.applyToPortfolio(rev.Portfolio, func(p *Portfolio) {
+				p.Balance -= rev.Amount
+				p.release(rev.Amount)
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// applySettlement settles the deposits of a session committed on a customer being rebuilt with the credits made
+// from them. Events published before the deposits were recorded carry no references and settle nothing.
+func (c *Customer) applySettlement(e SessionCommitted) error {
+	if len(e.References) == 0 {
+		return nil
+	}
+	s := &depositSettlement{credits: e.Credits}
+	for _, cr := range e.Credits {
+		s.portfolios = append(s.portfolios, c.findPortfolio(cr.Portfolio))
+	}
+	for _, d := range e.Deposits {
+		s.total += d
+	}
+	for _, reference := range e.References {
+		if err := c.applyToDeposit(reference, func(d *ReceivedDeposit) { d.settlement = s }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyToDeposit changes the deposit received with the reference from a customer being rebuilt
+func (c *Customer) applyToDeposit(reference string, change func(d *ReceivedDeposit)) error {
+	d := c.findDeposit(reference)
+	if d == nil {
+		return fmt.Errorf("%w: %s", ErrDepositNotFound, reference)
+	}
+	change(d)
+	return nil
+}
+
+// applyProfile sets the profile fields of a customer being rebuilt as they were updated, without validating them
+// again as the rules, such as the age limit, may have changed since
+func (c *Customer) applyProfile(fields map[string]string) error {
+	for field, value := range fields {
+		switch field {
+		case "name":
+			c.Name = value
+		case "email":
+			c.Email = value
+		case "phone":
+			c.Phone = value
+		case "dob":
+			dob, err := time.Parse("2006-01-02", value)
+			if err != nil {
+				return ErrInvalidDateOfBirth
+			}
+			c.DateOfBirth = dob
+		case "kyc":
+			c.KYCStatus = KYCStatus(value)
+		case "status":
+			c.Status = CustomerStatus(value)
+		default:
+			return fmt.Errorf("%w: %s", ErrUnknownField, field)
+		}
+	}
+	return nil
+}
+
+// applyToPortfolio changes the named portfolio of a customer being rebuilt
+func (c *Customer) applyToPortfolio(name string, change func(p *Portfolio)) error {
+	p := c.findPortfolio(name)
+	if p == nil {
+		return fmt.Errorf("%w: %s", &PortfolioNotFoundError{Name: name}, name)
+	}
+	change(p)
+	return nil
+}
+
+func findCustomer(customers []*Customer, id string) *Customer {
+	for _, c := range customers {
+		if c.ID == id {
+			return c
+		}
+	}
+	return nil
+}
@@ -0,0 +1,255 @@
+package app
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRebuildApp(t *testing.T) (App, *FileOutbox, *SnapshotStore) {
+	dir := t.TempDir()
+	outbox, err := NewFileOutbox(filepath.Join(dir, "outbox.jsonl"))
+	assert.NoError(t, err)
+	snapshots := NewSnapshotStore(filepath.Join(dir, "snapshots.jsonl"))
+
+	app := NewApp()
+	bus := NewEventBus()
+	bus.Subscribe(outbox)
+	app.SetEventBus(bus)
+	app.SetEventStore(outbox, snapshots)
+	return app, outbox, snapshots
+}
+
+func TestRebuild_shouldMatchLiveState_givenCommands(t *testing.T) {
+	app, _, _ := newTestRebuildApp(t)
+	today := time.Date(2020, 1, 10, 12, 0, 0, 0, time.UTC)
+	setNow(t, today)
+	app.SetClock(func() time.Time { return today })
+
+	for _, input := range []string{
+		"newcustomer test1",
+		"updatecustomer --name \"Test One\" --kyc verified",
+		"addportfolio Savings savings",
+		"addportfolio Growth",
+		"addportfolio Spare",
+		"startDeposit",
+		"addOneTimePlan plan Savings 1000 Growth 500",
+		"deposit 1000 --ref TX1 --status pending",
+		"deposit 500 --ref TX2 --status pending",
+		"endDeposit",
+		"cleardeposit TX1",
+		"returndeposit TX2",
+		"withdraw Savings 200",
+		"renameportfolio Growth Equity",
+		"mergeportfolio Equity Spare",
+		"newcustomer test2",
+	} {
+		_, err := app.processInput(input)
+		assert.NoError(t, err, input)
+	}
+	today = time.Date(2020, 3, 15, 12, 0, 0, 0, time.UTC)
+	setNow(t, today)
+	_, err := app.processInput("showcustomer test1")
+	assert.NoError(t, err)
+
+	diffs, err := app.VerifyRebuild()
+	assert.NoError(t, err)
+	assert.Empty(t, diffs)
+
+	rebuilt, err := app.Rebuild(time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(rebuilt))
+	c := rebuilt[0]
+	assert.Equal(t, "Test One", c.Name)
+	assert.Equal(t, KYCVerified, c.KYCStatus)
+	assert.Equal(t, []string{"Savings", "Equity", "Spare"}, []string{c.portfolios[0].Name, c.portfolios[1].Name, c.portfolios[2].Name})
+	assert.Equal(t, app.customers[0].portfolios[0].Balance, c.portfolios[0].Balance)
+	assert.Greater(t, c.portfolios[0].Balance, float32(466.67), "interest is replayed")
+	assert.True(t, c.portfolios[1].Closed)
+	_, err = app.processInput("rebuild")
+	assert.NoError(t, err)
+}
+
+func TestRebuild_shouldReportDifferences_givenLiveStateChangedOutsideEvents(t *testing.T) {
+	app, _, _ := newTestRebuildApp(t)
+	app.processInput("newcustomer test1")
+	app.processInput("addportfolio Retirement")
+	app.processInput("newcustomer test2")
+	app.customers[0].portfolios[0].Balance = 10
+
+	diffs, err := app.VerifyRebuild()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"test1: portfolio Retirement balance 10.00, rebuilt 0.00"}, diffs)
+
+	_, err = app.processInput("rebuild")
+	assert.EqualError(t, err, "rebuilt state does not match live state")
+
+	app.customers = append(app.customers, &Customer{ID: "test3"})
+	diffs, _ = app.VerifyRebuild()
+	assert.Equal(t, "test3: missing from rebuilt state", diffs[1])
+}
+
+func TestRebuild_shouldReplayDepositsAndPlans_givenCommands(t *testing.T) {
+	app, _, _ := newTestRebuildApp(t)
+	setNow(t, time.Date(2020, 1, 31, 12, 0, 0, 0, time.UTC))
+	for _, input := range []string{
+		"newcustomer test1",
+		"addportfolio Retirement",
+		"addportfolio Growth",
+		"startDeposit",
+		"addMonthlyPlan monthly Retirement 60 Growth 40",
+		"deposit 60 --ref TX1 --source bank-transfer --payer \"Test One\" --status pending",
+		"deposit 40 --ref TX2 --source bank-transfer --status pending",
+		"endDeposit",
+		"cleardeposit TX1",
+		"returndeposit TX2",
+		"renameportfolio Growth Equity",
+	} {
+		_, err := app.processInput(input)
+		assert.NoError(t, err, input)
+	}
+	path := writeTestStatement(t, "statement.csv", "date,amount,currency,reference,description\n2020-01-31,60,SGD,TX1,\n")
+	_, err := app.processInput("reconcile " + path)
+	assert.NoError(t, err)
+
+	diffs, err := app.VerifyRebuild()
+	assert.NoError(t, err)
+	assert.Empty(t, diffs)
+	rebuilt, err := app.Rebuild(time.Time{})
+	assert.NoError(t, err)
+	cs := snapshotCustomer(rebuilt[0])
+	assert.Equal(t, 2, len(cs.Deposits))
+	assert.Equal(t, DepositCleared, cs.Deposits[0].Status)
+	assert.Equal(t, "Test One", cs.Deposits[0].Payer)
+	assert.True(t, cs.Deposits[0].Reconciled)
+	assert.Equal(t, []PortfolioAmount{{Portfolio: "Retirement", Amount: 36}, {Portfolio: "Equity", Amount: 24}}, cs.Deposits[0].Credited)
+	assert.Equal(t, DepositReturned, cs.Deposits[1].Status)
+	assert.Equal(t, map[string]float32{"Retirement": 60, "Equity": 40}, cs.Plans[0].Portfolios)
+
+	c := app.customers[0]
+	c.received[0].Reconciled = false
+	c.savedPlans = nil
+	diffs, err = app.VerifyRebuild()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"test1: deposit TX1 does not match", "test1: saved plans do not match"}, diffs)
+}
+
+func TestNewCustomer_shouldReturnError_givenCustomerInEventStore(t *testing.T) {
+	app, outbox, snapshots := newTestRebuildApp(t)
+	_, err := app.processInput("newcustomer test1")
+	assert.NoError(t, err)
+
+	restarted := NewApp()
+	bus := NewEventBus()
+	bus.Subscribe(outbox)
+	restarted.SetEventBus(bus)
+	restarted.SetEventStore(outbox, snapshots)
+	_, err = restarted.processInput("newcustomer test1")
+	assert.EqualError(t, err, "customer already exists")
+	_, err = restarted.processInput("newcustomer test2")
+	assert.NoError(t, err)
+	_, err = restarted.Rebuild(time.Time{})
+	assert.NoError(t, err)
+}
+
+func TestRebuild_shouldReturnBalancesAsOf_givenDate(t *testing.T) {
+	app, _, _ := newTestRebuildApp(t)
+	setNow(t, time.Date(2020, 1, 31, 12, 0, 0, 0, time.Local))
+	app.processInput("newcustomer test1")
+	app.processInput("addportfolio Retirement")
+	app.processInput("startDeposit")
+	app.processInput("addOneTimePlan plan Retirement 100")
+	app.processInput("deposit 100")
+	app.processInput("endDeposit")
+	setNow(t, time.Date(2020, 2, 10, 12, 0, 0, 0, time.Local))
+	app.processInput("withdraw Retirement 40")
+
+	testAsOf := func(asOf time.Time, expected float32) {
+		customers, err := app.Rebuild(asOf)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, customers[0].portfolios[0].Balance, asOf.String())
+	}
+	testAsOf(time.Date(2020, 1, 31, 23, 59, 59, 0, time.Local), 100)
+	testAsOf(time.Date(2020, 2, 10, 23, 59, 59, 0, time.Local), 60)
+
+	customers, err := app.Rebuild(time.Date(2020, 1, 1, 0, 0, 0, 0, time.Local))
+	assert.NoError(t, err)
+	assert.Empty(t, customers)
+
+	_, err = app.processInput("rebuild --as-of 2020-01-31")
+	assert.NoError(t, err)
+	_, err = app.processInput("rebuild --as-of 31/01/2020")
+	assert.EqualError(t, err, "invalid time: 31/01/2020")
+}
+
+func TestRebuild_shouldStartFromSnapshot_givenEnoughEventsReplayed(t *testing.T) {
+	app, outbox, snapshots := newTestRebuildApp(t)
+	setNow(t, time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC))
+	app.processInput("newcustomer test1")
+	app.processInput("addportfolio Retirement")
+	sessions := snapshotInterval/3 + 1
+	for i := 0; i < sessions; i++ {
+		app.processInput("startDeposit")
+		app.processInput("addOneTimePlan plan Retirement 10")
+		app.processInput("deposit 10")
+		app.processInput("endDeposit")
+	}
+
+	_, err := app.Rebuild(time.Time{})
+	assert.NoError(t, err)
+	snapshot, err := snapshots.Latest(time.Time{})
+	assert.NoError(t, err)
+	assert.NotNil(t, snapshot)
+	assert.Equal(t, 2+3*sessions, snapshot.LastEventID)
+	assert.Equal(t, float32(10*sessions), snapshot.Customers[0].Portfolios[0].Balance)
+
+	setNow(t, time.Date(2020, 1, 2, 12, 0, 0, 0, time.UTC))
+	app.processInput("withdraw Retirement 50")
+	records, err := outbox.Records()
+	assert.NoError(t, err)
+	fromSnapshot, _, err := Rebuild(records, snapshot, time.Time{})
+	assert.NoError(t, err)
+	fromStart, _, err := Rebuild(records, nil, time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, fromStart[0].portfolios[0].Balance, fromSnapshot[0].portfolios[0].Balance)
+	assert.Empty(t, CompareCustomers(app.customers, fromSnapshot))
+
+	snapshot, err = snapshots.Latest(time.Date(2019, 12, 31, 0, 0, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.Nil(t, snapshot)
+}
+
+func TestRebuild_shouldReturnError_givenInvalidEvents(t *testing.T) {
+	tm := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	testRebuild := func(records []OutboxRecord, expectedErr string) {
+		_, _, err := Rebuild(records, nil, time.Time{})
+		assert.EqualError(t, err, expectedErr)
+	}
+
+	testRebuild([]OutboxRecord{{ID: 1, Type: "Unknown", Time: tm, Payload: []byte(`{}`)}}, "unknown event type: Unknown")
+	testRebuild([]OutboxRecord{{ID: 1, Type: EventWithdrawalMade, Time: tm, Payload: []byte(`{"customer":"c1","portfolio":"A","amount":10}`)}}, "event 1: customer not found: c1")
+	testRebuild([]OutboxRecord{
+		{ID: 1, Type: EventCustomerCreated, Time: tm, Payload: []byte(`{"customer":"c1"}`)},
+		{ID: 2, Type: EventWithdrawalMade, Time: tm, Payload: []byte(`{"customer":"c1","portfolio":"A","amount":10}`)},
+	}, "event 2: portfolio not found: A")
+
+	app := NewApp()
+	_, err := app.processInput("rebuild")
+	assert.EqualError(t, err, "event store not configured")
+}
+
+func TestRebuild_shouldApplyProfileAsUpdated_givenRulesChangedSince(t *testing.T) {
+	tm := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	setNow(t, tm.AddDate(10, 0, 0))
+	records := []OutboxRecord{
+		{ID: 1, Type: EventCustomerCreated, Time: tm, Payload: []byte(`{"customer":"c1"}`)},
+		{ID: 2, Type: EventCustomerUpdated, Time: tm, Payload: []byte(`{"customer":"c1","fields":{"name":"Test One","dob":"1871-01-01","status":"frozen"}}`)},
+	}
+	customers, _, err := Rebuild(records, nil, time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, "Test One", customers[0].Name)
+	assert.Equal(t, time.Date(1871, 1, 1, 0, 0, 0, 0, time.UTC), customers[0].DateOfBirth)
+	assert.Equal(t, CustomerFrozen, customers[0].Status)
+}
@@ -0,0 +1,256 @@
+package app
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StatementLine is a credit to the bank account taken from a bank statement
+type StatementLine struct {
+	Date        time.Time
+	Amount      float32
+	Currency    string
+	Reference   string
+	Description string
+}
+
+// ReconciledDeposit is a deposit received from a customer
+type ReconciledDeposit struct {
+	Customer string
+	Deposit  *ReceivedDeposit
+}
+
+// ReconciliationMatch is a statement line matched to the deposit it paid for
+type ReconciliationMatch struct {
+	Line StatementLine
+	ReconciledDeposit
+	Manual bool
+}
+
+// Reconciliation is the outcome of matching a bank statement to the deposits recorded
+type Reconciliation struct {
+	Matched         []ReconciliationMatch
+	UnmatchedBank   []StatementLine
+	UnmatchedSystem []ReconciledDeposit
+}
+
+var mt940Transaction = regexp.MustCompile(`^(\d{6})\d{0,4}(C|D|RC|RD)(\d+,\d*)N\w{3}(.*)$`)
+
+// LoadStatement reads the credit lines of a bank statement. Files with a .csv extension are read as CSV with
+// a header of date,amount,currency,reference,description, and any other file as an MT940-like statement.
+func LoadStatement(path string) ([]StatementLine, error) {
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		return loadCSVStatement(path)
+	}
+	return loadMT940Statement(path)
+}
+
+func loadCSVStatement(path string) ([]StatementLine, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = 5
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	lines := []StatementLine{}
+	for i, rec := range records {
+		if i == 0 {
+			continue
+		}
+		date, err := time.Parse("2006-01-02", rec[0])
+		if err != nil {
+			return nil, &InvalidValueError{Field: "statement date", Value: rec[0]}
+		}
+		amount, err := strconv.ParseFloat(rec[1], 32)
+		if err != nil {
+			return nil, &InvalidValueError{Field: "statement amount", Value: rec[1]}
+		}
+		if err := validateCurrency(rec[2]); err != nil {
+			return nil, err
+		}
+		if amount <= 0 {
+			continue
+		}
+		lines = append(lines, StatementLine{Date: date, Amount: float32(amount), Currency: rec[2], Reference: rec[3], Description: rec[4]})
+	}
+	return lines, nil
+}
+
+// loadMT940Statement reads the :61: transaction lines of the statement, in the currency of the :60F: opening
+// balance, along with the :86: line following each as its description
+func loadMT940Statement(path string) ([]StatementLine, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	lines := []StatementLine{}
+	var currency string
+	var last *StatementLine
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		text := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(text, ":60F:") || strings.HasPrefix(text, ":60M:"):
+			if len(text) < 15 {
+				return nil, &InvalidValueError{Field: "opening balance", Value: text}
+			}
+			currency = text[12:15]
+			if err := validateCurrency(currency); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(text, ":61:"):
+			last = nil
+			m := mt940Transaction.FindStringSubmatch(text[4:])
+			if m == nil {
+				return nil, &InvalidValueError{Field: "transaction", Value: text}
+			}
+			if m[2] != "C" {
+				continue
+			}
+			date, err := time.Parse("060102", m[1])
+			if err != nil {
+				return nil, &InvalidValueError{Field: "statement date", Value: m[1]}
+			}
+			amount, err := strconv.ParseFloat(strings.Replace(m[3], ",", ".", 1), 32)
+			if err != nil {
+				return nil, &InvalidValueError{Field: "statement amount", Value: m[3]}
+			}
+			if currency == "" {
+				return nil, ErrTransactionBeforeOpening
+			}
+			reference := strings.SplitN(m[4], "//", 2)[0]
+			lines = append(lines, StatementLine{Date: date, Amount: float32(amount), Currency: currency, Reference: reference})
+			last = &lines[len(lines)-1]
+		case strings.HasPrefix(text, ":86:"):
+			if last != nil {
+				last.Description = text[4:]
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
+
+// Reconcile matches the statement lines to the deposits received from the customers by reference, or
+// failing that by the reference appearing as a whole word in the line description, along with the amount and
+// currency. Cash deposits, returned deposits and deposits already reconciled are left out.
+func Reconcile(lines []StatementLine, customers []*Customer) *Reconciliation {
+	r := &Reconciliation{Matched: []ReconciliationMatch{}, UnmatchedBank: []StatementLine{}, UnmatchedSystem: []ReconciledDeposit{}}
+	for _, c := range customers {
+		for _, d := range c.received {
+			if d.Source != SourceCash && d.Status != DepositReturned && !d.Reconciled {
+				r.UnmatchedSystem = append(r.UnmatchedSystem, ReconciledDeposit{Customer: c.ID, Deposit: d})
+			}
+		}
+	}
+
+	for _, line := range lines {
+		i := r.findMatch(line)
+		if i < 0 {
+			r.UnmatchedBank = append(r.UnmatchedBank, line)
+			continue
+		}
+		r.match(line, i, false)
+	}
+	return r
+}
+
+// Match manually matches the unmatched statement line, numbered from 1, to the unmatched deposit of the customer
+func (r *Reconciliation) Match(line int, customer string, reference string) error {
+	if line < 1 || line > len(r.UnmatchedBank) {
+		return ErrStatementLineNotFound
+	}
+	for i, d := range r.UnmatchedSystem {
+		if d.Customer == customer && d.Deposit.Reference == reference {
+			l := r.UnmatchedBank[line-1]
+			r.UnmatchedBank = append(r.UnmatchedBank[:line-1], r.UnmatchedBank[line:]...)
+			r.match(l, i, true)
+			return nil
+		}
+	}
+	return ErrUnmatchedNotFound
+}
+
+// findMatch returns the index of the unmatched deposit the line pays for, or -1 when there is not exactly one
+func (r *Reconciliation) findMatch(line StatementLine) int {
+	byReference, byDescription := -1, -1
+	var references, descriptions int
+	for i, d := range r.UnmatchedSystem {
+		if d.Deposit.Currency != line.Currency || roundCents(float64(d.Deposit.Amount)) != roundCents(float64(line.Amount)) {
+			continue
+		}
+		if line.Reference != "" && strings.EqualFold(d.Deposit.Reference, line.Reference) {
+			byReference = i
+			references++
+		} else if containsReference(line.Description, d.Deposit.Reference) {
+			byDescription = i
+			descriptions++
+		}
+	}
+	if references == 1 {
+		return byReference
+	}
+	if references == 0 && descriptions == 1 {
+		return byDescription
+	}
+	return -1
+}
+
+// containsReference reports whether the reference appears in the text, ignoring case, as a whole token rather than
+// as part of a longer reference
+func containsReference(text string, reference string) bool {
+	text, reference = strings.ToLower(text), strings.ToLower(reference)
+	if reference == "" {
+		return false
+	}
+	for start := 0; ; {
+		i := strings.Index(text[start:], reference)
+		if i < 0 {
+			return false
+		}
+		i += start
+		end := i + len(reference)
+		if (i == 0 || !isReferenceChar(text[i-1])) && (end == len(text) || !isReferenceChar(text[end])) {
+			return true
+		}
+		start = i + 1
+	}
+}
+
+func isReferenceChar(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= '0' && b <= '9'
+}
+
+func (r *Reconciliation) match(line StatementLine, deposit int, manual bool) {
+	d := r.UnmatchedSystem[deposit]
+	d.Deposit.Reconciled = true
+	r.UnmatchedSystem = append(r.UnmatchedSystem[:deposit], r.UnmatchedSystem[deposit+1:]...)
+	r.Matched = append(r.Matched, ReconciliationMatch{Line: line, ReconciledDeposit: d, Manual: manual})
+}
+
+// String formats the statement line for display
+func (l StatementLine) String() string {
+	s := fmt.Sprintf("%s %.2f %s %s", l.Date.Format("2006-01-02"), l.Amount, l.Currency, l.Reference)
+	if l.Description != "" {
+		s += " " + l.Description
+	}
+	return s
+}
@@ -0,0 +1,133 @@
+package app
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTestStatement(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestLoadStatement_shouldReadCreditLines_givenCSV(t *testing.T) {
+	path := writeTestStatement(t, "statement.csv", "date,amount,currency,reference,description\n"+
+		"2020-01-31,300.50,SGD,TX1,Test One\n"+
+		"2020-01-31,-20,SGD,CHG,bank charges\n")
+
+	res, err := LoadStatement(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []StatementLine{
+		{Date: time.Date(2020, 1, 31, 0, 0, 0, 0, time.UTC), Amount: 300.5, Currency: "SGD", Reference: "TX1", Description: "Test One"},
+	}, res)
+}
+
+func TestLoadStatement_shouldReadCreditLines_givenMT940(t *testing.T) {
+	path := writeTestStatement(t, "statement.sta", ":20:STATEMENT1\n"+
+		":25:123456789\n"+
+		":60F:C200101SGD1000,00\n"+
+		":61:2001310131C300,50NTRFTX1//B1\n"+
+		":86:Test One\n"+
+		":61:200131D20,NCHGCHG\n"+
+		":86:bank charges\n"+
+		":61:200201C100,NTRFNONREF\n"+
+		":62F:C200201SGD1380,50\n")
+
+	res, err := LoadStatement(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []StatementLine{
+		{Date: time.Date(2020, 1, 31, 0, 0, 0, 0, time.UTC), Amount: 300.5, Currency: "SGD", Reference: "TX1", Description: "Test One"},
+		{Date: time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC), Amount: 100, Currency: "SGD", Reference: "NONREF"},
+	}, res)
+}
+
+func TestLoadStatement_shouldReturnError_givenInvalidStatement(t *testing.T) {
+	testLoad := func(name string, content string, expectedErr string) {
+		res, err := LoadStatement(writeTestStatement(t, name, content))
+		assert.Error(t, err)
+		assert.Equal(t, expectedErr, err.Error())
+		assert.Nil(t, res)
+	}
+
+	testLoad("s.csv", "date,amount,currency,reference,description\n31/01/2020,1,SGD,TX1,\n", "invalid statement date: 31/01/2020")
+	testLoad("s.csv", "date,amount,currency,reference,description\n2020-01-31,one,SGD,TX1,\n", "invalid statement amount: one")
+	testLoad("s.sta", ":61:200131C300,NTRFTX1\n", "transaction before opening balance")
+	testLoad("s.sta", ":60F:C200101SGD1000,00\n:61:garbage\n", "invalid transaction: :61:garbage")
+}
+
+func TestReconcile_shouldMatchDepositsByReferenceAndAmount(t *testing.T) {
+	c1 := &Customer{ID: "c1"}
+	c1.StartSession()
+	c1.ReceiveDeposit(300, "", DepositDetails{Source: SourceBankTransfer, Reference: "TX1"}, DepositPending)
+	c1.ReceiveDeposit(50, "", DepositDetails{Source: SourceCash, Reference: "CASH1"}, DepositCleared)
+	c2 := &Customer{ID: "c2"}
+	c2.StartSession()
+	c2.ReceiveDeposit(200, "", DepositDetails{Source: SourceCheque, Reference: "CHQ9"}, DepositPending)
+	c2.ReceiveDeposit(75, "", DepositDetails{Source: SourceCard, Reference: "CARD1"}, DepositPending)
+
+	lines := []StatementLine{
+		{Amount: 300, Currency: "SGD", Reference: "tx1"},
+		{Amount: 200, Currency: "SGD", Reference: "DEP", Description: "cheque CHQ9 deposit"},
+		{Amount: 80, Currency: "SGD", Reference: "CARD1"},
+	}
+	r := Reconcile(lines, []*Customer{c1, c2})
+
+	assert.Equal(t, []ReconciliationMatch{
+		{Line: lines[0], ReconciledDeposit: ReconciledDeposit{Customer: "c1", Deposit: c1.received[0]}},
+		{Line: lines[1], ReconciledDeposit: ReconciledDeposit{Customer: "c2", Deposit: c2.received[0]}},
+	}, r.Matched)
+	assert.Equal(t, []StatementLine{lines[2]}, r.UnmatchedBank)
+	assert.Equal(t, []ReconciledDeposit{{Customer: "c2", Deposit: c2.received[1]}}, r.UnmatchedSystem)
+	assert.True(t, c1.received[0].Reconciled)
+
+	err := r.Match(1, "c2", "CARD2")
+	assert.Error(t, err)
+	assert.Equal(t, "unmatched deposit not found", err.Error())
+	err = r.Match(2, "c2", "CARD1")
+	assert.Error(t, err)
+	assert.Equal(t, "statement line not found", err.Error())
+	assert.NoError(t, r.Match(1, "c2", "CARD1"))
+	assert.Equal(t, ReconciliationMatch{Line: lines[2], ReconciledDeposit: ReconciledDeposit{Customer: "c2", Deposit: c2.received[1]}, Manual: true}, r.Matched[2])
+	assert.Empty(t, r.UnmatchedBank)
+	assert.Empty(t, r.UnmatchedSystem)
+
+	assert.Empty(t, Reconcile(lines, []*Customer{c1, c2}).Matched)
+}
+
+func TestReconcile_shouldLeaveLineUnmatched_givenAmbiguousMatch(t *testing.T) {
+	c1 := &Customer{ID: "c1"}
+	c1.StartSession()
+	c1.ReceiveDeposit(100, "", DepositDetails{Source: SourceBankTransfer, Reference: "A1"}, DepositPending)
+	c2 := &Customer{ID: "c2"}
+	c2.StartSession()
+	c2.ReceiveDeposit(100, "", DepositDetails{Source: SourceBankTransfer, Reference: "A1"}, DepositPending)
+
+	r := Reconcile([]StatementLine{{Amount: 100, Currency: "SGD", Reference: "A1"}}, []*Customer{c1, c2})
+	assert.Empty(t, r.Matched)
+	assert.Equal(t, 1, len(r.UnmatchedBank))
+	assert.Equal(t, 2, len(r.UnmatchedSystem))
+}
+
+func TestReconcile_shouldMatchWholeReferenceInDescription(t *testing.T) {
+	c := &Customer{ID: "c1"}
+	c.StartSession()
+	c.ReceiveDeposit(100, "", DepositDetails{Source: SourceBankTransfer, Reference: "TX1"}, DepositPending)
+
+	testReconcile := func(description string, matched bool) {
+		c.received[0].Reconciled = false
+		r := Reconcile([]StatementLine{{Amount: 100, Currency: "SGD", Description: description}}, []*Customer{c})
+		assert.Equal(t, matched, len(r.Matched) == 1, description)
+	}
+
+	testReconcile("payment TX1", true)
+	testReconcile("payment tx1/invoice", true)
+	testReconcile("TX1", true)
+	testReconcile("payment TX10", false)
+	testReconcile("payment ATX1", false)
+	testReconcile("", false)
+}
@@ -0,0 +1,223 @@
+package app
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Repository stores the customers, their portfolios and the ledger between runs of the app
+type Repository interface {
+	// Load returns the customers and the ledger saved
+	Load() ([]*Customer, *Ledger, error)
+	// LoadReviews returns the reviews saved, oldest first, with their sessions holding the deposits of the customers
+	LoadReviews(customers []*Customer) ([]*Review, error)
+	// Commit saves the changes, either all of them or none
+	Commit(changes ChangeSet) error
+	// CustomerEntries returns the ledger entries of the customer recorded between from and to, oldest first
+	CustomerEntries(customer string, from time.Time, to time.Time) ([]LedgerEntry, error)
+}
+
+// ChangeSet is the customers changed and the ledger entries recorded by a command. Reviews are every review of
+// the customers changed, replacing those saved for them.
+type ChangeSet struct {
+	Customers []*Customer
+	Entries   []LedgerEntry
+	Reviews   []*Review
+	renames   []ledgerRename
+}
+
+// MemoryRepository is a repository keeping everything in memory
+type MemoryRepository struct {
+	mu        sync.Mutex
+	customers []CustomerSnapshot
+	entries   []LedgerEntry
+	renames   []ledgerRename
+	reviews   []ReviewSnapshot
+}
+
+// NewMemoryRepository instantiate an empty repository
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{customers: []CustomerSnapshot{}, entries: []LedgerEntry{}}
+}
+
+// Load returns copies of the customers and the ledger saved
+func (r *MemoryRepository) Load() ([]*Customer, *Ledger, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	customers := []*Customer{}
+	for _, cs := range r.customers {
+		customers = append(customers, cs.restore())
+	}
+	ledger := &Ledger{entries: append([]LedgerEntry{}, r.entries...), renames: append([]ledgerRename{}, r.renames...)}
+	return customers, ledger, nil
+}
+
+// Commit saves the state of the customers and appends the entries to the ledger
+func (r *MemoryRepository) Commit(changes ChangeSet) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, c := range changes.Customers {
+		cs := snapshotCustomer(c)
+		saved := false
+		for i := range r.customers {
+			if r.customers[i].ID == cs.ID {
+				r.customers[i], saved = cs, true
+				break
+			}
+		}
+		if !saved {
+			r.customers = append(r.customers, cs)
+		}
+	}
+	r.entries = append(r.entries, changes.Entries...)
+	r.renames = append(r.renames, changes.renames...)
+
+	reviews := []ReviewSnapshot{}
+	for _, rs := range r.reviews {
+		if findCustomer(changes.Customers, rs.Customer) == nil {
+			reviews = append(reviews, rs)
+		}
+	}
+	for _, rv := range changes.Reviews {
+		reviews = append(reviews, snapshotReview(rv))
+	}
+	sortReviews(reviews)
+	r.reviews = reviews
+	return nil
+}
+
+// LoadReviews returns copies of the reviews saved
+func (r *MemoryRepository) LoadReviews(customers []*Customer) ([]*Review, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return restoreReviews(r.reviews, customers)
+}
+
+// sortReviews puts the reviews in the order they were held, which is that of their numbered ids
+func sortReviews(reviews []ReviewSnapshot) {
+	sort.SliceStable(reviews, func(i, j int) bool {
+		a, _ := strconv.Atoi(reviews[i].ID)
+		b, _ := strconv.Atoi(reviews[j].ID)
+		return a < b
+	})
+}
+
+func restoreReviews(snapshots []ReviewSnapshot, customers []*Customer) ([]*Review, error) {
+	reviews := []*Review{}
+	for _, rs := range snapshots {
+		rv, err := rs.restore(customers)
+		if err != nil {
+			return nil, err
+		}
+		reviews = append(reviews, rv)
+	}
+	return reviews, nil
+}
+
+// CustomerEntries returns the ledger entries of the customer recorded between from and to, oldest first
+func (r *MemoryRepository) CustomerEntries(customer string, from time.Time, to time.Time) ([]LedgerEntry, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entries := []LedgerEntry{}
+	for _, e := range r.entries {
+		if e.Customer == customer && !e.Time.Before(from) && !e.Time.After(to) {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+// SetRepository sets the repository customers, the ledger and reviews are committed to after every command,
+// replacing those of the app with the ones already saved in it
+func (a *App) SetRepository(repo Repository) error {
+	if err := a.load(repo); err != nil {
+		return err
+	}
+	a.repo = repo
+	a.currentCustomer = nil
+	return nil
+}
+
+// load replaces the customers, the ledger and the reviews of the app with those saved in the repository. The
+// active customer stays selected. Anything pointing into the state replaced, such as deposit sessions in
+// progress and commands pending approval, is dropped.
+func (a *App) load(repo Repository) error {
+	customers, ledger, err := repo.Load()
+	if err != nil {
+		return err
+	}
+	reviews, err := repo.LoadReviews(customers)
+	if err != nil {
+		return err
+	}
+
+	var current *Customer
+	if a.currentCustomer != nil {
+		current = findCustomer(customers, a.currentCustomer.ID)
+	}
+	a.ledger = ledger
+	a.customers = customers
+	a.currentCustomer = current
+	a.reviews = ReviewQueue{reviews: reviews}
+	for _, c := range customers {
+		a.attach(c)
+	}
+	a.committedEntries, a.committedRenames = len(ledger.entries), len(ledger.renames)
+	a.changed = nil
+	a.pendingApproval, a.rebalance, a.reconciliation = nil, nil, nil
+	return nil
+}
+
+// markChanged has the customer committed along with the active customer at the end of the command
+func (a *App) markChanged(c *Customer) {
+	if c != nil {
+		a.changed = append(a.changed, c)
+	}
+}
+
+// commitChanges commits the ledger entries recorded since the last commit to the repository, along with the
+// active customer, the customers marked changed and the customers they were recorded for. When the commit fails,
+// the state last committed is loaded back so the app does not hold changes the repository does not.
+func (a *App) commitChanges() error {
+	if a.repo == nil {
+		a.changed = nil
+		return nil
+	}
+
+	changes := ChangeSet{Entries: a.ledger.entries[a.committedEntries:], renames: a.ledger.renames[a.committedRenames:]}
+	changed := map[string]bool{}
+	add := func(c *Customer) {
+		if c != nil && !changed[c.ID] {
+			changed[c.ID] = true
+			changes.Customers = append(changes.Customers, c)
+		}
+	}
+	add(a.currentCustomer)
+	for _, c := range a.changed {
+		add(c)
+	}
+	for _, e := range changes.Entries {
+		if e.Customer != "" {
+			add(a.findCustomer(e.Customer))
+		}
+	}
+	for _, rv := range a.reviews.reviews {
+		if changed[rv.Customer] {
+			changes.Reviews = append(changes.Reviews, rv)
+		}
+	}
+
+	if err := a.repo.Commit(changes); err != nil {
+		if lerr := a.load(a.repo); lerr != nil {
+			return fmt.Errorf("%w (reloading failed: %v)", err, lerr)
+		}
+		return err
+	}
+	a.committedEntries += len(changes.Entries)
+	a.committedRenames += len(changes.renames)
+	a.changed = nil
+	return nil
+}
@@ -0,0 +1,200 @@
+package app
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// failingRepository is a memory repository whose commits fail once failing is set
+type failingRepository struct {
+	*MemoryRepository
+	failing bool
+}
+
+func (r *failingRepository) Commit(changes ChangeSet) error {
+	if r.failing {
+		return errors.New("commit failed")
+	}
+	return r.MemoryRepository.Commit(changes)
+}
+
+// testRepositoryRoundTrip commits a customer with a ledger entry, a renamed portfolio, deposits, a saved plan and a
+// review to the repository and checks they load back the same
+func testRepositoryRoundTrip(t *testing.T, repo Repository) {
+	tm := time.Date(2020, 1, 31, 12, 0, 0, 0, time.UTC)
+	c := &Customer{ID: "test1", Name: "Test One", DateOfBirth: time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC), KYCStatus: KYCVerified, Status: CustomerActive, portfolios: []*Portfolio{
+		{Name: "Retirement", Balance: 100.5, Product: DefaultProductCatalogue()["retirement"], OpenedAt: tm, Currency: "USD", uncleared: 20, contributionYear: 2020, yearContributions: 100.5, accruedThrough: tm, accruedInterest: 0.125, capitalisedInterest: 0.5},
+		{Name: "Growth", Closed: true},
+	}, targets: map[string]float64{"Retirement": 100}, goals: map[string]Goal{"Retirement": {Amount: 50000, Date: time.Date(2028, 12, 1, 0, 0, 0, 0, time.UTC)}}}
+	entries := []LedgerEntry{
+		{Time: tm, Customer: "test1", Portfolio: "Retirement", Type: EntryDeposit, Amount: 100, Currency: "USD", OriginalAmount: 135, OriginalCurrency: "SGD", Reference: "plan", PlanType: "monthly"},
+		{Time: tm.Add(time.Hour), Customer: "test1", Portfolio: "Retirement", Type: EntryInterest, Amount: 0.5, Currency: "USD"},
+	}
+	renames := []ledgerRename{{customer: "test1", from: "Old", to: "Retirement", seq: 1}}
+	settled := &ReceivedDeposit{DepositDetails: DepositDetails{Source: SourceBankTransfer, Reference: "TX1", Payer: "Test One", ReceivedAt: tm}, Amount: 20, Currency: "USD", Status: DepositPending, Reconciled: true}
+	settled.settlement = &depositSettlement{credits: []DepositCredit{{Portfolio: "Retirement", Net: 100}}, portfolios: []*Portfolio{c.portfolios[0]}, total: 100}
+	held := &ReceivedDeposit{DepositDetails: DepositDetails{Source: SourceCash, Reference: "test1-2", ReceivedAt: tm}, Amount: 5000, Currency: "USD", Status: DepositCleared}
+	plan := &baseDepositPlan{name: "Monthly", planType: "monthly", currency: "USD", portfolioRatio: map[string]float32{"Retirement": 5000}}
+	session := &DepositSession{depositPlans: []DepositPlan{plan}, deposits: []float32{5000}, currency: "USD", flags: []string{"large deposit"}, received: []*ReceivedDeposit{held}}
+	held.session = session
+	c.received, c.savedPlans = []*ReceivedDeposit{settled, held}, []DepositPlan{plan}
+	reviews := []*Review{{ID: "1", Customer: "test1", Session: session, Reasons: []string{"large deposit"}, HeldAt: tm, Status: ReviewPending}}
+	assert.NoError(t, repo.Commit(ChangeSet{Customers: []*Customer{c}, Entries: entries, Reviews: reviews, renames: renames}))
+
+	c.Name = "Test Uno"
+	c.portfolios = c.portfolios[:1]
+	reviews[0].Status, reviews[0].ReviewedBy = ReviewRejected, "super"
+	assert.NoError(t, repo.Commit(ChangeSet{Customers: []*Customer{c}, Reviews: reviews}))
+
+	customers, ledger, err := repo.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(customers))
+	assert.Equal(t, snapshotCustomer(c).Name, customers[0].Name)
+	assert.Equal(t, 1, len(customers[0].portfolios))
+	loaded, expected := snapshotCustomer(customers[0]), snapshotCustomer(c)
+	assert.True(t, expected.DateOfBirth.Equal(loaded.DateOfBirth))
+	assert.True(t, expected.Portfolios[0].OpenedAt.Equal(loaded.Portfolios[0].OpenedAt))
+	assert.True(t, expected.Portfolios[0].AccruedThrough.Equal(loaded.Portfolios[0].AccruedThrough))
+	loaded.DateOfBirth, loaded.Portfolios[0].OpenedAt, loaded.Portfolios[0].AccruedThrough = expected.DateOfBirth, expected.Portfolios[0].OpenedAt, expected.Portfolios[0].AccruedThrough
+	for i := range loaded.Deposits {
+		assert.True(t, expected.Deposits[i].ReceivedAt.Equal(loaded.Deposits[i].ReceivedAt))
+		loaded.Deposits[i].ReceivedAt = expected.Deposits[i].ReceivedAt
+	}
+	assert.Equal(t, expected, loaded)
+	assert.Equal(t, float32(20), customers[0].received[0].share(customers[0].received[0].settlement.credits[0]))
+	assert.Equal(t, customers[0].portfolios[0], customers[0].received[0].settlement.portfolios[0])
+
+	loadedReviews, err := repo.LoadReviews(customers)
+	assert.NoError(t, err)
+	if assert.Equal(t, 1, len(loadedReviews)) {
+		rs, expectedReview := snapshotReview(loadedReviews[0]), snapshotReview(reviews[0])
+		assert.True(t, expectedReview.HeldAt.Equal(rs.HeldAt))
+		rs.HeldAt = expectedReview.HeldAt
+		assert.Equal(t, expectedReview, rs)
+		assert.Equal(t, customers[0].received[1], loadedReviews[0].Session.received[0])
+		assert.Equal(t, loadedReviews[0].Session, customers[0].received[1].session)
+	}
+
+	assert.Equal(t, len(entries), len(ledger.entries))
+	for i, e := range ledger.entries {
+		assert.True(t, entries[i].Time.Equal(e.Time))
+		e.Time = entries[i].Time
+		assert.Equal(t, entries[i], e)
+	}
+	assert.Equal(t, renames, ledger.renames)
+
+	found, err := repo.CustomerEntries("test1", tm.Add(time.Minute), tm.Add(2*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(found))
+	assert.Equal(t, EntryInterest, found[0].Type)
+	found, err = repo.CustomerEntries("test2", tm, tm.Add(2*time.Hour))
+	assert.NoError(t, err)
+	assert.Empty(t, found)
+}
+
+// testRepositoryApp runs commands on an app committing to the repository, and checks a new app loading from the
+// repository carries on from where the first left off
+func testRepositoryApp(t *testing.T, repo Repository) {
+	setNow(t, time.Date(2020, 1, 31, 12, 0, 0, 0, time.UTC))
+	app := NewApp()
+	app.SetScreener(&ScreeningRules{Threshold: 1000})
+	assert.NoError(t, app.SetRepository(repo))
+	for _, input := range []string{
+		"newcustomer test1",
+		"addportfolio Retirement",
+		"addportfolio Growth",
+		"startDeposit",
+		"addOneTimePlan plan Retirement 100 Growth 50",
+		"addMonthlyPlan monthly Retirement 60",
+		"deposit 150",
+		"deposit 60 --ref TX1 --status pending",
+		"endDeposit",
+		"renameportfolio Growth Equity",
+		"newcustomer test2",
+		"addportfolio Savings",
+		"startDeposit",
+		"addOneTimePlan big Savings 1500",
+		"deposit 1500",
+		"endDeposit",
+	} {
+		_, err := app.processInput(input)
+		assert.NoError(t, err, input)
+	}
+
+	reloaded := NewApp()
+	assert.NoError(t, reloaded.SetRepository(repo))
+	assert.Equal(t, 2, len(reloaded.customers))
+	assert.Nil(t, reloaded.currentCustomer)
+	c := reloaded.findCustomer("test1")
+	assert.Equal(t, []PortfolioBalance{
+		{Customer: "test1", Portfolio: "Retirement", Balance: 160, Currency: "SGD"},
+		{Customer: "test1", Portfolio: "Equity", Balance: 50, Currency: "SGD"},
+	}, c.BalancesAsOf(now()))
+	assert.Equal(t, reloaded.ledger, c.ledger)
+	assert.Equal(t, 1, len(c.SavedPlans()))
+	assert.Equal(t, 1, len(reloaded.reviews.Pending()))
+
+	_, err := reloaded.processInput("newcustomer test1")
+	assert.Equal(t, ErrCustomerExists, err)
+	for _, input := range []string{"selectcustomer test1", "withdraw Equity 20", "cleardeposit TX1", "withdraw Retirement 160", "approvereview 1"} {
+		_, err := reloaded.processInput(input)
+		assert.NoError(t, err, input)
+	}
+	customers, ledger, err := repo.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, float32(30), customers[0].portfolios[1].Balance)
+	assert.Equal(t, float32(0), customers[0].portfolios[0].Balance)
+	assert.Equal(t, DepositCleared, customers[0].received[1].Status)
+	assert.Equal(t, float32(1500), customers[1].portfolios[0].Balance)
+	assert.Equal(t, EntryDeposit, ledger.entries[len(ledger.entries)-1].Type)
+	reviews, err := repo.LoadReviews(customers)
+	assert.NoError(t, err)
+	assert.Equal(t, ReviewApproved, reviews[0].Status)
+}
+
+func TestMemoryRepository_shouldLoadCommittedChanges(t *testing.T) {
+	testRepositoryRoundTrip(t, NewMemoryRepository())
+}
+
+func TestMemoryRepository_shouldKeepAppStateBetweenRuns(t *testing.T) {
+	testRepositoryApp(t, NewMemoryRepository())
+}
+
+func TestApp_shouldLoadCommittedState_givenCommitFails(t *testing.T) {
+	app := NewApp()
+	repo := &failingRepository{MemoryRepository: NewMemoryRepository()}
+	assert.NoError(t, app.SetRepository(repo))
+	for _, input := range []string{"newcustomer test", "addportfolio Retirement", "startDeposit", "addOneTimePlan plan Retirement 100", "deposit 100", "endDeposit"} {
+		_, err := app.processInput(input)
+		assert.NoError(t, err, input)
+	}
+
+	repo.failing = true
+	_, err := app.processInput("withdraw Retirement 40")
+	assert.EqualError(t, err, "commit failed")
+	assert.Equal(t, "test", app.currentCustomer.ID)
+	assert.Equal(t, float32(100), app.currentCustomer.portfolios[0].Balance)
+	assert.Equal(t, 1, len(app.ledger.Entries("test")))
+
+	repo.failing = false
+	_, err = app.processInput("withdraw Retirement 40")
+	assert.NoError(t, err)
+	customers, ledger, err := repo.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, float32(60), customers[0].portfolios[0].Balance)
+	assert.Equal(t, 2, len(ledger.Entries("test")))
+}
+
+func TestMemoryRepository_shouldReturnCopies(t *testing.T) {
+	repo := NewMemoryRepository()
+	c := &Customer{ID: "test1", portfolios: []*Portfolio{{Name: "Retirement", Balance: 100}}}
+	assert.NoError(t, repo.Commit(ChangeSet{Customers: []*Customer{c}}))
+	c.portfolios[0].Balance = 50
+
+	customers, _, err := repo.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, float32(100), customers[0].portfolios[0].Balance)
+}
@@ -4,7 +4,10 @@ import (
 	"bufio"
 	"errors"
 	"fmt"
+	"reflect"
 	"strconv"
+	"strings"
+	"time"
 
 	"bitbucket.org/leeyousheng/account-deposit-server/pkg/cli"
 )
@@ -13,11 +16,211 @@ import (
 type App struct {
 	customers       []*Customer
 	currentCustomer *Customer
+	operator        string
+	auditLog        *AuditLog
+	operators       *OperatorStore
+	session         *Operator
+	sessionToken    string
+	products        ProductCatalogue
+	ledger          *Ledger
+	clock           func() time.Time
+	fx              RateProvider
+	fees            *FeeSchedule
+	limits          *Limits
+	pendingApproval *pendingApproval
+	rebalance       *pendingRebalance
+	screener        Screener
+	reviews         ReviewQueue
+	reconciliation  *Reconciliation
+	paymentQueue    []QueuedPayment
+	events          *EventBus
+	webhooks        *WebhookDispatcher
+	eventStore      *FileOutbox
+	snapshots       *SnapshotStore
+	repo            Repository
+
+	committedEntries int
+	committedRenames int
+	changed          []*Customer
+
+	// actedOn is the customer the command acts on when it is not the current customer
+	actedOn string
+}
+
+// pendingApproval is a command rejected by a soft limit, waiting for a supervisor to approve it. It is dropped
+// once another command is performed.
+type pendingApproval struct {
+	customer *Customer
+	command  cli.Command
+	limit    *LimitError
+}
+
+// pendingRebalance is a rebalance shown to the operator, made once they confirm it
+type pendingRebalance struct {
+	customer  *Customer
+	tolerance float64
+	transfers []RebalanceTransfer
+}
+
+// largeWithdrawalThreshold is the amount above which withdrawals need a supervisor
+const largeWithdrawalThreshold float32 = 10000
+
+var commandPermissions = map[string]permission{
+	"newcustomer":     permManageCustomers,
+	"selectcustomer":  permRead,
+	"addportfolio":    permManageCustomers,
+	"updatecustomer":  permManageCustomers,
+	"renameportfolio": permManageCustomers,
+	"closeportfolio":  permManageCustomers,
+	"mergeportfolio":  permManageCustomers,
+	"showcustomer":    permRead,
+	"startDeposit":    permDeposit,
+	"addOneTimePlan":  permDeposit,
+	"addMonthlyPlan":  permDeposit,
+	"addTargetPlan":   permDeposit,
+	"deposit":         permDeposit,
+	"endDeposit":      permDeposit,
+	"sessionStatus":   permRead,
+	"finddeposit":     permRead,
+	"cleardeposit":    permDeposit,
+	"returndeposit":   permReview,
+	"withdraw":        permWithdraw,
+	"printPortfolios": permRead,
+	"projectInterest": permRead,
+	"statement":       permRead,
+	"balances":        permRead,
+	"summary":         permRead,
+	"settarget":       permManageCustomers,
+	"rebalance":       permRead,
+	"applyrebalance":  permManageCustomers,
+	"setgoal":         permManageCustomers,
+	"removegoal":      permManageCustomers,
+	"goals":           permRead,
+	"audit":           permAudit,
+	"addoperator":     permManageOperators,
+	"approve":         permApproveLimits,
+	"reviews":         permAudit,
+	"approvereview":   permReview,
+	"rejectreview":    permReview,
+	"reconcile":       permReconcile,
+	"matchdeposit":    permReconcile,
+	"ingest":          permDeposit,
+	"payments":        permRead,
+	"addwebhook":      permManageWebhooks,
+	"removewebhook":   permManageWebhooks,
+	"webhooks":        permManageWebhooks,
+	"deliverwebhooks": permManageWebhooks,
+	"retrywebhook":    permManageWebhooks,
+	"rebuild":         permAudit,
+	"login":           permNone,
+	"logout":          permNone,
+	"help":            permNone,
+	"exit":            permNone,
 }
 
 // NewApp instantiate a new app without any customers
 func NewApp() App {
-	return App{customers: []*Customer{}, currentCustomer: nil}
+	return App{customers: []*Customer{}, currentCustomer: nil, ledger: NewLedger()}
+}
+
+// SetClock sets the clock commands are performed at. Interest is accrued up to it before every command, and
+// ledger entries and events are stamped with it.
+func (a *App) SetClock(clock func() time.Time) {
+	a.clock = clock
+	for _, c := range a.customers {
+		c.clock = clock
+	}
+}
+
+// SetOperator sets the identity recorded against the commands performed
+func (a *App) SetOperator(operator string) {
+	a.operator = operator
+}
+
+// SetAuditLog sets the log every command performed is recorded to
+func (a *App) SetAuditLog(auditLog *AuditLog) {
+	a.auditLog = auditLog
+}
+
+// SetProductCatalogue sets the products portfolios can be opened with
+func (a *App) SetProductCatalogue(products ProductCatalogue) {
+	a.products = products
+}
+
+// SetRateProvider sets the rates used to convert deposits into the currency of the portfolios
+func (a *App) SetRateProvider(fx RateProvider) {
+	a.fx = fx
+	for _, c := range a.customers {
+		c.fx = fx
+	}
+}
+
+// SetFeeSchedule sets the fees charged on deposits
+func (a *App) SetFeeSchedule(fees *FeeSchedule) {
+	a.fees = fees
+	for _, c := range a.customers {
+		c.fees = fees
+	}
+}
+
+// SetLimits sets the limits deposits and withdrawals are evaluated against
+func (a *App) SetLimits(limits *Limits) {
+	a.limits = limits
+	for _, c := range a.customers {
+		c.limits = limits
+	}
+}
+
+// SetScreener sets the screener deposits are inspected by for suspicious activity
+func (a *App) SetScreener(screener Screener) {
+	a.screener = screener
+	for _, c := range a.customers {
+		c.screener = screener
+	}
+}
+
+// SetEventBus sets the bus domain events are published on
+func (a *App) SetEventBus(events *EventBus) {
+	a.events = events
+	for _, c := range a.customers {
+		c.events = events
+	}
+}
+
+// SetWebhookDispatcher sets the dispatcher webhook subscriptions are managed with
+func (a *App) SetWebhookDispatcher(webhooks *WebhookDispatcher) {
+	a.webhooks = webhooks
+}
+
+// SetEventStore sets the outbox customer state is rebuilt from, and the store snapshots of the rebuilt
+// state are kept in. Without a snapshot store every rebuild replays all the events.
+func (a *App) SetEventStore(events *FileOutbox, snapshots *SnapshotStore) {
+	a.eventStore = events
+	a.snapshots = snapshots
+}
+
+// SetOperatorStore sets the operator accounts allowed to log in. Once the store has any
+// operator, every command is checked against the permissions of the logged in operator.
+func (a *App) SetOperatorStore(operators *OperatorStore) {
+	a.operators = operators
+}
+
+// Execute performs a single command on behalf of the operator the token was issued to
+func (a *App) Execute(token string, input string) error {
+	if a.operators == nil {
+		return &NotConfiguredError{Feature: "operators"}
+	}
+	o, err := a.operators.Authenticate(token)
+	if err != nil {
+		return err
+	}
+
+	session, sessionToken := a.session, a.sessionToken
+	a.session, a.sessionToken = o, token
+	defer func() { a.session, a.sessionToken = session, sessionToken }()
+
+	_, err = a.processInput(input)
+	return err
 }
 
 // Run performs the app loop
@@ -32,7 +235,7 @@ func (a *App) Run(scanner *bufio.Scanner) {
 		input := scanner.Text()
 		end, err = a.processInput(input)
 		if err != nil {
-			fmt.Println(err)
+			fmt.Println(FriendlyMessage(err))
 		}
 		fmt.Print("\n> ")
 	}
@@ -41,7 +244,7 @@ func (a *App) Run(scanner *bufio.Scanner) {
 func (a *App) processInput(input string) (bool, error) {
 	command, err := cli.ParseCmdInput(input)
 	if err != nil {
-		if err.Error() == "no command given" {
+		if errors.Is(err, cli.ErrNoCommand) {
 			return false, nil
 		}
 		return false, err
@@ -50,6 +253,33 @@ func (a *App) processInput(input string) (bool, error) {
 }
 
 func (a *App) performCommand(command cli.Command) (bool, error) {
+	var end bool
+	a.actedOn = ""
+	err := a.authorize(command)
+	if err == nil {
+		err = a.accrueInterest()
+	}
+	if err == nil {
+		a.events.Hold()
+		end, err = a.executeCommand(command)
+		if err == nil {
+			err = a.commitChanges()
+		}
+		a.releaseEvents(err)
+	}
+	if !keepsPendingApproval[command.Command] {
+		a.pendingApproval = nil
+		if lerr, ok := err.(*LimitError); ok && lerr.Rule.Soft {
+			a.pendingApproval = &pendingApproval{customer: a.currentCustomer, command: command, limit: lerr}
+		}
+	}
+	if aerr := a.audit(command, err); aerr != nil {
+		fmt.Println("Audit log error:", aerr)
+	}
+	return end, err
+}
+
+func (a *App) executeCommand(command cli.Command) (bool, error) {
 	var err error
 	switch command.Command {
 	case "newcustomer":
@@ -57,13 +287,40 @@ func (a *App) performCommand(command cli.Command) (bool, error) {
 		if err == nil {
 			fmt.Println("New customer created:", command.Args[0])
 		}
+	case "selectcustomer":
+		err = a.selectCustomer(command.Args)
+		if err == nil {
+			fmt.Println("Customer selected:", command.Args[0])
+		}
 	case "addportfolio":
 		err = a.addPortfolio(command.Args)
 		if err == nil {
 			fmt.Println("Portfolio added:", command.Args[0])
 		}
+	case "renameportfolio":
+		err = a.renamePortfolio(command.Args)
+		if err == nil {
+			fmt.Println("Portfolio renamed:", command.Args[0], "->", command.Args[1])
+		}
+	case "closeportfolio":
+		err = a.closePortfolio(command.Args)
+		if err == nil {
+			fmt.Println("Portfolio closed:", command.Args[0])
+		}
+	case "mergeportfolio":
+		err = a.mergePortfolio(command.Args)
+		if err == nil {
+			fmt.Println("Portfolio merged:", command.Args[0], "->", command.Args[1])
+		}
+	case "showcustomer":
+		err = a.showCustomer(command.Args)
+	case "updatecustomer":
+		err = a.updateCustomer(command.Args)
+		if err == nil {
+			fmt.Println("Customer updated:", a.currentCustomer.ID)
+		}
 	case "startDeposit":
-		err = a.startDeposit()
+		err = a.startDeposit(command.Args)
 		if err == nil {
 			fmt.Println("Deposit session started")
 		}
@@ -77,94 +334,367 @@ func (a *App) performCommand(command cli.Command) (bool, error) {
 		if err == nil {
 			fmt.Println("Monthly deposit plan selected for deposit:", command.Args[0])
 		}
+	case "addTargetPlan":
+		err = a.addTargetPlan(command.Args)
+		if err == nil {
+			fmt.Println("Target deposit plan selected for deposit:", command.Args[0])
+		}
 	case "deposit":
 		err = a.deposit(command.Args)
 		if err == nil {
 			fmt.Println("Deposit amount:", command.Args[0])
 		}
 	case "endDeposit":
-		err = a.endDeposit()
-		if err == nil {
+		var held bool
+		held, err = a.endDeposit()
+		if err == nil && !held {
 			fmt.Println("Session completed")
 			a.printPortfolios()
 		}
+	case "sessionStatus":
+		err = a.printSessionStatus()
+	case "finddeposit":
+		err = a.findDeposit(command.Args)
+	case "cleardeposit":
+		err = a.clearDeposit(command.Args)
+		if err == nil {
+			fmt.Println("Deposit cleared:", command.Args[0])
+		}
+	case "returndeposit":
+		err = a.returnDeposit(command.Args)
+		if err == nil {
+			fmt.Println("Deposit returned:", command.Args[0])
+		}
+	case "withdraw":
+		err = a.withdraw(command.Args)
+		if err == nil {
+			fmt.Println("Withdrawn from", command.Args[0]+":", command.Args[1])
+		}
 	case "printPortfolios":
 		err = a.printPortfolios()
+	case "statement":
+		err = a.printStatement()
+	case "balances":
+		err = a.printBalances(command.Args)
+	case "summary":
+		err = a.printSummary(command.Args)
+	case "settarget":
+		err = a.setTarget(command.Args)
+		if err == nil {
+			fmt.Println("Target allocation set")
+		}
+	case "setgoal":
+		err = a.setGoal(command.Args)
+		if err == nil {
+			fmt.Println("Goal set:", command.Args[0])
+		}
+	case "removegoal":
+		err = a.removeGoal(command.Args)
+		if err == nil {
+			fmt.Println("Goal removed:", command.Args[0])
+		}
+	case "goals":
+		err = a.printGoals()
+	case "rebalance":
+		err = a.proposeRebalance(command.Args)
+	case "applyrebalance":
+		err = a.applyRebalance()
+		if err == nil {
+			fmt.Println("Rebalance completed")
+			a.printPortfolios()
+		}
+	case "projectInterest":
+		err = a.projectInterest(command.Args)
+	case "login":
+		err = a.login(command.Args)
+		if err == nil {
+			fmt.Println("Logged in as", command.Args[0])
+		}
+	case "logout":
+		a.logout()
+		fmt.Println("Logged out")
+	case "addoperator":
+		err = a.addOperator(command.Args)
+		if err == nil {
+			fmt.Println("Operator added:", command.Args[0])
+		}
+	case "approve":
+		err = a.approve()
+	case "reviews":
+		a.printReviews()
+	case "approvereview":
+		err = a.approveReview(command.Args)
+		if err == nil {
+			fmt.Println("Review approved:", command.Args[0])
+		}
+	case "rejectreview":
+		err = a.rejectReview(command.Args)
+		if err == nil {
+			fmt.Println("Review rejected:", command.Args[0])
+		}
+	case "reconcile":
+		err = a.reconcile(command.Args)
+	case "matchdeposit":
+		err = a.matchDeposit(command.Args)
+		if err == nil {
+			a.printReconciliation()
+		}
+	case "ingest":
+		err = a.ingest(command.Args)
+	case "payments":
+		for _, q := range a.QueuedPayments() {
+			fmt.Println(q)
+		}
+	case "addwebhook":
+		err = a.addWebhook(command.Args)
+	case "removewebhook":
+		err = a.removeWebhook(command.Args)
+		if err == nil {
+			fmt.Println("Webhook removed:", command.Args[0])
+		}
+	case "webhooks":
+		err = a.printWebhooks()
+	case "deliverwebhooks":
+		err = a.deliverWebhooks()
+	case "retrywebhook":
+		err = a.retryWebhook(command.Args)
+		if err == nil {
+			fmt.Println("Delivery requeued:", command.Args[0])
+		}
+	case "rebuild":
+		err = a.rebuild(command.Args)
+	case "audit":
+		err = a.printAudit(command.Args)
 	case "exit":
 		fmt.Println("See ya!!!")
 		return true, nil
 	case "help":
 		printHelp()
 	default:
-		err = errors.New("invalid command")
+		err = ErrInvalidCommand
 	}
 	return false, err
 }
 
 func (a *App) createNewCustomer(args []string) error {
 	if len(args) < 1 {
-		return errors.New("invalid number of args")
+		return ErrInvalidArgs
+	}
+	if a.findCustomer(args[0]) != nil {
+		return ErrCustomerExists
+	}
+	stored, err := a.customerStored(args[0])
+	if err != nil {
+		return err
+	}
+	if stored {
+		return ErrCustomerExists
 	}
 	c, err := NewCustomer(args[0])
 	if err != nil {
 		return err
 	}
 
+	a.attach(&c)
 	a.customers = append(a.customers, &c)
 	a.currentCustomer = &c
+	a.events.Publish(CustomerCreated{Customer: c.ID, Time: a.now()})
+	return nil
+}
+
+// customerStored reports whether the event store holds the creation of the customer with the id, so a customer
+// created before the app restarted is not created again
+func (a *App) customerStored(id string) (bool, error) {
+	if a.eventStore == nil {
+		return false, nil
+	}
+	records, err := a.eventStore.Records()
+	if err != nil {
+		return false, err
+	}
+	for _, rec := range records {
+		if rec.Type != EventCustomerCreated {
+			continue
+		}
+		e, err := DecodeEvent(rec)
+		if err != nil {
+			return false, err
+		}
+		if e.CustomerID() == id {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// selectCustomer makes the customer with the id the active customer
+func (a *App) selectCustomer(args []string) error {
+	if len(args) < 1 {
+		return ErrInvalidArgs
+	}
+	c := a.findCustomer(args[0])
+	if c == nil {
+		return ErrCustomerNotFound
+	}
+	a.currentCustomer = c
 	return nil
 }
 
+// attach shares the ledger and configuration of the app with the customer
+func (a *App) attach(c *Customer) {
+	c.ledger = a.ledger
+	c.fx = a.fx
+	c.fees = a.fees
+	c.limits = a.limits
+	c.screener = a.screener
+	c.events = a.events
+	c.clock = a.clock
+}
+
 func (a *App) addPortfolio(args []string) error {
 	if len(args) < 1 {
-		return errors.New("invalid number of args")
+		return ErrInvalidArgs
+	}
+	if a.currentCustomer == nil {
+		return ErrNoActiveCustomer
+	}
+
+	flags, args, err := cli.ParseFlags(args)
+	if err != nil {
+		return err
+	}
+	if len(args) < 2 && flags["currency"] == "" {
+		return a.currentCustomer.AddPortfolio(args[0])
+	}
+
+	var product Product
+	if len(args) > 1 {
+		products := a.products
+		if products == nil {
+			products = DefaultProductCatalogue()
+		}
+		if product, err = products.Get(args[1]); err != nil {
+			return err
+		}
+	}
+	return a.currentCustomer.AddProductPortfolio(args[0], product, flags["currency"])
+}
+
+func (a *App) renamePortfolio(args []string) error {
+	if len(args) < 2 {
+		return ErrInvalidArgs
+	}
+	if a.currentCustomer == nil {
+		return ErrNoActiveCustomer
+	}
+	return a.currentCustomer.RenamePortfolio(args[0], args[1])
+}
+
+func (a *App) closePortfolio(args []string) error {
+	if len(args) < 1 {
+		return ErrInvalidArgs
 	}
 	if a.currentCustomer == nil {
-		return errors.New("no active customer")
+		return ErrNoActiveCustomer
+	}
+
+	var target string
+	if len(args) > 1 {
+		target = args[1]
 	}
-	return a.currentCustomer.AddPortfolio(args[0])
+	return a.currentCustomer.ClosePortfolio(args[0], target)
 }
 
-func (a *App) startDeposit() error {
+func (a *App) mergePortfolio(args []string) error {
+	if len(args) < 2 {
+		return ErrInvalidArgs
+	}
 	if a.currentCustomer == nil {
-		return errors.New("no active customer")
+		return ErrNoActiveCustomer
+	}
+	return a.currentCustomer.MergePortfolio(args[0], args[1])
+}
+
+func (a *App) showCustomer(args []string) error {
+	c := a.currentCustomer
+	if len(args) > 0 {
+		a.actedOn = args[0]
+		c = a.findCustomer(args[0])
+		if c == nil {
+			return ErrCustomerNotFound
+		}
 	}
-	a.currentCustomer.StartSession()
+	if c == nil {
+		return ErrNoActiveCustomer
+	}
+
+	c.PrintProfile()
 	return nil
 }
 
+func (a *App) updateCustomer(args []string) error {
+	if a.currentCustomer == nil {
+		return ErrNoActiveCustomer
+	}
+
+	flags, positional, err := cli.ParseFlags(args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 0 {
+		return ErrInvalidArgs
+	}
+	return a.currentCustomer.UpdateProfile(flags)
+}
+
+func (a *App) findCustomer(id string) *Customer {
+	return findCustomer(a.customers, id)
+}
+
+func (a *App) startDeposit(args []string) error {
+	if a.currentCustomer == nil {
+		return ErrNoActiveCustomer
+	}
+	if len(args) > 0 {
+		return a.currentCustomer.StartSessionInCurrency(args[0])
+	}
+	return a.currentCustomer.StartSession()
+}
+
 func (a *App) addPlan(planType string, args []string) error {
 	if a.currentCustomer == nil {
-		return errors.New("no active customer")
+		return ErrNoActiveCustomer
 	}
 
 	if a.currentCustomer.DepositSession == nil {
-		return errors.New("no active session")
+		return ErrNoActiveSession
+	}
+
+	flags, args, err := cli.ParseFlags(args)
+	if err != nil {
+		return err
 	}
 
 	if len(args) < 3 || (len(args)-1)%2 != 0 {
-		return errors.New("invalid number of args")
+		return ErrInvalidArgs
 	}
 
 	portfolioRatio := map[string]float32{}
 	for i := 1; i < len(args); i += 2 {
 		amt, err := strconv.ParseFloat(args[i+1], 32)
 		if err != nil {
-			return err
+			return &InvalidValueError{Field: "amount", Value: args[i+1]}
 		}
 		portfolioRatio[args[i]] = float32(amt)
 	}
 
 	var dp DepositPlan
-	var err error
 
 	switch planType {
-	case "one-time":
-		dp, err = NewOneTimeDepositPlan(args[0], portfolioRatio)
-	case "monthly":
-		dp, err = NewMonthlyDepositPlan(args[0], portfolioRatio)
+	case "one-time", "monthly":
+		dp, err = newBaseDepositPlan(args[0], planType, flags["currency"], portfolioRatio)
 	default:
-		return errors.New("invalid plan type")
+		return ErrInvalidPlanType
 	}
 
 	if err != nil {
@@ -176,58 +706,1031 @@ func (a *App) addPlan(planType string, args []string) error {
 
 func (a *App) deposit(args []string) error {
 	if a.currentCustomer == nil {
-		return errors.New("no active customer")
+		return ErrNoActiveCustomer
 	}
 
+	flags, args, err := cli.ParseFlags(args)
+	if err != nil {
+		return err
+	}
 	if len(args) < 1 {
-		return errors.New("amount not specified")
+		return &MissingArgumentError{Name: "amount"}
 	}
 
 	amount, err := strconv.ParseFloat(args[0], 32)
 	if err != nil {
-		return err
+		return &InvalidValueError{Field: "amount", Value: args[0]}
+	}
+
+	session := a.currentCustomer.DepositSession
+	if session == nil {
+		return ErrNoActiveSession
+	}
+	currency := session.currency
+	if len(args) > 1 {
+		currency = args[1]
+	}
+	status := DepositCleared
+	if flags["status"] != "" {
+		status = DepositStatus(flags["status"])
 	}
 
-	return a.currentCustomer.Deposit(float32(amount))
+	details := DepositDetails{Source: flags["source"], Reference: flags["ref"], Payer: flags["payer"]}
+	if flags["received"] != "" {
+		if details.ReceivedAt, err = parseTime(flags["received"], false); err != nil {
+			return err
+		}
+	}
+
+	flagged := len(session.flags)
+	if err := a.currentCustomer.ReceiveDeposit(float32(amount), currency, details, status); err != nil {
+		return err
+	}
+	for _, reason := range session.flags[flagged:] {
+		fmt.Println("Deposit flagged:", reason)
+	}
+	return nil
 }
 
-func (a *App) endDeposit() error {
+// endDeposit performs the deposit of the session, or holds the session for review when it has been flagged
+func (a *App) endDeposit() (bool, error) {
 	if a.currentCustomer == nil {
-		return errors.New("no active customer")
+		return false, ErrNoActiveCustomer
 	}
 
 	if a.currentCustomer.DepositSession == nil {
-		return errors.New("no active session")
+		return false, ErrNoActiveSession
 	}
 
-	err := a.currentCustomer.PerformDeposit(a.currentCustomer.DepositSession.depositPlans, a.currentCustomer.DepositSession.deposits)
+	session := a.currentCustomer.DepositSession
+	if _, err := a.currentCustomer.PreviewDeposit(session.depositPlans, session.deposits, session.currency); err != nil {
+		return false, err
+	}
+	flags, err := a.currentCustomer.ScreenSession()
 	if err != nil {
-		return err
+		return false, err
+	}
+	if len(flags) > 0 {
+		r := a.reviews.Hold(a.currentCustomer.ID, session, flags, a.now())
+		a.currentCustomer.DepositSession = nil
+		fmt.Println("Session held for review:", r.ID)
+		for _, reason := range flags {
+			fmt.Println("  " + reason)
+		}
+		return true, nil
+	}
+	credits, err := a.currentCustomer.completeSession(session)
+	if err != nil {
+		return false, err
 	}
+	printDepositSummary(credits)
 
 	a.currentCustomer.DepositSession = nil
+	return false, nil
+}
+
+func (a *App) printReviews() {
+	for _, r := range a.reviews.Pending() {
+		fmt.Println(r)
+	}
+}
+
+// approveReview performs the deposit of a held session
+func (a *App) approveReview(args []string) error {
+	if len(args) < 1 {
+		return &MissingArgumentError{Name: "review"}
+	}
+	r, err := a.reviews.Get(args[0])
+	if err != nil {
+		return err
+	}
+	a.actedOn = r.Customer
+	c := a.findCustomer(r.Customer)
+	if c == nil {
+		return ErrCustomerNotFound
+	}
+
+	credits, err := c.completeSession(r.Session)
+	if err != nil {
+		return err
+	}
+	printDepositSummary(credits)
+	r.Status, r.ReviewedBy = ReviewApproved, a.operatorID()
+	a.markChanged(c)
+	return nil
+}
+
+// rejectReview discards a held session without depositing it
+func (a *App) rejectReview(args []string) error {
+	if len(args) < 1 {
+		return &MissingArgumentError{Name: "review"}
+	}
+	r, err := a.reviews.Get(args[0])
+	if err != nil {
+		return err
+	}
+	a.actedOn = r.Customer
+	c := a.findCustomer(r.Customer)
+	if c == nil {
+		return ErrCustomerNotFound
+	}
+
+	c.returnSession(r.Session)
+	r.Status, r.ReviewedBy = ReviewRejected, a.operatorID()
+	a.markChanged(c)
 	return nil
 }
 
+func (a *App) clearDeposit(args []string) error {
+	if a.currentCustomer == nil {
+		return ErrNoActiveCustomer
+	}
+	if len(args) < 1 {
+		return &MissingArgumentError{Name: "deposit reference"}
+	}
+	return a.currentCustomer.ClearDeposit(args[0])
+}
+
+func (a *App) returnDeposit(args []string) error {
+	if a.currentCustomer == nil {
+		return ErrNoActiveCustomer
+	}
+	if len(args) < 1 {
+		return &MissingArgumentError{Name: "deposit reference"}
+	}
+	return a.currentCustomer.ReturnDeposit(args[0])
+}
+
 func (a *App) printPortfolios() error {
 	if a.currentCustomer == nil {
-		return errors.New("no active customer")
+		return ErrNoActiveCustomer
 	}
 
 	a.currentCustomer.PrintPortfolio()
 	return nil
 }
 
-func printHelp() {
-	fmt.Println("Sample flow:")
-	fmt.Println("newcustomer test1")
-	fmt.Println("addportfolio Retirement")
-	fmt.Println("addportfolio \"High Risk\"")
-	fmt.Println("startDeposit")
-	fmt.Println("addOneTimePlan \"One Time Plan 1\" \"High Risk\" 10000 Retirement 500")
-	fmt.Println("addMonthlyPlan \"Monthly Plan 1\" Retirement 100")
-	fmt.Println("deposit 10500")
-	fmt.Println("deposit 100")
+func (a *App) withdraw(args []string) error {
+	if a.currentCustomer == nil {
+		return ErrNoActiveCustomer
+	}
+
+	if len(args) < 2 {
+		return ErrInvalidArgs
+	}
+
+	amount, err := strconv.ParseFloat(args[1], 32)
+	if err != nil {
+		return &InvalidValueError{Field: "amount", Value: args[1]}
+	}
+
+	return a.currentCustomer.Withdraw(args[0], float32(amount))
+}
+
+// accrueInterest accrues interest on the portfolios of every customer up to now. Interest paid is committed
+// before the command is performed, so the command failing does not take it back.
+func (a *App) accrueInterest() error {
+	a.events.Hold()
+	asOf := a.now()
+	for _, c := range a.customers {
+		c.AccrueInterest(asOf)
+	}
+	var err error
+	if len(a.ledger.entries) > a.committedEntries {
+		err = a.commitChanges()
+	}
+	a.releaseEvents(err)
+	return err
+}
+
+// releaseEvents delivers the events held back while performing an operation once it succeeds, or drops them
+func (a *App) releaseEvents(err error) {
+	if err == nil {
+		a.events.Release()
+	} else {
+		a.events.Discard()
+	}
+}
+
+func printDepositSummary(credits []DepositCredit) {
+	names := []string{}
+	gross, fees, net, currencies := map[string]float32{}, map[string]float32{}, map[string]float32{}, map[string]string{}
+	for _, cr := range credits {
+		if _, ok := gross[cr.Portfolio]; !ok {
+			names = append(names, cr.Portfolio)
+		}
+		gross[cr.Portfolio] += cr.Gross
+		fees[cr.Portfolio] += cr.Fee
+		net[cr.Portfolio] += cr.Net
+		currencies[cr.Portfolio] = cr.Currency
+	}
+	for _, name := range names {
+		fmt.Printf("%s: gross %.2f, fees %.2f, net %.2f %s\n", name, gross[name], fees[name], net[name], currencies[name])
+	}
+}
+
+func (a *App) printStatement() error {
+	if a.currentCustomer == nil {
+		return ErrNoActiveCustomer
+	}
+
+	for _, e := range a.ledger.Entries(a.currentCustomer.ID) {
+		fmt.Println(e)
+	}
+	if len(a.currentCustomer.received) > 0 {
+		fmt.Println("Deposits received:")
+		for _, r := range a.currentCustomer.received {
+			fmt.Println(r)
+		}
+	}
+	return nil
+}
+
+func (a *App) printBalances(args []string) error {
+	flags, _, err := cli.ParseFlags(args)
+	if err != nil {
+		return err
+	}
+	if flags["as-of"] == "" {
+		return &MissingArgumentError{Name: "as-of date"}
+	}
+	asOf, err := parseTime(flags["as-of"], true)
+	if err != nil {
+		return err
+	}
+
+	balances := a.BalancesAsOf(asOf)
+	if flags["customer"] != "" {
+		a.actedOn = flags["customer"]
+		c := a.findCustomer(flags["customer"])
+		if c == nil {
+			return ErrCustomerNotFound
+		}
+		balances = c.BalancesAsOf(asOf)
+	}
+	for _, b := range balances {
+		fmt.Printf("%s %s %.2f %s\n", b.Customer, b.Portfolio, b.Balance, b.Currency)
+	}
+	return nil
+}
+
+// setTarget sets the target allocation of the current customer from portfolio and percentage pairs, clearing
+// it when none are given
+func (a *App) setTarget(args []string) error {
+	if a.currentCustomer == nil {
+		return ErrNoActiveCustomer
+	}
+	if len(args)%2 != 0 {
+		return ErrInvalidArgs
+	}
+
+	targets := map[string]float64{}
+	for i := 0; i < len(args); i += 2 {
+		percent, err := strconv.ParseFloat(args[i+1], 64)
+		if err != nil {
+			return ErrInvalidTargetPercentage
+		}
+		targets[args[i]] += percent
+	}
+	return a.currentCustomer.SetTargetAllocation(targets)
+}
+
+// setGoal sets the amount a portfolio of the current customer saves towards and the month it is due, given as
+// 2028-12 or as a date in that month
+func (a *App) setGoal(args []string) error {
+	if a.currentCustomer == nil {
+		return ErrNoActiveCustomer
+	}
+	if len(args) != 3 {
+		return ErrInvalidArgs
+	}
+	amount, err := strconv.ParseFloat(args[1], 32)
+	if err != nil {
+		return ErrInvalidGoalAmount
+	}
+	date, err := time.ParseInLocation("2006-01", args[2], time.Local)
+	if err != nil {
+		if date, err = parseTime(args[2], false); err != nil {
+			return err
+		}
+	}
+	return a.currentCustomer.SetGoal(args[0], float32(amount), date)
+}
+
+func (a *App) removeGoal(args []string) error {
+	if a.currentCustomer == nil {
+		return ErrNoActiveCustomer
+	}
+	if len(args) != 1 {
+		return ErrInvalidArgs
+	}
+	return a.currentCustomer.RemoveGoal(args[0])
+}
+
+// printGoals prints the progress of the current customer towards each goal, suggesting a monthly plan for the
+// goals monthly plans do not keep on track
+func (a *App) printGoals() error {
+	if a.currentCustomer == nil {
+		return ErrNoActiveCustomer
+	}
+	progress, err := a.currentCustomer.GoalProgress()
+	if err != nil {
+		return err
+	}
+	if len(progress) == 0 {
+		fmt.Println("No goals set")
+		return nil
+	}
+	for _, gp := range progress {
+		fmt.Printf("%s: %.2f of %.2f %s (%.2f%%) by %s, %d months left\n",
+			gp.Portfolio, gp.Balance, gp.Goal.Amount, gp.Currency, gp.Progress, gp.Goal.Date.Format("2006-01"), gp.MonthsLeft)
+		fmt.Printf("  monthly plans: %.2f, required per month: %.2f\n", gp.Monthly, gp.Required)
+		switch {
+		case gp.OnTrack:
+			fmt.Println("  on track")
+		case gp.MonthsLeft == 0:
+			fmt.Printf("  suggestion: deposit %.2f this month\n", gp.Required)
+		case gp.Monthly == 0:
+			fmt.Printf("  suggestion: addMonthlyPlan %q %q %.2f\n", gp.Portfolio+" goal", gp.Portfolio, gp.Required)
+		default:
+			fmt.Printf("  suggestion: increase the monthly plans into %s by %.2f to %.2f\n", gp.Portfolio, gp.Shortfall(), gp.Required)
+		}
+	}
+	return nil
+}
+
+// proposeRebalance prints the transfers that bring the current customer back to the target allocation and keeps
+// them until they are confirmed
+func (a *App) proposeRebalance(args []string) error {
+	if a.currentCustomer == nil {
+		return ErrNoActiveCustomer
+	}
+	flags, _, err := cli.ParseFlags(args)
+	if err != nil {
+		return err
+	}
+	tolerance := DefaultRebalanceTolerance
+	if flags["tolerance"] != "" {
+		if tolerance, err = strconv.ParseFloat(flags["tolerance"], 64); err != nil {
+			return ErrInvalidTolerance
+		}
+	}
+
+	transfers, err := a.currentCustomer.PlanRebalance(tolerance)
+	if err != nil {
+		return err
+	}
+	a.rebalance = nil
+	if len(transfers) == 0 {
+		fmt.Printf("Portfolios are within %.2f%% of the target allocation\n", tolerance)
+		return nil
+	}
+	a.rebalance = &pendingRebalance{customer: a.currentCustomer, tolerance: tolerance, transfers: transfers}
+	fmt.Println("Proposed transfers:")
+	for _, t := range transfers {
+		fmt.Println(" ", t)
+	}
+	fmt.Println("Type \"applyrebalance\" to make them")
+	return nil
+}
+
+// applyRebalance makes the transfers last proposed for the current customer, provided the balances have not
+// changed since
+func (a *App) applyRebalance() error {
+	if a.currentCustomer == nil {
+		return ErrNoActiveCustomer
+	}
+	pending := a.rebalance
+	if pending == nil || pending.customer != a.currentCustomer {
+		return ErrNoRebalance
+	}
+	a.rebalance = nil
+
+	transfers, err := a.currentCustomer.PlanRebalance(pending.tolerance)
+	if err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(transfers, pending.transfers) {
+		return ErrBalancesChanged
+	}
+	return a.currentCustomer.Rebalance(transfers)
+}
+
+// addTargetPlan adds a one-time plan to the session splitting the amount towards the portfolios furthest below
+// their target
+func (a *App) addTargetPlan(args []string) error {
+	if a.currentCustomer == nil {
+		return ErrNoActiveCustomer
+	}
+	if a.currentCustomer.DepositSession == nil {
+		return ErrNoActiveSession
+	}
+	if len(args) != 2 {
+		return ErrInvalidArgs
+	}
+	amount, err := strconv.ParseFloat(args[1], 32)
+	if err != nil {
+		return &InvalidValueError{Field: "amount", Value: args[1]}
+	}
+
+	split, err := a.currentCustomer.SteerDeposit(float32(amount))
+	if err != nil {
+		return err
+	}
+	dp, err := newBaseDepositPlan(args[0], "one-time", "", split)
+	if err != nil {
+		return err
+	}
+	return a.currentCustomer.PayDepositPlan(dp)
+}
+
+// printSummary prints the total balance of the current customer, the allocation across portfolios against the
+// target and the contributions paid by plan type, from the start of the account unless given a period
+func (a *App) printSummary(args []string) error {
+	if a.currentCustomer == nil {
+		return ErrNoActiveCustomer
+	}
+	flags, _, err := cli.ParseFlags(args)
+	if err != nil {
+		return err
+	}
+	var from time.Time
+	to := a.now()
+	if flags["from"] != "" {
+		if from, err = parseTime(flags["from"], false); err != nil {
+			return err
+		}
+	}
+	if flags["to"] != "" {
+		if to, err = parseTime(flags["to"], true); err != nil {
+			return err
+		}
+	}
+
+	s, err := a.currentCustomer.Summary(from, to)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Total: %.2f %s\n", s.Total, s.Currency)
+	for _, l := range s.Portfolios {
+		line := fmt.Sprintf("%s: %.2f %s (%.2f%%)", l.Portfolio, l.Balance, s.Currency, l.Share)
+		if s.HasTarget {
+			line += fmt.Sprintf(" target %.2f%%, drift %+.2f%%", l.Target, l.Drift)
+		}
+		fmt.Println(line)
+	}
+	period := "to " + s.To.Format("2006-01-02")
+	if !s.From.IsZero() {
+		period = s.From.Format("2006-01-02") + " " + period
+	}
+	fmt.Println("Contributions", period+":")
+	for _, t := range s.PlanTypes() {
+		fmt.Printf("  %s: %.2f %s\n", t, s.Contributions[t], s.Currency)
+	}
+	return nil
+}
+
+func (a *App) printSessionStatus() error {
+	if a.currentCustomer == nil {
+		return ErrNoActiveCustomer
+	}
+	session := a.currentCustomer.DepositSession
+	if session == nil {
+		return ErrNoActiveSession
+	}
+
+	fmt.Println("Currency:", currencyOrDefault(session.currency))
+	for _, dp := range session.depositPlans {
+		fmt.Printf("Plan %s (%s): %.2f %s\n", dp.Name(), dp.PlanType(), dp.DepositTotal(), currencyOrDefault(dp.Currency()))
+	}
+	for _, r := range session.received {
+		fmt.Println("Deposit", r)
+	}
+	for _, f := range session.flags {
+		fmt.Println("Flagged:", f)
+	}
+	return nil
+}
+
+// findDeposit prints the deposits of every customer whose reference contains the query
+func (a *App) findDeposit(args []string) error {
+	if len(args) < 1 {
+		return &MissingArgumentError{Name: "deposit reference"}
+	}
+
+	found := false
+	for _, c := range a.customers {
+		for _, r := range c.SearchDeposits(args[0]) {
+			fmt.Println(c.ID+":", r)
+			found = true
+		}
+	}
+	if !found {
+		return ErrDepositNotFound
+	}
+	return nil
+}
+
+func (a *App) projectInterest(args []string) error {
+	if a.currentCustomer == nil {
+		return ErrNoActiveCustomer
+	}
+	if len(args) < 2 {
+		return ErrInvalidArgs
+	}
+
+	months, err := strconv.Atoi(args[1])
+	if err != nil {
+		return ErrInvalidMonths
+	}
+	balances, err := a.currentCustomer.ProjectInterest(args[0], a.now(), months)
+	if err != nil {
+		return err
+	}
+
+	start := a.currentCustomer.findPortfolio(args[0]).Balance
+	for i, b := range balances {
+		fmt.Printf("Month %d: %.2f\n", i+1, b)
+	}
+	fmt.Printf("Projected interest: %.2f\n", balances[len(balances)-1]-start)
+	return nil
+}
+
+func (a *App) now() time.Time {
+	if a.clock != nil {
+		return a.clock()
+	}
+	return now()
+}
+
+// keepsPendingApproval are the commands that leave the command pending approval in place
+var keepsPendingApproval = map[string]bool{"approve": true, "login": true, "logout": true, "help": true}
+
+// approve performs the command last rejected by a soft limit, lifting only the limit it broke. Limits are checked
+// again, so the command is rejected if it now breaks the limit by more or breaks another one.
+func (a *App) approve() error {
+	pending := a.pendingApproval
+	if pending == nil {
+		return ErrNoPendingApproval
+	}
+	if pending.customer != a.currentCustomer {
+		return ErrApprovalForOther
+	}
+
+	a.pendingApproval = nil
+	pending.customer.approvedLimit = pending.limit
+	defer func() { pending.customer.approvedLimit = nil }()
+
+	fmt.Println("Approving for customer", pending.customer.ID+":", pending.command.Command, strings.Join(maskArgs(pending.command.Command, pending.command.Args), " "))
+	fmt.Println("Limit:", pending.limit)
+	_, err := a.executeCommand(pending.command)
+	if lerr, ok := err.(*LimitError); ok && lerr.Rule.Soft {
+		a.pendingApproval = &pendingApproval{customer: pending.customer, command: pending.command, limit: lerr}
+	}
+	return err
+}
+
+// reconcile matches the credits of the bank statement file to the deposits received
+func (a *App) reconcile(args []string) error {
+	if len(args) < 1 {
+		return &MissingArgumentError{Name: "statement file"}
+	}
+	lines, err := LoadStatement(args[0])
+	if err != nil {
+		return err
+	}
+
+	a.reconciliation = Reconcile(lines, a.customers)
+	for _, m := range a.reconciliation.Matched {
+		a.markChanged(a.findCustomer(m.Customer))
+		a.events.Publish(DepositReconciled{Customer: m.Customer, Reference: m.Deposit.Reference, Time: a.now()})
+	}
+	a.printReconciliation()
+	return nil
+}
+
+// matchDeposit manually matches a statement line left unmatched by the last reconciliation to a deposit
+func (a *App) matchDeposit(args []string) error {
+	if a.reconciliation == nil {
+		return ErrNoReconciliation
+	}
+	if len(args) < 3 {
+		return ErrInvalidArgs
+	}
+	a.actedOn = args[1]
+	line, err := strconv.Atoi(args[0])
+	if err != nil {
+		return &InvalidValueError{Field: "statement line", Value: args[0]}
+	}
+	if err := a.reconciliation.Match(line, args[1], args[2]); err != nil {
+		return err
+	}
+	a.markChanged(a.findCustomer(args[1]))
+	a.events.Publish(DepositReconciled{Customer: args[1], Reference: args[2], Time: a.now()})
+	return nil
+}
+
+func (a *App) printReconciliation() {
+	r := a.reconciliation
+	fmt.Println("Matched:", len(r.Matched))
+	for _, m := range r.Matched {
+		manual := ""
+		if m.Manual {
+			manual = " (manual)"
+		}
+		fmt.Printf("  %s -> %s %s%s\n", m.Line, m.Customer, m.Deposit.Reference, manual)
+	}
+	fmt.Println("Unmatched in bank:", len(r.UnmatchedBank))
+	for i, l := range r.UnmatchedBank {
+		fmt.Printf("  %d %s\n", i+1, l)
+	}
+	fmt.Println("Unmatched in system:", len(r.UnmatchedSystem))
+	for _, d := range r.UnmatchedSystem {
+		fmt.Printf("  %s: %s\n", d.Customer, d.Deposit)
+	}
+}
+
+// ingest credits the incoming payments of the file to the customers they match
+func (a *App) ingest(args []string) error {
+	if len(args) < 1 {
+		return &MissingArgumentError{Name: "payments file"}
+	}
+	payments, err := LoadIncomingPayments(args[0])
+	if err != nil {
+		return err
+	}
+
+	for _, res := range a.IngestPayments(payments) {
+		switch {
+		case res.Err != nil:
+			fmt.Println("Queued:", res.Payment, "-", res.Err)
+		case res.Review != nil:
+			fmt.Println("Held for review:", res.Payment, "->", res.Customer, strings.Join(res.Plans, ", "), "review", res.Review.ID)
+		default:
+			fmt.Println("Credited:", res.Payment, "->", res.Customer, strings.Join(res.Plans, ", "))
+		}
+	}
+	return nil
+}
+
+func (a *App) addWebhook(args []string) error {
+	if a.webhooks == nil {
+		return &NotConfiguredError{Feature: "webhooks"}
+	}
+	flags, args, err := cli.ParseFlags(args)
+	if err != nil {
+		return err
+	}
+	if len(args) < 2 {
+		return ErrInvalidArgs
+	}
+
+	var events []string
+	if flags["events"] != "" {
+		events = strings.Split(flags["events"], ",")
+	}
+	w, err := a.webhooks.AddWebhook(args[0], events, args[1])
+	if err != nil {
+		return err
+	}
+	fmt.Println("Webhook added:", w.ID)
+	return nil
+}
+
+func (a *App) removeWebhook(args []string) error {
+	if a.webhooks == nil {
+		return &NotConfiguredError{Feature: "webhooks"}
+	}
+	if len(args) < 1 {
+		return ErrInvalidArgs
+	}
+	return a.webhooks.RemoveWebhook(args[0])
+}
+
+func (a *App) printWebhooks() error {
+	if a.webhooks == nil {
+		return &NotConfiguredError{Feature: "webhooks"}
+	}
+	for _, w := range a.webhooks.Webhooks() {
+		events := "all events"
+		if len(w.Events) > 0 {
+			events = strings.Join(w.Events, ",")
+		}
+		fmt.Println(w.ID, w.URL, events)
+	}
+	fmt.Println("Queued deliveries:", len(a.webhooks.Queue()))
+	for _, dl := range a.webhooks.DeadLetters() {
+		fmt.Printf("Dead letter %s: %s %s after %d attempts (%s)\n", dl.ID, dl.Webhook, dl.Event, dl.Attempts, dl.LastError)
+	}
+	return nil
+}
+
+func (a *App) deliverWebhooks() error {
+	if a.webhooks == nil {
+		return &NotConfiguredError{Feature: "webhooks"}
+	}
+	delivered, err := a.webhooks.DeliverDue()
+	fmt.Println("Delivered:", delivered)
+	return err
+}
+
+func (a *App) retryWebhook(args []string) error {
+	if a.webhooks == nil {
+		return &NotConfiguredError{Feature: "webhooks"}
+	}
+	if len(args) < 1 {
+		return ErrInvalidArgs
+	}
+	return a.webhooks.Retry(args[0])
+}
+
+// Rebuild reconstructs the customers from the events stored up to asOf, or all of them when asOf is zero,
+// starting from the latest snapshot taken by then. Rebuilding every event takes a new snapshot once enough
+// events have been replayed past the latest one.
+func (a *App) Rebuild(asOf time.Time) ([]*Customer, error) {
+	if a.eventStore == nil {
+		return nil, &NotConfiguredError{Feature: "event store"}
+	}
+	records, err := a.eventStore.Records()
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshot *Snapshot
+	if a.snapshots != nil {
+		if snapshot, err = a.snapshots.Latest(asOf); err != nil {
+			return nil, err
+		}
+	}
+	customers, rebuilt, err := Rebuild(records, snapshot, asOf)
+	if err != nil {
+		return nil, err
+	}
+
+	replayed := rebuilt.LastEventID
+	if snapshot != nil {
+		replayed -= snapshot.LastEventID
+	}
+	if asOf.IsZero() && a.snapshots != nil && replayed >= snapshotInterval {
+		if err := a.snapshots.Save(rebuilt); err != nil {
+			return nil, err
+		}
+	}
+	return customers, nil
+}
+
+// VerifyRebuild rebuilds the customers from every stored event, returning the differences from the live state
+func (a *App) VerifyRebuild() ([]string, error) {
+	rebuilt, err := a.Rebuild(time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	return CompareCustomers(a.customers, rebuilt), nil
+}
+
+func (a *App) rebuild(args []string) error {
+	flags, _, err := cli.ParseFlags(args)
+	if err != nil {
+		return err
+	}
+
+	if flags["as-of"] != "" {
+		asOf, err := parseTime(flags["as-of"], true)
+		if err != nil {
+			return err
+		}
+		customers, err := a.Rebuild(asOf)
+		if err != nil {
+			return err
+		}
+		for _, c := range customers {
+			fmt.Println("Customer:", c.ID)
+			c.PrintPortfolio()
+		}
+		return nil
+	}
+
+	diffs, err := a.VerifyRebuild()
+	if err != nil {
+		return err
+	}
+	for _, d := range diffs {
+		fmt.Println(d)
+	}
+	if len(diffs) > 0 {
+		return ErrRebuildMismatch
+	}
+	fmt.Println("Rebuilt state matches live state")
+	return nil
+}
+
+func (a *App) login(args []string) error {
+	if len(args) < 2 {
+		return ErrInvalidArgs
+	}
+	if !a.operators.Enabled() {
+		return &NotConfiguredError{Feature: "operators"}
+	}
+
+	token, err := a.operators.Login(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	a.logout()
+	a.session, _ = a.operators.Authenticate(token)
+	a.sessionToken = token
+	return nil
+}
+
+func (a *App) logout() {
+	if a.sessionToken != "" {
+		a.operators.Logout(a.sessionToken)
+	}
+	a.session, a.sessionToken = nil, ""
+}
+
+func (a *App) addOperator(args []string) error {
+	if len(args) < 3 {
+		return ErrInvalidArgs
+	}
+	if a.operators == nil {
+		a.operators = NewOperatorStore("")
+	}
+	return a.operators.AddOperator(args[0], Role(args[1]), args[2])
+}
+
+// authorize checks the logged in operator is permitted to perform the command
+func (a *App) authorize(command cli.Command) error {
+	if !a.operators.Enabled() {
+		return nil
+	}
+
+	perm, ok := requiredPermission(command)
+	if !ok {
+		return ErrPermissionDenied
+	}
+	if perm == permNone {
+		return nil
+	}
+	if a.session == nil {
+		return ErrNotLoggedIn
+	}
+	if !a.session.Role.can(perm) {
+		return ErrPermissionDenied
+	}
+	return nil
+}
+
+// requiredPermission returns the permission the command needs, and false for commands missing from
+// commandPermissions, which no operator may perform
+func requiredPermission(command cli.Command) (permission, bool) {
+	perm, ok := commandPermissions[command.Command]
+	if !ok {
+		return permNone, false
+	}
+	if command.Command == "withdraw" && len(command.Args) >= 2 {
+		amount, err := strconv.ParseFloat(command.Args[1], 32)
+		if err == nil && float32(amount) > largeWithdrawalThreshold {
+			return permLargeWithdraw, true
+		}
+	}
+	return perm, true
+}
+
+func (a *App) operatorID() string {
+	if a.session != nil {
+		return a.session.ID
+	}
+	return a.operator
+}
+
+func (a *App) audit(command cli.Command, err error) error {
+	if a.auditLog == nil {
+		return nil
+	}
+
+	entry := AuditEntry{
+		Operator: a.operatorID(),
+		Command:  command.Command,
+		Args:     maskArgs(command.Command, command.Args),
+		Outcome:  "success",
+	}
+	if a.actedOn != "" {
+		entry.Customer = a.actedOn
+	} else if a.currentCustomer != nil {
+		entry.Customer = a.currentCustomer.ID
+	}
+	if err != nil {
+		entry.Outcome = "failure"
+		entry.Error = err.Error()
+		entry.Code = ErrorCode(err)
+	}
+	return a.auditLog.Record(entry)
+}
+
+func (a *App) printAudit(args []string) error {
+	if a.auditLog == nil {
+		return &NotConfiguredError{Feature: "audit log"}
+	}
+
+	flags, _, err := cli.ParseFlags(args)
+	if err != nil {
+		return err
+	}
+
+	filter := AuditFilter{Customer: flags["customer"]}
+	if v, ok := flags["from"]; ok {
+		if filter.From, err = parseTime(v, false); err != nil {
+			return err
+		}
+	}
+	if v, ok := flags["to"]; ok {
+		if filter.To, err = parseTime(v, true); err != nil {
+			return err
+		}
+	}
+
+	entries, err := a.auditLog.Query(filter)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		fmt.Println(e)
+	}
+	return nil
+}
+
+// parseTime accepts either a RFC3339 timestamp or a date. Dates are taken as the
+// start of the day, or the end of the day when endOfDay is set.
+func parseTime(value string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, &InvalidValueError{Field: "time", Value: value}
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+	return t, nil
+}
+
+func printHelp() {
+	fmt.Println("Sample flow:")
+	fmt.Println("login supervisor1 password")
+	fmt.Println("newcustomer test1")
+	fmt.Println("selectcustomer test1")
+	fmt.Println("addportfolio Retirement retirement")
+	fmt.Println("addportfolio \"High Risk\"")
+	fmt.Println("addportfolio \"US Equities\" --currency USD")
+	fmt.Println("updatecustomer --name \"Test One\" --email test1@example.com --phone +6591234567 --dob 1990-01-31 --kyc verified")
+	fmt.Println("showcustomer")
+	fmt.Println("startDeposit")
+	fmt.Println("addOneTimePlan \"One Time Plan 1\" \"High Risk\" 10000 Retirement 500")
+	fmt.Println("addMonthlyPlan \"Monthly Plan 1\" Retirement 100")
+	fmt.Println("addTargetPlan \"Top Up\" 1000")
+	fmt.Println("deposit 10500")
+	fmt.Println("deposit 100 --source bank-transfer --ref TX1001 --payer \"Test One\" --received 2020-01-31 --status pending")
+	fmt.Println("sessionStatus")
+	fmt.Println("startDeposit USD")
+	fmt.Println("addOneTimePlan \"US Plan\" --currency USD \"US Equities\" 200")
+	fmt.Println("deposit 200 USD")
+	fmt.Println("endDeposit")
+	fmt.Println("finddeposit TX1001")
+	fmt.Println("cleardeposit TX1001")
+	fmt.Println("returndeposit TX1001")
+	fmt.Println("statement")
 	fmt.Println("endDeposit")
 	fmt.Println("printPortfolios")
+	fmt.Println("balances --as-of 2020-12-31 [--customer test1]")
+	fmt.Println("settarget Retirement 60 \"High Risk\" 40")
+	fmt.Println("summary [--from 2020-01-01] [--to 2020-12-31]")
+	fmt.Println("rebalance [--tolerance 5]")
+	fmt.Println("applyrebalance")
+	fmt.Println("setgoal House 50000 2028-12")
+	fmt.Println("removegoal House")
+	fmt.Println("goals")
+	fmt.Println("projectInterest Retirement 12")
+	fmt.Println("withdraw Retirement 50")
+	fmt.Println("approve")
+	fmt.Println("reviews")
+	fmt.Println("approvereview 1")
+	fmt.Println("rejectreview 1")
+	fmt.Println("renameportfolio \"High Risk\" Growth")
+	fmt.Println("mergeportfolio Growth Retirement")
+	fmt.Println("closeportfolio Retirement [target portfolio]")
+	fmt.Println("reconcile statement.csv")
+	fmt.Println("matchdeposit 1 test1 TX1001")
+	fmt.Println("ingest payments.csv")
+	fmt.Println("payments")
+	fmt.Println("addwebhook https://example.com/hook secret --events SessionCommitted,DepositReceived")
+	fmt.Println("removewebhook wh1")
+	fmt.Println("webhooks")
+	fmt.Println("deliverwebhooks")
+	fmt.Println("retrywebhook 1")
+	fmt.Println("rebuild [--as-of 2020-12-31]")
+	fmt.Println("audit --customer test1 --from 2020-01-01 --to 2020-12-31")
+	fmt.Println("addoperator teller1 teller password")
+	fmt.Println("logout")
 }
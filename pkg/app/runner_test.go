@@ -2,6 +2,7 @@ package app
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -9,7 +10,7 @@ import (
 func TestNewApp_shouldReturnAppWithoutAnyCustomers(t *testing.T) {
 	testNewApp := func() {
 		res := NewApp()
-		assert.Equal(t, App{customers: []*Customer{}}, res)
+		assert.Equal(t, App{customers: []*Customer{}, ledger: NewLedger()}, res)
 	}
 
 	testNewApp()
@@ -29,11 +30,12 @@ func TestProcessInput_shouldProcessInput(t *testing.T) {
 
 	testProcessInput("newcustomer", app.createNewCustomer([]string{}))
 	testProcessInput("addportfolio", app.addPortfolio([]string{}))
-	testProcessInput("startDeposit", app.startDeposit())
+	testProcessInput("startDeposit", app.startDeposit([]string{}))
 	testProcessInput("addOneTimePlan", app.addPlan("one-time", []string{}))
 	testProcessInput("addMonthlyPlan", app.addPlan("monthly", []string{}))
 	testProcessInput("deposit", app.deposit([]string{}))
-	testProcessInput("endDeposit", app.endDeposit())
+	_, err := app.endDeposit()
+	testProcessInput("endDeposit", err)
 }
 
 func TestProcessInput_shouldReturnError_givenInvalidCommand(t *testing.T) {
@@ -64,6 +66,7 @@ func TestCliCreateNewCustomer_shouldCreateNewCustomer(t *testing.T) {
 		err := app.createNewCustomer(args)
 		customer, err := NewCustomer(args[0])
 		assert.NoError(t, err)
+		customer.ledger = app.ledger
 		assert.Equal(t, []*Customer{&customer}, app.customers)
 		assert.Equal(t, &customer, app.currentCustomer)
 	}
@@ -84,6 +87,20 @@ func TestCliCreateNewCustomer_shouldReturnError_givenNoArgs(t *testing.T) {
 	testCreateNewCustomer([]string{})
 }
 
+func TestCliCreateNewCustomer_shouldReturnError_givenExistingID(t *testing.T) {
+	app := NewApp()
+	assert.NoError(t, app.createNewCustomer([]string{"test"}))
+	assert.NoError(t, app.currentCustomer.AddPortfolio("Retirement"))
+	assert.NoError(t, app.createNewCustomer([]string{"test2"}))
+
+	err := app.createNewCustomer([]string{"test"})
+	assert.Equal(t, ErrCustomerExists, err)
+	assert.Equal(t, "CUSTOMER_EXISTS", ErrorCode(err))
+	assert.Len(t, app.customers, 2)
+	assert.Len(t, app.customers[0].portfolios, 1)
+	assert.Equal(t, "test2", app.currentCustomer.ID)
+}
+
 func TestCliAddPortfolio_shouldAddPortfolioToCustomer(t *testing.T) {
 	testAddPortfolio := func(args []string) {
 		app := NewApp()
@@ -124,10 +141,10 @@ func TestStartDeposit_shouldStartASession(t *testing.T) {
 	testStartDeposit := func() {
 		app := NewApp()
 		app.createNewCustomer([]string{"test"})
-		err := app.startDeposit()
+		err := app.startDeposit([]string{})
 		assert.NoError(t, err)
 		assert.NotNil(t, app.currentCustomer.DepositSession)
-		assert.Equal(t, &DepositSession{[]DepositPlan{}, []float32{}}, app.currentCustomer.DepositSession)
+		assert.Equal(t, &DepositSession{depositPlans: []DepositPlan{}, deposits: []float32{}}, app.currentCustomer.DepositSession)
 	}
 
 	testStartDeposit()
@@ -136,10 +153,470 @@ func TestStartDeposit_shouldStartASession(t *testing.T) {
 func TestStartDeposit_shouldThrowError_givenNoActiveCustomer(t *testing.T) {
 	testStartDeposit := func() {
 		app := NewApp()
-		err := app.startDeposit()
+		err := app.startDeposit([]string{})
 		assert.Error(t, err)
 		assert.Equal(t, "no active customer", err.Error())
 	}
 
 	testStartDeposit()
 }
+
+func TestPerformCommand_shouldRecordAuditEntry(t *testing.T) {
+	now := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	app := NewApp()
+	app.SetOperator("op")
+	app.SetAuditLog(newTestAuditLog(t, 1<<20, 1, now))
+
+	app.processInput("newcustomer test")
+	app.processInput("endDeposit")
+
+	entries, err := app.auditLog.Query(AuditFilter{})
+	assert.NoError(t, err)
+	assert.Equal(t, []AuditEntry{
+		{Timestamp: now, Operator: "op", Customer: "test", Command: "newcustomer", Args: []string{"test"}, Outcome: "success"},
+		{Timestamp: now, Operator: "op", Customer: "test", Command: "endDeposit", Args: []string{}, Outcome: "failure", Error: "no active session", Code: "NO_ACTIVE_SESSION"},
+	}, entries)
+}
+
+func TestAudit_shouldRecordCustomerActedOn(t *testing.T) {
+	now := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	app := NewApp()
+	app.SetScreener(&ScreeningRules{Threshold: 1000})
+	app.SetAuditLog(newTestAuditLog(t, 1<<20, 1, now))
+	for _, input := range []string{
+		"newcustomer test1",
+		"addportfolio Retirement",
+		"startDeposit",
+		"addOneTimePlan plan Retirement 1500",
+		"deposit 1500",
+		"endDeposit",
+		"newcustomer test2",
+		"approvereview 1",
+		"balances --as-of 2020-01-31 --customer test1",
+		"showcustomer test1",
+		"printPortfolios",
+	} {
+		_, err := app.processInput(input)
+		assert.NoError(t, err, input)
+	}
+
+	entries, err := app.auditLog.Query(AuditFilter{Customer: "test1"})
+	assert.NoError(t, err)
+	var commands []string
+	for _, e := range entries {
+		commands = append(commands, e.Command)
+	}
+	assert.Equal(t, []string{"newcustomer", "addportfolio", "startDeposit", "addOneTimePlan", "deposit", "endDeposit", "approvereview", "balances", "showcustomer"}, commands)
+}
+
+func TestSetClock_shouldStampLedgerAndEvents(t *testing.T) {
+	setNow(t, time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC))
+	clock := time.Date(2020, 1, 15, 12, 0, 0, 0, time.UTC)
+	app := NewApp()
+	app.SetClock(func() time.Time { return clock })
+	bus := NewEventBus()
+	sub := &recordingSubscriber{}
+	bus.Subscribe(sub)
+	app.SetEventBus(bus)
+	for _, input := range []string{
+		"newcustomer test",
+		"addportfolio Retirement",
+		"startDeposit",
+		"addOneTimePlan plan Retirement 100",
+		"deposit 100",
+		"endDeposit",
+		"withdraw Retirement 30",
+	} {
+		_, err := app.processInput(input)
+		assert.NoError(t, err, input)
+	}
+
+	for _, e := range app.ledger.Entries("test") {
+		assert.Equal(t, clock, e.Time)
+	}
+	for _, e := range sub.events {
+		assert.Equal(t, clock, e.OccurredAt(), e.Type())
+	}
+	assert.Equal(t, []PortfolioBalance{{Customer: "test", Portfolio: "Retirement", Balance: 70, Currency: "SGD"}}, app.currentCustomer.BalancesAsOf(clock))
+	s, err := app.currentCustomer.Summary(time.Time{}, clock)
+	assert.NoError(t, err)
+	assert.Equal(t, float32(100), s.Contributions["one-time"])
+}
+
+func TestParseTime_shouldParseDatesAndTimestamps(t *testing.T) {
+	testParseTime := func(value string, endOfDay bool, expected time.Time) {
+		res, err := parseTime(value, endOfDay)
+		assert.NoError(t, err)
+		assert.True(t, expected.Equal(res))
+	}
+
+	testParseTime("2020-01-02T03:04:05Z", false, time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC))
+	testParseTime("2020-01-02", false, time.Date(2020, 1, 2, 0, 0, 0, 0, time.Local))
+	testParseTime("2020-01-02", true, time.Date(2020, 1, 3, 0, 0, 0, 0, time.Local).Add(-time.Nanosecond))
+
+	_, err := parseTime("yesterday", false)
+	assert.Error(t, err)
+	assert.Equal(t, "invalid time: yesterday", err.Error())
+}
+
+func newTestAuthApp(t *testing.T) App {
+	app := NewApp()
+	operators := NewOperatorStore("")
+	assert.NoError(t, operators.AddOperator("super", RoleSupervisor, "pw"))
+	assert.NoError(t, operators.AddOperator("teller", RoleTeller, "pw"))
+	assert.NoError(t, operators.AddOperator("auditor", RoleAuditor, "pw"))
+	app.SetOperatorStore(operators)
+	return app
+}
+
+func TestAuthorize_shouldEnforceRolePermissions(t *testing.T) {
+	app := newTestAuthApp(t)
+	testProcessInput := func(command string, expectedErr string) {
+		_, err := app.processInput(command)
+		if expectedErr == "" {
+			assert.NoError(t, err, command)
+		} else {
+			assert.Error(t, err, command)
+			assert.Equal(t, expectedErr, err.Error(), command)
+		}
+	}
+
+	testProcessInput("newcustomer test", "not logged in")
+	testProcessInput("login super wrong", "invalid operator id or password")
+	testProcessInput("login super pw", "")
+	testProcessInput("newcustomer test", "")
+	testProcessInput("addportfolio Retirement", "")
+	testProcessInput("login teller pw", "")
+	testProcessInput("newcustomer test2", "permission denied")
+	testProcessInput("startDeposit", "")
+	testProcessInput("addOneTimePlan plan Retirement 20000", "")
+	testProcessInput("deposit 20000", "")
+	testProcessInput("endDeposit", "")
+	testProcessInput("withdraw Retirement 100", "permission denied")
+	testProcessInput("returndeposit test-1", "permission denied")
+	testProcessInput("reconcile statement.csv", "permission denied")
+	testProcessInput("login auditor pw", "")
+	testProcessInput("printPortfolios", "")
+	testProcessInput("withdraw Retirement 100", "permission denied")
+	testProcessInput("reconcile statement.csv", "permission denied")
+	testProcessInput("matchdeposit 1 test test-1", "permission denied")
+	testProcessInput("logout", "")
+	testProcessInput("printPortfolios", "not logged in")
+	testProcessInput("login super pw", "")
+	testProcessInput("withdraw Retirement 15000", "")
+	assert.Equal(t, float32(5000), app.currentCustomer.portfolios[0].Balance)
+	testProcessInput("unlisted", "permission denied")
+}
+
+func TestAuthorize_shouldNotAccrueInterest_givenCommandDenied(t *testing.T) {
+	opened := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	setNow(t, opened)
+	app := newTestAuthApp(t)
+	app.SetProductCatalogue(DefaultProductCatalogue())
+	for _, input := range []string{"login super pw", "newcustomer test1", "addportfolio Savings savings", "login teller pw"} {
+		_, err := app.processInput(input)
+		assert.NoError(t, err, input)
+	}
+
+	setNow(t, opened.AddDate(0, 0, 10))
+	_, err := app.processInput("newcustomer test2")
+	assert.Equal(t, ErrPermissionDenied, err)
+	assert.Equal(t, opened, app.currentCustomer.portfolios[0].accruedThrough)
+
+	_, err = app.processInput("printPortfolios")
+	assert.NoError(t, err)
+	assert.Equal(t, opened.AddDate(0, 0, 10), app.currentCustomer.portfolios[0].accruedThrough)
+}
+
+func TestExecute_shouldPerformCommandAsTokenOperator(t *testing.T) {
+	app := newTestAuthApp(t)
+	token, err := app.operators.Login("auditor", "pw")
+	assert.NoError(t, err)
+
+	err = app.Execute(token, "newcustomer test")
+	assert.Error(t, err)
+	assert.Equal(t, "permission denied", err.Error())
+	assert.Nil(t, app.session)
+
+	err = app.Execute("bad-token", "newcustomer test")
+	assert.Error(t, err)
+	assert.Equal(t, "invalid token", err.Error())
+
+	token, _ = app.operators.Login("super", "pw")
+	assert.NoError(t, app.Execute(token, "newcustomer test"))
+	assert.Equal(t, "test", app.currentCustomer.ID)
+}
+
+func TestAudit_shouldRecordLoggedInOperatorAndMaskPassword(t *testing.T) {
+	now := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	app := newTestAuthApp(t)
+	app.SetOperator("host")
+	app.SetAuditLog(newTestAuditLog(t, 1<<20, 1, now))
+
+	app.processInput("login super pw")
+	app.processInput("addoperator t2 teller pw2")
+
+	entries, err := app.auditLog.Query(AuditFilter{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"super", maskedArg}, entries[0].Args)
+	assert.Equal(t, "super", entries[0].Operator)
+	assert.Equal(t, []string{"t2", "teller", maskedArg}, entries[1].Args)
+}
+
+func TestCliUpdateCustomer_shouldUpdateCurrentCustomer(t *testing.T) {
+	app := NewApp()
+	app.createNewCustomer([]string{"test"})
+
+	err := app.updateCustomer([]string{"--name", "Test One", "--status", "frozen"})
+	assert.NoError(t, err)
+	assert.Equal(t, "Test One", app.currentCustomer.Name)
+
+	err = app.startDeposit([]string{})
+	assert.Error(t, err)
+	assert.Equal(t, "customer account is frozen", err.Error())
+}
+
+func TestCliShowCustomer_shouldReturnError_givenUnknownCustomer(t *testing.T) {
+	app := NewApp()
+	err := app.showCustomer([]string{})
+	assert.Error(t, err)
+	assert.Equal(t, "no active customer", err.Error())
+
+	err = app.showCustomer([]string{"missing"})
+	assert.Error(t, err)
+	assert.Equal(t, "customer not found", err.Error())
+}
+
+func TestCliSelectCustomer_shouldSelectExistingCustomer(t *testing.T) {
+	app := NewApp()
+	app.createNewCustomer([]string{"test1"})
+	app.createNewCustomer([]string{"test2"})
+
+	assert.NoError(t, app.selectCustomer([]string{"test1"}))
+	assert.Equal(t, "test1", app.currentCustomer.ID)
+	assert.Equal(t, ErrInvalidArgs, app.selectCustomer([]string{}))
+	assert.Equal(t, ErrCustomerNotFound, app.selectCustomer([]string{"missing"}))
+	assert.Equal(t, "test1", app.currentCustomer.ID)
+}
+
+func TestCliAddPortfolio_shouldAddProductPortfolio_givenProductType(t *testing.T) {
+	app := NewApp()
+	app.SetProductCatalogue(ProductCatalogue{"savings": {Type: "savings", MinimumBalance: 100}})
+	app.createNewCustomer([]string{"test"})
+
+	err := app.addPortfolio([]string{"Rainy Day", "savings"})
+	assert.NoError(t, err)
+	assert.Equal(t, "savings", app.currentCustomer.portfolios[0].Product.Type)
+
+	err = app.addPortfolio([]string{"Retirement", "retirement"})
+	assert.Error(t, err)
+	assert.Equal(t, "unknown product type: retirement", err.Error())
+}
+
+func TestCliEndDeposit_shouldDepositInSessionCurrency(t *testing.T) {
+	app := NewApp()
+	app.SetRateProvider(StaticRateProvider{"USD/SGD": 1.5})
+	for _, input := range []string{
+		"newcustomer test",
+		"addportfolio Retirement",
+		"addportfolio \"US Equities\" --currency USD",
+		"startDeposit USD",
+		"addOneTimePlan \"Plan A\" --currency USD \"US Equities\" 100 Retirement 100",
+		"deposit 200 USD",
+		"endDeposit",
+	} {
+		_, err := app.processInput(input)
+		assert.NoError(t, err, input)
+	}
+
+	assert.Equal(t, []*Portfolio{{Name: "Retirement", Balance: 150}, {Name: "US Equities", Balance: 100, Currency: "USD"}}, app.currentCustomer.portfolios)
+}
+
+func TestApprove_shouldPerformCommandRejectedBySoftLimit(t *testing.T) {
+	app := newTestAuthApp(t)
+	app.SetProductCatalogue(DefaultProductCatalogue())
+	app.SetLimits(&Limits{Rules: []LimitRule{{Operation: OperationDeposit, Scope: ScopeProduct, ProductType: "savings", Period: PeriodTransaction, Amount: 500, Soft: true}}})
+	testProcessInput := func(command string, expectedErr string) {
+		_, err := app.processInput(command)
+		if expectedErr == "" {
+			assert.NoError(t, err, command)
+		} else {
+			assert.Error(t, err, command)
+			assert.Equal(t, expectedErr, err.Error(), command)
+		}
+	}
+
+	testProcessInput("login super pw", "")
+	testProcessInput("approve", "no command pending approval")
+	testProcessInput("newcustomer test", "")
+	testProcessInput("addportfolio Savings savings", "")
+	testProcessInput("login teller pw", "")
+	testProcessInput("startDeposit", "")
+	testProcessInput("addOneTimePlan plan Savings 600", "")
+	testProcessInput("deposit 600", "")
+	testProcessInput("endDeposit", "LIMIT_DEPOSIT_TRANSACTION_PRODUCT_SOFT: savings transaction deposit limit of 500.00 exceeded (600.00), supervisor approval required")
+	testProcessInput("approve", "permission denied")
+	testProcessInput("login super pw", "")
+	testProcessInput("approve", "")
+	assert.Equal(t, float32(600), app.currentCustomer.portfolios[0].Balance)
+	assert.Nil(t, app.currentCustomer.approvedLimit)
+	testProcessInput("approve", "no command pending approval")
+	testProcessInput("withdraw Savings 100", "")
+}
+
+func TestApprove_shouldDropPendingApproval_givenAnotherCommand(t *testing.T) {
+	app := NewApp()
+	app.SetLimits(&Limits{Rules: []LimitRule{{Operation: OperationWithdrawal, Scope: ScopeCustomer, Period: PeriodDaily, Amount: 500, Soft: true}}})
+	for _, input := range []string{
+		"newcustomer test",
+		"addportfolio Retirement",
+		"startDeposit",
+		"addOneTimePlan plan Retirement 1000",
+		"deposit 1000",
+		"endDeposit",
+	} {
+		_, err := app.processInput(input)
+		assert.NoError(t, err, input)
+	}
+
+	_, err := app.processInput("withdraw Retirement 600")
+	assert.IsType(t, &LimitError{}, err)
+	_, err = app.processInput("printPortfolios")
+	assert.NoError(t, err)
+	_, err = app.processInput("approve")
+	assert.EqualError(t, err, "no command pending approval")
+
+	_, err = app.processInput("withdraw Retirement 600")
+	assert.IsType(t, &LimitError{}, err)
+	app.currentCustomer.ledger.Record(LedgerEntry{Time: now(), Customer: "test", Portfolio: "Retirement", Type: EntryWithdrawal, Amount: -100})
+	_, err = app.processInput("approve")
+	assert.EqualError(t, err, "LIMIT_WITHDRAWAL_DAILY_CUSTOMER_SOFT: customer daily withdrawal limit of 500.00 exceeded (700.00), supervisor approval required")
+	assert.Equal(t, float32(1000), app.currentCustomer.portfolios[0].Balance)
+	assert.NotNil(t, app.pendingApproval)
+}
+
+func TestCliEndDeposit_shouldHoldFlaggedSessionForReview(t *testing.T) {
+	app := NewApp()
+	app.SetScreener(&ScreeningRules{Threshold: 1000})
+	app.processInput("newcustomer test")
+	app.processInput("addportfolio Retirement")
+	for i := 0; i < 2; i++ {
+		app.processInput("startDeposit")
+		app.processInput("addOneTimePlan plan Retirement 1500")
+		app.processInput("deposit 1500")
+		_, err := app.processInput("endDeposit")
+		assert.NoError(t, err)
+		assert.Nil(t, app.currentCustomer.DepositSession)
+	}
+	assert.Equal(t, float32(0), app.currentCustomer.portfolios[0].Balance)
+	assert.Equal(t, 2, len(app.reviews.Pending()))
+
+	_, err := app.processInput("approvereview 1")
+	assert.NoError(t, err)
+	_, err = app.processInput("rejectreview 2")
+	assert.NoError(t, err)
+	_, err = app.processInput("approvereview 2")
+	assert.Error(t, err)
+	assert.Equal(t, "review already rejected", err.Error())
+	assert.Equal(t, float32(1500), app.currentCustomer.portfolios[0].Balance)
+	assert.Empty(t, app.reviews.Pending())
+}
+
+func TestCliRejectReview_shouldReturnDepositsOfSession(t *testing.T) {
+	app := NewApp()
+	app.SetScreener(&ScreeningRules{Threshold: 1000})
+	for _, input := range []string{
+		"newcustomer test",
+		"addportfolio Retirement",
+		"startDeposit",
+		"addOneTimePlan plan Retirement 1500",
+		"deposit 1000 --ref TX1",
+		"deposit 500 --ref TX2 --status pending",
+		"endDeposit",
+		"rejectreview 1",
+	} {
+		_, err := app.processInput(input)
+		assert.NoError(t, err, input)
+	}
+
+	deposits := app.currentCustomer.SearchDeposits("TX")
+	if assert.Len(t, deposits, 2) {
+		assert.Equal(t, DepositReturned, deposits[0].Status)
+		assert.Equal(t, DepositReturned, deposits[1].Status)
+	}
+	_, err := app.processInput("cleardeposit TX2")
+	assert.EqualError(t, err, "deposit already returned")
+	assert.Equal(t, float32(0), app.currentCustomer.portfolios[0].Balance)
+}
+
+func TestCliClearDeposit_shouldReleasePendingDeposit(t *testing.T) {
+	app := NewApp()
+	app.processInput("newcustomer test")
+	app.processInput("addportfolio Retirement")
+	app.processInput("startDeposit")
+	app.processInput("addOneTimePlan plan Retirement 100")
+	_, err := app.processInput("deposit 100 --ref TX1 --status pending")
+	assert.NoError(t, err)
+	_, err = app.processInput("endDeposit")
+	assert.NoError(t, err)
+
+	_, err = app.processInput("withdraw Retirement 50")
+	assert.Error(t, err)
+	assert.Equal(t, "withdrawal amount more than cleared balance", err.Error())
+	_, err = app.processInput("cleardeposit TX1")
+	assert.NoError(t, err)
+	_, err = app.processInput("withdraw Retirement 50")
+	assert.NoError(t, err)
+	_, err = app.processInput("returndeposit TX1")
+	assert.Error(t, err)
+	assert.Equal(t, "deposit already cleared", err.Error())
+}
+
+func TestCliDeposit_shouldRecordDepositDetails(t *testing.T) {
+	app := NewApp()
+	app.processInput("newcustomer test")
+	app.processInput("startDeposit")
+	_, err := app.processInput("deposit 100 --source cheque --ref CHQ1 --payer \"Test One\" --received 2020-01-31")
+	assert.NoError(t, err)
+
+	r := app.currentCustomer.received[0]
+	assert.Equal(t, DepositDetails{Source: SourceCheque, Reference: "CHQ1", Payer: "Test One", ReceivedAt: time.Date(2020, 1, 31, 0, 0, 0, 0, time.Local)}, r.DepositDetails)
+	_, err = app.processInput("finddeposit chq")
+	assert.NoError(t, err)
+	_, err = app.processInput("finddeposit TX")
+	assert.Error(t, err)
+	assert.Equal(t, "deposit not found", err.Error())
+	_, err = app.processInput("deposit 100 --received yesterday")
+	assert.Error(t, err)
+}
+
+func TestCliReconcile_shouldReconcileStatementFile(t *testing.T) {
+	app := NewApp()
+	app.processInput("newcustomer test")
+	app.processInput("startDeposit")
+	app.processInput("deposit 100 --source bank-transfer --ref TX1 --status pending")
+	app.processInput("deposit 50 --source bank-transfer --ref TX2 --status pending")
+	path := writeTestStatement(t, "statement.csv", "date,amount,currency,reference,description\n2020-01-31,100,SGD,TX1,\n2020-01-31,50,SGD,UNKNOWN,\n")
+
+	_, err := app.processInput("matchdeposit 1 test TX2")
+	assert.Error(t, err)
+	assert.Equal(t, "no reconciliation in progress", err.Error())
+	_, err = app.processInput("reconcile " + path)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(app.reconciliation.Matched))
+	_, err = app.processInput("matchdeposit 1 test TX2")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(app.reconciliation.Matched))
+}
+
+func TestCliIngest_shouldCreditPaymentsFile(t *testing.T) {
+	app := newTestIngestApp(t)
+	path := writeTestStatement(t, "payments.csv", "date,amount,currency,reference,payer\n2020-01-31,100,SGD,c1,\n2020-01-31,5,SGD,c9,\n")
+
+	_, err := app.processInput("ingest " + path)
+	assert.NoError(t, err)
+	assert.Equal(t, float32(100), app.findCustomer("c1").portfolios[0].Balance)
+	assert.Equal(t, 1, len(app.QueuedPayments()))
+	_, err = app.processInput("payments")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,278 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Screening stages
+const (
+	ScreenDeposit = "deposit"
+	ScreenSession = "session"
+)
+
+// Screening is the deposit activity of a session handed to a screener. Amounts are in the default currency.
+// At the deposit stage Amount is the deposit being made and Deposits includes it, while at the session stage
+// Amount is zero and Deposits are all the deposits made before the session ends.
+type Screening struct {
+	Customer string
+	Stage    string
+	Amount   float32
+	Deposits []float32
+}
+
+// Screener inspects deposit activity for suspicious patterns, returning the reasons the activity is flagged
+type Screener interface {
+	Screen(s Screening) []string
+}
+
+// ScreeningRules flags deposits at or above a reporting threshold, and structuring where several deposits
+// in a session fall just under it. StructuringMargin is the fraction below the threshold counted as just under.
+type ScreeningRules struct {
+	Threshold         float32 `json:"threshold"`
+	StructuringMargin float32 `json:"structuringMargin"`
+	StructuringCount  int     `json:"structuringCount"`
+}
+
+// LoadScreeningRules reads JSON screening rules from path
+func LoadScreeningRules(path string) (*ScreeningRules, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &ScreeningRules{}
+	if err := json.Unmarshal(data, r); err != nil {
+		return nil, err
+	}
+	if err := r.validate(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *ScreeningRules) validate() error {
+	if r.Threshold <= 0 {
+		return &ConfigError{Message: "screening threshold must be positive"}
+	}
+	if r.StructuringMargin < 0 || r.StructuringMargin >= 1 {
+		return &ConfigError{Message: "invalid structuring margin"}
+	}
+	if r.StructuringCount < 0 {
+		return &ConfigError{Message: "structuring count is negative"}
+	}
+	return nil
+}
+
+// Screen flags single deposits at or above the threshold, sessions totalling at or above it and sessions
+// with StructuringCount or more deposits just under it
+func (r *ScreeningRules) Screen(s Screening) []string {
+	reasons := []string{}
+	if s.Stage == ScreenDeposit && s.Amount >= r.Threshold {
+		reasons = append(reasons, fmt.Sprintf("deposit of %.2f at or above threshold of %.2f", s.Amount, r.Threshold))
+	}
+
+	var total float32
+	var underThreshold int
+	for _, d := range s.Deposits {
+		total += d
+		if d < r.Threshold && d >= r.Threshold*(1-r.StructuringMargin) {
+			underThreshold++
+		}
+	}
+	if r.StructuringCount > 0 && underThreshold >= r.StructuringCount {
+		reasons = append(reasons, fmt.Sprintf("structuring: %d or more deposits just under threshold of %.2f", r.StructuringCount, r.Threshold))
+	}
+	if s.Stage == ScreenSession && len(s.Deposits) > 1 && total >= r.Threshold {
+		reasons = append(reasons, fmt.Sprintf("session total of %.2f at or above threshold of %.2f", total, r.Threshold))
+	}
+	return reasons
+}
+
+// Flags returns the reasons the session has been flagged for review
+func (s *DepositSession) Flags() []string {
+	return s.flags
+}
+
+func (s *DepositSession) flag(reasons []string) {
+	for _, r := range reasons {
+		found := false
+		for _, f := range s.flags {
+			if f == r {
+				found = true
+				break
+			}
+		}
+		if !found {
+			s.flags = append(s.flags, r)
+		}
+	}
+}
+
+// screen hands the session deposits, in the default currency, to the customer screener and flags the
+// session with the reasons given
+func (c *Customer) screen(stage string, amount float32, deposits []float32) error {
+	if c.screener == nil {
+		return nil
+	}
+
+	s := Screening{Customer: c.ID, Stage: stage, Deposits: []float32{}}
+	currency := c.DepositSession.currency
+	var err error
+	if s.Amount, err = convert(c.fx, amount, currency, DefaultCurrency); err != nil {
+		return err
+	}
+	for _, d := range deposits {
+		converted, err := convert(c.fx, d, currency, DefaultCurrency)
+		if err != nil {
+			return err
+		}
+		s.Deposits = append(s.Deposits, converted)
+	}
+	c.DepositSession.flag(c.screener.Screen(s))
+	return nil
+}
+
+// ScreenSession screens the session as a whole before it ends, returning every reason it has been flagged
+func (c *Customer) ScreenSession() ([]string, error) {
+	if c.DepositSession == nil {
+		return nil, ErrNoActiveSession
+	}
+	if err := c.screen(ScreenSession, 0, c.DepositSession.deposits); err != nil {
+		return nil, err
+	}
+	return c.DepositSession.Flags(), nil
+}
+
+// ReviewStatus is the outcome of the review of a held session
+type ReviewStatus string
+
+// Review statuses
+const (
+	ReviewPending  ReviewStatus = "pending"
+	ReviewApproved ReviewStatus = "approved"
+	ReviewRejected ReviewStatus = "rejected"
+)
+
+// Review is a flagged deposit session held until it is approved or rejected
+type Review struct {
+	ID         string
+	Customer   string
+	Session    *DepositSession
+	Reasons    []string
+	HeldAt     time.Time
+	Status     ReviewStatus
+	ReviewedBy string
+}
+
+// ReviewQueue keeps the sessions held for review. The zero value is an empty queue.
+type ReviewQueue struct {
+	reviews []*Review
+}
+
+// Hold adds the session to the queue pending review
+func (q *ReviewQueue) Hold(customer string, session *DepositSession, reasons []string, at time.Time) *Review {
+	r := &Review{
+		ID:       strconv.Itoa(len(q.reviews) + 1),
+		Customer: customer,
+		Session:  session,
+		Reasons:  reasons,
+		HeldAt:   at,
+		Status:   ReviewPending,
+	}
+	q.reviews = append(q.reviews, r)
+	return r
+}
+
+// Pending returns the reviews not yet approved or rejected, oldest first
+func (q *ReviewQueue) Pending() []*Review {
+	pending := []*Review{}
+	for _, r := range q.reviews {
+		if r.Status == ReviewPending {
+			pending = append(pending, r)
+		}
+	}
+	return pending
+}
+
+// Get returns the pending review with the id
+func (q *ReviewQueue) Get(id string) (*Review, error) {
+	for _, r := range q.reviews {
+		if r.ID != id {
+			continue
+		}
+		if r.Status != ReviewPending {
+			return nil, &AlreadySettledError{Item: "review", Status: string(r.Status)}
+		}
+		return r, nil
+	}
+	return nil, ErrReviewNotFound
+}
+
+// String formats the review for display
+func (r *Review) String() string {
+	var total float32
+	for _, d := range r.Session.deposits {
+		total += d
+	}
+	return fmt.Sprintf("%s %s %s %.2f %s: %s", r.ID, r.HeldAt.Format("2006-01-02 15:04:05"), r.Customer, total, currencyOrDefault(r.Session.currency), strings.Join(r.Reasons, "; "))
+}
+
+// ReviewSnapshot is the state of a review in a snapshot. The deposits of its session are given by reference.
+type ReviewSnapshot struct {
+	ID         string          `json:"id"`
+	Customer   string          `json:"customer"`
+	Reasons    []string        `json:"reasons"`
+	HeldAt     time.Time       `json:"heldAt"`
+	Status     ReviewStatus    `json:"status"`
+	ReviewedBy string          `json:"reviewedBy,omitempty"`
+	Session    SessionSnapshot `json:"session"`
+}
+
+// SessionSnapshot is a deposit session held for review in a snapshot
+type SessionSnapshot struct {
+	Currency string         `json:"currency,omitempty"`
+	Plans    []PlanSnapshot `json:"plans"`
+	Flags    []string       `json:"flags,omitempty"`
+	Deposits []string       `json:"deposits"`
+}
+
+func snapshotReview(r *Review) ReviewSnapshot {
+	rs := ReviewSnapshot{ID: r.ID, Customer: r.Customer, Reasons: r.Reasons, HeldAt: r.HeldAt, Status: r.Status, ReviewedBy: r.ReviewedBy}
+	rs.Session = SessionSnapshot{Currency: r.Session.currency, Plans: []PlanSnapshot{}, Flags: r.Session.flags, Deposits: []string{}}
+	for _, dp := range r.Session.depositPlans {
+		rs.Session.Plans = append(rs.Session.Plans, snapshotPlan(dp))
+	}
+	for _, d := range r.Session.received {
+		rs.Session.Deposits = append(rs.Session.Deposits, d.Reference)
+	}
+	return rs
+}
+
+// restore returns the review with its session holding the deposits received from the customer
+func (rs ReviewSnapshot) restore(customers []*Customer) (*Review, error) {
+	c := findCustomer(customers, rs.Customer)
+	if c == nil {
+		return nil, fmt.Errorf("review %s: %w: %s", rs.ID, ErrCustomerNotFound, rs.Customer)
+	}
+	session := &DepositSession{depositPlans: []DepositPlan{}, deposits: []float32{}, currency: rs.Session.Currency, flags: rs.Session.Flags}
+	for _, ps := range rs.Session.Plans {
+		session.depositPlans = append(session.depositPlans, ps.restore())
+	}
+	for _, reference := range rs.Session.Deposits {
+		d := c.findDeposit(reference)
+		if d == nil {
+			return nil, fmt.Errorf("review %s: %w: %s", rs.ID, ErrDepositNotFound, reference)
+		}
+		if d.settlement == nil {
+			d.session = session
+		}
+		session.deposits = append(session.deposits, d.Amount)
+		session.received = append(session.received, d)
+	}
+	return &Review{ID: rs.ID, Customer: rs.Customer, Session: session, Reasons: rs.Reasons, HeldAt: rs.HeldAt, Status: rs.Status, ReviewedBy: rs.ReviewedBy}, nil
+}
@@ -0,0 +1,76 @@
+package app
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadScreeningRules_shouldReturnError_givenInvalidRules(t *testing.T) {
+	testLoad := func(content string, expectedErr string) {
+		path := filepath.Join(t.TempDir(), "screening.json")
+		ioutil.WriteFile(path, []byte(content), 0600)
+		res, err := LoadScreeningRules(path)
+		assert.Error(t, err)
+		assert.Equal(t, expectedErr, err.Error())
+		assert.Nil(t, res)
+	}
+
+	testLoad(`{"threshold":0}`, "screening threshold must be positive")
+	testLoad(`{"threshold":10000,"structuringMargin":1}`, "invalid structuring margin")
+	testLoad(`{"threshold":10000,"structuringCount":-1}`, "structuring count is negative")
+}
+
+func TestScreeningRulesScreen_shouldFlagThresholdAndStructuring(t *testing.T) {
+	rules := &ScreeningRules{Threshold: 10000, StructuringMargin: 0.1, StructuringCount: 3}
+	testScreen := func(s Screening, expected []string) {
+		assert.Equal(t, expected, rules.Screen(s))
+	}
+
+	testScreen(Screening{Stage: ScreenDeposit, Amount: 9999, Deposits: []float32{9999}}, []string{})
+	testScreen(Screening{Stage: ScreenDeposit, Amount: 10000, Deposits: []float32{10000}}, []string{"deposit of 10000.00 at or above threshold of 10000.00"})
+	testScreen(Screening{Stage: ScreenDeposit, Amount: 9500, Deposits: []float32{9500, 9000, 8000, 9500}}, []string{"structuring: 3 or more deposits just under threshold of 10000.00"})
+	testScreen(Screening{Stage: ScreenSession, Deposits: []float32{6000, 5000}}, []string{"session total of 11000.00 at or above threshold of 10000.00"})
+	testScreen(Screening{Stage: ScreenSession, Deposits: []float32{6000}}, []string{})
+}
+
+func TestDeposit_shouldFlagSession_givenScreenerFlagsDeposit(t *testing.T) {
+	c := Customer{ID: "c1", screener: &ScreeningRules{Threshold: 1000, StructuringMargin: 0.1, StructuringCount: 2}}
+	c.StartSession()
+
+	assert.NoError(t, c.Deposit(950))
+	assert.Empty(t, c.DepositSession.Flags())
+	assert.NoError(t, c.Deposit(990))
+	assert.NoError(t, c.Deposit(900))
+	assert.Equal(t, []string{"structuring: 2 or more deposits just under threshold of 1000.00"}, c.DepositSession.Flags())
+	assert.Equal(t, []float32{950, 990, 900}, c.DepositSession.deposits)
+
+	flags, err := c.ScreenSession()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"structuring: 2 or more deposits just under threshold of 1000.00",
+		"session total of 2840.00 at or above threshold of 1000.00",
+	}, flags)
+}
+
+func TestReviewQueue_shouldKeepPendingReviews(t *testing.T) {
+	q := ReviewQueue{}
+	tm := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	r1 := q.Hold("c1", &DepositSession{deposits: []float32{100, 200}}, []string{"a", "b"}, tm)
+	r2 := q.Hold("c2", &DepositSession{deposits: []float32{50}, currency: "USD"}, []string{"c"}, tm)
+	assert.Equal(t, "1 2020-01-01 10:00:00 c1 300.00 SGD: a; b", r1.String())
+	assert.Equal(t, []*Review{r1, r2}, q.Pending())
+
+	r2.Status = ReviewRejected
+	assert.Equal(t, []*Review{r1}, q.Pending())
+	_, err := q.Get("2")
+	assert.Equal(t, "review already rejected", err.Error())
+	_, err = q.Get("3")
+	assert.Equal(t, "review not found", err.Error())
+	res, err := q.Get("1")
+	assert.NoError(t, err)
+	assert.Equal(t, r1, res)
+}
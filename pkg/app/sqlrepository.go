@@ -0,0 +1,560 @@
+package app
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	// registers the pure Go sqlite driver, which builds without cgo
+	_ "modernc.org/sqlite"
+)
+
+// sqlMigrations are the changes to the database schema, applied in order. Applied migrations must never be
+// changed, only followed by new ones.
+var sqlMigrations = []string{
+	`CREATE TABLE customers (
+		id            TEXT PRIMARY KEY,
+		name          TEXT NOT NULL,
+		email         TEXT NOT NULL,
+		phone         TEXT NOT NULL,
+		date_of_birth INTEGER,
+		kyc_status    TEXT NOT NULL,
+		status        TEXT NOT NULL
+	);
+	CREATE TABLE portfolios (
+		customer_id          TEXT NOT NULL REFERENCES customers (id),
+		position             INTEGER NOT NULL,
+		name                 TEXT NOT NULL,
+		balance              REAL NOT NULL,
+		closed               INTEGER NOT NULL,
+		product              TEXT NOT NULL,
+		opened_at            INTEGER,
+		currency             TEXT NOT NULL,
+		uncleared            REAL NOT NULL,
+		contribution_year    INTEGER NOT NULL,
+		year_contributions   REAL NOT NULL,
+		accrued_through      INTEGER,
+		accrued_interest     REAL NOT NULL,
+		capitalised_interest REAL NOT NULL,
+		PRIMARY KEY (customer_id, position)
+	);
+	CREATE TABLE ledger_entries (
+		id                INTEGER PRIMARY KEY AUTOINCREMENT,
+		time              INTEGER NOT NULL,
+		customer_id       TEXT NOT NULL,
+		portfolio         TEXT NOT NULL,
+		type              TEXT NOT NULL,
+		amount            REAL NOT NULL,
+		currency          TEXT NOT NULL,
+		original_amount   REAL NOT NULL,
+		original_currency TEXT NOT NULL,
+		reference         TEXT NOT NULL
+	);`,
+	`CREATE TABLE portfolio_renames (
+		id          INTEGER PRIMARY KEY AUTOINCREMENT,
+		customer_id TEXT NOT NULL,
+		from_name   TEXT NOT NULL,
+		to_name     TEXT NOT NULL,
+		seq         INTEGER NOT NULL
+	);`,
+	`CREATE INDEX ledger_entries_customer_time ON ledger_entries (customer_id, time);
+	CREATE INDEX ledger_entries_time ON ledger_entries (time);`,
+	`ALTER TABLE ledger_entries ADD COLUMN plan_type TEXT NOT NULL DEFAULT '';
+	CREATE TABLE target_allocations (
+		customer_id TEXT NOT NULL REFERENCES customers (id),
+		portfolio   TEXT NOT NULL,
+		percent     REAL NOT NULL,
+		PRIMARY KEY (customer_id, portfolio)
+	);`,
+	`CREATE TABLE goals (
+		customer_id TEXT NOT NULL REFERENCES customers (id),
+		portfolio   TEXT NOT NULL,
+		amount      REAL NOT NULL,
+		date        INTEGER NOT NULL,
+		PRIMARY KEY (customer_id, portfolio)
+	);`,
+	`CREATE TABLE deposits (
+		customer_id TEXT NOT NULL REFERENCES customers (id),
+		position    INTEGER NOT NULL,
+		source      TEXT NOT NULL,
+		reference   TEXT NOT NULL,
+		payer       TEXT NOT NULL,
+		received_at INTEGER,
+		amount      REAL NOT NULL,
+		currency    TEXT NOT NULL,
+		status      TEXT NOT NULL,
+		reconciled  INTEGER NOT NULL,
+		settled     INTEGER NOT NULL,
+		credited    TEXT NOT NULL,
+		PRIMARY KEY (customer_id, position)
+	);
+	CREATE TABLE deposit_plans (
+		customer_id TEXT NOT NULL REFERENCES customers (id),
+		position    INTEGER NOT NULL,
+		name        TEXT NOT NULL,
+		plan_type   TEXT NOT NULL,
+		currency    TEXT NOT NULL,
+		portfolios  TEXT NOT NULL,
+		PRIMARY KEY (customer_id, position)
+	);
+	CREATE TABLE reviews (
+		id          TEXT PRIMARY KEY,
+		customer_id TEXT NOT NULL REFERENCES customers (id),
+		reasons     TEXT NOT NULL,
+		held_at     INTEGER,
+		status      TEXT NOT NULL,
+		reviewed_by TEXT NOT NULL,
+		session     TEXT NOT NULL
+	);`,
+}
+
+// SQLRepository is a repository keeping everything in a SQLite database file
+type SQLRepository struct {
+	db *sql.DB
+}
+
+// OpenSQLRepository opens the database at path, creating it when missing, and migrates its schema to the latest
+func OpenSQLRepository(path string) (*SQLRepository, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+
+	r := &SQLRepository{db: db}
+	if err := r.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Close closes the database
+func (r *SQLRepository) Close() error {
+	return r.db.Close()
+}
+
+// SchemaVersion returns the number of migrations applied to the database
+func (r *SQLRepository) SchemaVersion() (int, error) {
+	var version int
+	err := r.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	return version, err
+}
+
+func (r *SQLRepository) migrate() error {
+	if _, err := r.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`); err != nil {
+		return err
+	}
+	version, err := r.SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	for i := version; i < len(sqlMigrations); i++ {
+		tx, err := r.db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(sqlMigrations[i]); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version) VALUES (?)`, i+1); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Load returns the customers and the ledger saved in the database
+func (r *SQLRepository) Load() ([]*Customer, *Ledger, error) {
+	customers, err := r.loadCustomers()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ledger := NewLedger()
+	if ledger.entries, err = r.queryEntries(`SELECT time, customer_id, portfolio, type, amount, currency, original_amount, original_currency, reference, plan_type FROM ledger_entries ORDER BY id`); err != nil {
+		return nil, nil, err
+	}
+
+	rows, err := r.db.Query(`SELECT customer_id, from_name, to_name, seq FROM portfolio_renames ORDER BY id`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var rn ledgerRename
+		if err := rows.Scan(&rn.customer, &rn.from, &rn.to, &rn.seq); err != nil {
+			return nil, nil, err
+		}
+		ledger.renames = append(ledger.renames, rn)
+	}
+	return customers, ledger, rows.Err()
+}
+
+func (r *SQLRepository) loadCustomers() ([]*Customer, error) {
+	rows, err := r.db.Query(`SELECT id, name, email, phone, date_of_birth, kyc_status, status FROM customers ORDER BY rowid`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snapshots := []CustomerSnapshot{}
+	for rows.Next() {
+		var cs CustomerSnapshot
+		var dob sql.NullInt64
+		if err := rows.Scan(&cs.ID, &cs.Name, &cs.Email, &cs.Phone, &dob, &cs.KYCStatus, &cs.Status); err != nil {
+			return nil, err
+		}
+		cs.DateOfBirth = timeFromSQL(dob)
+		snapshots = append(snapshots, cs)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	customers := []*Customer{}
+	for _, cs := range snapshots {
+		if cs.Portfolios, err = r.loadPortfolios(cs.ID); err != nil {
+			return nil, err
+		}
+		if cs.Targets, err = r.loadTargets(cs.ID); err != nil {
+			return nil, err
+		}
+		if cs.Goals, err = r.loadGoals(cs.ID); err != nil {
+			return nil, err
+		}
+		if cs.Deposits, err = r.loadDeposits(cs.ID); err != nil {
+			return nil, err
+		}
+		if cs.Plans, err = r.loadPlans(cs.ID); err != nil {
+			return nil, err
+		}
+		customers = append(customers, cs.restore())
+	}
+	return customers, nil
+}
+
+func (r *SQLRepository) loadPortfolios(customer string) ([]PortfolioSnapshot, error) {
+	rows, err := r.db.Query(`SELECT name, balance, closed, product, opened_at, currency, uncleared, contribution_year,
+		year_contributions, accrued_through, accrued_interest, capitalised_interest
+		FROM portfolios WHERE customer_id = ? ORDER BY position`, customer)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	portfolios := []PortfolioSnapshot{}
+	for rows.Next() {
+		var ps PortfolioSnapshot
+		var product string
+		var openedAt, accruedThrough sql.NullInt64
+		if err := rows.Scan(&ps.Name, &ps.Balance, &ps.Closed, &product, &openedAt, &ps.Currency, &ps.Uncleared, &ps.ContributionYear,
+			&ps.YearContributions, &accruedThrough, &ps.AccruedInterest, &ps.CapitalisedInterest); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(product), &ps.Product); err != nil {
+			return nil, err
+		}
+		ps.OpenedAt, ps.AccruedThrough = timeFromSQL(openedAt), timeFromSQL(accruedThrough)
+		portfolios = append(portfolios, ps)
+	}
+	return portfolios, rows.Err()
+}
+
+func (r *SQLRepository) loadTargets(customer string) (map[string]float64, error) {
+	rows, err := r.db.Query(`SELECT portfolio, percent FROM target_allocations WHERE customer_id = ?`, customer)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var targets map[string]float64
+	for rows.Next() {
+		var name string
+		var percent float64
+		if err := rows.Scan(&name, &percent); err != nil {
+			return nil, err
+		}
+		if targets == nil {
+			targets = map[string]float64{}
+		}
+		targets[name] = percent
+	}
+	return targets, rows.Err()
+}
+
+func (r *SQLRepository) loadGoals(customer string) (map[string]Goal, error) {
+	rows, err := r.db.Query(`SELECT portfolio, amount, date FROM goals WHERE customer_id = ?`, customer)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var goals map[string]Goal
+	for rows.Next() {
+		var name string
+		var g Goal
+		var date int64
+		if err := rows.Scan(&name, &g.Amount, &date); err != nil {
+			return nil, err
+		}
+		g.Date = time.Unix(0, date).UTC()
+		if goals == nil {
+			goals = map[string]Goal{}
+		}
+		goals[name] = g
+	}
+	return goals, rows.Err()
+}
+
+func (r *SQLRepository) loadDeposits(customer string) ([]DepositSnapshot, error) {
+	rows, err := r.db.Query(`SELECT source, reference, payer, received_at, amount, currency, status, reconciled, settled, credited
+		FROM deposits WHERE customer_id = ? ORDER BY position`, customer)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deposits []DepositSnapshot
+	for rows.Next() {
+		var ds DepositSnapshot
+		var receivedAt sql.NullInt64
+		var credited string
+		if err := rows.Scan(&ds.Source, &ds.Reference, &ds.Payer, &receivedAt, &ds.Amount, &ds.Currency, &ds.Status, &ds.Reconciled, &ds.Settled, &credited); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(credited), &ds.Credited); err != nil {
+			return nil, err
+		}
+		ds.ReceivedAt = timeFromSQL(receivedAt)
+		deposits = append(deposits, ds)
+	}
+	return deposits, rows.Err()
+}
+
+func (r *SQLRepository) loadPlans(customer string) ([]PlanSnapshot, error) {
+	rows, err := r.db.Query(`SELECT name, plan_type, currency, portfolios FROM deposit_plans WHERE customer_id = ? ORDER BY position`, customer)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var plans []PlanSnapshot
+	for rows.Next() {
+		var ps PlanSnapshot
+		var portfolios string
+		if err := rows.Scan(&ps.Name, &ps.Type, &ps.Currency, &portfolios); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(portfolios), &ps.Portfolios); err != nil {
+			return nil, err
+		}
+		plans = append(plans, ps)
+	}
+	return plans, rows.Err()
+}
+
+// LoadReviews returns the reviews saved in the database
+func (r *SQLRepository) LoadReviews(customers []*Customer) ([]*Review, error) {
+	rows, err := r.db.Query(`SELECT id, customer_id, reasons, held_at, status, reviewed_by, session FROM reviews ORDER BY CAST(id AS INTEGER)`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snapshots := []ReviewSnapshot{}
+	for rows.Next() {
+		var rs ReviewSnapshot
+		var reasons, session string
+		var heldAt sql.NullInt64
+		if err := rows.Scan(&rs.ID, &rs.Customer, &reasons, &heldAt, &rs.Status, &rs.ReviewedBy, &session); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(reasons), &rs.Reasons); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(session), &rs.Session); err != nil {
+			return nil, err
+		}
+		rs.HeldAt = timeFromSQL(heldAt)
+		snapshots = append(snapshots, rs)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return restoreReviews(snapshots, customers)
+}
+
+// Commit saves the customers, replacing their portfolios, target allocations, goals, deposits, saved plans and
+// reviews, and appends the entries to the ledger in a single transaction
+func (r *SQLRepository) Commit(changes ChangeSet) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := commitChanges(tx, changes); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func commitChanges(tx *sql.Tx, changes ChangeSet) error {
+	for _, c := range changes.Customers {
+		cs := snapshotCustomer(c)
+		if _, err := tx.Exec(`INSERT INTO customers (id, name, email, phone, date_of_birth, kyc_status, status) VALUES (?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (id) DO UPDATE SET name = excluded.name, email = excluded.email, phone = excluded.phone,
+			date_of_birth = excluded.date_of_birth, kyc_status = excluded.kyc_status, status = excluded.status`,
+			cs.ID, cs.Name, cs.Email, cs.Phone, timeToSQL(cs.DateOfBirth), cs.KYCStatus, cs.Status); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM portfolios WHERE customer_id = ?`, cs.ID); err != nil {
+			return err
+		}
+		for i, ps := range cs.Portfolios {
+			product, err := json.Marshal(ps.Product)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.Exec(`INSERT INTO portfolios (customer_id, position, name, balance, closed, product, opened_at, currency, uncleared,
+				contribution_year, year_contributions, accrued_through, accrued_interest, capitalised_interest)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				cs.ID, i, ps.Name, ps.Balance, ps.Closed, string(product), timeToSQL(ps.OpenedAt), ps.Currency, ps.Uncleared,
+				ps.ContributionYear, ps.YearContributions, timeToSQL(ps.AccruedThrough), ps.AccruedInterest, ps.CapitalisedInterest); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Exec(`DELETE FROM target_allocations WHERE customer_id = ?`, cs.ID); err != nil {
+			return err
+		}
+		for name, percent := range cs.Targets {
+			if _, err := tx.Exec(`INSERT INTO target_allocations (customer_id, portfolio, percent) VALUES (?, ?, ?)`, cs.ID, name, percent); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Exec(`DELETE FROM goals WHERE customer_id = ?`, cs.ID); err != nil {
+			return err
+		}
+		for name, g := range cs.Goals {
+			if _, err := tx.Exec(`INSERT INTO goals (customer_id, portfolio, amount, date) VALUES (?, ?, ?, ?)`, cs.ID, name, g.Amount, g.Date.UnixNano()); err != nil {
+				return err
+			}
+		}
+		if err := commitDeposits(tx, cs); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM reviews WHERE customer_id = ?`, cs.ID); err != nil {
+			return err
+		}
+	}
+	for _, rv := range changes.Reviews {
+		rs := snapshotReview(rv)
+		reasons, err := json.Marshal(rs.Reasons)
+		if err != nil {
+			return err
+		}
+		session, err := json.Marshal(rs.Session)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO reviews (id, customer_id, reasons, held_at, status, reviewed_by, session) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			rs.ID, rs.Customer, string(reasons), timeToSQL(rs.HeldAt), rs.Status, rs.ReviewedBy, string(session)); err != nil {
+			return err
+		}
+	}
+
+	for _, e := range changes.Entries {
+		if _, err := tx.Exec(`INSERT INTO ledger_entries (time, customer_id, portfolio, type, amount, currency, original_amount, original_currency, reference, plan_type)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			e.Time.UnixNano(), e.Customer, e.Portfolio, e.Type, e.Amount, e.Currency, e.OriginalAmount, e.OriginalCurrency, e.Reference, e.PlanType); err != nil {
+			return err
+		}
+	}
+	for _, rn := range changes.renames {
+		if _, err := tx.Exec(`INSERT INTO portfolio_renames (customer_id, from_name, to_name, seq) VALUES (?, ?, ?, ?)`,
+			rn.customer, rn.from, rn.to, rn.seq); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commitDeposits replaces the deposits and saved plans of the customer
+func commitDeposits(tx *sql.Tx, cs CustomerSnapshot) error {
+	if _, err := tx.Exec(`DELETE FROM deposits WHERE customer_id = ?`, cs.ID); err != nil {
+		return err
+	}
+	for i, ds := range cs.Deposits {
+		credited, err := json.Marshal(ds.Credited)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO deposits (customer_id, position, source, reference, payer, received_at, amount, currency, status, reconciled, settled, credited)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			cs.ID, i, ds.Source, ds.Reference, ds.Payer, timeToSQL(ds.ReceivedAt), ds.Amount, ds.Currency, ds.Status, ds.Reconciled, ds.Settled, string(credited)); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(`DELETE FROM deposit_plans WHERE customer_id = ?`, cs.ID); err != nil {
+		return err
+	}
+	for i, ps := range cs.Plans {
+		portfolios, err := json.Marshal(ps.Portfolios)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO deposit_plans (customer_id, position, name, plan_type, currency, portfolios) VALUES (?, ?, ?, ?, ?, ?)`,
+			cs.ID, i, ps.Name, ps.Type, ps.Currency, string(portfolios)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CustomerEntries returns the ledger entries of the customer recorded between from and to, oldest first
+func (r *SQLRepository) CustomerEntries(customer string, from time.Time, to time.Time) ([]LedgerEntry, error) {
+	return r.queryEntries(`SELECT time, customer_id, portfolio, type, amount, currency, original_amount, original_currency, reference, plan_type
+		FROM ledger_entries WHERE customer_id = ? AND time BETWEEN ? AND ? ORDER BY id`, customer, from.UnixNano(), to.UnixNano())
+}
+
+func (r *SQLRepository) queryEntries(query string, args ...interface{}) ([]LedgerEntry, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []LedgerEntry{}
+	for rows.Next() {
+		var e LedgerEntry
+		var t int64
+		if err := rows.Scan(&t, &e.Customer, &e.Portfolio, &e.Type, &e.Amount, &e.Currency, &e.OriginalAmount, &e.OriginalCurrency, &e.Reference, &e.PlanType); err != nil {
+			return nil, err
+		}
+		e.Time = time.Unix(0, t)
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// timeToSQL stores times as nanoseconds since the epoch, so they sort and compare as numbers. Zero times are stored as null.
+func timeToSQL(t time.Time) sql.NullInt64 {
+	if t.IsZero() {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: t.UnixNano(), Valid: true}
+}
+
+func timeFromSQL(v sql.NullInt64) time.Time {
+	if !v.Valid {
+		return time.Time{}
+	}
+	return time.Unix(0, v.Int64)
+}
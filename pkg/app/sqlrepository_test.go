@@ -0,0 +1,106 @@
+package app
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSQLRepository(t *testing.T) (*SQLRepository, string) {
+	path := filepath.Join(t.TempDir(), "deposits.db")
+	repo, err := OpenSQLRepository(path)
+	assert.NoError(t, err)
+	t.Cleanup(func() { repo.Close() })
+	return repo, path
+}
+
+// failLedgerInserts makes the database reject ledger entries with the reference, to fail a commit midway
+func failLedgerInserts(t *testing.T, repo *SQLRepository, reference string) {
+	_, err := repo.db.Exec(`CREATE TRIGGER fail_ledger_insert BEFORE INSERT ON ledger_entries WHEN NEW.reference = '` + reference + `'
+		BEGIN SELECT RAISE(ABORT, 'injected failure'); END`)
+	assert.NoError(t, err)
+}
+
+func TestSQLRepository_shouldLoadCommittedChanges(t *testing.T) {
+	repo, _ := newTestSQLRepository(t)
+	testRepositoryRoundTrip(t, repo)
+}
+
+func TestSQLRepository_shouldKeepAppStateBetweenRuns(t *testing.T) {
+	repo, _ := newTestSQLRepository(t)
+	testRepositoryApp(t, repo)
+}
+
+func TestOpenSQLRepository_shouldMigrateSchemaOnce(t *testing.T) {
+	repo, path := newTestSQLRepository(t)
+	version, err := repo.SchemaVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, len(sqlMigrations), version)
+	assert.NoError(t, repo.Commit(ChangeSet{Customers: []*Customer{{ID: "test1"}}}))
+	repo.Close()
+
+	reopened, err := OpenSQLRepository(path)
+	assert.NoError(t, err)
+	defer reopened.Close()
+	version, err = reopened.SchemaVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, len(sqlMigrations), version)
+	customers, _, err := reopened.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, "test1", customers[0].ID)
+
+	var indexes int
+	assert.NoError(t, reopened.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND tbl_name = 'ledger_entries' AND name LIKE 'ledger_entries_%'`).Scan(&indexes))
+	assert.Equal(t, 2, indexes)
+}
+
+func TestSQLRepository_Commit_shouldRollBack_givenFailure(t *testing.T) {
+	repo, _ := newTestSQLRepository(t)
+	failLedgerInserts(t, repo, "fail")
+	tm := time.Date(2020, 1, 31, 12, 0, 0, 0, time.UTC)
+
+	err := repo.Commit(ChangeSet{
+		Customers: []*Customer{{ID: "test1", portfolios: []*Portfolio{{Name: "Retirement", Balance: 100}}}},
+		Entries: []LedgerEntry{
+			{Time: tm, Customer: "test1", Portfolio: "Retirement", Type: EntryDeposit, Amount: 100},
+			{Time: tm, Customer: "test1", Portfolio: "Retirement", Type: EntryFee, Amount: -1, Reference: "fail"},
+		},
+	})
+	assert.Error(t, err)
+
+	customers, ledger, err := repo.Load()
+	assert.NoError(t, err)
+	assert.Empty(t, customers)
+	assert.Empty(t, ledger.entries)
+}
+
+func TestSQLRepository_shouldCommitEndDepositInOneTransaction(t *testing.T) {
+	repo, _ := newTestSQLRepository(t)
+	app := NewApp()
+	assert.NoError(t, app.SetRepository(repo))
+	for _, input := range []string{
+		"newcustomer test1",
+		"addportfolio Retirement",
+		"addportfolio Growth",
+		"startDeposit",
+		"addOneTimePlan plan Retirement 100 Growth 50",
+		"deposit 150",
+	} {
+		_, err := app.processInput(input)
+		assert.NoError(t, err, input)
+	}
+	failLedgerInserts(t, repo, "plan")
+
+	_, err := app.processInput("endDeposit")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "injected failure")
+	}
+
+	customers, ledger, err := repo.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, float32(0), customers[0].portfolios[0].Balance)
+	assert.Equal(t, float32(0), customers[0].portfolios[1].Balance)
+	assert.Empty(t, ledger.entries)
+}
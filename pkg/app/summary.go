@@ -0,0 +1,92 @@
+package app
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// planTypeOther groups deposits recorded without a plan type
+const planTypeOther = "other"
+
+// AllocationLine is the balance of an open portfolio in the default currency, the percentage of the total it
+// makes up and how far that is, in percentage points, from the target. Target and Drift are zero without a target
+// allocation.
+type AllocationLine struct {
+	Portfolio string
+	Balance   float32
+	Share     float64
+	Target    float64
+	Drift     float64
+}
+
+// Summary is the consolidated position of a customer in the default currency, with the contributions paid
+// between From and To by plan type
+type Summary struct {
+	Customer      string
+	Currency      string
+	Total         float32
+	Portfolios    []AllocationLine
+	HasTarget     bool
+	From          time.Time
+	To            time.Time
+	Contributions map[string]float32
+}
+
+// PlanTypes returns the plan types contributions were paid through, sorted
+func (s Summary) PlanTypes() []string {
+	types := []string{}
+	for t := range s.Contributions {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Summary returns the total balance of the open portfolios of the customer, each portfolio's share of it and its
+// drift from the target allocation, and the deposits paid between from and to by plan type. Amounts are
+// converted to the default currency.
+func (c *Customer) Summary(from time.Time, to time.Time) (Summary, error) {
+	s := Summary{Customer: c.ID, Currency: DefaultCurrency, HasTarget: c.targets != nil, From: from, To: to, Contributions: map[string]float32{}}
+	for _, p := range c.portfolios {
+		if p.Closed {
+			continue
+		}
+		balance, err := convert(c.fx, p.Balance, p.currency(), "")
+		if err != nil {
+			return Summary{}, err
+		}
+		s.Total += balance
+		s.Portfolios = append(s.Portfolios, AllocationLine{Portfolio: p.Name, Balance: balance, Target: c.targets[p.Name]})
+	}
+	for i := range s.Portfolios {
+		l := &s.Portfolios[i]
+		if s.Total > 0 {
+			l.Share = roundPercent(float64(l.Balance) / float64(s.Total) * 100)
+		}
+		if s.HasTarget {
+			l.Drift = roundPercent(l.Share - l.Target)
+		}
+	}
+
+	for _, e := range c.ledger.Entries(c.ID) {
+		if e.Type != EntryDeposit || e.Time.Before(from) || e.Time.After(to) {
+			continue
+		}
+		amount, err := convert(c.fx, e.Amount, e.Currency, "")
+		if err != nil {
+			return Summary{}, err
+		}
+		planType := e.PlanType
+		if planType == "" {
+			planType = planTypeOther
+		}
+		s.Contributions[planType] += amount
+	}
+	return s, nil
+}
+
+// roundPercent rounds the percentage to two decimal places
+func roundPercent(percent float64) float64 {
+	return math.Round(percent*100) / 100
+}
@@ -0,0 +1,93 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCustomerSummary_shouldReportAllocationAndContributions(t *testing.T) {
+	jan := time.Date(2020, 1, 15, 12, 0, 0, 0, time.UTC)
+	feb := time.Date(2020, 2, 15, 12, 0, 0, 0, time.UTC)
+	ledger := NewLedger()
+	ledger.Record(LedgerEntry{Time: jan, Customer: "c1", Portfolio: "Retirement", Type: EntryDeposit, Amount: 500, PlanType: "one-time"})
+	ledger.Record(LedgerEntry{Time: feb, Customer: "c1", Portfolio: "Retirement", Type: EntryDeposit, Amount: 100, PlanType: "monthly"})
+	ledger.Record(LedgerEntry{Time: feb, Customer: "c1", Portfolio: "US Equities", Type: EntryDeposit, Amount: 100, Currency: "USD", PlanType: "one-time"})
+	ledger.Record(LedgerEntry{Time: feb, Customer: "c1", Portfolio: "Retirement", Type: EntryDeposit, Amount: 50})
+	ledger.Record(LedgerEntry{Time: feb, Customer: "c1", Portfolio: "Retirement", Type: EntryWithdrawal, Amount: -50})
+	ledger.Record(LedgerEntry{Time: feb, Customer: "c2", Portfolio: "Retirement", Type: EntryDeposit, Amount: 1000, PlanType: "monthly"})
+	c := Customer{
+		ID: "c1",
+		portfolios: []*Portfolio{
+			{Name: "Retirement", Balance: 600},
+			{Name: "US Equities", Balance: 100, Currency: "USD"},
+			{Name: "Closed", Closed: true},
+		},
+		ledger:  ledger,
+		fx:      StaticRateProvider{"USD/SGD": 2},
+		targets: map[string]float64{"Retirement": 60, "US Equities": 40},
+	}
+
+	s, err := c.Summary(time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC), time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.Equal(t, float32(800), s.Total)
+	assert.Equal(t, "SGD", s.Currency)
+	assert.True(t, s.HasTarget)
+	assert.Equal(t, []AllocationLine{
+		{Portfolio: "Retirement", Balance: 600, Share: 75, Target: 60, Drift: 15},
+		{Portfolio: "US Equities", Balance: 200, Share: 25, Target: 40, Drift: -15},
+	}, s.Portfolios)
+	assert.Equal(t, map[string]float32{"monthly": 100, "one-time": 200, "other": 50}, s.Contributions)
+	assert.Equal(t, []string{"monthly", "one-time", "other"}, s.PlanTypes())
+}
+
+func TestCustomerSummary_shouldNotReportDrift_givenNoTarget(t *testing.T) {
+	c := Customer{ID: "c1", portfolios: []*Portfolio{{Name: "Retirement", Balance: 100}, {Name: "High Risk", Balance: 200}, {Name: "Empty"}}}
+	s, err := c.Summary(time.Time{}, time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.False(t, s.HasTarget)
+	assert.Equal(t, []AllocationLine{
+		{Portfolio: "Retirement", Balance: 100, Share: 33.33},
+		{Portfolio: "High Risk", Balance: 200, Share: 66.67},
+		{Portfolio: "Empty", Balance: 0, Share: 0},
+	}, s.Portfolios)
+	assert.Empty(t, s.Contributions)
+}
+
+func TestCustomerSummary_shouldReturnError_givenNoRate(t *testing.T) {
+	c := Customer{ID: "c1", portfolios: []*Portfolio{{Name: "US Equities", Balance: 100, Currency: "USD"}}}
+	_, err := c.Summary(time.Time{}, time.Now())
+	assert.EqualError(t, err, "currency mismatch: USD to SGD")
+}
+
+func TestApp_Summary_shouldPrintSummary(t *testing.T) {
+	setNow(t, time.Date(2020, 1, 15, 12, 0, 0, 0, time.UTC))
+	app := NewApp()
+	_, err := app.processInput("summary")
+	assert.Equal(t, ErrNoActiveCustomer, err)
+
+	for _, input := range []string{
+		"newcustomer test1",
+		"addportfolio Retirement",
+		"addportfolio Growth",
+		"settarget Retirement 50 Growth 50",
+		"startDeposit",
+		"addOneTimePlan plan Retirement 100",
+		"addMonthlyPlan monthly Growth 50",
+		"deposit 150",
+		"endDeposit",
+		"summary",
+		"summary --from 2020-01-01 --to 2020-01-31",
+	} {
+		_, err := app.processInput(input)
+		assert.NoError(t, err, input)
+	}
+	_, err = app.processInput("summary --from yesterday")
+	assert.EqualError(t, err, "invalid time: yesterday")
+
+	s, err := app.currentCustomer.Summary(time.Time{}, now())
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]float32{"one-time": 100, "monthly": 50}, s.Contributions)
+	assert.Equal(t, 16.67, s.Portfolios[0].Drift)
+}
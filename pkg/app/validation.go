@@ -0,0 +1,118 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DepositProblem is a problem found validating a deposit. Plan and Portfolio are set when the problem is with
+// a plan or one of its portfolios.
+type DepositProblem struct {
+	Plan      string
+	Portfolio string
+	Err       error
+}
+
+// String describes the problem along with the plan and portfolio it is found in
+func (p DepositProblem) String() string {
+	switch {
+	case p.Portfolio != "":
+		return fmt.Sprintf("%s (plan %s, portfolio %s)", p.Err, p.Plan, p.Portfolio)
+	case p.Plan != "":
+		return fmt.Sprintf("%s (plan %s)", p.Err, p.Plan)
+	}
+	return p.Err.Error()
+}
+
+// DepositValidationError is returned with every problem found validating a deposit
+type DepositValidationError struct {
+	Problems []DepositProblem
+}
+
+func (e *DepositValidationError) Error() string {
+	reasons := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		reasons[i] = p.Err.Error()
+	}
+	return strings.Join(reasons, "; ")
+}
+
+func (e *DepositValidationError) Code() string {
+	return "DEPOSIT_INVALID"
+}
+
+// Is reports whether any of the problems is the target error
+func (e *DepositValidationError) Is(target error) bool {
+	for _, p := range e.Problems {
+		if errors.Is(p.Err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first problem matching the target error type
+func (e *DepositValidationError) As(target interface{}) bool {
+	for _, p := range e.Problems {
+		if errors.As(p.Err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateDeposit checks the plans are for open portfolios of the customer and have something to pay, and the
+// deposits, in the specified currency, match what the plans total. All the problems found are returned together.
+func (c *Customer) validateDeposit(depositPlans []DepositPlan, deposits []float32, currency string) error {
+	var problems []DepositProblem
+	if len(depositPlans) == 0 {
+		err := ErrNoDepositPlan
+		if len(deposits) == 0 {
+			err = ErrEmptySession
+		}
+		return &DepositValidationError{Problems: []DepositProblem{{Err: err}}}
+	}
+
+	var totalNeeded float32
+	converted := true
+	for _, dp := range depositPlans {
+		names := []string{}
+		for k := range dp.PortfolioRatio() {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		for _, k := range names {
+			p := c.findPortfolio(k)
+			if p == nil {
+				problems = append(problems, DepositProblem{Plan: dp.Name(), Portfolio: k, Err: ErrPlanPortfolioUnknown})
+			} else if p.Closed {
+				problems = append(problems, DepositProblem{Plan: dp.Name(), Portfolio: k, Err: ErrPlanPortfolioClosed})
+			}
+		}
+
+		if dp.DepositTotal() <= 0 {
+			problems = append(problems, DepositProblem{Plan: dp.Name(), Err: ErrPlanTotalZero})
+		}
+		planTotal, err := convert(c.fx, dp.DepositTotal(), dp.Currency(), currency)
+		if err != nil {
+			problems = append(problems, DepositProblem{Plan: dp.Name(), Err: err})
+			converted = false
+		}
+		totalNeeded += planTotal
+	}
+
+	var totalDeposit float32
+	for _, v := range deposits {
+		totalDeposit += v
+	}
+	if converted && roundCents(float64(totalNeeded)) != roundCents(float64(totalDeposit)) {
+		problems = append(problems, DepositProblem{Err: &AmountMismatchError{Expected: totalNeeded, Received: totalDeposit}})
+	}
+
+	if len(problems) > 0 {
+		return &DepositValidationError{Problems: problems}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package app
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateDeposit_shouldReturnNil_givenValidDeposit(t *testing.T) {
+	c := Customer{portfolios: []*Portfolio{{Name: "Retirement"}, {Name: "High Risk"}}}
+	err := c.validateDeposit(
+		[]DepositPlan{&baseDepositPlan{name: "Plan A", planType: "one-time", portfolioRatio: map[string]float32{"Retirement": 100, "High Risk": 50}}},
+		[]float32{100, 50},
+		"",
+	)
+	assert.NoError(t, err)
+}
+
+func TestValidateDeposit_shouldReturnEveryProblem_givenInvalidDeposit(t *testing.T) {
+	testValidateDeposit := func(depositPlans []DepositPlan, deposits []float32, expected []DepositProblem) {
+		c := Customer{portfolios: []*Portfolio{{Name: "Retirement"}, {Name: "Closed", Closed: true}}}
+		err := c.validateDeposit(depositPlans, deposits, "")
+		var verr *DepositValidationError
+		assert.True(t, errors.As(err, &verr))
+		assert.Equal(t, expected, verr.Problems)
+	}
+
+	testValidateDeposit([]DepositPlan{}, []float32{}, []DepositProblem{{Err: ErrEmptySession}})
+	testValidateDeposit([]DepositPlan{}, []float32{100}, []DepositProblem{{Err: ErrNoDepositPlan}})
+	testValidateDeposit(
+		[]DepositPlan{
+			&baseDepositPlan{name: "Plan A", planType: "one-time", portfolioRatio: map[string]float32{"Retirement": 100, "Unknown": 50, "Closed": 10}},
+			&baseDepositPlan{name: "Plan B", planType: "monthly", portfolioRatio: map[string]float32{"Retirement": 0}},
+		},
+		[]float32{100},
+		[]DepositProblem{
+			{Plan: "Plan A", Portfolio: "Closed", Err: ErrPlanPortfolioClosed},
+			{Plan: "Plan A", Portfolio: "Unknown", Err: ErrPlanPortfolioUnknown},
+			{Plan: "Plan B", Err: ErrPlanTotalZero},
+			{Err: &AmountMismatchError{Expected: 160, Received: 100}},
+		},
+	)
+	testValidateDeposit(
+		[]DepositPlan{&baseDepositPlan{name: "Plan A", planType: "one-time", portfolioRatio: map[string]float32{"Retirement": 100}, currency: "USD"}},
+		[]float32{100},
+		[]DepositProblem{{Plan: "Plan A", Err: &CurrencyMismatchError{From: "USD", To: "SGD"}}},
+	)
+}
+
+func TestDepositValidationError_shouldJoinReasons(t *testing.T) {
+	err := &DepositValidationError{Problems: []DepositProblem{
+		{Plan: "Plan A", Err: ErrPlanTotalZero},
+		{Err: &AmountMismatchError{Expected: 100, Received: 50}},
+	}}
+	assert.Equal(t, "deposit plan total is zero; deposits does not match the plan amounts", err.Error())
+}
+
+func TestPerformDeposit_shouldReturnError_givenEmptyDeposit(t *testing.T) {
+	c := Customer{portfolios: []*Portfolio{{Name: "Retirement"}}}
+	err := c.PerformDeposit([]DepositPlan{}, []float32{})
+	assert.EqualError(t, err, "deposit session is empty")
+}
+
+func TestApp_EndDeposit_shouldReportEveryProblem_givenInvalidSession(t *testing.T) {
+	app := NewApp()
+	for _, input := range []string{
+		"newcustomer test1",
+		"addportfolio Retirement",
+		"startDeposit",
+		"addOneTimePlan planA Retirement 100",
+		"addOneTimePlan planB Growth 50",
+		"deposit 100",
+	} {
+		_, err := app.processInput(input)
+		assert.NoError(t, err, input)
+	}
+
+	_, err := app.processInput("endDeposit")
+	assert.EqualError(t, err, "deposit plan does not match customer portfolio; deposits does not match the plan amounts")
+	assert.NotNil(t, app.currentCustomer.DepositSession)
+	assert.Equal(t, float32(0), app.currentCustomer.portfolios[0].Balance)
+}
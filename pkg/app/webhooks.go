@@ -0,0 +1,391 @@
+package app
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Webhook delivery settings
+const (
+	webhookMaxAttempts = 6
+	webhookBaseBackoff = 30 * time.Second
+	webhookMaxBackoff  = time.Hour
+	webhookTimeout     = 10 * time.Second
+)
+
+// SignatureHeader carries the hex encoded HMAC-SHA256 of the request body, keyed by the webhook secret
+const SignatureHeader = "X-Webhook-Signature"
+
+// Webhook is a subscription delivering events to a URL. An empty event filter delivers every event. The secret
+// is saved in plain text with the subscription, so the dispatcher file is only readable by its owner and should
+// be protected like any other credential.
+type Webhook struct {
+	ID     string   `json:"id"`
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret"`
+}
+
+// WebhookDelivery is an event waiting to be delivered to a webhook
+type WebhookDelivery struct {
+	ID          string          `json:"id"`
+	Webhook     string          `json:"webhook"`
+	Event       string          `json:"event"`
+	Body        json.RawMessage `json:"body"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"nextAttempt"`
+	LastError   string          `json:"lastError,omitempty"`
+}
+
+// webhookState is what the dispatcher saves to its file
+type webhookState struct {
+	LastWebhookID  int               `json:"lastWebhookId"`
+	LastDeliveryID int               `json:"lastDeliveryId"`
+	Webhooks       []Webhook         `json:"webhooks"`
+	Queue          []WebhookDelivery `json:"queue"`
+	DeadLetters    []WebhookDelivery `json:"deadLetters"`
+}
+
+// WebhookDispatcher is a subscriber queueing events for the webhooks subscribed to them and delivering them
+// as signed JSON posts. Failed deliveries are retried with exponential backoff and dead-lettered after the
+// last attempt. The subscriptions and queue are saved to a file after every change so no delivery is lost.
+type WebhookDispatcher struct {
+	mu     sync.Mutex
+	path   string
+	state  webhookState
+	client *http.Client
+	now    func() time.Time
+	stop   chan struct{}
+	done   chan struct{}
+
+	// delivering serialises DeliverDue so a delivery is never posted twice at once
+	delivering sync.Mutex
+}
+
+type webhookBody struct {
+	ID   string          `json:"id"`
+	Type string          `json:"type"`
+	Time time.Time       `json:"time"`
+	Data json.RawMessage `json:"data"`
+}
+
+// NewWebhookDispatcher instantiate a dispatcher saved to path, loading the subscriptions and queue already
+// saved there. An empty path keeps everything in memory.
+func NewWebhookDispatcher(path string) (*WebhookDispatcher, error) {
+	d := &WebhookDispatcher{
+		path:   path,
+		state:  webhookState{Webhooks: []Webhook{}, Queue: []WebhookDelivery{}, DeadLetters: []WebhookDelivery{}},
+		client: &http.Client{Timeout: webhookTimeout},
+		now:    time.Now,
+	}
+	if path == "" {
+		return d, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return d, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &d.state); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// AddWebhook subscribes the URL to the events, returning the subscription
+func (d *WebhookDispatcher) AddWebhook(rawURL string, events []string, secret string) (Webhook, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Webhook{}, ErrInvalidWebhookURL
+	}
+	if secret == "" {
+		return Webhook{}, ErrWebhookSecretEmpty
+	}
+	for _, e := range events {
+		if !validEventType(e) {
+			return Webhook{}, &InvalidValueError{Field: "event type", Value: e}
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.state.LastWebhookID++
+	w := Webhook{ID: "wh" + strconv.Itoa(d.state.LastWebhookID), URL: rawURL, Events: events, Secret: secret}
+	d.state.Webhooks = append(d.state.Webhooks, w)
+	return w, d.save()
+}
+
+// RemoveWebhook unsubscribes the webhook, dropping the deliveries queued for it
+func (d *WebhookDispatcher) RemoveWebhook(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i, w := range d.state.Webhooks {
+		if w.ID != id {
+			continue
+		}
+		d.state.Webhooks = append(d.state.Webhooks[:i], d.state.Webhooks[i+1:]...)
+		queue := []WebhookDelivery{}
+		for _, dl := range d.state.Queue {
+			if dl.Webhook != id {
+				queue = append(queue, dl)
+			}
+		}
+		d.state.Queue = queue
+		return d.save()
+	}
+	return ErrWebhookNotFound
+}
+
+// Webhooks returns the subscriptions
+func (d *WebhookDispatcher) Webhooks() []Webhook {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]Webhook{}, d.state.Webhooks...)
+}
+
+// Queue returns the deliveries waiting to be made
+func (d *WebhookDispatcher) Queue() []WebhookDelivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]WebhookDelivery{}, d.state.Queue...)
+}
+
+// DeadLetters returns the deliveries given up on
+func (d *WebhookDispatcher) DeadLetters() []WebhookDelivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]WebhookDelivery{}, d.state.DeadLetters...)
+}
+
+// Handle queues the event for every webhook subscribed to it
+func (d *WebhookDispatcher) Handle(e Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	queued := false
+	for _, w := range d.state.Webhooks {
+		if !w.subscribed(e.Type()) {
+			continue
+		}
+		d.state.LastDeliveryID++
+		id := strconv.Itoa(d.state.LastDeliveryID)
+		body, err := json.Marshal(webhookBody{ID: id, Type: e.Type(), Time: e.OccurredAt(), Data: data})
+		if err != nil {
+			return err
+		}
+		d.state.Queue = append(d.state.Queue, WebhookDelivery{ID: id, Webhook: w.ID, Event: e.Type(), Body: body, NextAttempt: d.now()})
+		queued = true
+	}
+	if !queued {
+		return nil
+	}
+	return d.save()
+}
+
+// Retry moves the dead-lettered delivery back onto the queue for another round of attempts
+func (d *WebhookDispatcher) Retry(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i, dl := range d.state.DeadLetters {
+		if dl.ID != id {
+			continue
+		}
+		d.state.DeadLetters = append(d.state.DeadLetters[:i], d.state.DeadLetters[i+1:]...)
+		dl.Attempts, dl.NextAttempt = 0, d.now()
+		d.state.Queue = append(d.state.Queue, dl)
+		return d.save()
+	}
+	return ErrDeadLetterNotFound
+}
+
+// DeliverDue attempts every delivery due by now, returning the number delivered. Calls made while another is
+// delivering wait for it to finish.
+func (d *WebhookDispatcher) DeliverDue() (int, error) {
+	d.delivering.Lock()
+	defer d.delivering.Unlock()
+
+	d.mu.Lock()
+	due := []WebhookDelivery{}
+	for _, dl := range d.state.Queue {
+		if !dl.NextAttempt.After(d.now()) {
+			due = append(due, dl)
+		}
+	}
+	d.mu.Unlock()
+
+	delivered := 0
+	for _, dl := range due {
+		d.mu.Lock()
+		w, ok := d.webhook(dl.Webhook)
+		d.mu.Unlock()
+		if !ok {
+			continue
+		}
+
+		err := d.post(w, dl)
+		d.mu.Lock()
+		d.settle(dl.ID, err)
+		if err == nil {
+			delivered++
+		}
+		serr := d.save()
+		d.mu.Unlock()
+		if serr != nil {
+			return delivered, serr
+		}
+	}
+	return delivered, nil
+}
+
+// Start delivers due deliveries every interval in the background until Stop is called
+func (d *WebhookDispatcher) Start(interval time.Duration) {
+	d.stop, d.done = make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(d.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := d.DeliverDue(); err != nil {
+					fmt.Fprintln(os.Stderr, "Webhook delivery error:", err)
+				}
+			case <-d.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the background delivery started by Start
+func (d *WebhookDispatcher) Stop() {
+	if d.stop == nil {
+		return
+	}
+	close(d.stop)
+	<-d.done
+	d.stop = nil
+}
+
+// Sign returns the signature of the body sent with the secret
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (d *WebhookDispatcher) post(w Webhook, dl WebhookDelivery) error {
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(dl.Body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", dl.Event)
+	req.Header.Set("X-Webhook-Delivery", dl.ID)
+	req.Header.Set(SignatureHeader, Sign(w.Secret, dl.Body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%w %d", ErrUnexpectedStatus, resp.StatusCode)
+	}
+	return nil
+}
+
+// settle removes a successful delivery from the queue, or schedules the next attempt of a failed one
+func (d *WebhookDispatcher) settle(id string, err error) {
+	for i := range d.state.Queue {
+		dl := &d.state.Queue[i]
+		if dl.ID != id {
+			continue
+		}
+		if err == nil {
+			d.state.Queue = append(d.state.Queue[:i], d.state.Queue[i+1:]...)
+			return
+		}
+
+		dl.Attempts++
+		dl.LastError = err.Error()
+		if dl.Attempts >= webhookMaxAttempts {
+			d.state.DeadLetters = append(d.state.DeadLetters, *dl)
+			d.state.Queue = append(d.state.Queue[:i], d.state.Queue[i+1:]...)
+			return
+		}
+		dl.NextAttempt = d.now().Add(webhookBackoff(dl.Attempts))
+		return
+	}
+}
+
+func (d *WebhookDispatcher) webhook(id string) (Webhook, bool) {
+	for _, w := range d.state.Webhooks {
+		if w.ID == id {
+			return w, true
+		}
+	}
+	return Webhook{}, false
+}
+
+func (d *WebhookDispatcher) save() error {
+	if d.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(d.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := d.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, d.path)
+}
+
+func (w Webhook) subscribed(eventType string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// webhookBackoff returns the wait after the number of failed attempts, doubling from the base up to the maximum
+func webhookBackoff(attempts int) time.Duration {
+	backoff := webhookBaseBackoff
+	for i := 1; i < attempts && backoff < webhookMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > webhookMaxBackoff {
+		return webhookMaxBackoff
+	}
+	return backoff
+}
+
+func validEventType(eventType string) bool {
+	_, ok := eventTypes[eventType]
+	return ok
+}
@@ -0,0 +1,224 @@
+package app
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testReceiver is a local webhook endpoint recording the requests it receives
+type testReceiver struct {
+	mu       sync.Mutex
+	status   int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func newTestReceiver(t *testing.T, status int) (*testReceiver, *httptest.Server) {
+	r := &testReceiver{status: status}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		r.mu.Lock()
+		r.requests = append(r.requests, req)
+		r.bodies = append(r.bodies, body)
+		status := r.status
+		r.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return r, server
+}
+
+func (r *testReceiver) setStatus(status int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status = status
+}
+
+func newTestDispatcher(t *testing.T, path string, at *time.Time) *WebhookDispatcher {
+	d, err := NewWebhookDispatcher(path)
+	assert.NoError(t, err)
+	d.now = func() time.Time { return *at }
+	return d
+}
+
+func TestWebhookDispatcher_shouldDeliverSignedEvents_givenSubscribedWebhook(t *testing.T) {
+	at := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	receiver, server := newTestReceiver(t, http.StatusOK)
+	d := newTestDispatcher(t, "", &at)
+	w, err := d.AddWebhook(server.URL, []string{EventSessionCommitted}, "s3cret")
+	assert.NoError(t, err)
+	assert.Equal(t, "wh1", w.ID)
+
+	assert.NoError(t, d.Handle(SessionStarted{Customer: "c1", Time: at}))
+	assert.NoError(t, d.Handle(SessionCommitted{Customer: "c1", Currency: "SGD", Deposits: []float32{100}, Time: at}))
+	assert.Equal(t, 1, len(d.Queue()))
+
+	delivered, err := d.DeliverDue()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, delivered)
+	assert.Equal(t, 0, len(d.Queue()))
+
+	assert.Equal(t, 1, len(receiver.requests))
+	req, body := receiver.requests[0], receiver.bodies[0]
+	assert.Equal(t, EventSessionCommitted, req.Header.Get("X-Webhook-Event"))
+	assert.Equal(t, Sign("s3cret", body), req.Header.Get(SignatureHeader))
+
+	var payload struct {
+		Type string
+		Data SessionCommitted
+	}
+	assert.NoError(t, json.Unmarshal(body, &payload))
+	assert.Equal(t, EventSessionCommitted, payload.Type)
+	assert.Equal(t, "c1", payload.Data.Customer)
+	assert.Equal(t, []float32{100}, payload.Data.Deposits)
+}
+
+func TestWebhookDispatcher_shouldBackOffAndDeadLetter_givenFailingReceiver(t *testing.T) {
+	at := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	receiver, server := newTestReceiver(t, http.StatusInternalServerError)
+	d := newTestDispatcher(t, "", &at)
+	_, err := d.AddWebhook(server.URL, nil, "s3cret")
+	assert.NoError(t, err)
+	assert.NoError(t, d.Handle(CustomerCreated{Customer: "c1", Time: at}))
+
+	for attempt := 1; attempt < webhookMaxAttempts; attempt++ {
+		delivered, err := d.DeliverDue()
+		assert.NoError(t, err)
+		assert.Equal(t, 0, delivered)
+		queue := d.Queue()
+		assert.Equal(t, attempt, queue[0].Attempts)
+		assert.Equal(t, "unexpected status 500", queue[0].LastError)
+		assert.Equal(t, at.Add(webhookBackoff(attempt)), queue[0].NextAttempt)
+
+		_, err = d.DeliverDue()
+		assert.NoError(t, err)
+		assert.Equal(t, attempt, len(receiver.requests), "not due until the backoff has passed")
+		at = queue[0].NextAttempt
+	}
+
+	_, err = d.DeliverDue()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(d.Queue()))
+	assert.Equal(t, 1, len(d.DeadLetters()))
+
+	receiver.setStatus(http.StatusOK)
+	assert.NoError(t, d.Retry("1"))
+	assert.Equal(t, 0, len(d.DeadLetters()))
+	delivered, err := d.DeliverDue()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, delivered)
+	assert.EqualError(t, d.Retry("1"), "dead letter not found")
+}
+
+func TestWebhookDispatcher_shouldKeepQueue_givenReload(t *testing.T) {
+	at := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	receiver, server := newTestReceiver(t, http.StatusOK)
+	path := filepath.Join(t.TempDir(), "webhooks.json")
+	d := newTestDispatcher(t, path, &at)
+	_, err := d.AddWebhook(server.URL, nil, "s3cret")
+	assert.NoError(t, err)
+	assert.NoError(t, d.Handle(CustomerCreated{Customer: "c1", Time: at}))
+
+	reloaded := newTestDispatcher(t, path, &at)
+	assert.Equal(t, d.Webhooks(), reloaded.Webhooks())
+	assert.Equal(t, 1, len(reloaded.Queue()))
+	delivered, err := reloaded.DeliverDue()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, delivered)
+	assert.Equal(t, Sign("s3cret", receiver.bodies[0]), receiver.requests[0].Header.Get(SignatureHeader))
+
+	w, err := reloaded.AddWebhook(server.URL, nil, "other")
+	assert.NoError(t, err)
+	assert.Equal(t, "wh2", w.ID)
+	assert.NoError(t, reloaded.RemoveWebhook("wh1"))
+	assert.EqualError(t, reloaded.RemoveWebhook("wh1"), "webhook not found")
+	assert.Equal(t, []Webhook{w}, newTestDispatcher(t, path, &at).Webhooks())
+}
+
+func TestWebhookDispatcher_AddWebhook_shouldReturnError_givenInvalidSubscription(t *testing.T) {
+	d, _ := NewWebhookDispatcher("")
+	testAddWebhook := func(url string, events []string, secret string, expectedErr string) {
+		_, err := d.AddWebhook(url, events, secret)
+		assert.EqualError(t, err, expectedErr)
+	}
+
+	testAddWebhook("ftp://example.com", nil, "s", "invalid webhook url")
+	testAddWebhook("example.com/hook", nil, "s", "invalid webhook url")
+	testAddWebhook("https://example.com/hook", nil, "", "webhook secret is empty")
+	testAddWebhook("https://example.com/hook", []string{"Unknown"}, "s", "invalid event type: Unknown")
+	assert.Equal(t, 0, len(d.Webhooks()))
+}
+
+func TestWebhookDispatcher_shouldDeliverOnce_givenConcurrentCalls(t *testing.T) {
+	at := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	receiver, server := newTestReceiver(t, http.StatusOK)
+	d := newTestDispatcher(t, "", &at)
+	_, err := d.AddWebhook(server.URL, nil, "s3cret")
+	assert.NoError(t, err)
+	assert.NoError(t, d.Handle(SessionStarted{Customer: "c1", Time: at}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := d.DeliverDue()
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, receiver.requests, 1)
+}
+
+func TestWebhookBackoff_shouldDoubleUpToMaximum(t *testing.T) {
+	assert.Equal(t, webhookBaseBackoff, webhookBackoff(1))
+	assert.Equal(t, 2*webhookBaseBackoff, webhookBackoff(2))
+	assert.Equal(t, 4*webhookBaseBackoff, webhookBackoff(3))
+	assert.Equal(t, webhookMaxBackoff, webhookBackoff(20))
+}
+
+func TestWebhooks_shouldDeliverCommittedSessions_givenCommands(t *testing.T) {
+	receiver, server := newTestReceiver(t, http.StatusOK)
+	app := newTestAuthApp(t)
+	bus := NewEventBus()
+	d, _ := NewWebhookDispatcher("")
+	bus.Subscribe(d)
+	app.SetEventBus(bus)
+	app.SetWebhookDispatcher(d)
+
+	_, err := app.processInput("login teller pw")
+	assert.NoError(t, err)
+	_, err = app.processInput("addwebhook " + server.URL + " s3cret --events SessionCommitted")
+	assert.EqualError(t, err, "permission denied")
+
+	for _, input := range []string{
+		"login super pw",
+		"addwebhook " + server.URL + " s3cret --events SessionCommitted",
+		"newcustomer test1",
+		"addportfolio Retirement",
+		"startDeposit",
+		"addOneTimePlan plan Retirement 100",
+		"deposit 100",
+		"endDeposit",
+		"deliverwebhooks",
+	} {
+		_, err := app.processInput(input)
+		assert.NoError(t, err, input)
+	}
+
+	assert.Equal(t, 1, len(receiver.requests))
+	assert.Equal(t, EventSessionCommitted, receiver.requests[0].Header.Get("X-Webhook-Event"))
+	assert.Equal(t, Sign("s3cret", receiver.bodies[0]), receiver.requests[0].Header.Get(SignatureHeader))
+
+	_, err = app.processInput("removewebhook wh1")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(d.Webhooks()))
+}
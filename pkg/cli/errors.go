@@ -0,0 +1,34 @@
+package cli
+
+// inputError is an error parsing the input, with a stable code for programs reading it
+type inputError struct {
+	code    string
+	message string
+}
+
+func (e *inputError) Error() string {
+	return e.message
+}
+
+func (e *inputError) Code() string {
+	return e.code
+}
+
+// Errors returned parsing input, compared with errors.Is
+var (
+	ErrNoCommand     = &inputError{code: "NO_COMMAND", message: "no command given"}
+	ErrFlagNameEmpty = &inputError{code: "FLAG_NAME_EMPTY", message: "flag name is empty"}
+)
+
+// FlagValueMissingError is returned when a flag is the last argument, without a value
+type FlagValueMissingError struct {
+	Name string
+}
+
+func (e *FlagValueMissingError) Error() string {
+	return "flag value missing: " + e.Name
+}
+
+func (e *FlagValueMissingError) Code() string {
+	return "FLAG_VALUE_MISSING"
+}
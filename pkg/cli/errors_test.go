@@ -0,0 +1,25 @@
+package cli
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCmdInput_shouldReturnErrNoCommand_givenEmptyInput(t *testing.T) {
+	_, err := ParseCmdInput("")
+	assert.True(t, errors.Is(err, ErrNoCommand))
+	assert.Equal(t, "NO_COMMAND", ErrNoCommand.Code())
+}
+
+func TestParseFlags_shouldReturnTypedErrors_givenInvalidFlags(t *testing.T) {
+	_, _, err := ParseFlags([]string{"--", "x"})
+	assert.True(t, errors.Is(err, ErrFlagNameEmpty))
+
+	_, _, err = ParseFlags([]string{"--customer"})
+	var missing *FlagValueMissingError
+	assert.True(t, errors.As(err, &missing))
+	assert.Equal(t, "customer", missing.Name)
+	assert.Equal(t, "FLAG_VALUE_MISSING", missing.Code())
+}
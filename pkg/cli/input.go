@@ -1,6 +1,6 @@
 package cli
 
-import "errors"
+import "strings"
 
 // Command is the struct used to define the command and arguments
 type Command struct {
@@ -34,8 +34,31 @@ func ParseCmdInput(input string) (Command, error) {
 	}
 
 	if len(parts) == 0 {
-		return Command{}, errors.New("no command given")
+		return Command{}, ErrNoCommand
 	}
 
 	return Command{Command: parts[0], Args: parts[1:]}, nil
 }
+
+// ParseFlags separates "--name value" pairs from positional arguments
+func ParseFlags(args []string) (map[string]string, []string, error) {
+	flags := map[string]string{}
+	positional := []string{}
+	for i := 0; i < len(args); i++ {
+		if !strings.HasPrefix(args[i], "--") {
+			positional = append(positional, args[i])
+			continue
+		}
+
+		name := strings.TrimPrefix(args[i], "--")
+		if name == "" {
+			return nil, nil, ErrFlagNameEmpty
+		}
+		if i+1 >= len(args) {
+			return nil, nil, &FlagValueMissingError{Name: name}
+		}
+		flags[name] = args[i+1]
+		i++
+	}
+	return flags, positional, nil
+}
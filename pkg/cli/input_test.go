@@ -30,3 +30,27 @@ func TestParseCmdInput_shouldReturnError_givenNoCommand(t *testing.T) {
 
 	testParseCmdInput("")
 }
+
+func TestParseFlags_shouldSplitFlagsFromPositionalArgs(t *testing.T) {
+	testParseFlags := func(args []string, expectedFlags map[string]string, expectedPositional []string) {
+		flags, positional, err := ParseFlags(args)
+		assert.NoError(t, err)
+		assert.Equal(t, expectedFlags, flags)
+		assert.Equal(t, expectedPositional, positional)
+	}
+
+	testParseFlags([]string{}, map[string]string{}, []string{})
+	testParseFlags([]string{"a", "b"}, map[string]string{}, []string{"a", "b"})
+	testParseFlags([]string{"--customer", "test1", "a", "--from", "2020-01-01"}, map[string]string{"customer": "test1", "from": "2020-01-01"}, []string{"a"})
+}
+
+func TestParseFlags_shouldReturnError_givenInvalidFlag(t *testing.T) {
+	testParseFlags := func(args []string, expectedErr string) {
+		_, _, err := ParseFlags(args)
+		assert.Error(t, err)
+		assert.Equal(t, expectedErr, err.Error())
+	}
+
+	testParseFlags([]string{"--customer"}, "flag value missing: customer")
+	testParseFlags([]string{"--", "x"}, "flag name is empty")
+}